@@ -37,10 +37,15 @@ func AdminConsoleCmd() *cobra.Command {
 				return errors.Wrap(err, "failed to wait for web")
 			}
 
+			remotePort, err := k8sutil.KotsadmHTTPPort(clientset, v.GetString("namespace"), podName)
+			if err != nil {
+				return errors.Wrap(err, "failed to find kotsadm port")
+			}
+
 			stopCh := make(chan struct{})
 			defer close(stopCh)
 
-			adminConsolePort, errChan, err := k8sutil.PortForward(kubernetesConfigFlags, 8800, 3000, v.GetString("namespace"), podName, true, stopCh, log)
+			adminConsolePort, errChan, err := k8sutil.PortForward(kubernetesConfigFlags, 8800, remotePort, v.GetString("namespace"), podName, true, stopCh, log)
 			if err != nil {
 				return errors.Wrap(err, "failed to port forward")
 			}
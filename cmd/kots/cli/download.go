@@ -1,12 +1,16 @@
 package cli
 
 import (
+	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/replicatedhq/kots/pkg/download"
 	"github.com/replicatedhq/kots/pkg/logger"
+	"github.com/replicatedhq/kots/pkg/util"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -39,10 +43,40 @@ func DownloadCmd() *cobra.Command {
 				KubernetesConfigFlags: kubernetesConfigFlags,
 				Overwrite:             v.GetBool("overwrite"),
 				DecryptPasswordValues: v.GetBool("decrypt-password-values"),
+				Resumable:             v.GetBool("resumable"),
+				HTTPS:                 v.GetBool("https"),
+				IncludeImageList:      v.GetBool("include-images"),
+				KeepArchiveOnError:    v.GetBool("keep-archive-on-error"),
+				SkipUnchanged:         v.GetBool("skip-unchanged"),
+				PodSelector:           v.GetString("selector"),
+				MaxBytes:              v.GetInt64("max-bytes"),
+			}
+
+			// the key isn't a flag value so that it doesn't end up in shell history or the process list
+			decryptionKey, err := readDecryptionKey(v.GetString("decryption-key-file"))
+			if err != nil {
+				return err
+			}
+			downloadOptions.DecryptionKey = decryptionKey
+
+			if caBundlePath := v.GetString("ca-bundle"); caBundlePath != "" {
+				caBundle, err := ioutil.ReadFile(ExpandDir(caBundlePath))
+				if err != nil {
+					return errors.Wrap(err, "failed to read ca bundle")
+				}
+				downloadOptions.CABundle = caBundle
 			}
 
 			downloadPath := filepath.Join(ExpandDir(v.GetString("dest")), appSlug)
-			if err := download.Download(appSlug, downloadPath, downloadOptions); err != nil {
+			if _, err := download.Download(appSlug, downloadPath, downloadOptions); err != nil {
+				if statusErr, ok := errors.Cause(err).(util.HTTPStatusError); ok {
+					switch statusErr.StatusCode {
+					case http.StatusUnauthorized, http.StatusForbidden:
+						return errors.New("authentication failed")
+					case http.StatusNotFound:
+						return errors.Errorf("application %s not found", appSlug)
+					}
+				}
 				return errors.Cause(err)
 			}
 
@@ -66,6 +100,33 @@ func DownloadCmd() *cobra.Command {
 	cmd.Flags().Bool("overwrite", false, "overwrite any local files, if present")
 	cmd.Flags().String("slug", "", "the application slug to download")
 	cmd.Flags().Bool("decrypt-password-values", false, "decrypt password values to plaintext")
+	cmd.Flags().String("decryption-key-file", "", "decrypt password values after downloading with the base64 encoded app encryption key in this file, instead of in kotsadm. the key can also be set in the KOTS_DECRYPTION_KEY env var")
+	cmd.Flags().Bool("resumable", false, "keep a partial download in the temp dir and continue it if the download is interrupted")
+	cmd.Flags().Bool("include-images", false, "write the images that the application uses to images.txt in the download, for mirroring to an airgapped registry")
+	cmd.Flags().String("selector", "", "the label selector of the kotsadm pod, for installs that don't use app=kotsadm")
+	cmd.Flags().Bool("skip-unchanged", false, "don't extract the application again if it hasn't changed since the last download to dest")
+	cmd.Flags().Bool("keep-archive-on-error", false, "keep the downloaded archive in the temp dir if it can't be extracted, so that it can be inspected")
+	cmd.Flags().Int64("max-bytes", 0, "abort the download if the application archive is larger than this many bytes, 0 is unlimited")
+	cmd.Flags().Bool("https", false, "connect to kotsadm with https, for when kotsadm is serving tls")
+	cmd.Flags().String("ca-bundle", "", "path to a pem encoded ca bundle to verify the kotsadm certificate with, when --https is set")
 
 	return cmd
 }
+
+// readDecryptionKey returns the key in decryptionKeyFile, or in the KOTS_DECRYPTION_KEY env var
+func readDecryptionKey(decryptionKeyFile string) (string, error) {
+	envKey := os.Getenv("KOTS_DECRYPTION_KEY")
+	if decryptionKeyFile == "" {
+		return strings.TrimSpace(envKey), nil
+	}
+	if envKey != "" {
+		return "", util.ActionableError{Message: "the decryption key can be set with --decryption-key-file or KOTS_DECRYPTION_KEY, but not both"}
+	}
+
+	b, err := ioutil.ReadFile(ExpandDir(decryptionKeyFile))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read decryption key file")
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
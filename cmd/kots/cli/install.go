@@ -64,7 +64,7 @@ func InstallCmd() *cobra.Command {
 				if len(errs) > 0 {
 					return errors.New(errs[0])
 				}
-			} else {
+			} else if !v.GetBool("generate-namespace") {
 				enteredNamespace, err := promptForNamespace(upstream)
 				if err != nil {
 					return errors.Wrap(err, "failed to prompt for namespace")
@@ -93,23 +93,28 @@ func InstallCmd() *cobra.Command {
 			}
 
 			deployOptions := kotsadmtypes.DeployOptions{
-				Namespace:             namespace,
-				KubernetesConfigFlags: kubernetesConfigFlags,
-				Context:               v.GetString("context"),
-				IncludeShip:           v.GetBool("include-ship"),
-				IncludeGitHub:         v.GetBool("include-github"),
-				SharedPassword:        v.GetString("shared-password"),
-				ServiceType:           v.GetString("service-type"),
-				NodePort:              v.GetInt32("node-port"),
-				Hostname:              v.GetString("hostname"),
-				ApplicationMetadata:   applicationMetadata,
-				License:               license,
+				Namespace:               namespace,
+				KubernetesConfigFlags:   kubernetesConfigFlags,
+				Context:                 v.GetString("context"),
+				IncludeShip:             v.GetBool("include-ship"),
+				IncludeGitHub:           v.GetBool("include-github"),
+				SharedPassword:          v.GetString("shared-password"),
+				ServiceType:             v.GetString("service-type"),
+				NodePort:                v.GetInt32("node-port"),
+				Hostname:                v.GetString("hostname"),
+				ApplicationMetadata:     applicationMetadata,
+				License:                 license,
+				SkipRBACPreflight:       v.GetBool("skip-rbac-check"),
+				GenerateNamespace:       v.GetBool("generate-namespace"),
+				GenerateNamespacePrefix: v.GetString("generate-namespace-prefix"),
 			}
 
 			log.ActionWithoutSpinner("Deploying Admin Console")
-			if err := kotsadm.Deploy(deployOptions); err != nil {
+			deployResult, err := kotsadm.Deploy(deployOptions)
+			if err != nil {
 				return errors.Wrap(err, "failed to deploy")
 			}
+			namespace = deployResult.Namespace
 
 			// port forward
 			clientset, err := k8sutil.GetClientset(kubernetesConfigFlags)
@@ -122,10 +127,15 @@ func InstallCmd() *cobra.Command {
 				return errors.Wrap(err, "failed to wait for web")
 			}
 
+			remotePort, err := k8sutil.KotsadmHTTPPort(clientset, namespace, podName)
+			if err != nil {
+				return errors.Wrap(err, "failed to find kotsadm port")
+			}
+
 			stopCh := make(chan struct{})
 			defer close(stopCh)
 
-			adminConsolePort, errChan, err := k8sutil.PortForward(kubernetesConfigFlags, 8800, 3000, namespace, podName, true, stopCh, log)
+			adminConsolePort, errChan, err := k8sutil.PortForward(kubernetesConfigFlags, 8800, remotePort, namespace, podName, true, stopCh, log)
 			if err != nil {
 				return errors.Wrap(err, "failed to forward port")
 			}
@@ -182,6 +192,9 @@ func InstallCmd() *cobra.Command {
 	cmd.Flags().String("local-path", "", "specify a local-path to test the behavior of rendering a replicated app locally (only supported on replicated app types currently)")
 	cmd.Flags().String("license-file", "", "path to a license file to use when download a replicated app")
 	cmd.Flags().Bool("port-forward", true, "set to false to disable automatic port forward")
+	cmd.Flags().Bool("skip-rbac-check", false, "set to true to skip checking that the current user has the permissions required to deploy the admin console")
+	cmd.Flags().Bool("generate-namespace", false, "set to true to deploy the admin console to a new namespace with a generated name")
+	cmd.Flags().String("generate-namespace-prefix", "kotsadm-", "the prefix of the generated namespace name, when generate-namespace is set")
 
 	cmd.Flags().String("repo", "", "repo uri to use when installing a helm chart")
 	cmd.Flags().StringSlice("set", []string{}, "values to pass to helm when running helm template")
@@ -6,6 +6,7 @@ import (
 
 	"github.com/replicatedhq/kots/pkg/logger"
 	"github.com/replicatedhq/kots/pkg/pull"
+	"github.com/replicatedhq/kots/pkg/util"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -31,13 +32,22 @@ func PullCmd() *cobra.Command {
 			// registry host should not have the scheme (https).  need to
 			// strip it if included or else the rewrite images will fail
 
+			licenseFile := v.GetString("license-file")
+			if licenseFile != "" && os.Getenv("KOTS_LICENSE") != "" {
+				return util.ActionableError{Message: "the license can be set with --license-file or the KOTS_LICENSE env var, but not both"}
+			}
+			if licenseFile != pull.LicenseFileStdin {
+				licenseFile = ExpandDir(licenseFile)
+			}
+
 			pullOptions := pull.PullOptions{
 				HelmRepoURI:         v.GetString("repo"),
 				RootDir:             ExpandDir(v.GetString("rootdir")),
 				Namespace:           v.GetString("namespace"),
 				Downstreams:         v.GetStringSlice("downstream"),
 				LocalPath:           ExpandDir(v.GetString("local-path")),
-				LicenseFile:         ExpandDir(v.GetString("license-file")),
+				LicenseFile:         licenseFile,
+				LicenseData:         []byte(os.Getenv("KOTS_LICENSE")),
 				ExcludeKotsKinds:    v.GetBool("exclude-kots-kinds"),
 				ExcludeAdminConsole: v.GetBool("exclude-admin-console"),
 				SharedPassword:      v.GetString("shared-password"),
@@ -77,7 +87,7 @@ func PullCmd() *cobra.Command {
 	cmd.Flags().StringP("namespace", "n", "default", "namespace to render the upstream to in the base")
 	cmd.Flags().StringSlice("downstream", []string{}, "the list of any downstreams to create/update")
 	cmd.Flags().String("local-path", "", "specify a local-path to pull a locally available replicated app (only supported on replicated app types currently)")
-	cmd.Flags().String("license-file", "", "path to a license file to use when download a replicated app")
+	cmd.Flags().String("license-file", "", "path to a license file to use when download a replicated app, or - to read it from stdin. the license can also be set in the KOTS_LICENSE env var")
 	cmd.Flags().Bool("exclude-kots-kinds", true, "set to true to exclude rendering kots custom objects to the base directory")
 	cmd.Flags().Bool("exclude-admin-console", false, "set to true to exclude the admin console (replicated apps only)")
 	cmd.Flags().String("shared-password", "", "shared password to use when deploying the admin console")
@@ -1,11 +1,9 @@
 package cli
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/pkg/errors"
-	"github.com/replicatedhq/kots/pkg/logger"
 	"github.com/replicatedhq/kots/pkg/upload"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -29,8 +27,6 @@ func UploadCmd() *cobra.Command {
 				os.Exit(1)
 			}
 
-			log := logger.NewLogger()
-
 			sourceDir := homeDir()
 			if len(args) > 0 {
 				sourceDir = ExpandDir(args[0])
@@ -42,29 +38,8 @@ func UploadCmd() *cobra.Command {
 				ExistingAppSlug:       v.GetString("slug"),
 				NewAppName:            v.GetString("name"),
 				UpstreamURI:           v.GetString("upstream-uri"),
-				Endpoint:              "http://localhost:3000",
 			}
 
-			stopCh := make(chan struct{})
-			defer close(stopCh)
-
-			localPort, errChan, err := upload.StartPortForward(uploadOptions.Namespace, kubernetesConfigFlags, stopCh, log)
-			if err != nil {
-				return errors.Wrap(err, "failed to port forward")
-			}
-
-			uploadOptions.Endpoint = fmt.Sprintf("http://localhost:%d", localPort)
-			go func() {
-				select {
-				case err := <-errChan:
-					if err != nil {
-						log.Error(err)
-						os.Exit(-1)
-					}
-				case <-stopCh:
-				}
-			}()
-
 			if err := upload.Upload(sourceDir, uploadOptions); err != nil {
 				return errors.Cause(err)
 			}
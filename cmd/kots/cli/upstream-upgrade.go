@@ -46,13 +46,19 @@ func UpstreamUpgradeCmd() *cobra.Command {
 				return errors.Wrap(err, "failed to get clientset")
 			}
 
-			podName, err := k8sutil.FindKotsadm(clientset, v.GetString("namespace"))
+			podName, err := k8sutil.FindKotsadm(clientset, v.GetString("namespace"), "")
 			if err != nil {
 				log.FinishSpinnerWithError()
 				return errors.Wrap(err, "failed to find kotsadm pod")
 			}
 
-			localPort, errChan, err := k8sutil.PortForward(kubernetesConfigFlags, 0, 3000, v.GetString("namespace"), podName, false, stopCh, log)
+			remotePort, err := k8sutil.KotsadmHTTPPort(clientset, v.GetString("namespace"), podName)
+			if err != nil {
+				log.FinishSpinnerWithError()
+				return errors.Wrap(err, "failed to find kotsadm port")
+			}
+
+			localPort, errChan, err := k8sutil.PortForward(kubernetesConfigFlags, 0, remotePort, v.GetString("namespace"), podName, false, stopCh, log)
 			if err != nil {
 				log.FinishSpinnerWithError()
 				return errors.Wrap(err, "failed to start port forwarding")
@@ -22,6 +22,7 @@ import (
 
 // AirgapSpec defines the desired state of AirgapSpec
 type AirgapSpec struct {
+	AppSlug      string `json:"appSlug,omitempty"`
 	VersionLabel string `json:"versionLabel,omitempty"`
 	ReleaseNotes string `json:"releaseNotes,omitempty"`
 	UpdateCursor string `json:"updateCursor,omitempty"`
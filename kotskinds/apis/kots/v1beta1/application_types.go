@@ -56,6 +56,7 @@ type ApplicationSpec struct {
 	AdditionalImages             []string          `json:"additionalImages,omitempty"`
 	AdditionalNamespaces         []string          `json:"additionalNamespaces,omitempty"`
 	RequireMinimalRBACPrivileges bool              `json:"requireMinimalRBACPrivileges"`
+	RequiredEntitlements         []string          `json:"requiredEntitlements,omitempty"`
 }
 
 type ApplicationPort struct {
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/replicatedhq/kots/pkg/kotsadm/types"
@@ -11,37 +12,132 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 )
 
 const KotsadmAuthstringSecretName = "kotsadm-authstring"
 const KotsadmAuthstringSecretKey = "kotsadm-authstring"
 
-var authSlugCache string
+var (
+	authSlugCacheMtx sync.Mutex
+	authSlugCache    = map[authSlugCacheKey]string{}
+	authSlugOverride string
+)
+
+// authSlugCacheKey identifies the kotsadm that an auth slug is for, by the kubeconfig and context that
+// are used to reach the cluster and the namespace that kotsadm is in
+type authSlugCacheKey struct {
+	kubeconfig string
+	context    string
+	namespace  string
+}
+
+func newAuthSlugCacheKey(kubernetesConfigFlags *genericclioptions.ConfigFlags, namespace string) authSlugCacheKey {
+	key := authSlugCacheKey{namespace: namespace}
+	if kubernetesConfigFlags == nil {
+		return key
+	}
+
+	if kubernetesConfigFlags.KubeConfig != nil {
+		key.kubeconfig = *kubernetesConfigFlags.KubeConfig
+	}
+	if kubernetesConfigFlags.Context != nil && *kubernetesConfigFlags.Context != "" {
+		key.context = *kubernetesConfigFlags.Context
+	} else if rawConfig, err := kubernetesConfigFlags.ToRawKubeConfigLoader().RawConfig(); err == nil {
+		key.context = rawConfig.CurrentContext
+	}
+	return key
+}
+
+func getCachedAuthSlug(key authSlugCacheKey) string {
+	authSlugCacheMtx.Lock()
+	defer authSlugCacheMtx.Unlock()
+
+	if authSlugOverride != "" {
+		return authSlugOverride
+	}
+	return authSlugCache[key]
+}
+
+func setCachedAuthSlug(key authSlugCacheKey, authSlug string) {
+	authSlugCacheMtx.Lock()
+	defer authSlugCacheMtx.Unlock()
+
+	if authSlug == "" {
+		delete(authSlugCache, key)
+		return
+	}
+	authSlugCache[key] = authSlug
+}
 
-// SetAuthSlugCache sets the auth slug to be used instead of querying kubernetes
+// SetAuthSlugCache sets the auth slug to be used instead of querying kubernetes, for every cluster and namespace
 // this improves run speed by reducing the number of queries, and also allows testing without a kubernetes cluster available
+// an empty value clears it, along with the auth slugs that were cached for each namespace
 func SetAuthSlugCache(newval string) {
-	authSlugCache = newval
+	authSlugCacheMtx.Lock()
+	defer authSlugCacheMtx.Unlock()
+
+	authSlugOverride = newval
+	if newval == "" {
+		authSlugCache = map[authSlugCacheKey]string{}
+	}
+}
+
+// RefreshAuthSlug clears the cached auth slug and reads it from the cluster again, for when kotsadm
+// rejects the cached one because it has been rotated. An auth slug that was set with SetAuthSlugCache
+// is kept and returned.
+func RefreshAuthSlug(kubernetesConfigFlags *genericclioptions.ConfigFlags, namespace string) (string, error) {
+	key := newAuthSlugCacheKey(kubernetesConfigFlags, namespace)
+	setCachedAuthSlug(key, "")
+
+	return GetOrCreateAuthSlug(kubernetesConfigFlags, namespace)
 }
 
 // GetOrCreateAuthSlug will check for an authslug secret in the provided namespace
 // if one exists, it will return the value from that secret
 // if none exists, it will create one and return that value
 func GetOrCreateAuthSlug(kubernetesConfigFlags *genericclioptions.ConfigFlags, namespace string) (string, error) {
-	if authSlugCache != "" {
-		return authSlugCache, nil
+	key := newAuthSlugCacheKey(kubernetesConfigFlags, namespace)
+	if authSlug := getCachedAuthSlug(key); authSlug != "" {
+		return authSlug, nil
+	}
+
+	clientset, err := getClientset(kubernetesConfigFlags)
+	if err != nil {
+		return "", err
 	}
 
+	return getOrCreateAuthSlug(clientset, key)
+}
+
+// RotateAuthSlug replaces the auth slug in the namespace with a new one and returns it. The old auth slug
+// is no longer accepted once kotsadm reads the secret again. When another caller rotates the auth slug at
+// the same time, the auth slug that it wrote is returned instead, so that both callers have the same one.
+func RotateAuthSlug(kubernetesConfigFlags *genericclioptions.ConfigFlags, namespace string) (string, error) {
+	clientset, err := getClientset(kubernetesConfigFlags)
+	if err != nil {
+		return "", err
+	}
+
+	return rotateAuthSlug(clientset, newAuthSlugCacheKey(kubernetesConfigFlags, namespace))
+}
+
+func getClientset(kubernetesConfigFlags *genericclioptions.ConfigFlags) (kubernetes.Interface, error) {
 	cfg, err := kubernetesConfigFlags.ToRESTConfig()
 	if err != nil {
-		return "", errors.Wrap(err, "failed to convert kube flags to rest config")
+		return nil, errors.Wrap(err, "failed to convert kube flags to rest config")
 	}
 
 	clientset, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to create kubernetes clientset")
+		return nil, errors.Wrap(err, "failed to create kubernetes clientset")
 	}
 
+	return clientset, nil
+}
+
+func getOrCreateAuthSlug(clientset kubernetes.Interface, key authSlugCacheKey) (string, error) {
+	namespace := key.namespace
 	existingSecret, err := clientset.CoreV1().Secrets(namespace).Get(KotsadmAuthstringSecretName, metav1.GetOptions{})
 	if err != nil {
 		if !kuberneteserrors.IsNotFound(err) {
@@ -50,30 +146,93 @@ func GetOrCreateAuthSlug(kubernetesConfigFlags *genericclioptions.ConfigFlags, n
 		}
 
 		// secret does not yet exist, so we need to generate a random key and create the secret from that
-		newAuthstring := "Kots " + util.GenPassword(32)
-		newSecret := v1.Secret{
-			TypeMeta: metav1.TypeMeta{
-				APIVersion: "v1",
-				Kind:       "Secret",
-			},
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      KotsadmAuthstringSecretName,
-				Namespace: namespace,
-				Labels: map[string]string{
-					types.KotsadmKey: types.KotsadmLabelValue,
-				},
-			},
-			StringData: map[string]string{KotsadmAuthstringSecretKey: newAuthstring},
-		}
-
-		_, err := clientset.CoreV1().Secrets(namespace).Create(&newSecret)
-		if err != nil {
+		newAuthstring := newAuthSlug()
+		_, err := clientset.CoreV1().Secrets(namespace).Create(authSlugSecret(namespace, newAuthstring))
+		if kuberneteserrors.IsAlreadyExists(err) {
+			// another caller created it first, use theirs
+			return getOrCreateAuthSlug(clientset, key)
+		} else if err != nil {
 			return "", errors.Wrap(err, "failed to create new kotsadm authstring secret")
 		}
-		SetAuthSlugCache(newAuthstring)
+		setCachedAuthSlug(key, newAuthstring)
 		return newAuthstring, nil
 	}
 
-	SetAuthSlugCache(string(existingSecret.Data[KotsadmAuthstringSecretKey]))
+	setCachedAuthSlug(key, string(existingSecret.Data[KotsadmAuthstringSecretKey]))
 	return string(existingSecret.Data[KotsadmAuthstringSecretKey]), nil
 }
+
+func rotateAuthSlug(clientset kubernetes.Interface, key authSlugCacheKey) (string, error) {
+	namespace := key.namespace
+	newAuthstring := newAuthSlug()
+
+	existingSecret, err := clientset.CoreV1().Secrets(namespace).Get(KotsadmAuthstringSecretName, metav1.GetOptions{})
+	if err != nil {
+		if !kuberneteserrors.IsNotFound(err) {
+			return "", errors.Wrap(err, "failed to get kotsadm authstring secret")
+		}
+
+		_, err := clientset.CoreV1().Secrets(namespace).Create(authSlugSecret(namespace, newAuthstring))
+		if kuberneteserrors.IsAlreadyExists(err) {
+			return getOrCreateAuthSlug(clientset, key)
+		} else if err != nil {
+			return "", errors.Wrap(err, "failed to create kotsadm authstring secret")
+		}
+		setCachedAuthSlug(key, newAuthstring)
+		return newAuthstring, nil
+	}
+	previousAuthstring := string(existingSecret.Data[KotsadmAuthstringSecretKey])
+
+	// the update only succeeds if the secret hasn't changed since it was read. after a conflict, the auth
+	// slug that another caller rotated to is kept, and other changes to the secret are retried.
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		updatedSecret := existingSecret.DeepCopy()
+		if updatedSecret.Data == nil {
+			updatedSecret.Data = map[string][]byte{}
+		}
+		updatedSecret.Data[KotsadmAuthstringSecretKey] = []byte(newAuthstring)
+
+		_, err := clientset.CoreV1().Secrets(namespace).Update(updatedSecret)
+		if !kuberneteserrors.IsConflict(err) {
+			return err
+		}
+
+		currentSecret, getErr := clientset.CoreV1().Secrets(namespace).Get(KotsadmAuthstringSecretName, metav1.GetOptions{})
+		if getErr != nil {
+			return errors.Wrap(getErr, "failed to get kotsadm authstring secret")
+		}
+		if currentAuthstring := string(currentSecret.Data[KotsadmAuthstringSecretKey]); currentAuthstring != previousAuthstring {
+			newAuthstring = currentAuthstring
+			return nil
+		}
+		existingSecret = currentSecret
+		return err
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to update kotsadm authstring secret")
+	}
+
+	setCachedAuthSlug(key, newAuthstring)
+	return newAuthstring, nil
+}
+
+func newAuthSlug() string {
+	return "Kots " + util.GenPassword(32)
+}
+
+func authSlugSecret(namespace string, authSlug string) *v1.Secret {
+	return &v1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Secret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      KotsadmAuthstringSecretName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				types.KotsadmKey: types.KotsadmLabelValue,
+			},
+		},
+		StringData: map[string]string{KotsadmAuthstringSecretKey: authSlug},
+	}
+}
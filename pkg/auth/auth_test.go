@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+	v1 "k8s.io/api/core/v1"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func existingAuthSlugSecret(authSlug string) *v1.Secret {
+	secret := authSlugSecret("default", authSlug)
+	secret.StringData = nil
+	secret.Data = map[string][]byte{KotsadmAuthstringSecretKey: []byte(authSlug)}
+	return secret
+}
+
+func Test_rotateAuthSlug(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+	defer SetAuthSlugCache("")
+
+	clientset := fake.NewSimpleClientset(existingAuthSlugSecret("Kots old-slug"))
+
+	// kotsadm accepts the auth slug that's in the secret when the request is made
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, err := clientset.CoreV1().Secrets("default").Get(KotsadmAuthstringSecretName, metav1.GetOptions{})
+		if err != nil || r.Header.Get("Authorization") != string(secret.Data[KotsadmAuthstringSecretKey]) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	statusWith := func(authSlug string) int {
+		r, err := http.NewRequest("GET", server.URL, nil)
+		req.NoError(err)
+		r.Header.Set("Authorization", authSlug)
+		resp, err := http.DefaultClient.Do(r)
+		req.NoError(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	key := authSlugCacheKey{namespace: "default"}
+	oldAuthSlug, err := getOrCreateAuthSlug(clientset, key)
+	req.NoError(err)
+	assert.Equal(t, "Kots old-slug", oldAuthSlug)
+	assert.Equal(t, http.StatusOK, statusWith(oldAuthSlug))
+
+	newAuthSlug, err := rotateAuthSlug(clientset, key)
+	req.NoError(err)
+	assert.NotEqual(t, oldAuthSlug, newAuthSlug)
+	assert.Equal(t, newAuthSlug, getCachedAuthSlug(key))
+
+	assert.Equal(t, http.StatusUnauthorized, statusWith(oldAuthSlug))
+	assert.Equal(t, http.StatusOK, statusWith(newAuthSlug))
+}
+
+func Test_rotateAuthSlugConcurrent(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+	defer SetAuthSlugCache("")
+
+	secretsResource := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+	// another caller rotates the auth slug between the get and the update
+	clientset := fake.NewSimpleClientset(existingAuthSlugSecret("Kots old-slug"))
+	updates := 0
+	clientset.PrependReactor("update", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		if updates > 1 {
+			return false, nil, nil
+		}
+		if err := clientset.Tracker().Update(secretsResource, existingAuthSlugSecret("Kots other-slug"), "default"); err != nil {
+			return true, nil, err
+		}
+		return true, nil, kuberneteserrors.NewConflict(secretsResource.GroupResource(), KotsadmAuthstringSecretName, nil)
+	})
+
+	authSlug, err := rotateAuthSlug(clientset, authSlugCacheKey{namespace: "default"})
+	req.NoError(err)
+	assert.Equal(t, "Kots other-slug", authSlug)
+	assert.Equal(t, 1, updates)
+
+	// a conflict from a change that isn't a rotation is retried
+	secret := existingAuthSlugSecret("Kots old-slug")
+	clientset = fake.NewSimpleClientset(secret)
+	updates = 0
+	clientset.PrependReactor("update", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		updates++
+		if updates > 1 {
+			return false, nil, nil
+		}
+		labeled := secret.DeepCopy()
+		labeled.Labels["other"] = "label"
+		if err := clientset.Tracker().Update(secretsResource, labeled, "default"); err != nil {
+			return true, nil, err
+		}
+		return true, nil, kuberneteserrors.NewConflict(secretsResource.GroupResource(), KotsadmAuthstringSecretName, nil)
+	})
+
+	authSlug, err = rotateAuthSlug(clientset, authSlugCacheKey{namespace: "default"})
+	req.NoError(err)
+	assert.NotEqual(t, "Kots old-slug", authSlug)
+	assert.Equal(t, 2, updates)
+
+	current, err := clientset.CoreV1().Secrets("default").Get(KotsadmAuthstringSecretName, metav1.GetOptions{})
+	req.NoError(err)
+	assert.Equal(t, authSlug, string(current.Data[KotsadmAuthstringSecretKey]))
+	assert.Equal(t, "label", current.Labels["other"])
+}
+
+func Test_authSlugCache(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+	defer SetAuthSlugCache("")
+
+	// each cluster and namespace has its own auth slug
+	firstCluster := authSlugCacheKey{context: "first", namespace: "default"}
+	secondCluster := authSlugCacheKey{context: "second", namespace: "default"}
+	otherNamespace := authSlugCacheKey{context: "first", namespace: "other"}
+
+	firstSlug, err := getOrCreateAuthSlug(fake.NewSimpleClientset(existingAuthSlugSecret("Kots first-slug")), firstCluster)
+	req.NoError(err)
+	assert.Equal(t, "Kots first-slug", firstSlug)
+	secondSlug, err := getOrCreateAuthSlug(fake.NewSimpleClientset(existingAuthSlugSecret("Kots second-slug")), secondCluster)
+	req.NoError(err)
+	assert.Equal(t, "Kots second-slug", secondSlug)
+
+	assert.Equal(t, "Kots first-slug", getCachedAuthSlug(firstCluster))
+	assert.Equal(t, "Kots second-slug", getCachedAuthSlug(secondCluster))
+	assert.Equal(t, "", getCachedAuthSlug(otherNamespace))
+
+	// the cache can be used from multiple goroutines
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := authSlugCacheKey{context: fmt.Sprintf("cluster-%d", i), namespace: "default"}
+			setCachedAuthSlug(key, fmt.Sprintf("Kots slug-%d", i))
+			assert.Equal(t, fmt.Sprintf("Kots slug-%d", i), getCachedAuthSlug(key))
+		}(i)
+	}
+	wg.Wait()
+
+	// an override is used for every cluster, until it's cleared along with the cache
+	SetAuthSlugCache("Kots override")
+	assert.Equal(t, "Kots override", getCachedAuthSlug(firstCluster))
+	assert.Equal(t, "Kots override", getCachedAuthSlug(otherNamespace))
+
+	// refreshing doesn't clear the override
+	authSlug, err := RefreshAuthSlug(nil, "default")
+	req.NoError(err)
+	assert.Equal(t, "Kots override", authSlug)
+
+	SetAuthSlugCache("")
+	assert.Equal(t, "", getCachedAuthSlug(firstCluster))
+}
@@ -112,3 +112,23 @@ func (c *AESCipher) Decrypt(in []byte) (result []byte, err error) {
 	result, err = c.cipher.Open(nil, c.nonce, in, nil)
 	return
 }
+
+// DecryptBase64 decrypts a base64 encoded value that was encrypted with the cipher, such as the value of
+// a password config item
+func (c *AESCipher) DecryptBase64(input string) (string, error) {
+	if c == nil {
+		return "", errors.New("cipher not defined")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(input)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to base64 decode")
+	}
+
+	decrypted, err := c.Decrypt(decoded)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt")
+	}
+
+	return string(decrypted), nil
+}
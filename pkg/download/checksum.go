@@ -0,0 +1,86 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ChecksumFile is the name of the file that DownloadOptions.SkipUnchanged stores the sha256 of the
+// downloaded archive in
+const ChecksumFile = ".kots-checksum"
+
+// fileChecksum returns the hex encoded sha256 of the file
+func fileChecksum(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open file")
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrap(err, "failed to hash file")
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// readChecksum returns the checksum stored in path by a previous download, or "" if there isn't one
+func readChecksum(path string) (string, error) {
+	checksum, err := ioutil.ReadFile(filepath.Join(path, ChecksumFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read checksum file")
+	}
+
+	return strings.TrimSpace(string(checksum)), nil
+}
+
+func writeChecksum(path string, checksum string) error {
+	if err := ioutil.WriteFile(filepath.Join(path, ChecksumFile), []byte(checksum+"\n"), 0644); err != nil {
+		return errors.Wrap(err, "failed to write checksum file")
+	}
+	return nil
+}
+
+// listDownloadedFiles returns the sorted paths of the files in path, relative to path, in the same
+// form that Download returns them. The checksum file isn't included.
+func listDownloadedFiles(path string) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(path, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ChecksumFile {
+			return nil
+		}
+
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list downloaded files")
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,80 @@
+package download
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_downloadSkipUnchanged(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	newArchive := func(content string) []byte {
+		var archive bytes.Buffer
+		gzw := gzip.NewWriter(&archive)
+		tw := tar.NewWriter(gzw)
+		req.NoError(tw.WriteHeader(&tar.Header{Name: "upstream/app.yaml", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		req.NoError(err)
+		req.NoError(tw.Close())
+		req.NoError(gzw.Close())
+		return archive.Bytes()
+	}
+
+	archive := newArchive("first")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "app")
+	appFile := filepath.Join(path, "upstream", "app.yaml")
+	downloadOptions := DownloadOptions{Silent: true, SkipUnchanged: true, Overwrite: true}
+	conn := testKotsadmConnection(t, server)
+
+	files, _, err := download("my-app", path, downloadOptions, conn)
+	req.NoError(err)
+	assert.Equal(t, []string{"upstream/app.yaml"}, files)
+	_, err = os.Stat(filepath.Join(path, ChecksumFile))
+	req.NoError(err)
+
+	// an unchanged archive isn't extracted again
+	req.NoError(ioutil.WriteFile(appFile, []byte("edited"), 0644))
+	files, _, err = download("my-app", path, downloadOptions, conn)
+	req.NoError(err)
+	assert.Equal(t, []string{"upstream/app.yaml"}, files)
+	content, err := ioutil.ReadFile(appFile)
+	req.NoError(err)
+	assert.Equal(t, "edited", string(content))
+
+	archive = newArchive("second")
+	_, _, err = download("my-app", path, downloadOptions, conn)
+	req.NoError(err)
+	content, err = ioutil.ReadFile(appFile)
+	req.NoError(err)
+	assert.Equal(t, "second", string(content))
+
+	checksum, err := readChecksum(path)
+	req.NoError(err)
+	archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+	req.NoError(ioutil.WriteFile(archivePath, archive, 0644))
+	expected, err := fileChecksum(archivePath)
+	req.NoError(err)
+	assert.Equal(t, expected, checksum)
+}
@@ -0,0 +1,34 @@
+package download
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// debugTrail writes the connection details of a download to DownloadOptions.DebugWriter. Nothing is
+// written when the writer is nil. The auth slug is never written, only whether one was sent.
+type debugTrail struct {
+	w     io.Writer
+	start time.Time
+}
+
+func newDebugTrail(w io.Writer) *debugTrail {
+	return &debugTrail{w: w, start: time.Now()}
+}
+
+// printf writes a line prefixed with the time since the download started
+func (d *debugTrail) printf(format string, args ...interface{}) {
+	if d.w == nil {
+		return
+	}
+	elapsed := time.Since(d.start).Round(time.Millisecond)
+	fmt.Fprintf(d.w, "[%8s] %s\n", elapsed, fmt.Sprintf(format, args...))
+}
+
+func redactedAuthorization(authSlug string) string {
+	if authSlug == "" {
+		return "none"
+	}
+	return "present (redacted)"
+}
@@ -0,0 +1,57 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_fetchArchiveDebugWriter(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte("archive"))
+	}))
+	defer server.Close()
+
+	conn := testKotsadmConnection(t, server)
+	conn.PodName = "kotsadm-5d8f7c-abcde"
+	conn.RemotePort = 3000
+
+	debug := bytes.NewBuffer(nil)
+	downloadOptions := DownloadOptions{Silent: true, Namespace: "default", DebugWriter: debug}
+
+	_, err := fetchArchive("my-app", downloadOptions, conn, getLogger(downloadOptions), nil, func(resp *http.Response, archive io.Reader) error {
+		_, err := ioutil.ReadAll(archive)
+		return err
+	})
+	req.NoError(err)
+
+	trail := debug.String()
+	assert.Contains(t, trail, fmt.Sprintf(`forwarding localhost:%d to port 3000 of pod kotsadm-5d8f7c-abcde in namespace "default"`, conn.LocalPort))
+	assert.Contains(t, trail, fmt.Sprintf("GET http://localhost:%d/api/v1/download?slug=my-app, authorization present (redacted)", conn.LocalPort))
+	assert.Contains(t, trail, "kotsadm responded with 200 OK")
+	assert.Contains(t, trail, "transferred 7 bytes")
+	assert.NotContains(t, trail, "auth-slug")
+
+	debug.Reset()
+	status = http.StatusInternalServerError
+	_, err = fetchArchive("my-app", downloadOptions, conn, getLogger(downloadOptions), nil, func(resp *http.Response, archive io.Reader) error {
+		return nil
+	})
+	req.Error(err)
+	assert.Contains(t, debug.String(), "kotsadm responded with 500 Internal Server Error")
+	assert.NotContains(t, debug.String(), "auth-slug")
+}
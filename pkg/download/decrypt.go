@@ -0,0 +1,167 @@
+package download
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/crypto"
+	"github.com/replicatedhq/kots/pkg/util"
+	"gopkg.in/yaml.v2"
+)
+
+var yamlDocSeparatorRegexp = regexp.MustCompile(`(?m)^---[ \t]*$\n?`)
+
+// configDoc is the part of a kots.io/v1beta1 Config that's needed to find its password items
+type configDoc struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Spec       struct {
+		Groups []struct {
+			Items []struct {
+				Name string `yaml:"name"`
+				Type string `yaml:"type"`
+			} `yaml:"items"`
+		} `yaml:"groups"`
+	} `yaml:"spec"`
+}
+
+// decryptPasswordValues decrypts the values of the password items in the ConfigValues files that were
+// extracted to path, and returns how many were decrypted. The password items are the ones that have the
+// password type in the extracted Config, so nothing is decrypted if OnlyPaths left it out.
+func decryptPasswordValues(path string, files []string, decryptionKey string) (int, error) {
+	cipher, err := crypto.AESCipherFromString(decryptionKey)
+	if err != nil {
+		return 0, util.ActionableError{Message: "the decryption key is invalid, it must be the base64 encoded encryption key of the app"}
+	}
+
+	yamlFiles := []string{}
+	for _, file := range files {
+		if ext := strings.ToLower(filepath.Ext(file)); ext == ".yaml" || ext == ".yml" {
+			yamlFiles = append(yamlFiles, file)
+		}
+	}
+
+	passwordItems := map[string]bool{}
+	for _, file := range yamlFiles {
+		content, err := ioutil.ReadFile(filepath.Join(path, file))
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to read %s", file)
+		}
+		for _, doc := range yamlDocSeparatorRegexp.Split(string(content), -1) {
+			config := configDoc{}
+			if err := yaml.Unmarshal([]byte(doc), &config); err != nil {
+				continue
+			}
+			if config.APIVersion != "kots.io/v1beta1" || config.Kind != "Config" {
+				continue
+			}
+			for _, group := range config.Spec.Groups {
+				for _, item := range group.Items {
+					if item.Type == "password" {
+						passwordItems[item.Name] = true
+					}
+				}
+			}
+		}
+	}
+	if len(passwordItems) == 0 {
+		return 0, nil
+	}
+
+	decrypted := 0
+	for _, file := range yamlFiles {
+		n, err := decryptConfigValuesFile(filepath.Join(path, file), passwordItems, cipher)
+		if err != nil {
+			return decrypted, errors.Wrapf(err, "failed to decrypt password values in %s", file)
+		}
+		decrypted += n
+	}
+
+	return decrypted, nil
+}
+
+// decryptConfigValuesFile decrypts the password values of the ConfigValues documents in filename, and
+// rewrites the file if any were decrypted. The rewritten file is only readable by the owner.
+func decryptConfigValuesFile(filename string, passwordItems map[string]bool, cipher *crypto.AESCipher) (int, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read file")
+	}
+
+	docs := yamlDocSeparatorRegexp.Split(string(content), -1)
+	decrypted := 0
+	for i, doc := range docs {
+		obj := yaml.MapSlice{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			continue
+		}
+		if mapSliceValue(obj, "apiVersion") != "kots.io/v1beta1" || mapSliceValue(obj, "kind") != "ConfigValues" {
+			continue
+		}
+		spec, _ := mapSliceValue(obj, "spec").(yaml.MapSlice)
+		values, _ := mapSliceValue(spec, "values").(yaml.MapSlice)
+
+		n := 0
+		for i, value := range values {
+			name, _ := value.Key.(string)
+			item, _ := value.Value.(yaml.MapSlice)
+			if !passwordItems[name] {
+				continue
+			}
+			encrypted, _ := mapSliceValue(item, "value").(string)
+			if encrypted == "" {
+				continue
+			}
+			plaintext, err := cipher.DecryptBase64(encrypted)
+			if err != nil {
+				return 0, errors.Wrapf(err, "failed to decrypt the value of %s", name)
+			}
+
+			// value is always encrypted for password items, so the decrypted value replaces it as valuePlaintext
+			decryptedItem := yaml.MapSlice{}
+			for _, field := range item {
+				if field.Key != "value" && field.Key != "valuePlaintext" {
+					decryptedItem = append(decryptedItem, field)
+				}
+			}
+			values[i].Value = append(decryptedItem, yaml.MapItem{Key: "valuePlaintext", Value: plaintext})
+			n++
+		}
+		if n == 0 {
+			continue
+		}
+
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to marshal config values")
+		}
+		docs[i] = string(b)
+		decrypted += n
+	}
+
+	if decrypted == 0 {
+		return 0, nil
+	}
+	// WriteFile only sets the mode of new files, so the mode is changed before the plaintext is written
+	if err := os.Chmod(filename, 0600); err != nil {
+		return 0, errors.Wrap(err, "failed to set file mode")
+	}
+	if err := ioutil.WriteFile(filename, []byte(strings.Join(docs, "---\n")), 0600); err != nil {
+		return 0, errors.Wrap(err, "failed to write file")
+	}
+
+	return decrypted, nil
+}
+
+func mapSliceValue(m yaml.MapSlice, key string) interface{} {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package download
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/crypto"
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_decryptPasswordValues(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	cipher, err := crypto.NewAESCipher()
+	req.NoError(err)
+	encrypted := base64.StdEncoding.EncodeToString(cipher.Encrypt([]byte("hunter2")))
+
+	dir, err := ioutil.TempDir("", "kots-decrypt")
+	req.NoError(err)
+	defer os.RemoveAll(dir)
+
+	config := `apiVersion: kots.io/v1beta1
+kind: Config
+metadata:
+  name: config
+spec:
+  groups:
+  - name: database
+    items:
+    - name: db_password
+      type: password
+    - name: db_host
+      type: text
+`
+	configValues := `apiVersion: kots.io/v1beta1
+kind: ConfigValues
+metadata:
+  name: my-app
+spec:
+  values:
+    db_host:
+      value: ` + encrypted + `
+    db_password:
+      value: ` + encrypted + `
+`
+	req.NoError(os.MkdirAll(filepath.Join(dir, "upstream", "userdata"), 0755))
+	req.NoError(ioutil.WriteFile(filepath.Join(dir, "upstream", "config.yaml"), []byte(config), 0644))
+	req.NoError(ioutil.WriteFile(filepath.Join(dir, "upstream", "userdata", "config.yaml"), []byte(configValues), 0644))
+	files := []string{"upstream/config.yaml", "upstream/userdata/config.yaml"}
+
+	// a key that isn't the one the values were encrypted with
+	otherCipher, err := crypto.NewAESCipher()
+	req.NoError(err)
+	_, err = decryptPasswordValues(dir, files, otherCipher.ToString())
+	req.Error(err)
+	assert.Contains(t, err.Error(), "db_password")
+
+	_, err = decryptPasswordValues(dir, files, "not-a-key")
+	req.Error(err)
+	assert.IsType(t, util.ActionableError{}, err)
+
+	decrypted, err := decryptPasswordValues(dir, files, cipher.ToString())
+	req.NoError(err)
+	assert.Equal(t, 1, decrypted)
+
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "upstream", "userdata", "config.yaml"))
+	req.NoError(err)
+	assert.Equal(t, `apiVersion: kots.io/v1beta1
+kind: ConfigValues
+metadata:
+  name: my-app
+spec:
+  values:
+    db_host:
+      value: `+encrypted+`
+    db_password:
+      valuePlaintext: hunter2
+`, string(contents))
+
+	// the plaintext values are only readable by the owner
+	fi, err := os.Stat(filepath.Join(dir, "upstream", "userdata", "config.yaml"))
+	req.NoError(err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// the config is unchanged
+	contents, err = ioutil.ReadFile(filepath.Join(dir, "upstream", "config.yaml"))
+	req.NoError(err)
+	assert.Equal(t, config, string(contents))
+}
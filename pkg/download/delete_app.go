@@ -0,0 +1,81 @@
+package download
+
+import (
+	"fmt"
+	"net/http"
+	neturl "net/url"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/kotsadmclient"
+	"github.com/replicatedhq/kots/pkg/logger"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// DeleteApp removes the app from kotsadm, connecting in the same way as Download. Deleting an app
+// that doesn't exist is not an error.
+func DeleteApp(appSlug string, downloadOptions DownloadOptions) error {
+	if err := validateAppSlug(appSlug); err != nil {
+		return err
+	}
+
+	log := getLogger(downloadOptions)
+
+	log.ActionWithSpinner("Connecting to cluster")
+	conn, err := connectToKotsadm(downloadOptions, log)
+	if err != nil {
+		log.FinishSpinnerWithError()
+		return err
+	}
+	defer conn.Close()
+
+	if err := deleteApp(appSlug, downloadOptions, conn, log); err != nil {
+		log.FinishSpinnerWithError()
+		return err
+	}
+
+	log.FinishSpinner()
+
+	return nil
+}
+
+func deleteApp(appSlug string, downloadOptions DownloadOptions, conn *kotsadmclient.Client, log logger.Interface) error {
+	url := conn.URL(fmt.Sprintf("/api/v1/app/%s", neturl.PathEscape(appSlug)))
+
+	newRequest := func(authSlug string) (*http.Request, error) {
+		req, err := http.NewRequest("DELETE", url, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create delete request")
+		}
+		req = req.WithContext(conn.Context())
+		req.Header.Add("Authorization", authSlug)
+		req.Header.Set("User-Agent", getUserAgent(downloadOptions))
+
+		log.Debug("Requesting DELETE %s (Authorization redacted)", url)
+		return req, nil
+	}
+
+	resp, err := conn.Do(newRequest)
+	if err != nil {
+		return conn.PortForwardError(errors.Wrap(err, "failed to delete from kotsadm"))
+	}
+	defer resp.Body.Close()
+
+	log.Debug("kotsadm responded with %s", resp.Status)
+
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		log.Debug("App %s does not exist, nothing to delete", appSlug)
+		return nil
+	case resp.StatusCode == http.StatusConflict:
+		statusErr := util.NewHTTPStatusError(url, resp)
+		return util.ActionableError{
+			Message: fmt.Sprintf("App %s can't be deleted in its current state: %s", appSlug, statusErr.Body),
+		}
+	case kotsadmclient.IsAuthError(resp.StatusCode):
+		return errors.Wrap(util.NewHTTPStatusError(url, resp), "authentication to kotsadm failed")
+	default:
+		return errors.Wrap(util.NewHTTPStatusError(url, resp), "failed to delete app")
+	}
+}
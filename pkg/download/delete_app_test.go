@@ -0,0 +1,70 @@
+package download
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_deleteApp(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		wantErr    string
+	}{
+		{
+			name:       "deleted",
+			statusCode: http.StatusNoContent,
+		},
+		{
+			name:       "already gone",
+			statusCode: http.StatusNotFound,
+		},
+		{
+			name:       "conflict",
+			statusCode: http.StatusConflict,
+			body:       "app has a deployment in progress",
+			wantErr:    "App my-app can't be deleted in its current state: app has a deployment in progress",
+		},
+		{
+			name:       "server error",
+			statusCode: http.StatusInternalServerError,
+			wantErr:    "failed to delete app",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "DELETE", r.Method)
+				assert.Equal(t, "/api/v1/app/my-app", r.URL.Path)
+				assert.Equal(t, "auth-slug", r.Header.Get("Authorization"))
+				w.WriteHeader(test.statusCode)
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			downloadOptions := DownloadOptions{Silent: true}
+			err := deleteApp("my-app", downloadOptions, testKotsadmConnection(t, server), getLogger(downloadOptions))
+			if test.wantErr == "" {
+				req.NoError(err)
+				return
+			}
+			req.Error(err)
+			assert.Contains(t, err.Error(), test.wantErr)
+			if test.statusCode == http.StatusConflict {
+				assert.IsType(t, util.ActionableError{}, err)
+			}
+		})
+	}
+}
@@ -1,136 +1,668 @@
 package download
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"compress/zlib"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/mholt/archiver"
 	"github.com/pkg/errors"
-	"github.com/replicatedhq/kots/pkg/auth"
-	"github.com/replicatedhq/kots/pkg/k8sutil"
+	"github.com/replicatedhq/kots/pkg/kotsadmclient"
 	"github.com/replicatedhq/kots/pkg/logger"
+	"github.com/replicatedhq/kots/pkg/retry"
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/replicatedhq/kots/pkg/version"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
 type DownloadOptions struct {
 	Namespace             string
 	KubernetesConfigFlags *genericclioptions.ConfigFlags
+	// KubeconfigPath or KubeconfigBytes connect with an explicit kubeconfig instead of KubernetesConfigFlags
+	KubeconfigPath        string
+	KubeconfigBytes       []byte
 	Overwrite             bool
 	Silent                bool
 	DecryptPasswordValues bool
+	UserAgent             string
+	// Log replaces the default console logger, and Silent and Verbose are ignored when it's set
+	Log logger.Interface
+	// Verbose enables debug logging of the request, response and extracted files
+	Verbose bool
+	// TempDir is where the archive is downloaded to before it's extracted. It defaults to the system temp dir.
+	TempDir string
+	// OnComplete is called with the number of bytes that were transferred and how long it took when a
+	// download finishes, whether it succeeded or not
+	OnComplete func(appSlug string, bytes int64, duration time.Duration, err error)
+	// OnUploadProgress is called with the number of bytes sent so far as Upload sends the app
+	OnUploadProgress func(bytes int64, total int64)
+	// OnlyPaths are path.Match patterns for the archive files to extract, all files are extracted
+	// when it's empty. Patterns without a slash also match the file name in any directory.
+	OnlyPaths []string
+	// Resumable keeps a partial download in resumableArchiveDir and continues from where it
+	// stopped on the next attempt, as long as kotsadm still has the same archive
+	Resumable bool
+	// FlattenTopLevelFolder leaves out the folder of an archive whose files are all under a single
+	// top-level folder, so that its contents are written directly to path instead of to path/<folder>/.
+	// By default the archive is extracted as is. Archives with more than one top-level entry, such as
+	// the upstream, base and overlays of an app, are always extracted as is.
+	FlattenTopLevelFolder bool
+	// IncludeImageList writes the images that the upstream manifests of the app reference to ImageListFile
+	// in the download path, so that they can be mirrored for an airgapped install.
+	IncludeImageList bool
+	// SkipHealthCheck skips checking that kotsadm is ready after connecting, before any request is sent
+	SkipHealthCheck bool
+	// HTTPS and CABundle connect to kotsadm with tls, see kotsadmclient.Options
+	HTTPS    bool
+	CABundle []byte
+	// PodSelector is the label selector of the kotsadm pod to connect to, for installs that don't use
+	// the default of app=kotsadm
+	PodSelector string
+	// KeepArchiveOnError leaves the downloaded archive on disk when it can't be extracted, and includes
+	// its path in the error, so that it can be inspected
+	KeepArchiveOnError bool
+	// SkipUnchanged stores the sha256 of the archive in ChecksumFile in path, and doesn't extract the
+	// archive again when a later download has the same checksum. The files that are already in path are
+	// returned instead. A changed archive still needs Overwrite to replace the existing download.
+	// OnlyPaths and FlattenTopLevelFolder aren't part of the checksum.
+	SkipUnchanged bool
+	// Retry retries the whole download, including connecting to kotsadm, after a network error or a
+	// 5xx or 429 response. It isn't retried by default. Combined with Resumable, a retry continues
+	// the partial archive.
+	Retry retry.Options
+	// MaxBytes aborts the download once more than this many bytes of the archive have been read, or
+	// before reading it when the Content-Length is already larger. A resumed download counts the bytes
+	// that were downloaded before. 0 is unlimited. This is a safety valve for when kotsadm isn't trusted.
+	MaxBytes int64
+	// DebugWriter records the pod, ports and namespace that were used, whether an auth slug was sent,
+	// the request url, the response status and timings, for diagnosing intermittent failures without
+	// enabling debug logging. The auth slug itself is never written.
+	DebugWriter io.Writer
+	// DecryptionKey decrypts the values of password config items in the downloaded ConfigValues on this
+	// side instead of in kotsadm, such as for an archive that has the encrypted values. The decrypted values
+	// are written to valuePlaintext in place of value, as kotsadm does, and the files that have them are
+	// made readable only by the owner. It's the app's
+	// encryption key as in spec.encryptionKey of upstream/userdata/installation.yaml: base64 of the 24
+	// byte AES key followed by the 12 byte GCM nonce. It can't be combined with DecryptPasswordValues.
+	DecryptionKey string
+	// RemotePort is the port in the kotsadm pod to connect to, see kotsadmclient.Options
+	RemotePort int
 }
 
-func Download(appSlug string, path string, downloadOptions DownloadOptions) error {
-	log := logger.NewLogger()
-	if downloadOptions.Silent {
-		log.Silence()
+// Download extracts the application archive from kotsadm to path, and returns the sorted paths of the
+// files that were written, relative to path
+func Download(appSlug string, path string, downloadOptions DownloadOptions) ([]string, error) {
+	start := time.Now()
+	var files []string
+	var transferred int64
+	err := retry.Do(downloadOptions.Retry, retry.IsTemporary, func() error {
+		var err error
+		files, transferred, err = download(appSlug, path, downloadOptions, nil)
+		return err
+	})
+	if downloadOptions.OnComplete != nil {
+		downloadOptions.OnComplete(appSlug, transferred, time.Since(start), err)
+	}
+	return files, err
+}
+
+// download is Download through conn, or through a new connection if conn is nil
+func download(appSlug string, path string, downloadOptions DownloadOptions, conn *kotsadmclient.Client) ([]string, int64, error) {
+	if err := validateAppSlug(appSlug); err != nil {
+		return nil, 0, err
+	}
+	if err := util.ValidateTempDir(downloadOptions.TempDir); err != nil {
+		return nil, 0, err
+	}
+	if err := validateOnlyPaths(downloadOptions.OnlyPaths); err != nil {
+		return nil, 0, err
+	}
+	if downloadOptions.DecryptPasswordValues && downloadOptions.DecryptionKey != "" {
+		return nil, 0, util.ActionableError{Message: "password values can be decrypted by kotsadm or with a decryption key, but not both"}
+	}
+
+	log := getLogger(downloadOptions)
+
+	var archivePath string
+	var transferred int64
+	// names the file when kotsadm sends a gzip of a single file instead of an archive
+	var contentDisposition string
+	// set when extraction fails with KeepArchiveOnError
+	keepArchive := false
+	if downloadOptions.Resumable {
+		resumablePath, n, err := fetchResumableArchive(appSlug, downloadOptions, conn, log)
+		transferred = n
+		if err != nil {
+			return nil, transferred, err
+		}
+		archivePath = resumablePath
+	} else {
+		tmpFile, err := ioutil.TempFile(downloadOptions.TempDir, "kots")
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "failed to create temp file")
+		}
+		defer func() {
+			if !keepArchive {
+				os.Remove(tmpFile.Name())
+			}
+		}()
+		// closes the file when the fetch fails, before it's removed
+		defer tmpFile.Close()
+
+		transferred, err = fetchArchive(appSlug, downloadOptions, conn, log, nil, func(resp *http.Response, archive io.Reader) error {
+			contentDisposition = resp.Header.Get("Content-Disposition")
+			_, err := io.Copy(tmpFile, archive)
+			if err != nil {
+				return errors.Wrap(err, "failed to write archive")
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, transferred, err
+		}
+		if err := tmpFile.Close(); err != nil {
+			return nil, transferred, errors.Wrap(err, "failed to close temp file")
+		}
+		archivePath = tmpFile.Name()
+	}
+
+	var checksum string
+	if downloadOptions.SkipUnchanged {
+		c, err := fileChecksum(archivePath)
+		if err != nil {
+			log.FinishSpinnerWithError()
+			return nil, transferred, err
+		}
+		checksum = c
+
+		previousChecksum, err := readChecksum(path)
+		if err != nil {
+			log.FinishSpinnerWithError()
+			return nil, transferred, err
+		}
+		if previousChecksum == checksum {
+			files, err := listDownloadedFiles(path)
+			if err != nil {
+				log.FinishSpinnerWithError()
+				return nil, transferred, err
+			}
+			if downloadOptions.Resumable {
+				removeResumableArchive(downloadOptions.TempDir, downloadOptions.Namespace, appSlug)
+			}
+			log.FinishSpinner()
+			log.Info("%s is up to date", path)
+			return files, transferred, nil
+		}
+		log.Debug("Archive checksum %s does not match %q in %s", checksum, previousChecksum, path)
 	}
 
-	log.ActionWithSpinner("Connecting to cluster")
+	// Delete the destination, if needed and requested
+	if _, err := os.Stat(path); err == nil {
+		if downloadOptions.Overwrite {
+			if err := os.RemoveAll(path); err != nil {
+				return nil, transferred, errors.Wrap(err, "failed to delete existing download")
+			}
+		} else {
+			log.FinishSpinner()
+			log.ActionWithoutSpinner("")
+			log.Error(errors.Errorf("Directory %s already exists. You can re-run this command with --overwrite to automatically overwrite it", path))
+			log.ActionWithoutSpinner("")
+			return nil, transferred, errors.Errorf("directory already exists at %s", path)
+		}
+	}
 
-	clientset, err := k8sutil.GetClientset(downloadOptions.KubernetesConfigFlags)
+	files, err := extractArchive(archivePath, path, downloadOptions.OnlyPaths, downloadOptions.FlattenTopLevelFolder, singleFileName(contentDisposition, appSlug))
 	if err != nil {
 		log.FinishSpinnerWithError()
-		return errors.Wrap(err, "failed to get clientset")
+		if downloadOptions.KeepArchiveOnError {
+			keepArchive = true
+			return nil, transferred, errors.Wrapf(err, "the downloaded archive was kept at %s", archivePath)
+		}
+		return nil, transferred, err
+	}
+
+	log.Debug("Extracted %d files to %s", len(files), path)
+
+	if downloadOptions.DecryptionKey != "" {
+		decrypted, err := decryptPasswordValues(path, files, downloadOptions.DecryptionKey)
+		if err != nil {
+			log.FinishSpinnerWithError()
+			return nil, transferred, err
+		}
+		log.Debug("Decrypted %d password values", decrypted)
+	}
+
+	if downloadOptions.SkipUnchanged {
+		if err := writeChecksum(path, checksum); err != nil {
+			log.FinishSpinnerWithError()
+			return nil, transferred, err
+		}
 	}
 
-	podName, err := k8sutil.FindKotsadm(clientset, downloadOptions.Namespace)
+	if downloadOptions.IncludeImageList {
+		if err := writeImageList(path); err != nil {
+			log.FinishSpinnerWithError()
+			return nil, transferred, err
+		}
+		if !containsString(files, ImageListFile) {
+			files = append(files, ImageListFile)
+			sort.Strings(files)
+		}
+	}
+
+	if downloadOptions.Resumable {
+		removeResumableArchive(downloadOptions.TempDir, downloadOptions.Namespace, appSlug)
+	}
+
+	log.FinishSpinner()
+
+	return files, transferred, nil
+}
+
+// DownloadStream fetches the application archive in the same way as Download, but instead of
+// extracting to disk, each file and directory in the archive is passed to fn as it's read.
+// The stream is aborted if fn returns an error.
+func DownloadStream(appSlug string, downloadOptions DownloadOptions, fn func(path string, info os.FileInfo, r io.Reader) error) error {
+	start := time.Now()
+	transferred, err := downloadStream(appSlug, downloadOptions, fn)
+	if downloadOptions.OnComplete != nil {
+		downloadOptions.OnComplete(appSlug, transferred, time.Since(start), err)
+	}
+	return err
+}
+
+func downloadStream(appSlug string, downloadOptions DownloadOptions, fn func(path string, info os.FileInfo, r io.Reader) error) (int64, error) {
+	if err := validateAppSlug(appSlug); err != nil {
+		return 0, err
+	}
+
+	log := getLogger(downloadOptions)
+
+	transferred, err := fetchArchive(appSlug, downloadOptions, nil, log, nil, func(resp *http.Response, archive io.Reader) error {
+		return streamTarGz(archive, fn)
+	})
 	if err != nil {
-		log.FinishSpinnerWithError()
-		return errors.Wrap(err, "failed to find kotsadm pod")
+		return transferred, err
 	}
 
-	stopCh := make(chan struct{})
-	defer close(stopCh)
+	log.FinishSpinner()
+
+	return transferred, nil
+}
 
-	localPort, errChan, err := k8sutil.PortForward(downloadOptions.KubernetesConfigFlags, 0, 3000, downloadOptions.Namespace, podName, false, stopCh, log)
+// extractArchive extracts the files in the tar gz at archivePath that match onlyPaths, or all files if
+// it's empty, to path. A gzip of a single file is written to path/fileName instead.
+func extractArchive(archivePath string, path string, onlyPaths []string, flattenTopLevelFolder bool, fileName string) ([]string, error) {
+	isTarGz, err := util.IsTarGz(archivePath)
 	if err != nil {
-		log.FinishSpinnerWithError()
-		return errors.Wrap(err, "failed to start port forwarding")
+		return nil, errors.Wrap(err, "failed to read archive")
+	}
+	if !isTarGz {
+		if len(onlyPaths) > 0 && !matchesOnlyPaths(onlyPaths, fileName) {
+			return nil, errors.Errorf("no files matched %s", strings.Join(onlyPaths, ", "))
+		}
+		if err := util.ExtractGzipFile(archivePath, path, fileName); err != nil {
+			return nil, errors.Wrap(err, "failed to extract gzip")
+		}
+		return []string{fileName}, nil
 	}
 
-	go func() {
-		select {
-		case err := <-errChan:
-			if err != nil {
-				log.Error(err)
+	extract := util.ExtractTGZArchiveFilesMatching
+	if flattenTopLevelFolder {
+		extract = util.ExtractTGZArchiveFilesFlattened
+	}
+
+	var match func(name string) bool
+	if len(onlyPaths) > 0 {
+		match = func(name string) bool {
+			return matchesOnlyPaths(onlyPaths, name)
+		}
+	}
+
+	files, err := extract(archivePath, path, match)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to extract tar gz")
+	}
+	if len(onlyPaths) > 0 && len(files) == 0 {
+		return nil, errors.Errorf("no files matched %s", strings.Join(onlyPaths, ", "))
+	}
+
+	return files, nil
+}
+
+// singleFileName is the name of the file in a gzip that isn't a tar archive. It's the filename from
+// the Content-Disposition header without the .gz extension, or the app slug as yaml.
+func singleFileName(contentDisposition string, appSlug string) string {
+	if _, params, err := mime.ParseMediaType(contentDisposition); err == nil {
+		name := path.Base(strings.Replace(params["filename"], "\\", "/", -1))
+		name = strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".gzip")
+		if name != "" && name != "." && name != ".." && name != "/" {
+			return name
+		}
+	}
+
+	return appSlug + ".yaml"
+}
+
+func matchesOnlyPaths(onlyPaths []string, name string) bool {
+	for _, pattern := range onlyPaths {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if matched, _ := path.Match(pattern, path.Base(name)); matched {
+				return true
 			}
-		case <-stopCh:
 		}
-	}()
+	}
+	return false
+}
+
+// validateOnlyPaths catches malformed patterns before anything is downloaded
+func validateOnlyPaths(onlyPaths []string) error {
+	for _, pattern := range onlyPaths {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return util.ActionableError{Message: fmt.Sprintf("Invalid path pattern %q", pattern)}
+		}
+	}
+	return nil
+}
+
+var appSlugRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// validateAppSlug catches slugs that kotsadm could never match before the port forward is set up
+func validateAppSlug(appSlug string) error {
+	if strings.TrimSpace(appSlug) == "" {
+		return util.ActionableError{Message: "An app slug is required"}
+	}
+	if !appSlugRegex.MatchString(appSlug) {
+		return util.ActionableError{
+			Message: fmt.Sprintf("Invalid app slug %q. App slugs can only contain letters, numbers, '-', '_' and '.'", appSlug),
+		}
+	}
+	return nil
+}
 
-	authSlug, err := auth.GetOrCreateAuthSlug(downloadOptions.KubernetesConfigFlags, downloadOptions.Namespace)
+// connectToKotsadm connects to kotsadm with the cluster and namespace in downloadOptions, and checks
+// that kotsadm is ready unless downloadOptions.SkipHealthCheck is set
+func connectToKotsadm(downloadOptions DownloadOptions, log logger.Interface) (*kotsadmclient.Client, error) {
+	conn, err := kotsadmclient.Connect(kotsadmclient.Options{
+		Namespace:             downloadOptions.Namespace,
+		KubernetesConfigFlags: downloadOptions.KubernetesConfigFlags,
+		KubeconfigPath:        downloadOptions.KubeconfigPath,
+		KubeconfigBytes:       downloadOptions.KubeconfigBytes,
+		TempDir:               downloadOptions.TempDir,
+		HTTPS:                 downloadOptions.HTTPS,
+		CABundle:              downloadOptions.CABundle,
+		PodSelector:           downloadOptions.PodSelector,
+		Log:                   log,
+		RemotePort:            downloadOptions.RemotePort,
+	})
 	if err != nil {
-		log.FinishSpinnerWithError()
-		return errors.Wrap(err, "failed to get kotsadm auth slug")
+		return nil, err
+	}
+
+	if !downloadOptions.SkipHealthCheck {
+		if err := conn.CheckReady(kotsadmReadyTimeout); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// kotsadmReadyTimeout is how long kotsadm has to respond to the health check after connecting
+const kotsadmReadyTimeout = 10 * time.Second
+
+// getUserAgent returns the user agent from downloadOptions, or the kots user agent when none is set
+func getUserAgent(downloadOptions DownloadOptions) string {
+	if downloadOptions.UserAgent != "" {
+		return downloadOptions.UserAgent
+	}
+	return version.UserAgent()
+}
+
+// getLogger returns the logger from downloadOptions, or a console logger when none is set
+func getLogger(downloadOptions DownloadOptions) logger.Interface {
+	if downloadOptions.Log != nil {
+		return downloadOptions.Log
+	}
+
+	log := logger.NewLogger()
+	if downloadOptions.Silent {
+		log.Silence()
+	}
+	if downloadOptions.Verbose {
+		log.Verbose()
+	}
+	return log
+}
+
+func streamTarGz(archive io.Reader, fn func(path string, info os.FileInfo, r io.Reader) error) error {
+	gzr, err := gzip.NewReader(archive)
+	if err != nil {
+		return errors.Wrap(err, "failed to create gzip reader")
+	}
+	defer gzr.Close()
+
+	tarReader := tar.NewReader(gzr)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read tar data")
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeDir {
+			continue
+		}
+
+		// match the paths that extracting the archive to disk would create
+		name, err := util.SanitizeArchivePath(header.Name)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			continue
+		}
+
+		if err := fn(name, header.FileInfo(), tarReader); err != nil {
+			return errors.Wrapf(err, "failed to process %s", name)
+		}
+	}
+
+	return nil
+}
+
+// fetchArchive requests the application archive from kotsadm, connecting first if conn is nil. The
+// request can be changed with prepareRequest, and the decoded archive is passed to handleArchive
+// while the connection is still open. The number of bytes that were read from the archive is returned.
+func fetchArchive(appSlug string, downloadOptions DownloadOptions, conn *kotsadmclient.Client, log logger.Interface, prepareRequest func(req *http.Request), handleArchive func(resp *http.Response, archive io.Reader) error) (int64, error) {
+	trail := newDebugTrail(downloadOptions.DebugWriter)
+
+	if conn == nil {
+		log.ActionWithSpinner("Connecting to cluster")
+
+		c, err := connectToKotsadm(downloadOptions, log)
+		if err != nil {
+			trail.printf("connecting to kotsadm in namespace %q failed: %s", downloadOptions.Namespace, err)
+			log.FinishSpinnerWithError()
+			return 0, err
+		}
+		defer c.Close()
+		conn = c
+	}
+
+	if conn.PodName != "" {
+		trail.printf("forwarding localhost:%d to port %d of pod %s in namespace %q", conn.LocalPort, conn.RemotePort, conn.PodName, downloadOptions.Namespace)
+	} else {
+		trail.printf("using kotsadm on localhost:%d", conn.LocalPort)
 	}
 
-	url := fmt.Sprintf("http://localhost:%d/api/v1/download?slug=%s", localPort, appSlug)
+	url := conn.URL(fmt.Sprintf("/api/v1/download?slug=%s", neturl.QueryEscape(appSlug)))
 	if downloadOptions.DecryptPasswordValues {
 		url = fmt.Sprintf("%s&decryptPasswordValues=1", url)
 	}
 
-	newRequest, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		log.FinishSpinnerWithError()
-		return errors.Wrap(err, "failed to create download request")
+	newRequest := func(authSlug string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create download request")
+		}
+		req = req.WithContext(conn.Context())
+		req.Header.Add("Authorization", authSlug)
+		req.Header.Set("User-Agent", getUserAgent(downloadOptions))
+		req.Header.Set("Accept", "application/gzip")
+		if prepareRequest != nil {
+			prepareRequest(req)
+		}
+
+		// the auth slug is only sent in the Authorization header, so the url is safe to log
+		log.Debug("Requesting %s (Authorization redacted)", url)
+		trail.printf("GET %s, authorization %s", url, redactedAuthorization(authSlug))
+		if rangeHeader := req.Header.Get("Range"); rangeHeader != "" {
+			log.Debug("Resuming download with range %s", rangeHeader)
+			trail.printf("resuming with range %s", rangeHeader)
+		}
+		return req, nil
 	}
-	newRequest.Header.Add("Authorization", authSlug)
 
-	resp, err := http.DefaultClient.Do(newRequest)
+	resp, err := conn.Do(newRequest)
 	if err != nil {
+		err = conn.PortForwardError(errors.Wrap(err, "failed to get from kotsadm"))
+		trail.printf("request failed: %s", err)
 		log.FinishSpinnerWithError()
-		return errors.Wrap(err, "failed to get from kotsadm")
+		return 0, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	log.Debug("kotsadm responded with %s", resp.Status)
+	trail.printf("kotsadm responded with %s", resp.Status)
+
+	if kotsadmclient.IsAuthError(resp.StatusCode) {
 		log.FinishSpinnerWithError()
-		return errors.Errorf("unexpected status code from %s: %s", url, resp.Status)
+		return 0, errors.Wrap(util.NewHTTPStatusError(url, resp), "authentication to kotsadm failed")
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		log.FinishSpinnerWithError()
+		return 0, errors.Wrap(util.NewHTTPStatusError(url, resp), "failed to download from kotsadm")
 	}
 
-	tmpFile, err := ioutil.TempFile("", "kots")
-	if err != nil {
-		log.FinishSpinner()
-		return errors.Wrap(err, "failed to create temp file")
+	// a resumed download already has the bytes before the range on disk, which count toward the limit
+	maxBytes := downloadOptions.MaxBytes
+	if maxBytes > 0 && resp.StatusCode == http.StatusPartialContent {
+		maxBytes -= contentRangeStart(resp)
+		if maxBytes < 0 {
+			maxBytes = 0
+		}
 	}
-	defer os.Remove(tmpFile.Name())
 
-	_, err = io.Copy(tmpFile, resp.Body)
+	if downloadOptions.MaxBytes > 0 && resp.ContentLength > maxBytes {
+		log.FinishSpinnerWithError()
+		return 0, maxBytesError{MaxBytes: downloadOptions.MaxBytes}
+	}
+
+	body, err := decodeResponseBody(resp)
 	if err != nil {
-		log.FinishSpinner()
-		return errors.Wrap(err, "failed to write archive")
+		log.FinishSpinnerWithError()
+		return 0, conn.PortForwardError(errors.Wrap(err, "failed to decode response body"))
 	}
-	tmpFile.Close()
+	defer body.Close()
 
-	// Delete the destination, if needed and requested
-	if _, err := os.Stat(path); err == nil {
-		if downloadOptions.Overwrite {
-			if err := os.RemoveAll(path); err != nil {
-				return errors.Wrap(err, "failed to delete existing download")
-			}
-		} else {
-			log.FinishSpinner()
-			log.ActionWithoutSpinner("")
-			log.Error(errors.Errorf("Directory %s already exists. You can re-run this command with --overwrite to automatically overwrite it", path))
-			log.ActionWithoutSpinner("")
-			return errors.Errorf("directory already exists at %s", path)
-		}
+	// the limit is on the decoded archive, so a small compressed response can't expand past it. one
+	// byte more than the limit is read, to tell an archive of exactly MaxBytes from a larger one.
+	var archive io.Reader = body
+	if downloadOptions.MaxBytes > 0 {
+		archive = io.LimitReader(body, maxBytes+1)
+	}
+	counter := &countingReader{r: archive}
+	err = handleArchive(resp, counter)
+	log.Debug("Transferred %d bytes", counter.n)
+	trail.printf("transferred %d bytes", counter.n)
+	if err != nil {
+		trail.printf("reading the archive failed: %s", err)
+	}
+	if downloadOptions.MaxBytes > 0 && counter.n > maxBytes {
+		log.FinishSpinnerWithError()
+		return counter.n, maxBytesError{MaxBytes: downloadOptions.MaxBytes}
+	}
+	if err != nil {
+		log.FinishSpinnerWithError()
+		return counter.n, conn.PortForwardError(err)
 	}
 
-	tarGz := archiver.TarGz{
-		Tar: &archiver.Tar{
-			ImplicitTopLevelFolder: false,
-		},
+	return counter.n, nil
+}
+
+// contentRangeStart returns the first byte of a partial response, from a Content-Range header such as
+// "bytes 100-199/200", or 0 when it can't be parsed
+func contentRangeStart(resp *http.Response) int64 {
+	contentRange := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	dash := strings.Index(contentRange, "-")
+	if dash == -1 {
+		return 0
+	}
+	start, err := strconv.ParseInt(contentRange[:dash], 10, 64)
+	if err != nil {
+		return 0
 	}
-	if err := tarGz.Unarchive(tmpFile.Name(), path); err != nil {
-		return errors.Wrap(err, "failed to extract tar gz")
+	return start
+}
+
+// decodeResponseBody will undo any transfer compression that kotsadm applied to the archive,
+// so that what's written to disk is the archive itself
+func decodeResponseBody(resp *http.Response) (io.ReadCloser, error) {
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip":
+		gzr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create gzip reader")
+		}
+		return gzr, nil
+	case "deflate":
+		// http deflate is a zlib stream, not raw deflate
+		zr, err := zlib.NewReader(resp.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create zlib reader")
+		}
+		return zr, nil
+	default:
+		return ioutil.NopCloser(resp.Body), nil
 	}
+}
 
-	log.FinishSpinner()
+// maxBytesError is returned when the archive is larger than DownloadOptions.MaxBytes
+type maxBytesError struct {
+	MaxBytes int64
+}
 
-	return nil
+func (e maxBytesError) Error() string {
+	return fmt.Sprintf("download exceeded maximum size of %d bytes", e.MaxBytes)
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
@@ -0,0 +1,75 @@
+package download
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/replicatedhq/kots/pkg/kotsadmclient"
+	"github.com/replicatedhq/kots/pkg/logger"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// DownloadResult is the outcome of downloading one app with DownloadAll
+type DownloadResult struct {
+	AppSlug string
+	// Path is the directory that the app was extracted to
+	Path  string
+	Files []string
+	Err   error
+}
+
+// DownloadAll extracts the archive of each of appSlugs to destDir/<slug>, using a single port forward.
+// kotsadm has no endpoint that lists its apps, so the slugs are passed in. A failure to download one app
+// doesn't stop the others, it's returned in that app's result. The error is only set when kotsadm
+// couldn't be connected to.
+func DownloadAll(appSlugs []string, destDir string, downloadOptions DownloadOptions) ([]DownloadResult, error) {
+	if err := util.ValidateTempDir(downloadOptions.TempDir); err != nil {
+		return nil, err
+	}
+
+	log := getLogger(downloadOptions)
+
+	log.ActionWithSpinner("Connecting to cluster")
+	conn, err := connectToKotsadm(downloadOptions, log)
+	if err != nil {
+		log.FinishSpinnerWithError()
+		return nil, err
+	}
+	defer conn.Close()
+	log.FinishSpinner()
+
+	return downloadApps(appSlugs, destDir, downloadOptions, conn, log), nil
+}
+
+func downloadApps(appSlugs []string, destDir string, downloadOptions DownloadOptions, conn *kotsadmclient.Client, log logger.Interface) []DownloadResult {
+	downloadOptions.Log = log
+
+	results := []DownloadResult{}
+	for _, appSlug := range appSlugs {
+		path := filepath.Join(destDir, appSlug)
+
+		// the slug is part of the path, so it's checked before anything is written
+		if err := validateAppSlug(appSlug); err != nil {
+			results = append(results, DownloadResult{AppSlug: appSlug, Err: err})
+			continue
+		}
+
+		// download finishes the spinner
+		log.ActionWithSpinner("Downloading %s", appSlug)
+
+		start := time.Now()
+		files, transferred, err := download(appSlug, path, downloadOptions, conn)
+		if downloadOptions.OnComplete != nil {
+			downloadOptions.OnComplete(appSlug, transferred, time.Since(start), err)
+		}
+
+		results = append(results, DownloadResult{
+			AppSlug: appSlug,
+			Path:    path,
+			Files:   files,
+			Err:     err,
+		})
+	}
+
+	return results
+}
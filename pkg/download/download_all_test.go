@@ -0,0 +1,97 @@
+package download
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/replicatedhq/kots/pkg/kotsadmclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_downloadApps(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	var tarGz bytes.Buffer
+	gzw := gzip.NewWriter(&tarGz)
+	tw := tar.NewWriter(gzw)
+	content := []byte("kind: Deployment")
+	req.NoError(tw.WriteHeader(&tar.Header{Name: "upstream/deployment.yaml", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write(content)
+	req.NoError(err)
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "auth-slug", r.Header.Get("Authorization"))
+
+		switch r.URL.Path {
+		case "/api/v1/download":
+			if r.URL.Query().Get("slug") == "missing" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(tarGz.Bytes())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	conn := testKotsadmConnection(t, server)
+
+	destDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(destDir)
+
+	downloadOptions := DownloadOptions{Silent: true}
+	log := getLogger(downloadOptions)
+
+	appSlugs := []string{"app-1", "missing", "app-2"}
+
+	completed := []string{}
+	downloadOptions.OnComplete = func(appSlug string, bytes int64, duration time.Duration, err error) {
+		completed = append(completed, appSlug)
+	}
+
+	results := downloadApps(appSlugs, destDir, downloadOptions, conn, log)
+	req.Len(results, 3)
+	assert.Equal(t, appSlugs, completed)
+
+	for _, result := range results {
+		if result.AppSlug == "missing" {
+			req.Error(result.Err)
+			continue
+		}
+		req.NoError(result.Err)
+		assert.Equal(t, filepath.Join(destDir, result.AppSlug), result.Path)
+		assert.Equal(t, []string{"upstream/deployment.yaml"}, result.Files)
+
+		actual, err := ioutil.ReadFile(filepath.Join(result.Path, "upstream", "deployment.yaml"))
+		req.NoError(err)
+		assert.Equal(t, content, actual)
+	}
+}
+
+// testKotsadmConnection returns a client for server, as if it was port forwarded from kotsadm
+func testKotsadmConnection(t *testing.T, server *httptest.Server) *kotsadmclient.Client {
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	localPort, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	return kotsadmclient.NewClient(localPort, "auth-slug")
+}
@@ -0,0 +1,609 @@
+package download
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_decodeResponseBody(t *testing.T) {
+	req := require.New(t)
+
+	var tarGz bytes.Buffer
+	gzw := gzip.NewWriter(&tarGz)
+	tw := tar.NewWriter(gzw)
+	content := []byte("apiVersion: v1\nkind: ConfigMap\n")
+	req.NoError(tw.WriteHeader(&tar.Header{Name: "upstream/configmap.yaml", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write(content)
+	req.NoError(err)
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+
+	var encodedTarGz bytes.Buffer
+	encoder := gzip.NewWriter(&encodedTarGz)
+	_, err = encoder.Write(tarGz.Bytes())
+	req.NoError(err)
+	req.NoError(encoder.Close())
+
+	var deflatedTarGz bytes.Buffer
+	deflater := zlib.NewWriter(&deflatedTarGz)
+	_, err = deflater.Write(tarGz.Bytes())
+	req.NoError(err)
+	req.NoError(deflater.Close())
+
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+	}{
+		{
+			name:            "no content encoding",
+			contentEncoding: "",
+			body:            tarGz.Bytes(),
+		},
+		{
+			name:            "gzip content encoding",
+			contentEncoding: "gzip",
+			body:            encodedTarGz.Bytes(),
+		},
+		{
+			name:            "deflate content encoding",
+			contentEncoding: "deflate",
+			body:            deflatedTarGz.Bytes(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.contentEncoding != "" {
+					w.Header().Set("Content-Encoding", test.contentEncoding)
+				}
+				w.Write(test.body)
+			}))
+			defer server.Close()
+
+			// disable the transport's transparent decompression to see the header as kotsadm sent it
+			client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+			resp, err := client.Get(server.URL)
+			req.NoError(err)
+			defer resp.Body.Close()
+
+			body, err := decodeResponseBody(resp)
+			req.NoError(err)
+			defer body.Close()
+
+			actual, err := ioutil.ReadAll(body)
+			req.NoError(err)
+			assert.Equal(t, tarGz.Bytes(), actual)
+		})
+	}
+}
+
+func Test_streamTarGz(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	var tarGz bytes.Buffer
+	gzw := gzip.NewWriter(&tarGz)
+	tw := tar.NewWriter(gzw)
+	req.NoError(tw.WriteHeader(&tar.Header{Name: "./upstream/", Mode: 0755, Typeflag: tar.TypeDir}))
+	for _, name := range []string{"./upstream/a.yaml", "./upstream/b.yaml"} {
+		req.NoError(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(name))
+		req.NoError(err)
+	}
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+
+	actual := map[string]string{}
+	err := streamTarGz(bytes.NewReader(tarGz.Bytes()), func(path string, info os.FileInfo, r io.Reader) error {
+		if info.IsDir() {
+			actual[path] = ""
+			return nil
+		}
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		actual[path] = string(content)
+		return nil
+	})
+	req.NoError(err)
+	assert.Equal(t, map[string]string{
+		"upstream":        "",
+		"upstream/a.yaml": "./upstream/a.yaml",
+		"upstream/b.yaml": "./upstream/b.yaml",
+	}, actual)
+
+	calls := 0
+	err = streamTarGz(bytes.NewReader(tarGz.Bytes()), func(path string, info os.FileInfo, r io.Reader) error {
+		calls++
+		return errors.New("stop")
+	})
+	req.Error(err)
+	assert.Equal(t, 1, calls)
+}
+
+func Test_resumableArchive(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	etag := `"sequence-1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "app.tar.gz", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	fetch := func(archive *resumableArchive) (*http.Response, error) {
+		request, err := http.NewRequest("GET", server.URL, nil)
+		req.NoError(err)
+		archive.prepareRequest(request)
+		resp, err := http.DefaultClient.Do(request)
+		req.NoError(err)
+		defer resp.Body.Close()
+		return resp, archive.write(resp, resp.Body)
+	}
+
+	archivePath := filepath.Join(tmpDir, "downloads", "default-app.tar.gz")
+
+	// a previous attempt stopped partway through
+	archive, err := openResumableArchive(archivePath)
+	req.NoError(err)
+	req.NoError(archive.saveValidator(etag))
+	req.NoError(ioutil.WriteFile(archivePath, content[:400], 0600))
+
+	archive, err = openResumableArchive(archivePath)
+	req.NoError(err)
+	assert.Equal(t, int64(400), archive.offset)
+
+	resp, err := fetch(archive)
+	req.NoError(err)
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	actual, err := ioutil.ReadFile(archivePath)
+	req.NoError(err)
+	assert.Equal(t, content, actual)
+
+	// the archive changed since the partial download, so the full archive is fetched
+	req.NoError(ioutil.WriteFile(archivePath, []byte("stale"), 0600))
+	etag = `"sequence-2"`
+	content = bytes.Repeat([]byte("abcdefghij"), 100)
+
+	archive, err = openResumableArchive(archivePath)
+	req.NoError(err)
+	resp, err = fetch(archive)
+	req.NoError(err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	actual, err = ioutil.ReadFile(archivePath)
+	req.NoError(err)
+	assert.Equal(t, content, actual)
+
+	validator, err := ioutil.ReadFile(archivePath + ".validator")
+	req.NoError(err)
+	assert.Equal(t, etag, string(validator))
+
+	// a partial response for a different version isn't appended
+	req.NoError(ioutil.WriteFile(archivePath, content[:400], 0600))
+	archive, err = openResumableArchive(archivePath)
+	req.NoError(err)
+	resp = &http.Response{
+		StatusCode: http.StatusPartialContent,
+		Header: http.Header{
+			"Etag":          []string{`"sequence-3"`},
+			"Content-Range": []string{"bytes 400-999/1000"},
+		},
+	}
+	err = archive.write(resp, bytes.NewReader(content[400:]))
+	assert.Equal(t, errPartialDownloadChanged, err)
+	_, err = os.Stat(archivePath)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, int64(0), archive.offset)
+
+	archive.reset()
+	_, err = os.Stat(archivePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_validateAppSlug(t *testing.T) {
+	tests := []struct {
+		name        string
+		appSlug     string
+		expectError string
+	}{
+		{
+			name:    "valid",
+			appSlug: "my-app",
+		},
+		{
+			name:        "empty",
+			appSlug:     "",
+			expectError: "An app slug is required",
+		},
+		{
+			name:        "whitespace",
+			appSlug:     "  ",
+			expectError: "An app slug is required",
+		},
+		{
+			name:        "slash",
+			appSlug:     "my-app/../other",
+			expectError: `Invalid app slug "my-app/../other"`,
+		},
+		{
+			name:        "space",
+			appSlug:     "my app",
+			expectError: `Invalid app slug "my app"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			err := validateAppSlug(test.appSlug)
+			if test.expectError == "" {
+				req.NoError(err)
+				return
+			}
+			req.Error(err)
+			assert.Contains(t, err.Error(), test.expectError)
+
+			// the slug is rejected before connecting to the cluster
+			_, err = Download(test.appSlug, "", DownloadOptions{Silent: true})
+			req.Error(err)
+			assert.Contains(t, err.Error(), test.expectError)
+		})
+	}
+}
+
+func Test_DownloadTempDirNotWritable(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	// the temp dir is checked before connecting to the cluster
+	_, err = Download("my-app", filepath.Join(tmpDir, "dest"), DownloadOptions{
+		Silent:  true,
+		TempDir: filepath.Join(tmpDir, "missing"),
+	})
+	req.Error(err)
+	assert.Contains(t, err.Error(), "is not writable")
+}
+
+func Test_DownloadOnComplete(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	var calls int
+	var completeErr error
+	downloadOptions := DownloadOptions{
+		Silent: true,
+		OnComplete: func(appSlug string, bytes int64, duration time.Duration, err error) {
+			calls++
+			completeErr = err
+			assert.Equal(t, "my app", appSlug)
+			assert.Equal(t, int64(0), bytes)
+		},
+	}
+
+	_, err := Download("my app", "", downloadOptions)
+	req.Error(err)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, err, completeErr)
+
+	err = DownloadStream("my app", downloadOptions, nil)
+	req.Error(err)
+	assert.Equal(t, 2, calls)
+}
+
+func Test_extractArchiveOnlyPaths(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+	f, err := os.Create(archivePath)
+	req.NoError(err)
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+	for _, name := range []string{"upstream/userdata/config.yaml", "upstream/userdata/license.yaml", "upstream/app.yaml", "base/kustomization.yaml"} {
+		req.NoError(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name)), Typeflag: tar.TypeReg}))
+		_, err = tw.Write([]byte(name))
+		req.NoError(err)
+	}
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+	req.NoError(f.Close())
+
+	files, err := extractArchive(archivePath, filepath.Join(tmpDir, "config"), []string{"config.yaml"}, false, "my-app.yaml")
+	req.NoError(err)
+	assert.Equal(t, []string{"upstream/userdata/config.yaml"}, files)
+
+	files, err = extractArchive(archivePath, filepath.Join(tmpDir, "userdata"), []string{"upstream/userdata/*"}, false, "my-app.yaml")
+	req.NoError(err)
+	assert.Equal(t, []string{"upstream/userdata/config.yaml", "upstream/userdata/license.yaml"}, files)
+
+	_, err = extractArchive(archivePath, filepath.Join(tmpDir, "none"), []string{"*.json"}, false, "my-app.yaml")
+	req.Error(err)
+	assert.Contains(t, err.Error(), "no files matched")
+
+	req.Error(validateOnlyPaths([]string{"[config.yaml"}))
+}
+
+func Test_extractArchiveTopLevelFolder(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	// an archive with everything under a single top-level folder
+	archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+	f, err := os.Create(archivePath)
+	req.NoError(err)
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+	req.NoError(tw.WriteHeader(&tar.Header{Name: "my-app/", Mode: 0755, Typeflag: tar.TypeDir}))
+	for _, name := range []string{"my-app/upstream/app.yaml", "my-app/base/kustomization.yaml"} {
+		req.NoError(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name)), Typeflag: tar.TypeReg}))
+		_, err = tw.Write([]byte(name))
+		req.NoError(err)
+	}
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+	req.NoError(f.Close())
+
+	flattenedDir := filepath.Join(tmpDir, "flattened")
+	files, err := extractArchive(archivePath, flattenedDir, nil, true, "my-app.yaml")
+	req.NoError(err)
+	assert.Equal(t, []string{"base/kustomization.yaml", "upstream/app.yaml"}, files)
+	content, err := ioutil.ReadFile(filepath.Join(flattenedDir, "upstream", "app.yaml"))
+	req.NoError(err)
+	assert.Equal(t, "my-app/upstream/app.yaml", string(content))
+	_, err = os.Stat(filepath.Join(flattenedDir, "my-app"))
+	assert.True(t, os.IsNotExist(err))
+
+	preservedDir := filepath.Join(tmpDir, "preserved")
+	files, err = extractArchive(archivePath, preservedDir, nil, false, "my-app.yaml")
+	req.NoError(err)
+	assert.Equal(t, []string{"my-app/base/kustomization.yaml", "my-app/upstream/app.yaml"}, files)
+	content, err = ioutil.ReadFile(filepath.Join(preservedDir, "my-app", "upstream", "app.yaml"))
+	req.NoError(err)
+	assert.Equal(t, "my-app/upstream/app.yaml", string(content))
+
+	// only paths are matched against the flattened paths
+	files, err = extractArchive(archivePath, filepath.Join(tmpDir, "only"), []string{"upstream/*"}, true, "my-app.yaml")
+	req.NoError(err)
+	assert.Equal(t, []string{"upstream/app.yaml"}, files)
+}
+
+func Test_downloadSingleFileGzip(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	// a gzip of a single manifest rather than a tar gz
+	content := []byte("apiVersion: v1\nkind: ConfigMap\n")
+	var gzipped bytes.Buffer
+	gzw := gzip.NewWriter(&gzipped)
+	_, err := gzw.Write(content)
+	req.NoError(err)
+	req.NoError(gzw.Close())
+
+	contentDisposition := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contentDisposition != "" {
+			w.Header().Set("Content-Disposition", contentDisposition)
+		}
+		w.Write(gzipped.Bytes())
+	}))
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	downloadOptions := DownloadOptions{Silent: true}
+	conn := testKotsadmConnection(t, server)
+
+	contentDisposition = `attachment; filename="configmap.yaml.gz"`
+	path := filepath.Join(tmpDir, "named")
+	files, _, err := download("my-app", path, downloadOptions, conn)
+	req.NoError(err)
+	assert.Equal(t, []string{"configmap.yaml"}, files)
+	written, err := ioutil.ReadFile(filepath.Join(path, "configmap.yaml"))
+	req.NoError(err)
+	assert.Equal(t, content, written)
+
+	contentDisposition = ""
+	path = filepath.Join(tmpDir, "unnamed")
+	files, _, err = download("my-app", path, downloadOptions, conn)
+	req.NoError(err)
+	assert.Equal(t, []string{"my-app.yaml"}, files)
+	written, err = ioutil.ReadFile(filepath.Join(path, "my-app.yaml"))
+	req.NoError(err)
+	assert.Equal(t, content, written)
+}
+
+func Test_singleFileName(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	tests := []struct {
+		contentDisposition string
+		expected           string
+	}{
+		{contentDisposition: `attachment; filename="app.yaml.gz"`, expected: "app.yaml"},
+		{contentDisposition: `attachment; filename=app.yaml`, expected: "app.yaml"},
+		{contentDisposition: `attachment; filename="../../etc/app.yaml.gz"`, expected: "app.yaml"},
+		{contentDisposition: `attachment; filename=".."`, expected: "my-app.yaml"},
+		{contentDisposition: `attachment`, expected: "my-app.yaml"},
+		{contentDisposition: ``, expected: "my-app.yaml"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, singleFileName(test.contentDisposition, "my-app"), test.contentDisposition)
+	}
+}
+
+func Test_downloadKeepArchiveOnError(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	archive := []byte("this is not an archive")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	archiveDir := filepath.Join(tmpDir, "archives")
+	req.NoError(os.Mkdir(archiveDir, 0755))
+
+	conn := testKotsadmConnection(t, server)
+
+	// by default the archive is removed
+	_, _, err = download("my-app", filepath.Join(tmpDir, "removed"), DownloadOptions{Silent: true, TempDir: archiveDir}, conn)
+	req.Error(err)
+	archives, err := ioutil.ReadDir(archiveDir)
+	req.NoError(err)
+	assert.Empty(t, archives)
+
+	_, _, downloadErr := download("my-app", filepath.Join(tmpDir, "kept"), DownloadOptions{Silent: true, TempDir: archiveDir, KeepArchiveOnError: true}, conn)
+	req.Error(downloadErr)
+	archives, err = ioutil.ReadDir(archiveDir)
+	req.NoError(err)
+	req.Len(archives, 1)
+
+	archivePath := filepath.Join(archiveDir, archives[0].Name())
+	assert.Contains(t, downloadErr.Error(), archivePath)
+	kept, err := ioutil.ReadFile(archivePath)
+	req.NoError(err)
+	assert.Equal(t, archive, kept)
+}
+
+func Test_downloadMaxBytes(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	archive := []byte(strings.Repeat("a", 100))
+	chunked := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=60-" {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 60-99/%d", len(archive)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(archive[60:])
+			return
+		}
+		if chunked {
+			// no Content-Length, so the limit is only found while reading
+			w.Write(archive[:50])
+			w.(http.Flusher).Flush()
+			w.Write(archive[50:])
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(archive)))
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	archiveDir := filepath.Join(tmpDir, "archives")
+	req.NoError(os.Mkdir(archiveDir, 0755))
+
+	conn := testKotsadmConnection(t, server)
+	downloadOptions := DownloadOptions{Silent: true, TempDir: archiveDir, MaxBytes: 99}
+
+	transferred, err := fetchArchive("my-app", downloadOptions, conn, getLogger(downloadOptions), nil, func(resp *http.Response, archive io.Reader) error {
+		return errors.New("the archive should not be read")
+	})
+	req.Error(err)
+	assert.Equal(t, "download exceeded maximum size of 99 bytes", err.Error())
+	assert.Equal(t, int64(0), transferred)
+
+	chunked = true
+	_, _, err = download("my-app", filepath.Join(tmpDir, "app"), downloadOptions, conn)
+	req.Error(err)
+	assert.Equal(t, "download exceeded maximum size of 99 bytes", err.Error())
+	archives, err := ioutil.ReadDir(archiveDir)
+	req.NoError(err)
+	assert.Empty(t, archives)
+
+	// an archive of exactly MaxBytes is allowed
+	downloadOptions.MaxBytes = 100
+	var read []byte
+	_, err = fetchArchive("my-app", downloadOptions, conn, getLogger(downloadOptions), nil, func(resp *http.Response, archive io.Reader) error {
+		read, err = ioutil.ReadAll(archive)
+		return err
+	})
+	req.NoError(err)
+	assert.Equal(t, archive, read)
+
+	// the bytes that were downloaded before a resumed request count toward the limit
+	resume := func(req *http.Request) {
+		req.Header.Set("Range", "bytes=60-")
+	}
+	downloadOptions.MaxBytes = 90
+	_, err = fetchArchive("my-app", downloadOptions, conn, getLogger(downloadOptions), resume, func(resp *http.Response, archive io.Reader) error {
+		_, err := ioutil.ReadAll(archive)
+		return err
+	})
+	req.Error(err)
+	assert.Equal(t, "download exceeded maximum size of 90 bytes", err.Error())
+
+	downloadOptions.MaxBytes = 100
+	_, err = fetchArchive("my-app", downloadOptions, conn, getLogger(downloadOptions), resume, func(resp *http.Response, archive io.Reader) error {
+		read, err = ioutil.ReadAll(archive)
+		return err
+	})
+	req.NoError(err)
+	assert.Equal(t, archive[60:], read)
+}
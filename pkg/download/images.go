@@ -0,0 +1,64 @@
+package download
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/image"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// ImageListFile is the name of the file that DownloadOptions.IncludeImageList writes, with one image
+// per line
+const ImageListFile = "images.txt"
+
+// writeImageList writes the sorted, unique images that the upstream manifests extracted to path reference
+// to ImageListFile in path
+func writeImageList(path string) error {
+	upstreamDir := filepath.Join(path, "upstream")
+	if _, err := os.Stat(upstreamDir); os.IsNotExist(err) {
+		return util.ActionableError{Message: "the image list is read from the upstream manifests, which were not downloaded"}
+	} else if err != nil {
+		return errors.Wrap(err, "failed to stat upstream dir")
+	}
+
+	images, err := image.GetImages(upstreamDir)
+	if err != nil {
+		return errors.Wrap(err, "failed to list images")
+	}
+
+	unique := map[string]bool{}
+	sorted := []string{}
+	for _, img := range images {
+		img = strings.TrimSpace(img)
+		if img == "" || unique[img] {
+			continue
+		}
+		unique[img] = true
+		sorted = append(sorted, img)
+	}
+	sort.Strings(sorted)
+
+	content := ""
+	if len(sorted) > 0 {
+		content = strings.Join(sorted, "\n") + "\n"
+	}
+	if err := ioutil.WriteFile(filepath.Join(path, ImageListFile), []byte(content), 0644); err != nil {
+		return errors.Wrap(err, "failed to write image list")
+	}
+
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
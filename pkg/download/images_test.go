@@ -0,0 +1,116 @@
+package download
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_downloadIncludeImageList(t *testing.T) {
+	deployment := `apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      initContainers:
+        - image: busybox:1.31
+      containers:
+        - image: redis:5
+        - image: nginx:1.19
+---
+apiVersion: apps/v1
+kind: StatefulSet
+spec:
+  template:
+    spec:
+      containers:
+        - image: redis:5
+`
+	service := "apiVersion: v1\nkind: Service\n"
+
+	tests := []struct {
+		name       string
+		files      map[string]string
+		onlyPaths  []string
+		wantFiles  []string
+		wantImages string
+		wantErr    bool
+	}{
+		{
+			name: "upstream manifests",
+			files: map[string]string{
+				"upstream/deployment.yaml": deployment,
+				"upstream/service.yaml":    service,
+			},
+			wantFiles:  []string{ImageListFile, "upstream/deployment.yaml", "upstream/service.yaml"},
+			wantImages: "busybox:1.31\nnginx:1.19\nredis:5\n",
+		},
+		{
+			name: "no images",
+			files: map[string]string{
+				"upstream/service.yaml": service,
+			},
+			wantFiles: []string{ImageListFile, "upstream/service.yaml"},
+		},
+		{
+			name: "upstream not downloaded",
+			files: map[string]string{
+				"upstream/deployment.yaml": deployment,
+				"base/deployment.yaml":     deployment,
+			},
+			onlyPaths: []string{"base/*"},
+			wantErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			var tarGz bytes.Buffer
+			gzw := gzip.NewWriter(&tarGz)
+			tw := tar.NewWriter(gzw)
+			for name, content := range test.files {
+				req.NoError(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+				_, err := tw.Write([]byte(content))
+				req.NoError(err)
+			}
+			req.NoError(tw.Close())
+			req.NoError(gzw.Close())
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(tarGz.Bytes())
+			}))
+			defer server.Close()
+
+			tmpDir, err := ioutil.TempDir("", "kots")
+			req.NoError(err)
+			defer os.RemoveAll(tmpDir)
+
+			path := filepath.Join(tmpDir, "my-app")
+			downloadOptions := DownloadOptions{Silent: true, IncludeImageList: true, OnlyPaths: test.onlyPaths}
+			files, _, err := download("my-app", path, downloadOptions, testKotsadmConnection(t, server))
+			if test.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+			assert.Equal(t, test.wantFiles, files)
+
+			images, err := ioutil.ReadFile(filepath.Join(path, ImageListFile))
+			req.NoError(err)
+			assert.Equal(t, test.wantImages, string(images))
+		})
+	}
+}
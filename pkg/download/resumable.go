@@ -0,0 +1,208 @@
+package download
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/kotsadmclient"
+	"github.com/replicatedhq/kots/pkg/logger"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// resumableArchiveDir is where partial archives are kept between attempts when downloading with
+// DownloadOptions.Resumable, under DownloadOptions.TempDir or the system temp dir. Each archive has a
+// ".validator" file next to it with the ETag or Last-Modified value that kotsadm sent, so that a partial
+// archive of a different version is never continued.
+func resumableArchiveDir(tempDir string) string {
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	return filepath.Join(tempDir, "kots-downloads")
+}
+
+// errPartialDownloadChanged is returned when kotsadm sent part of an archive that's different from
+// the one the partial download was started from
+var errPartialDownloadChanged = errors.New("archive changed since the partial download was started")
+
+// resumableArchive is a partially downloaded application archive
+type resumableArchive struct {
+	path          string
+	validatorPath string
+	validator     string
+	offset        int64
+}
+
+// resumableArchivePath is the partial archive of appSlug. The sequence isn't part of the name because
+// kotsadm always sends the latest sequence, which isn't known until it responds. The validator is what
+// ties the partial archive to a sequence, and a new sequence replaces it.
+func resumableArchivePath(tempDir string, namespace string, appSlug string) string {
+	return filepath.Join(resumableArchiveDir(tempDir), fmt.Sprintf("%s-%s.tar.gz", namespace, appSlug))
+}
+
+// openResumableArchive loads the state of a previous attempt at path. A partial archive without a
+// validator can't be continued safely and is downloaded again.
+func openResumableArchive(path string) (*resumableArchive, error) {
+	archive := &resumableArchive{
+		path:          path,
+		validatorPath: path + ".validator",
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create download dir")
+	}
+
+	validator, err := ioutil.ReadFile(archive.validatorPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to read download validator")
+	}
+	archive.validator = strings.TrimSpace(string(validator))
+	if archive.validator == "" {
+		return archive, nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to stat partial download")
+	}
+	if fi != nil {
+		archive.offset = fi.Size()
+	}
+
+	return archive, nil
+}
+
+// prepareRequest asks for the rest of the archive when there's a partial download. If-Range makes
+// kotsadm send the full archive instead if it has changed since the partial download was started.
+func (a *resumableArchive) prepareRequest(req *http.Request) {
+	if a.offset == 0 || a.validator == "" {
+		return
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", a.offset))
+	req.Header.Set("If-Range", a.validator)
+	// byte ranges only line up with the partial archive when no transfer compression is applied
+	req.Header.Set("Accept-Encoding", "identity")
+}
+
+// write appends the remainder of the archive to the partial download for a 206 response, or
+// replaces the partial download with the full archive for a 200 response. The partial download is
+// discarded if a 206 response is for a different version of the archive.
+func (a *resumableArchive) write(resp *http.Response, body io.Reader) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+
+	if resp.StatusCode == http.StatusPartialContent {
+		if validator := responseValidator(resp); validator != "" && validator != a.validator {
+			a.reset()
+			return errPartialDownloadChanged
+		}
+		expected := fmt.Sprintf("bytes %d-", a.offset)
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), expected) {
+			return errors.Errorf("unexpected content range %q, expected range starting at %d", resp.Header.Get("Content-Range"), a.offset)
+		}
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	} else {
+		if err := a.saveValidator(responseValidator(resp)); err != nil {
+			return err
+		}
+		a.offset = 0
+	}
+
+	f, err := os.OpenFile(a.path, flags, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open partial download")
+	}
+	defer f.Close()
+
+	written, err := io.Copy(f, body)
+	a.offset += written
+	if err != nil {
+		return errors.Wrap(err, "failed to write archive")
+	}
+
+	return nil
+}
+
+// responseValidator is the ETag or Last-Modified value that identifies the version of the archive
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// saveValidator is written before any of the archive, so that a partial archive is never left
+// with a validator from a different version
+func (a *resumableArchive) saveValidator(validator string) error {
+	a.validator = validator
+	if validator == "" {
+		if err := os.Remove(a.validatorPath); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to remove download validator")
+		}
+		return nil
+	}
+
+	if err := ioutil.WriteFile(a.validatorPath, []byte(validator), 0600); err != nil {
+		return errors.Wrap(err, "failed to write download validator")
+	}
+	return nil
+}
+
+// reset discards the partial download so that the next request fetches the full archive
+func (a *resumableArchive) reset() {
+	os.Remove(a.path)
+	os.Remove(a.validatorPath)
+	a.validator = ""
+	a.offset = 0
+}
+
+// fetchResumableArchive downloads the archive to its resumable path, continuing a partial download
+// from a previous attempt when kotsadm still has the same archive. The number of bytes transferred
+// is returned.
+func fetchResumableArchive(appSlug string, downloadOptions DownloadOptions, conn *kotsadmclient.Client, log logger.Interface) (string, int64, error) {
+	archive, err := openResumableArchive(resumableArchivePath(downloadOptions.TempDir, downloadOptions.Namespace, appSlug))
+	if err != nil {
+		return "", 0, errors.Wrap(err, "failed to open partial download")
+	}
+
+	transferred, err := fetchArchive(appSlug, downloadOptions, conn, log, archive.prepareRequest, archive.write)
+	if err == nil {
+		return archive.path, transferred, nil
+	}
+
+	// a partial archive that's over the limit can't be continued
+	if _, ok := err.(maxBytesError); ok {
+		archive.reset()
+		return "", transferred, err
+	}
+
+	// the partial download is larger than the archive or is from a different version of it, so the full
+	// archive is fetched
+	statusErr, isStatusErr := errors.Cause(err).(util.HTTPStatusError)
+	if (isStatusErr && statusErr.StatusCode == http.StatusRequestedRangeNotSatisfiable) || errors.Cause(err) == errPartialDownloadChanged {
+		log.Debug("Discarding partial download of %d bytes", archive.offset)
+		archive.reset()
+		n, err := fetchArchive(appSlug, downloadOptions, conn, log, archive.prepareRequest, archive.write)
+		transferred += n
+		if err != nil {
+			if _, ok := err.(maxBytesError); ok {
+				archive.reset()
+			}
+			return "", transferred, err
+		}
+		return archive.path, transferred, nil
+	}
+
+	return "", transferred, err
+}
+
+func removeResumableArchive(tempDir string, namespace string, appSlug string) {
+	archive := resumableArchive{path: resumableArchivePath(tempDir, namespace, appSlug)}
+	archive.validatorPath = archive.path + ".validator"
+	archive.reset()
+}
@@ -0,0 +1,200 @@
+package download
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/kotsadmclient"
+	"github.com/replicatedhq/kots/pkg/logger"
+	"github.com/replicatedhq/kots/pkg/upload"
+	"github.com/replicatedhq/kots/pkg/upstream"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// Upload sends the app at path to kotsadm as a new version of appSlug, connecting in the same way as
+// Download. The archive and request are the same as kots upload sends, except that files ignored by a
+// .kotsignore at the root of path are left out. Errors from kotsadm validating the version, such as
+// config errors, are returned as actionable errors.
+func Upload(appSlug string, path string, downloadOptions DownloadOptions) error {
+	if err := validateAppSlug(appSlug); err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat app path")
+	}
+	if !fi.IsDir() {
+		return util.ActionableError{Message: fmt.Sprintf("%s is not a directory", path)}
+	}
+
+	uploadOptions := upload.UploadOptions{
+		ExistingAppSlug: appSlug,
+	}
+	if err := upload.ReadUploadMetadata(path, &uploadOptions); err != nil {
+		return errors.Wrap(err, "failed to read app metadata")
+	}
+
+	archiveFilename, err := createUploadArchive(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to create upload archive")
+	}
+	defer os.Remove(archiveFilename)
+
+	log := getLogger(downloadOptions)
+
+	log.ActionWithSpinner("Connecting to cluster")
+	conn, err := connectToKotsadm(downloadOptions, log)
+	if err != nil {
+		log.FinishSpinnerWithError()
+		return err
+	}
+	defer conn.Close()
+	log.FinishSpinner()
+
+	log.ActionWithSpinner("Uploading %s", appSlug)
+	if err := uploadArchive(appSlug, archiveFilename, uploadOptions, downloadOptions, conn, log); err != nil {
+		log.FinishSpinnerWithError()
+		return err
+	}
+	log.FinishSpinner()
+
+	return nil
+}
+
+// createUploadArchive returns the path of the archive to upload the app at path with. Without a
+// .kotsignore, it's the same archive that kots upload creates. Otherwise the files that aren't ignored
+// are copied to a staging dir first, with their modes, and the archive is created from there.
+func createUploadArchive(path string) (string, error) {
+	_, err := os.Stat(filepath.Join(path, upstream.KotsIgnoreFile))
+	if os.IsNotExist(err) {
+		return upload.CreateUploadableArchive(path)
+	} else if err != nil {
+		return "", errors.Wrapf(err, "failed to stat %s", upstream.KotsIgnoreFile)
+	}
+
+	app, err := upstream.FetchUpstream(path, &upstream.FetchOptions{})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read app files")
+	}
+
+	stagingDir, err := ioutil.TempDir("", "kots-upload")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create staging dir")
+	}
+	defer os.RemoveAll(stagingDir)
+
+	for _, file := range app.Files {
+		fi, err := os.Stat(filepath.Join(path, filepath.FromSlash(file.Path)))
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to stat %s", file.Path)
+		}
+
+		stagedPath := filepath.Join(stagingDir, filepath.FromSlash(file.Path))
+		if err := os.MkdirAll(filepath.Dir(stagedPath), 0755); err != nil {
+			return "", errors.Wrapf(err, "failed to create dir for %s", file.Path)
+		}
+		if err := ioutil.WriteFile(stagedPath, file.Content, fi.Mode().Perm()); err != nil {
+			return "", errors.Wrapf(err, "failed to stage %s", file.Path)
+		}
+	}
+
+	return upload.CreateUploadableArchive(stagingDir)
+}
+
+// uploadArchive sends the archive to the kotsadm upload endpoint as a new version of the existing app.
+// Progress is passed to downloadOptions.OnUploadProgress as the request body is sent.
+func uploadArchive(appSlug string, archiveFilename string, uploadOptions upload.UploadOptions, downloadOptions DownloadOptions, conn *kotsadmclient.Client, log logger.Interface) error {
+	requestBody, err := upload.NewUploadRequestBody(archiveFilename, uploadOptions)
+	if err != nil {
+		return errors.Wrap(err, "failed to create upload request body")
+	}
+
+	url := conn.URL("/api/v1/upload")
+	size := int64(len(requestBody.Body))
+
+	newRequest := func(authSlug string) (*http.Request, error) {
+		var reqBody io.Reader = bytes.NewReader(requestBody.Body)
+		if downloadOptions.OnUploadProgress != nil {
+			reqBody = &progressReader{r: reqBody, total: size, onProgress: downloadOptions.OnUploadProgress}
+		}
+
+		req, err := http.NewRequest(requestBody.Method, url, reqBody)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create upload request")
+		}
+		req = req.WithContext(conn.Context())
+		req.ContentLength = size
+		req.Header.Add("Authorization", authSlug)
+		req.Header.Set("User-Agent", getUserAgent(downloadOptions))
+		req.Header.Set("Content-Type", requestBody.ContentType)
+
+		log.Debug("Uploading %d bytes to %s (Authorization redacted)", size, url)
+		return req, nil
+	}
+
+	resp, err := conn.Do(newRequest)
+	if err != nil {
+		return conn.PortForwardError(errors.Wrap(err, "failed to upload to kotsadm"))
+	}
+	defer resp.Body.Close()
+
+	log.Debug("kotsadm responded with %s", resp.Status)
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case kotsadmclient.IsAuthError(resp.StatusCode):
+		return errors.Wrap(util.NewHTTPStatusError(url, resp), "authentication to kotsadm failed")
+	case resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnprocessableEntity:
+		return uploadValidationError(appSlug, url, resp)
+	default:
+		return errors.Wrap(util.NewHTTPStatusError(url, resp), "failed to upload to kotsadm")
+	}
+}
+
+// uploadValidationError returns the reason that kotsadm gave for rejecting the version, such as
+// config errors or failed preflights
+func uploadValidationError(appSlug string, url string, resp *http.Response) error {
+	statusErr := util.NewHTTPStatusError(url, resp)
+
+	message := statusErr.Body
+	validationResponse := struct {
+		Error string `json:"error"`
+	}{}
+	if err := json.Unmarshal([]byte(statusErr.Body), &validationResponse); err == nil && validationResponse.Error != "" {
+		message = validationResponse.Error
+	}
+	if strings.TrimSpace(message) == "" {
+		return errors.Wrap(statusErr, "kotsadm rejected the upload")
+	}
+
+	return util.ActionableError{
+		Message: fmt.Sprintf("kotsadm rejected the upload of %s: %s", appSlug, message),
+	}
+}
+
+// progressReader calls onProgress with the number of bytes read so far
+type progressReader struct {
+	r          io.Reader
+	n          int64
+	total      int64
+	onProgress func(bytes int64, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.n += int64(n)
+		p.onProgress(p.n, p.total)
+	}
+	return n, err
+}
@@ -0,0 +1,216 @@
+package download
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/upload"
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_createUploadArchive(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	appDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(appDir)
+
+	files := map[string]string{
+		"upstream/deployment.yaml":      "kind: Deployment",
+		"upstream/hooks/migrate.sh":     "#!/bin/sh",
+		"base/deployment.yaml":          "kind: Deployment",
+		"overlays/midstream/k.yaml":     "kind: Kustomization",
+		"overlays/midstream/k.yaml.bak": "kind: Kustomization",
+		"notes/todo.txt":                "edit the deployment",
+	}
+	for path, content := range files {
+		req.NoError(os.MkdirAll(filepath.Join(appDir, filepath.Dir(path)), 0755))
+		req.NoError(ioutil.WriteFile(filepath.Join(appDir, path), []byte(content), 0644))
+	}
+	req.NoError(os.Chmod(filepath.Join(appDir, "upstream", "hooks", "migrate.sh"), 0755))
+
+	// the archive is the one that kots upload creates, with only the upstream, base and overlays
+	archiveFilename, err := createUploadArchive(appDir)
+	req.NoError(err)
+	defer os.Remove(archiveFilename)
+	archive, modes := readTestArchiveFile(t, archiveFilename)
+	assert.Equal(t, map[string]string{
+		"upstream/deployment.yaml":      "kind: Deployment",
+		"upstream/hooks/migrate.sh":     "#!/bin/sh",
+		"base/deployment.yaml":          "kind: Deployment",
+		"overlays/midstream/k.yaml":     "kind: Kustomization",
+		"overlays/midstream/k.yaml.bak": "kind: Kustomization",
+	}, archive)
+	assert.Equal(t, int64(0755), modes["upstream/hooks/migrate.sh"])
+
+	// ignored files are left out, and the modes of the others are kept
+	req.NoError(ioutil.WriteFile(filepath.Join(appDir, ".kotsignore"), []byte("*.bak\n"), 0644))
+	archiveFilename, err = createUploadArchive(appDir)
+	req.NoError(err)
+	defer os.Remove(archiveFilename)
+	archive, modes = readTestArchiveFile(t, archiveFilename)
+	assert.Equal(t, map[string]string{
+		"upstream/deployment.yaml":  "kind: Deployment",
+		"upstream/hooks/migrate.sh": "#!/bin/sh",
+		"base/deployment.yaml":      "kind: Deployment",
+		"overlays/midstream/k.yaml": "kind: Kustomization",
+	}, archive)
+	assert.Equal(t, int64(0755), modes["upstream/hooks/migrate.sh"])
+	assert.Equal(t, int64(0644), modes["base/deployment.yaml"])
+}
+
+func Test_uploadArchive(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		body       string
+		wantErr    string
+	}{
+		{
+			name:       "uploaded",
+			statusCode: http.StatusOK,
+		},
+		{
+			name:       "config errors",
+			statusCode: http.StatusBadRequest,
+			body:       `{"error": "config item hostname is required"}`,
+			wantErr:    "kotsadm rejected the upload of my-app: config item hostname is required",
+		},
+		{
+			name:       "server error",
+			statusCode: http.StatusInternalServerError,
+			wantErr:    "failed to upload to kotsadm",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			archiveFile, err := ioutil.TempFile("", "kots-upload")
+			req.NoError(err)
+			defer os.Remove(archiveFile.Name())
+			_, err = archiveFile.Write([]byte("archive"))
+			req.NoError(err)
+			req.NoError(archiveFile.Close())
+
+			appDir := testUploadApp(t)
+			defer os.RemoveAll(appDir)
+			uploadOptions := upload.UploadOptions{ExistingAppSlug: "my-app"}
+			req.NoError(upload.ReadUploadMetadata(appDir, &uploadOptions))
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "PUT", r.Method)
+				assert.Equal(t, "/api/v1/upload", r.URL.Path)
+				assert.Equal(t, "auth-slug", r.Header.Get("Authorization"))
+
+				file, _, err := r.FormFile("file")
+				assert.NoError(t, err)
+				uploaded, err := ioutil.ReadAll(file)
+				assert.NoError(t, err)
+				assert.Equal(t, []byte("archive"), uploaded)
+
+				metadata := map[string]string{}
+				assert.NoError(t, json.Unmarshal([]byte(r.FormValue("metadata")), &metadata))
+				assert.Equal(t, "my-app", metadata["slug"])
+				assert.Equal(t, "12", metadata["updateCursor"])
+				assert.Equal(t, "1.0.1", metadata["versionLabel"])
+
+				w.WriteHeader(test.statusCode)
+				w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+
+			var sent, total int64
+			downloadOptions := DownloadOptions{
+				Silent: true,
+				OnUploadProgress: func(bytes int64, t int64) {
+					sent = bytes
+					total = t
+				},
+			}
+
+			err = uploadArchive("my-app", archiveFile.Name(), uploadOptions, downloadOptions, testKotsadmConnection(t, server), getLogger(downloadOptions))
+			assert.NotZero(t, total)
+			assert.Equal(t, total, sent)
+			if test.wantErr == "" {
+				req.NoError(err)
+				return
+			}
+			req.Error(err)
+			assert.Contains(t, err.Error(), test.wantErr)
+			if test.statusCode == http.StatusBadRequest {
+				assert.IsType(t, util.ActionableError{}, err)
+			}
+		})
+	}
+}
+
+// testUploadApp returns an app dir with an installation, which the caller should remove
+func testUploadApp(t *testing.T) string {
+	appDir, err := ioutil.TempDir("", "kots")
+	require.NoError(t, err)
+
+	installation := `apiVersion: kots.io/v1beta1
+kind: Installation
+metadata:
+  name: my-app
+spec:
+  updateCursor: "12"
+  versionLabel: 1.0.1
+`
+	require.NoError(t, os.MkdirAll(filepath.Join(appDir, "upstream", "userdata"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(appDir, "upstream", "userdata", "installation.yaml"), []byte(installation), 0644))
+
+	return appDir
+}
+
+// readTestArchiveFile returns the contents and modes of each file in a tar gz, without the top level folder
+func readTestArchiveFile(t *testing.T, archiveFilename string) (map[string]string, map[string]int64) {
+	f, err := os.Open(archiveFilename)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	files := map[string]string{}
+	modes := map[string]int64{}
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+
+		parts := strings.SplitN(header.Name, "/", 2)
+		require.Len(t, parts, 2)
+		files[parts[1]] = string(content)
+		modes[parts[1]] = header.Mode & 0777
+	}
+
+	return files, modes
+}
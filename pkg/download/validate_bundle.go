@@ -0,0 +1,200 @@
+package download
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// ManifestFile is the name of the checksum manifest that ValidateBundle checks a bundle against when
+// it's in the bundle. Each line is the sha256 of a file and its path relative to the bundle, separated
+// by whitespace, which is the format that sha256sum writes.
+const ManifestFile = ".kots-manifest"
+
+// requiredBundleFiles are the files that every app downloaded from kotsadm has
+var requiredBundleFiles = []string{
+	"base/kustomization.yaml",
+	"overlays/midstream/kustomization.yaml",
+}
+
+// bundleTopLevelEntries are the entries that can be at the top level of a bundle
+var bundleTopLevelEntries = []string{
+	"upstream",
+	"base",
+	"overlays",
+	ChecksumFile,
+	ImageListFile,
+	ManifestFile,
+}
+
+// BundleReport describes the problems that ValidateBundle found in a bundle
+type BundleReport struct {
+	// MissingFiles are required files, and files in the manifest, that aren't in the bundle
+	MissingFiles []string
+	// ExtraFiles are top-level entries that aren't part of an app, and files that aren't in the manifest
+	ExtraFiles []string
+	// ChecksumMismatches are the files whose sha256 doesn't match the manifest
+	ChecksumMismatches []string
+	// HasManifest is true when the bundle has a ManifestFile that the files were checked against
+	HasManifest bool
+}
+
+// IsValid returns true if no problems were found
+func (r *BundleReport) IsValid() bool {
+	return len(r.MissingFiles) == 0 && len(r.ExtraFiles) == 0 && len(r.ChecksumMismatches) == 0
+}
+
+// ValidateBundle checks that the app that Download extracted to path is complete, such as before it's
+// moved across an air gap. It doesn't connect to a cluster. An error is only returned if the bundle
+// can't be read, problems with its contents are in the report.
+func ValidateBundle(path string) (*BundleReport, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat bundle")
+	}
+	if !info.IsDir() {
+		return nil, util.ActionableError{Message: fmt.Sprintf("%s is not a directory", path)}
+	}
+
+	files, err := listDownloadedFiles(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list bundle files")
+	}
+
+	report := BundleReport{
+		MissingFiles:       []string{},
+		ExtraFiles:         []string{},
+		ChecksumMismatches: []string{},
+	}
+
+	hasFile := map[string]bool{}
+	for _, file := range files {
+		hasFile[file] = true
+	}
+
+	for _, required := range requiredBundleFiles {
+		if !hasFile[required] {
+			report.MissingFiles = append(report.MissingFiles, required)
+		}
+	}
+	if !hasFileWithPrefix(files, "upstream/") {
+		report.MissingFiles = append(report.MissingFiles, "upstream/")
+	}
+
+	for _, file := range files {
+		topLevelEntry := strings.SplitN(file, "/", 2)[0]
+		if !containsString(bundleTopLevelEntries, topLevelEntry) {
+			report.ExtraFiles = append(report.ExtraFiles, file)
+		}
+	}
+
+	if hasFile[ManifestFile] {
+		report.HasManifest = true
+		if err := checkBundleManifest(path, files, &report); err != nil {
+			return nil, errors.Wrap(err, "failed to check manifest")
+		}
+	}
+
+	sort.Strings(report.MissingFiles)
+	report.ExtraFiles = uniqueSortedStrings(report.ExtraFiles)
+	sort.Strings(report.ChecksumMismatches)
+
+	return &report, nil
+}
+
+// checkBundleManifest compares the files in the bundle with the manifest, and adds the differences to report
+func checkBundleManifest(bundlePath string, files []string, report *BundleReport) error {
+	manifest, err := readBundleManifest(filepath.Join(bundlePath, ManifestFile))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file == ManifestFile || file == ChecksumFile {
+			continue
+		}
+		if _, ok := manifest[file]; !ok {
+			report.ExtraFiles = append(report.ExtraFiles, file)
+		}
+	}
+
+	for file, expected := range manifest {
+		actual, err := fileChecksum(filepath.Join(bundlePath, filepath.FromSlash(file)))
+		if os.IsNotExist(errors.Cause(err)) {
+			report.MissingFiles = append(report.MissingFiles, file)
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to get checksum of %s", file)
+		}
+		if actual != expected {
+			report.ChecksumMismatches = append(report.ChecksumMismatches, file)
+		}
+	}
+
+	return nil
+}
+
+// readBundleManifest returns the sha256 of each file in the manifest, by the cleaned path of the file
+func readBundleManifest(manifestPath string) (map[string]string, error) {
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open manifest")
+	}
+	defer f.Close()
+
+	manifest := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, util.ActionableError{Message: fmt.Sprintf("line %d of %s is not a checksum and a path", lineNumber, ManifestFile)}
+		}
+
+		// sha256sum marks paths that were read in binary mode with a leading *
+		filePath := path.Clean(strings.TrimPrefix(strings.TrimPrefix(fields[1], "*"), "./"))
+		if path.IsAbs(filePath) || filePath == ".." || strings.HasPrefix(filePath, "../") {
+			return nil, util.ActionableError{Message: fmt.Sprintf("line %d of %s has a path outside of the bundle", lineNumber, ManifestFile)}
+		}
+		manifest[filePath] = strings.ToLower(fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read manifest")
+	}
+
+	return manifest, nil
+}
+
+func hasFileWithPrefix(files []string, prefix string) bool {
+	for _, file := range files {
+		if strings.HasPrefix(file, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueSortedStrings(values []string) []string {
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
@@ -0,0 +1,137 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_ValidateBundle(t *testing.T) {
+	bundleFiles := map[string]string{
+		"upstream/deployment.yaml":              "kind: Deployment",
+		"base/kustomization.yaml":               "resources: []",
+		"overlays/midstream/kustomization.yaml": "bases: []",
+	}
+
+	tests := []struct {
+		name        string
+		files       map[string]string
+		manifest    func(files map[string]string) string
+		expect      BundleReport
+		expectValid bool
+	}{
+		{
+			name:  "complete",
+			files: bundleFiles,
+			expect: BundleReport{
+				MissingFiles:       []string{},
+				ExtraFiles:         []string{},
+				ChecksumMismatches: []string{},
+			},
+			expectValid: true,
+		},
+		{
+			name: "missing and extra files",
+			files: map[string]string{
+				"base/kustomization.yaml": "resources: []",
+				"notes.txt":               "hello",
+			},
+			expect: BundleReport{
+				MissingFiles:       []string{"overlays/midstream/kustomization.yaml", "upstream/"},
+				ExtraFiles:         []string{"notes.txt"},
+				ChecksumMismatches: []string{},
+			},
+		},
+		{
+			name:  "matching manifest",
+			files: bundleFiles,
+			manifest: func(files map[string]string) string {
+				manifest := ""
+				for name, content := range files {
+					manifest += fmt.Sprintf("%s  ./%s\n", sha256Hex(content), name)
+				}
+				return manifest
+			},
+			expect: BundleReport{
+				MissingFiles:       []string{},
+				ExtraFiles:         []string{},
+				ChecksumMismatches: []string{},
+				HasManifest:        true,
+			},
+			expectValid: true,
+		},
+		{
+			name:  "manifest differences",
+			files: bundleFiles,
+			manifest: func(files map[string]string) string {
+				return fmt.Sprintf("%s  base/kustomization.yaml\n%s *overlays/midstream/kustomization.yaml\n%s  upstream/service.yaml\n",
+					sha256Hex("changed"), sha256Hex(files["overlays/midstream/kustomization.yaml"]), sha256Hex("kind: Service"))
+			},
+			expect: BundleReport{
+				MissingFiles:       []string{"upstream/service.yaml"},
+				ExtraFiles:         []string{"upstream/deployment.yaml"},
+				ChecksumMismatches: []string{"base/kustomization.yaml"},
+				HasManifest:        true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			bundlePath, err := ioutil.TempDir("", "kots")
+			req.NoError(err)
+			defer os.RemoveAll(bundlePath)
+
+			for name, content := range test.files {
+				filePath := filepath.Join(bundlePath, filepath.FromSlash(name))
+				req.NoError(os.MkdirAll(filepath.Dir(filePath), 0755))
+				req.NoError(ioutil.WriteFile(filePath, []byte(content), 0644))
+			}
+			if test.manifest != nil {
+				req.NoError(ioutil.WriteFile(filepath.Join(bundlePath, ManifestFile), []byte(test.manifest(test.files)), 0644))
+			}
+
+			report, err := ValidateBundle(bundlePath)
+			req.NoError(err)
+			assert.Equal(t, test.expect, *report)
+			assert.Equal(t, test.expectValid, report.IsValid())
+		})
+	}
+}
+
+func Test_ValidateBundleInvalidManifest(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	bundlePath, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(bundlePath)
+
+	req.NoError(ioutil.WriteFile(filepath.Join(bundlePath, ManifestFile), []byte(sha256Hex("x")+"  ../outside.yaml\n"), 0644))
+
+	_, err = ValidateBundle(bundlePath)
+	req.Error(err)
+
+	_, err = ValidateBundle(filepath.Join(bundlePath, ManifestFile))
+	req.Error(err)
+	assert.IsType(t, util.ActionableError{}, err)
+}
+
+func sha256Hex(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
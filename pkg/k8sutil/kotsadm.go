@@ -7,17 +7,75 @@ import (
 	"k8s.io/client-go/kubernetes"
 )
 
-func FindKotsadm(clientset *kubernetes.Clientset, namespace string) (string, error) {
-	pods, err := clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: "app=kotsadm"})
+// KotsadmPodSelector is the label selector of the kotsadm pods in a standard install
+const KotsadmPodSelector = "app=kotsadm"
+
+// DefaultKotsadmPort is the port that kotsadm listens on in its pod when the container doesn't name an
+// http port
+const DefaultKotsadmPort = 3000
+
+// FindKotsadm returns the name of a ready kotsadm pod. Pods that are terminating or not ready are
+// skipped, so that another replica is used during a rollout. An empty selector uses KotsadmPodSelector,
+// other selectors find installs that label kotsadm differently.
+func FindKotsadm(clientset kubernetes.Interface, namespace string, selector string) (string, error) {
+	if selector == "" {
+		selector = KotsadmPodSelector
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: selector})
 	if err != nil {
 		return "", errors.Wrap(err, "failed to list pods")
 	}
 
+	notReady := 0
 	for _, pod := range pods.Items {
-		if pod.Status.Phase == corev1.PodRunning {
+		if isPodReady(&pod) {
 			return pod.Name, nil
 		}
+		notReady++
 	}
 
+	if notReady > 0 {
+		return "", errors.Errorf("unable to find a ready kotsadm pod, %d pods with selector %s are not ready or are terminating", notReady, selector)
+	}
+	if selector != KotsadmPodSelector {
+		return "", errors.Errorf("unable to find kotsadm pod with selector %s", selector)
+	}
 	return "", errors.New("unable to find kotsadm pod")
 }
+
+// KotsadmHTTPPort returns the port named http of the kotsadm container in the pod, which follows the
+// configured container port of the deploy, or DefaultKotsadmPort if there isn't one
+func KotsadmHTTPPort(clientset kubernetes.Interface, namespace string, podName string) (int, error) {
+	pod, err := clientset.CoreV1().Pods(namespace).Get(podName, metav1.GetOptions{})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get kotsadm pod")
+	}
+
+	for _, container := range pod.Spec.Containers {
+		if container.Name != "kotsadm" {
+			continue
+		}
+		for _, port := range container.Ports {
+			if port.Name == "http" {
+				return int(port.ContainerPort), nil
+			}
+		}
+	}
+
+	return DefaultKotsadmPort, nil
+}
+
+// isPodReady returns true for a running pod that isn't terminating and has passed its readiness checks
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
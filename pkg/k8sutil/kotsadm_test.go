@@ -0,0 +1,120 @@
+package k8sutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFindKotsadm(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	pod := func(name string, labels map[string]string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+			Status: corev1.PodStatus{
+				Phase:      phase,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+
+	clientset := fake.NewSimpleClientset(
+		pod("kotsadm-pending", map[string]string{"app": "kotsadm"}, corev1.PodPending),
+		pod("kotsadm", map[string]string{"app": "kotsadm"}, corev1.PodRunning),
+		pod("my-release-admin", map[string]string{"app.kubernetes.io/name": "admin-console", "release": "my-release"}, corev1.PodRunning),
+	)
+
+	podName, err := FindKotsadm(clientset, "default", "")
+	req.NoError(err)
+	assert.Equal(t, "kotsadm", podName)
+
+	podName, err = FindKotsadm(clientset, "default", "app.kubernetes.io/name=admin-console,release=my-release")
+	req.NoError(err)
+	assert.Equal(t, "my-release-admin", podName)
+
+	_, err = FindKotsadm(clientset, "default", "release=other")
+	req.Error(err)
+	assert.Contains(t, err.Error(), "release=other")
+
+	_, err = FindKotsadm(clientset, "other", "")
+	req.Error(err)
+}
+
+func TestFindKotsadmMultiplePods(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	labels := map[string]string{"app": "kotsadm"}
+	deletionTimestamp := metav1.Now()
+	terminating := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "kotsadm-old", Namespace: "default", Labels: labels, DeletionTimestamp: &deletionTimestamp},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	notReady := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "kotsadm-starting", Namespace: "default", Labels: labels},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}},
+		},
+	}
+	ready := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "kotsadm-ready", Namespace: "default", Labels: labels},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+
+	clientset := fake.NewSimpleClientset(terminating, notReady, ready)
+	podName, err := FindKotsadm(clientset, "default", "")
+	req.NoError(err)
+	assert.Equal(t, "kotsadm-ready", podName)
+
+	clientset = fake.NewSimpleClientset(terminating, notReady)
+	_, err = FindKotsadm(clientset, "default", "")
+	req.Error(err)
+	assert.Contains(t, err.Error(), "2 pods")
+}
+
+func TestKotsadmHTTPPort(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	pod := func(name string, containers ...corev1.Container) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       corev1.PodSpec{Containers: containers},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		pod("kotsadm-custom",
+			corev1.Container{Name: "proxy", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8443}}},
+			corev1.Container{Name: "kotsadm", Ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: 9090}, {Name: "http", ContainerPort: 8080}}},
+		),
+		pod("kotsadm-unnamed", corev1.Container{Name: "kotsadm"}),
+	)
+
+	port, err := KotsadmHTTPPort(clientset, "default", "kotsadm-custom")
+	req.NoError(err)
+	assert.Equal(t, 8080, port)
+
+	port, err = KotsadmHTTPPort(clientset, "default", "kotsadm-unnamed")
+	req.NoError(err)
+	assert.Equal(t, DefaultKotsadmPort, port)
+
+	_, err = KotsadmHTTPPort(clientset, "default", "kotsadm-missing")
+	req.Error(err)
+}
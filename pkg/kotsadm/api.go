@@ -85,7 +85,7 @@ func ensureAPI(deployOptions *types.DeployOptions, clientset *kubernetes.Clients
 		return errors.Wrap(err, "failed to ensure api rbac")
 	}
 
-	if err := ensureApplicationMetadata(*deployOptions, clientset); err != nil {
+	if err := EnsureApplicationMetadata(*deployOptions, clientset); err != nil {
 		return errors.Wrap(err, "failed to ensure custom branding")
 	}
 	if err := ensureAPIDeployment(*deployOptions, clientset); err != nil {
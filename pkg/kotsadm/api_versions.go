@@ -0,0 +1,42 @@
+package kotsadm
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/util"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+)
+
+// minKubernetesVersion is the first version that serves the rbac and apps api versions that the admin
+// console resources are generated with. There aren't any admin console resources with api versions that
+// differ across the versions that are supported, so the version is only checked and nothing is selected.
+var minKubernetesVersion = version.MustParseGeneric("1.9.0")
+
+// getKubernetesVersion returns the version of the api server
+func getKubernetesVersion(clientset kubernetes.Interface) (*version.Version, error) {
+	info, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get server version")
+	}
+
+	// git versions of managed clusters have a suffix (v1.16.8-eks-e16311), which isn't valid semver
+	serverVersion, err := version.ParseGeneric(info.GitVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse server version %q", info.GitVersion)
+	}
+
+	return serverVersion, nil
+}
+
+// checkKubernetesVersion returns an error if the kubernetes version is too old for the admin console
+func checkKubernetesVersion(kubernetesVersion *version.Version) error {
+	if !kubernetesVersion.AtLeast(minKubernetesVersion) {
+		return util.ActionableError{
+			Message: fmt.Sprintf("Kubernetes %s is not supported, the Admin Console requires Kubernetes %s or later", kubernetesVersion, minKubernetesVersion),
+		}
+	}
+
+	return nil
+}
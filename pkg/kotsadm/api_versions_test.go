@@ -0,0 +1,69 @@
+package kotsadm
+
+import (
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_checkKubernetesVersion(t *testing.T) {
+	tests := []struct {
+		name          string
+		gitVersion    string
+		expectVersion string
+	}{
+		{
+			name:          "1.16 managed cluster",
+			gitVersion:    "v1.16.8-eks-e16311",
+			expectVersion: "1.16.8",
+		},
+		{
+			name:          "1.21",
+			gitVersion:    "v1.21.2",
+			expectVersion: "1.21.2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			clientset := fake.NewSimpleClientset()
+			clientset.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{
+				GitVersion: test.gitVersion,
+			}
+
+			kubernetesVersion, err := getKubernetesVersion(clientset)
+			req.NoError(err)
+			assert.Equal(t, test.expectVersion, kubernetesVersion.String())
+
+			req.NoError(checkKubernetesVersion(kubernetesVersion))
+		})
+	}
+}
+
+func Test_checkKubernetesVersionUnsupported(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	clientset := fake.NewSimpleClientset()
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{
+		GitVersion: "v1.8.15",
+	}
+
+	kubernetesVersion, err := getKubernetesVersion(clientset)
+	req.NoError(err)
+
+	err = checkKubernetesVersion(kubernetesVersion)
+	req.Error(err)
+	assert.IsType(t, util.ActionableError{}, err)
+}
@@ -25,17 +25,36 @@ func getApplicationMetadataYAML(data []byte, namespace string) (map[string][]byt
 	return docs, nil
 }
 
-func ensureApplicationMetadata(deployOptions types.DeployOptions, clientset *kubernetes.Clientset) error {
-	_, err := clientset.CoreV1().ConfigMaps(deployOptions.Namespace).Get("kotsadm-application-metadata", metav1.GetOptions{})
+// EnsureApplicationMetadata creates the application metadata config map, or updates it when the
+// metadata has changed since the last deploy. Existing metadata is left in place when none is provided.
+func EnsureApplicationMetadata(deployOptions types.DeployOptions, clientset kubernetes.Interface) error {
+	desiredConfigMap := applicationMetadataConfig(deployOptions.ApplicationMetadata, deployOptions.Namespace)
+
+	existingConfigMap, err := clientset.CoreV1().ConfigMaps(deployOptions.Namespace).Get(desiredConfigMap.Name, metav1.GetOptions{})
 	if err != nil {
 		if !kuberneteserrors.IsNotFound(err) {
 			return errors.Wrap(err, "failed to get existing metadata config map")
 		}
 
-		_, err := clientset.CoreV1().ConfigMaps(deployOptions.Namespace).Create(applicationMetadataConfig(deployOptions.ApplicationMetadata, deployOptions.Namespace))
+		_, err := clientset.CoreV1().ConfigMaps(deployOptions.Namespace).Create(desiredConfigMap)
 		if err != nil {
 			return errors.Wrap(err, "failed to create metadata config map")
 		}
+
+		return nil
+	}
+
+	if deployOptions.ApplicationMetadata == nil {
+		return nil
+	}
+
+	if existingConfigMap.Data["application.yaml"] == desiredConfigMap.Data["application.yaml"] {
+		return nil
+	}
+
+	existingConfigMap.Data = desiredConfigMap.Data
+	if _, err := clientset.CoreV1().ConfigMaps(deployOptions.Namespace).Update(existingConfigMap); err != nil {
+		return errors.Wrap(err, "failed to update metadata config map")
 	}
 
 	return nil
@@ -0,0 +1,51 @@
+package kotsadm
+
+import (
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_EnsureApplicationMetadata(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	originalMetadata := []byte(`apiVersion: kots.io/v1beta1
+kind: Application
+metadata:
+  name: app-slug
+spec:
+  title: App Name`)
+	updatedMetadata := []byte(`apiVersion: kots.io/v1beta1
+kind: Application
+metadata:
+  name: app-slug
+spec:
+  title: New App Name`)
+
+	clientset := fake.NewSimpleClientset(applicationMetadataConfig(originalMetadata, "default"))
+
+	deployOptions := types.DeployOptions{
+		Namespace:           "default",
+		ApplicationMetadata: updatedMetadata,
+	}
+	req.NoError(EnsureApplicationMetadata(deployOptions, clientset))
+
+	configMap, err := clientset.CoreV1().ConfigMaps("default").Get("kotsadm-application-metadata", metav1.GetOptions{})
+	req.NoError(err)
+	assert.Equal(t, string(updatedMetadata), configMap.Data["application.yaml"])
+
+	// deploying without metadata does not remove the existing branding
+	deployOptions.ApplicationMetadata = nil
+	req.NoError(EnsureApplicationMetadata(deployOptions, clientset))
+
+	configMap, err = clientset.CoreV1().ConfigMaps("default").Get("kotsadm-application-metadata", metav1.GetOptions{})
+	req.NoError(err)
+	assert.Equal(t, string(updatedMetadata), configMap.Data["application.yaml"])
+}
@@ -0,0 +1,49 @@
+package kotsadm
+
+import (
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	"github.com/replicatedhq/kots/pkg/retry"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const defaultKubernetesMaxRetries = 3
+
+// kubernetesRetryOptions returns the retry options for the kubernetes api calls of a deploy
+func kubernetesRetryOptions(deployOptions types.DeployOptions) retry.Options {
+	maxRetries := deployOptions.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultKubernetesMaxRetries
+	} else if maxRetries < 0 {
+		maxRetries = 0
+	}
+
+	return retry.Options{
+		MaxAttempts:     maxRetries + 1,
+		InitialInterval: deployOptions.RetryBackoff,
+	}
+}
+
+// retryKubernetes calls fn again after a transient api server error. fn should get the resources it
+// changes each time it's called, so that a retry after a conflict updates the latest version.
+func retryKubernetes(deployOptions types.DeployOptions, fn func() error) error {
+	return retry.Do(kubernetesRetryOptions(deployOptions), isTransientKubernetesError, fn)
+}
+
+// isTransientKubernetesError returns true for errors that may succeed when the call is retried, such as
+// a conflict, a server timeout or a dropped connection. Errors such as forbidden or invalid are not.
+func isTransientKubernetesError(err error) bool {
+	cause := errors.Cause(err)
+
+	switch {
+	case kuberneteserrors.IsConflict(cause),
+		kuberneteserrors.IsServerTimeout(cause),
+		kuberneteserrors.IsTimeout(cause),
+		kuberneteserrors.IsTooManyRequests(cause),
+		kuberneteserrors.IsServiceUnavailable(cause):
+		return true
+	}
+
+	// connection errors, such as a reset while the api server elects a leader
+	return retry.IsTemporary(cause)
+}
@@ -0,0 +1,107 @@
+package kotsadm
+
+import (
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_isTransientKubernetesError(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	deployments := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	connectionReset := &url.Error{
+		Op:  "Get",
+		URL: "https://10.96.0.1:443/apis/apps/v1/namespaces/default/deployments/kotsadm",
+		Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET},
+	}
+
+	transient := []error{
+		kuberneteserrors.NewConflict(deployments, "kotsadm", errors.New("the object has been modified")),
+		kuberneteserrors.NewServerTimeout(deployments, "get", 1),
+		kuberneteserrors.NewTimeoutError("request timed out", 1),
+		kuberneteserrors.NewTooManyRequests("too many requests", 1),
+		kuberneteserrors.NewServiceUnavailable("unavailable"),
+		errors.Wrap(kuberneteserrors.NewServerTimeout(deployments, "get", 1), "failed to get existing deployment"),
+		errors.Wrap(connectionReset, "failed to get existing deployment"),
+	}
+	for _, err := range transient {
+		assert.True(t, isTransientKubernetesError(err), err.Error())
+	}
+
+	permanent := []error{
+		kuberneteserrors.NewForbidden(deployments, "kotsadm", errors.New("forbidden")),
+		kuberneteserrors.NewInvalid(schema.GroupKind{Group: "apps", Kind: "Deployment"}, "kotsadm", nil),
+		kuberneteserrors.NewNotFound(deployments, "kotsadm"),
+		errors.New("failed to find kotsadm container in deployment"),
+	}
+	for _, err := range permanent {
+		assert.False(t, isTransientKubernetesError(err), err.Error())
+	}
+}
+
+func Test_ensureKotsadmComponentRetry(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	deployOptions := types.DeployOptions{Namespace: "default", RetryBackoff: time.Millisecond}
+
+	// the first get of the deployment times out
+	clientset := fake.NewSimpleClientset()
+	gets := 0
+	clientset.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if gets == 1 {
+			return true, nil, kuberneteserrors.NewServerTimeout(schema.GroupResource{Group: "apps", Resource: "deployments"}, "get", 1)
+		}
+		return false, nil, nil
+	})
+
+	_, err := ensureKotsadmComponent(&deployOptions, clientset)
+	req.NoError(err)
+	assert.Equal(t, 2, gets)
+	_, err = clientset.AppsV1().Deployments("default").Get("kotsadm", metav1.GetOptions{})
+	req.NoError(err)
+
+	// a forbidden error isn't retried
+	clientset = fake.NewSimpleClientset()
+	gets = 0
+	clientset.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return true, nil, kuberneteserrors.NewForbidden(schema.GroupResource{Group: "apps", Resource: "deployments"}, "kotsadm", errors.New("forbidden"))
+	})
+
+	_, err = ensureKotsadmComponent(&deployOptions, clientset)
+	req.Error(err)
+	assert.True(t, kuberneteserrors.IsForbidden(errors.Cause(err)))
+	assert.Equal(t, 1, gets)
+
+	// retries give up after MaxRetries
+	clientset = fake.NewSimpleClientset()
+	gets = 0
+	clientset.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		return true, nil, kuberneteserrors.NewServerTimeout(schema.GroupResource{Group: "apps", Resource: "deployments"}, "get", 1)
+	})
+
+	deployOptions.MaxRetries = 2
+	_, err = ensureKotsadmComponent(&deployOptions, clientset)
+	req.Error(err)
+	assert.Equal(t, 3, gets)
+}
@@ -2,19 +2,27 @@ package kotsadm
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
 	"github.com/replicatedhq/kots/pkg/k8sutil"
 	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/retry"
 )
 
 var timeoutWaitingForKotsadm = time.Duration(time.Minute * 2)
@@ -41,6 +49,9 @@ func getKotsadmYAML(deployOptions types.DeployOptions) (map[string][]byte, error
 	}
 	docs["kotsadm-serviceaccount.yaml"] = serviceAccount.Bytes()
 
+	if err := validateDeployOptions(deployOptions); err != nil {
+		return nil, err
+	}
 	var deployment bytes.Buffer
 	if err := s.Encode(kotsadmDeployment(deployOptions), &deployment); err != nil {
 		return nil, errors.Wrap(err, "failed to marshal kotsadm deployment")
@@ -48,26 +59,49 @@ func getKotsadmYAML(deployOptions types.DeployOptions) (map[string][]byte, error
 	docs["kotsadm-deployment.yaml"] = deployment.Bytes()
 
 	var service bytes.Buffer
-	if err := s.Encode(kotsadmService(deployOptions.Namespace), &service); err != nil {
+	if err := s.Encode(kotsadmService(deployOptions.Namespace, kotsadmServicePort(deployOptions)), &service); err != nil {
 		return nil, errors.Wrap(err, "failed to marshal kotsadm service")
 	}
 	docs["kotsadm-service.yaml"] = service.Bytes()
 
+	if deployOptions.NetworkPolicy != nil {
+		var networkPolicy bytes.Buffer
+		if err := s.Encode(kotsadmNetworkPolicy(deployOptions.Namespace, *deployOptions.NetworkPolicy, kotsadmContainerPort(deployOptions)), &networkPolicy); err != nil {
+			return nil, errors.Wrap(err, "failed to marshal kotsadm network policy")
+		}
+		docs["kotsadm-networkpolicy.yaml"] = networkPolicy.Bytes()
+	}
+
 	return docs, nil
 }
 
-func waitForKotsadm(deployOptions *types.DeployOptions, clientset *kubernetes.Clientset) error {
+func kotsadmWaitTimeout(deployOptions types.DeployOptions) time.Duration {
+	if deployOptions.WaitTimeout > 0 {
+		return deployOptions.WaitTimeout
+	}
+	return timeoutWaitingForKotsadm
+}
+
+func waitForKotsadm(deployOptions *types.DeployOptions, clientset kubernetes.Interface) error {
 	start := time.Now()
 
+	if err := waitForKotsadmPod(deployOptions.Namespace, deployOptions.ReadyContainerName, clientset, kotsadmWaitTimeout(*deployOptions)); err != nil {
+		return err
+	}
+	if !deployOptions.WaitForEndpoints {
+		return nil
+	}
+
+	// a ready pod doesn't mean that the service is routing to it yet
 	for {
-		pods, err := clientset.CoreV1().Pods(deployOptions.Namespace).List(metav1.ListOptions{LabelSelector: "app=kotsadm"})
-		if err != nil {
-			return errors.Wrap(err, "failed to list pods")
+		endpoints, err := clientset.CoreV1().Endpoints(deployOptions.Namespace).Get("kotsadm", metav1.GetOptions{})
+		if err != nil && !kuberneteserrors.IsNotFound(err) {
+			return errors.Wrap(err, "failed to get endpoints")
 		}
 
-		for _, pod := range pods.Items {
-			if pod.Status.Phase == corev1.PodRunning {
-				if pod.Status.ContainerStatuses[0].Ready == true {
+		if err == nil {
+			for _, subset := range endpoints.Subsets {
+				if len(subset.Addresses) > 0 {
 					return nil
 				}
 			}
@@ -75,235 +109,869 @@ func waitForKotsadm(deployOptions *types.DeployOptions, clientset *kubernetes.Cl
 
 		time.Sleep(time.Second)
 
-		if time.Now().Sub(start) > timeoutWaitingForKotsadm {
-			return errors.New("timeout waiting for kotsadm pod")
+		if time.Now().Sub(start) > kotsadmWaitTimeout(*deployOptions) {
+			return errors.New("timeout waiting for kotsadm service endpoints")
 		}
 	}
 }
 
-func ensureKotsadmComponent(deployOptions *types.DeployOptions, clientset *kubernetes.Clientset) error {
-	if err := ensureKotsadmRBAC(*deployOptions, clientset); err != nil {
-		return errors.Wrap(err, "failed to ensure kotsadm rbac")
+// kotsadmRBACResult describes the rbac that was applied for kotsadm. Resources are listed as kind/name.
+type kotsadmRBACResult struct {
+	ClusterScoped bool
+	Created       []string
+	Existing      []string
+	// AppendedSubject is true when the kotsadm service account was added to an existing cluster role binding
+	AppendedSubject bool
+}
+
+// ensureKotsadmComponent ensures the kotsadm rbac, deployment, service and network policy. Each of them is
+// ensured again after a transient api server error, see retryKubernetes.
+func ensureKotsadmComponent(deployOptions *types.DeployOptions, clientset kubernetes.Interface) (*kotsadmRBACResult, error) {
+	var rbacResult *kotsadmRBACResult
+	err := retryKubernetes(*deployOptions, func() error {
+		result, err := ensureKotsadmRBAC(*deployOptions, clientset)
+		rbacResult = result
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ensure kotsadm rbac")
+	}
+
+	err = retryKubernetes(*deployOptions, func() error {
+		return EnsureApplicationMetadata(*deployOptions, clientset)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ensure custom branding")
 	}
 
-	if err := ensureApplicationMetadata(*deployOptions, clientset); err != nil {
-		return errors.Wrap(err, "failed to ensure custom branding")
+	err = retryKubernetes(*deployOptions, func() error {
+		_, err := ensureKotsadmDeployment(*deployOptions, clientset)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ensure kotsadm deployment")
 	}
-	if err := ensureKotsadmDeployment(*deployOptions, clientset); err != nil {
-		return errors.Wrap(err, "failed to ensure kotsadm deployment")
+
+	err = retryKubernetes(*deployOptions, func() error {
+		return ensureKotsadmService(*deployOptions, clientset)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ensure kotsadm service")
 	}
 
-	if err := ensureKotsadmService(deployOptions.Namespace, clientset); err != nil {
-		return errors.Wrap(err, "failed to ensure kotsadm service")
+	err = retryKubernetes(*deployOptions, func() error {
+		return ensureKotsadmNetworkPolicy(*deployOptions, clientset)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ensure kotsadm network policy")
 	}
 
-	return nil
+	return rbacResult, nil
 }
 
-func ensureKotsadmRBAC(deployOptions types.DeployOptions, clientset *kubernetes.Clientset) error {
+func ensureKotsadmRBAC(deployOptions types.DeployOptions, clientset kubernetes.Interface) (*kotsadmRBACResult, error) {
 	isClusterScoped, err := isKotsadmClusterScoped(deployOptions.ApplicationMetadata)
 	if err != nil {
-		return errors.Wrap(err, "failed to check if kotsadm is cluster scoped")
+		return nil, errors.Wrap(err, "failed to check if kotsadm is cluster scoped")
 	}
 
 	if isClusterScoped {
 		return ensureKotsadmClusterRBAC(deployOptions, clientset)
 	}
 
-	if err := ensureKotsadmRole(deployOptions.Namespace, clientset); err != nil {
-		return errors.Wrap(err, "failed to ensure kotsadm role")
+	result := &kotsadmRBACResult{}
+
+	created, err := ensureKotsadmRole(deployOptions.Namespace, clientset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ensure kotsadm role")
 	}
+	result.add("Role/kotsadm-role", created)
 
-	if err := ensureKotsadmRoleBinding(deployOptions.Namespace, clientset); err != nil {
-		return errors.Wrap(err, "failed to ensure kotsadm role binding")
+	created, err = ensureKotsadmRoleBinding(deployOptions.Namespace, clientset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ensure kotsadm role binding")
 	}
+	result.add("RoleBinding/kotsadm-rolebinding", created)
 
-	if err := ensureKotsadmServiceAccount(deployOptions.Namespace, clientset); err != nil {
-		return errors.Wrap(err, "failed to ensure kotsadm service account")
+	created, err = ensureKotsadmServiceAccount(deployOptions.Namespace, clientset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ensure kotsadm service account")
 	}
+	result.add("ServiceAccount/kotsadm", created)
 
-	return nil
+	return result, nil
 }
 
-// ensureKotsadmClusterRBAC will ensure that the cluster role and cluster role bindings exists
-func ensureKotsadmClusterRBAC(deployOptions types.DeployOptions, clientset *kubernetes.Clientset) error {
-	err := ensureKotsadmClusterRole(clientset)
+// ensureKotsadmClusterRBAC will ensure that the cluster role and cluster role bindings exists. The binding
+// is ensured last since it's what grants access, and a cluster role that was created here is deleted
+// again if the binding can't be ensured, so a failure doesn't leave an unbound cluster role behind.
+func ensureKotsadmClusterRBAC(deployOptions types.DeployOptions, clientset kubernetes.Interface) (*kotsadmRBACResult, error) {
+	result := &kotsadmRBACResult{ClusterScoped: true}
+
+	created, err := ensureKotsadmServiceAccount(deployOptions.Namespace, clientset)
 	if err != nil {
-		return errors.Wrap(err, "failed to ensure kotsadm cluster role")
+		return nil, errors.Wrap(err, "failed to ensure kotsadm service account")
 	}
+	result.add("ServiceAccount/kotsadm", created)
 
-	if err := ensureKotsadmClusterRoleBinding(deployOptions.Namespace, clientset); err != nil {
-		return errors.Wrap(err, "failed to ensure kotsadm cluster role binding")
+	createdClusterRole, err := ensureKotsadmClusterRole(clientset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to ensure kotsadm cluster role")
 	}
+	result.add("ClusterRole/kotsadm-role", createdClusterRole)
 
-	if err := ensureKotsadmServiceAccount(deployOptions.Namespace, clientset); err != nil {
-		return errors.Wrap(err, "failed to ensure kotsadm service account")
+	created, appended, err := ensureKotsadmClusterRoleBinding(deployOptions.Namespace, clientset)
+	if err != nil {
+		err = errors.Wrap(err, "failed to ensure kotsadm cluster role binding")
+		if createdClusterRole {
+			if deleteErr := clientset.RbacV1().ClusterRoles().Delete("kotsadm-role", &metav1.DeleteOptions{}); deleteErr != nil && !kuberneteserrors.IsNotFound(deleteErr) {
+				return nil, errors.Wrapf(err, "failed to delete cluster role after error: %v", deleteErr)
+			}
+		}
+		return nil, err
 	}
+	result.add("ClusterRoleBinding/kotsadm-rolebinding", created)
+	result.AppendedSubject = appended
 
-	return nil
+	return result, nil
+}
+
+func (r *kotsadmRBACResult) add(resource string, created bool) {
+	if created {
+		r.Created = append(r.Created, resource)
+	} else {
+		r.Existing = append(r.Existing, resource)
+	}
 }
 
-func ensureKotsadmClusterRole(clientset *kubernetes.Clientset) error {
+func ensureKotsadmClusterRole(clientset kubernetes.Interface) (bool, error) {
 	_, err := clientset.RbacV1().ClusterRoles().Create(kotsadmClusterRole())
-	if err == nil || kuberneteserrors.IsAlreadyExists(err) {
-		return nil
+	if err == nil {
+		return true, nil
+	}
+	if kuberneteserrors.IsAlreadyExists(err) {
+		return false, nil
 	}
 
-	return errors.Wrap(err, "failed to create cluster role")
+	return false, errors.Wrap(err, "failed to create cluster role")
 }
 
-func ensureKotsadmClusterRoleBinding(serviceAccountNamespace string, clientset *kubernetes.Clientset) error {
-	clusterRoleBinding, err := clientset.RbacV1().ClusterRoleBindings().Get("kotsadm-rolebinding", metav1.GetOptions{})
-	if kuberneteserrors.IsNotFound(err) {
-		_, err := clientset.RbacV1().ClusterRoleBindings().Create(kotsadmClusterRoleBinding(serviceAccountNamespace))
+// ensureKotsadmClusterRoleBinding returns true if the binding was created, and true for appended if the
+// service account was added to the subjects of an existing binding. The update is retried on conflicts,
+// and the binding is checked afterwards to make sure that no subjects were lost.
+func ensureKotsadmClusterRoleBinding(serviceAccountNamespace string, clientset kubernetes.Interface) (bool, bool, error) {
+	kotsadmSubject := rbacv1.Subject{
+		Kind:      "ServiceAccount",
+		Name:      "kotsadm",
+		Namespace: serviceAccountNamespace,
+	}
+
+	created := false
+	appended := false
+	var expectedSubjects []rbacv1.Subject
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		clusterRoleBinding, err := clientset.RbacV1().ClusterRoleBindings().Get("kotsadm-rolebinding", metav1.GetOptions{})
+		if kuberneteserrors.IsNotFound(err) {
+			_, err := clientset.RbacV1().ClusterRoleBindings().Create(kotsadmClusterRoleBinding(serviceAccountNamespace))
+			if err != nil {
+				return errors.Wrap(err, "failed to create cluster rolebinding")
+			}
+			created = true
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "failed to get cluster rolebinding")
+		}
+
+		if hasSubject(clusterRoleBinding.Subjects, kotsadmSubject) {
+			return nil
+		}
+
+		clusterRoleBinding.Subjects = append(clusterRoleBinding.Subjects, kotsadmSubject)
+		expectedSubjects = clusterRoleBinding.Subjects
+
+		_, err = clientset.RbacV1().ClusterRoleBindings().Update(clusterRoleBinding)
 		if err != nil {
-			return errors.Wrap(err, "failed to create cluster rolebinding")
+			return err
 		}
+		appended = true
 		return nil
-	} else if err != nil {
-		return errors.Wrap(err, "failed to get cluster rolebinding")
+	})
+	if err != nil {
+		return false, false, errors.Wrap(err, "failed to update cluster rolebinding")
 	}
 
-	for _, subject := range clusterRoleBinding.Subjects {
-		if subject.Namespace == serviceAccountNamespace && subject.Name == "kotsadm" && subject.Kind == "ServiceAccount" {
-			return nil
+	if appended {
+		if err := verifyClusterRoleBindingSubjects(clientset, expectedSubjects); err != nil {
+			return false, false, err
 		}
 	}
 
-	clusterRoleBinding.Subjects = append(clusterRoleBinding.Subjects, rbacv1.Subject{
-		Kind:      "ServiceAccount",
-		Name:      "kotsadm",
-		Namespace: serviceAccountNamespace,
-	})
+	return created, appended, nil
+}
 
-	_, err = clientset.RbacV1().ClusterRoleBindings().Update(clusterRoleBinding)
+// verifyClusterRoleBindingSubjects checks that the kotsadm cluster role binding still has all of the expected subjects
+func verifyClusterRoleBindingSubjects(clientset kubernetes.Interface, expectedSubjects []rbacv1.Subject) error {
+	clusterRoleBinding, err := clientset.RbacV1().ClusterRoleBindings().Get("kotsadm-rolebinding", metav1.GetOptions{})
 	if err != nil {
-		return errors.Wrap(err, "failed to create cluster rolebinding")
+		return errors.Wrap(err, "failed to get cluster rolebinding to verify it")
+	}
+
+	missing := []string{}
+	for _, subject := range expectedSubjects {
+		if !hasSubject(clusterRoleBinding.Subjects, subject) {
+			missing = append(missing, fmt.Sprintf("%s/%s", subject.Namespace, subject.Name))
+		}
+	}
+	if len(missing) > 0 {
+		return errors.Errorf("cluster rolebinding is missing subjects after the update: %s", strings.Join(missing, ", "))
 	}
 
 	return nil
 }
 
-func ensureKotsadmRole(namespace string, clientset *kubernetes.Clientset) error {
+func hasSubject(subjects []rbacv1.Subject, subject rbacv1.Subject) bool {
+	for _, s := range subjects {
+		if s.Kind == subject.Kind && s.Name == subject.Name && s.Namespace == subject.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func ensureKotsadmRole(namespace string, clientset kubernetes.Interface) (bool, error) {
 	currentRole, err := clientset.RbacV1().Roles(namespace).Get("kotsadm-role", metav1.GetOptions{})
 	if err != nil {
 		if !kuberneteserrors.IsNotFound(err) {
-			return errors.Wrap(err, "failed to get role")
+			return false, errors.Wrap(err, "failed to get role")
 		}
 
 		_, err := clientset.RbacV1().Roles(namespace).Create(kotsadmRole(namespace))
 		if err != nil {
-			return errors.Wrap(err, "failed to create role")
+			return false, errors.Wrap(err, "failed to create role")
 		}
-		return nil
+		return true, nil
 	}
 
 	// we have now changed the role, so an upgrade is required
 	k8sutil.UpdateRole(currentRole, kotsadmRole(namespace))
 	_, err = clientset.RbacV1().Roles(namespace).Update(currentRole)
 	if err != nil {
-		return errors.Wrap(err, "failed to update role")
+		return false, errors.Wrap(err, "failed to update role")
 	}
 
-	return nil
+	return false, nil
 }
 
-func ensureKotsadmRoleBinding(namespace string, clientset *kubernetes.Clientset) error {
+func ensureKotsadmRoleBinding(namespace string, clientset kubernetes.Interface) (bool, error) {
 	_, err := clientset.RbacV1().RoleBindings(namespace).Get("kotsadm-rolebinding", metav1.GetOptions{})
 	if err != nil {
 		if !kuberneteserrors.IsNotFound(err) {
-			return errors.Wrap(err, "failed to get rolebinding")
+			return false, errors.Wrap(err, "failed to get rolebinding")
 		}
 
 		_, err := clientset.RbacV1().RoleBindings(namespace).Create(kotsadmRoleBinding(namespace))
 		if err != nil {
-			return errors.Wrap(err, "failed to create rolebinding")
+			return false, errors.Wrap(err, "failed to create rolebinding")
 		}
+		return true, nil
 	}
 
-	return nil
+	return false, nil
 }
 
-func ensureKotsadmServiceAccount(namespace string, clientset *kubernetes.Clientset) error {
+func ensureKotsadmServiceAccount(namespace string, clientset kubernetes.Interface) (bool, error) {
 	_, err := clientset.CoreV1().ServiceAccounts(namespace).Get("kotsadm", metav1.GetOptions{})
 	if err != nil {
 		if !kuberneteserrors.IsNotFound(err) {
-			return errors.Wrap(err, "failed to get serviceaccouont")
+			return false, errors.Wrap(err, "failed to get serviceaccouont")
 		}
 
 		_, err := clientset.CoreV1().ServiceAccounts(namespace).Create(kotsadmServiceAccount(namespace))
 		if err != nil {
-			return errors.Wrap(err, "failed to create serviceaccount")
+			return false, errors.Wrap(err, "failed to create serviceaccount")
 		}
+		return true, nil
 	}
 
-	return nil
+	return false, nil
 }
 
-func ensureKotsadmDeployment(deployOptions types.DeployOptions, clientset *kubernetes.Clientset) error {
+// watchReconnectBackoff is the delay before reconnecting a closed watch. It doubles on each
+// reconnect without events, up to watchReconnectMaxBackoff.
+var (
+	watchReconnectBackoff    = 500 * time.Millisecond
+	watchReconnectMaxBackoff = 8 * time.Second
+)
+
+// waitForKotsadmPod lists and then watches the kotsadm pods until one is ready. Watches that are
+// closed by the api server are resumed from the last resource version seen, and a fresh list is
+// done if that version is too old. Readiness is checked as in isKotsadmPodReady.
+func waitForKotsadmPod(namespace string, readyContainerName string, clientset kubernetes.Interface, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	listOptions := metav1.ListOptions{LabelSelector: "app=kotsadm"}
+
+	relist := true
+	resourceVersion := ""
+	backoff := watchReconnectBackoff
+	var lastErr error
+	for {
+		if relist {
+			pods, err := clientset.CoreV1().Pods(namespace).List(listOptions)
+			if err != nil {
+				return errors.Wrap(err, "failed to list pods")
+			}
+			for _, pod := range pods.Items {
+				if isKotsadmPodReady(&pod, readyContainerName) {
+					return nil
+				}
+			}
+			resourceVersion = pods.ResourceVersion
+			relist = false
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			if lastErr != nil {
+				return errors.Wrap(lastErr, "timeout waiting for kotsadm pod")
+			}
+			return errors.New("timeout waiting for kotsadm pod")
+		}
+
+		watchOptions := listOptions
+		watchOptions.ResourceVersion = resourceVersion
+		timeoutSeconds := int64(remaining/time.Second) + 1
+		watchOptions.TimeoutSeconds = &timeoutSeconds
+
+		w, err := clientset.CoreV1().Pods(namespace).Watch(watchOptions)
+		if err != nil {
+			// relisting is also backed off, in case the api server keeps expiring the version
+			if kuberneteserrors.IsGone(err) || kuberneteserrors.IsResourceExpired(err) {
+				relist = true
+			}
+			lastErr = errors.Wrap(err, "failed to watch pods")
+		} else {
+			result := watchForReadyPod(w, readyContainerName, deadline)
+			w.Stop()
+
+			if result.ready {
+				return nil
+			}
+			if result.resourceVersion != "" {
+				resourceVersion = result.resourceVersion
+				backoff = watchReconnectBackoff
+			}
+			if result.gone {
+				relist = true
+			}
+			if result.err != nil {
+				lastErr = result.err
+			}
+		}
+
+		sleep := backoff
+		if remaining := time.Until(deadline); remaining < sleep {
+			sleep = remaining
+		}
+		if sleep > 0 {
+			time.Sleep(sleep)
+		}
+		backoff *= 2
+		if backoff > watchReconnectMaxBackoff {
+			backoff = watchReconnectMaxBackoff
+		}
+	}
+}
+
+type watchResult struct {
+	ready           bool
+	gone            bool
+	resourceVersion string
+	err             error
+}
+
+// watchForReadyPod reads events from w until a kotsadm pod is ready, the watch is closed, or the deadline is reached
+func watchForReadyPod(w watch.Interface, readyContainerName string, deadline time.Time) watchResult {
+	result := watchResult{}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return result
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return result
+			}
+
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				pod, ok := event.Object.(*corev1.Pod)
+				if !ok {
+					continue
+				}
+				result.resourceVersion = pod.ResourceVersion
+				if isKotsadmPodReady(pod, readyContainerName) {
+					result.ready = true
+					return result
+				}
+			case watch.Deleted:
+				if pod, ok := event.Object.(*corev1.Pod); ok {
+					result.resourceVersion = pod.ResourceVersion
+				}
+			case watch.Error:
+				err := kuberneteserrors.FromObject(event.Object)
+				if kuberneteserrors.IsGone(err) || kuberneteserrors.IsResourceExpired(err) {
+					result.gone = true
+				} else {
+					result.err = errors.Wrap(err, "watch failed")
+				}
+				return result
+			}
+		}
+	}
+}
+
+// isKotsadmPodReady returns true when the pod is running and readyContainerName is ready. When
+// readyContainerName is empty, the kotsadm container and every other container that isn't listed in the
+// pod's OptionalContainersAnnotation must be ready.
+func isKotsadmPodReady(pod *corev1.Pod, readyContainerName string) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+
+	if readyContainerName != "" {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == readyContainerName {
+				return status.Ready
+			}
+		}
+		return false
+	}
+
+	optionalContainers := map[string]bool{}
+	for _, name := range strings.Split(pod.Annotations[types.OptionalContainersAnnotation], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			optionalContainers[name] = true
+		}
+	}
+
+	foundKotsadm := false
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == "kotsadm" {
+			foundKotsadm = true
+		} else if optionalContainers[status.Name] {
+			continue
+		}
+		if !status.Ready {
+			return false
+		}
+	}
+	return foundKotsadm
+}
+
+// ensureKotsadmDeployment creates or updates the kotsadm deployment, and returns true if a change was
+// applied. An existing deployment that already matches is not updated, so that it isn't rolled out again.
+func ensureKotsadmDeployment(deployOptions types.DeployOptions, clientset kubernetes.Interface) (bool, error) {
+	if err := validateDeployOptions(deployOptions); err != nil {
+		return false, err
+	}
+
 	existingDeployment, err := clientset.AppsV1().Deployments(deployOptions.Namespace).Get("kotsadm", metav1.GetOptions{})
 	if err != nil {
 		if !kuberneteserrors.IsNotFound(err) {
-			return errors.Wrap(err, "failed to get existing deployment")
+			return false, errors.Wrap(err, "failed to get existing deployment")
 		}
 
 		_, err := clientset.AppsV1().Deployments(deployOptions.Namespace).Create(kotsadmDeployment(deployOptions))
 		if err != nil {
-			return errors.Wrap(err, "failed to create deployment")
+			return false, errors.Wrap(err, "failed to create deployment")
 		}
-		return nil
+		return true, nil
 	}
 
-	if err = updateKotsadmDeployment(existingDeployment, deployOptions); err != nil {
-		return errors.Wrap(err, "failed to merge deployments")
+	if deployOptions.PreventDowngrade {
+		if err := checkKotsadmDowngrade(existingDeployment); err != nil {
+			return false, err
+		}
+	}
+
+	if deployOptions.RecreateDeployment {
+		if err := recreateKotsadmDeployment(deployOptions, clientset); err != nil {
+			return false, errors.Wrap(err, "failed to recreate deployment")
+		}
+		return true, nil
+	}
+
+	mergedDeployment, changed, err := mergeKotsadmDeployment(existingDeployment, deployOptions)
+	if err != nil {
+		return false, err
+	}
+	if !changed {
+		return false, nil
+	}
+
+	_, err = clientset.AppsV1().Deployments(deployOptions.Namespace).Update(mergedDeployment)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to update kotsadm deployment")
+	}
+
+	return true, nil
+}
+
+// mergeKotsadmDeployment returns a copy of the existing deployment with the deploy options applied,
+// and true if it differs from the existing deployment
+func mergeKotsadmDeployment(existingDeployment *appsv1.Deployment, deployOptions types.DeployOptions) (*appsv1.Deployment, bool, error) {
+	mergedDeployment := existingDeployment.DeepCopy()
+	if err := updateKotsadmDeployment(mergedDeployment, deployOptions); err != nil {
+		return nil, false, errors.Wrap(err, "failed to merge deployments")
+	}
+
+	changed, err := kotsadmDeploymentChanged(existingDeployment, mergedDeployment)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "failed to compare deployments")
+	}
+
+	return mergedDeployment, changed, nil
+}
+
+// kotsadmDeploymentChanged compares the fields that updateKotsadmDeployment manages. The api server
+// defaults fields that kots doesn't set, such as port protocols and volume modes, so those fields only
+// have to match where the merged deployment sets them.
+func kotsadmDeploymentChanged(existingDeployment *appsv1.Deployment, mergedDeployment *appsv1.Deployment) (bool, error) {
+	if !apiequality.Semantic.DeepEqual(existingDeployment.Annotations, mergedDeployment.Annotations) ||
+		!apiequality.Semantic.DeepEqual(existingDeployment.Spec.Template.Annotations, mergedDeployment.Spec.Template.Annotations) ||
+		!apiequality.Semantic.DeepEqual(existingDeployment.Spec.Template.Spec.TopologySpreadConstraints, mergedDeployment.Spec.Template.Spec.TopologySpreadConstraints) ||
+		existingDeployment.Spec.Strategy.Type != mergedDeployment.Spec.Strategy.Type {
+		return true, nil
+	}
+
+	type managedFields struct {
+		RollingUpdate *appsv1.RollingUpdateDeployment
+		Volumes       []corev1.Volume
+		Container     corev1.Container
+	}
+	existingFields := managedFields{
+		RollingUpdate: existingDeployment.Spec.Strategy.RollingUpdate,
+		Volumes:       existingDeployment.Spec.Template.Spec.Volumes,
+	}
+	mergedFields := managedFields{
+		RollingUpdate: mergedDeployment.Spec.Strategy.RollingUpdate,
+		Volumes:       mergedDeployment.Spec.Template.Spec.Volumes,
+	}
+	for idx, c := range mergedDeployment.Spec.Template.Spec.Containers {
+		if c.Name == "kotsadm" {
+			mergedFields.Container = c
+			existingFields.Container = existingDeployment.Spec.Template.Spec.Containers[idx]
+		}
+	}
+
+	// the command and args are cleared when they're no longer requested, which a subset can't tell
+	if !apiequality.Semantic.DeepEqual(existingFields.Container.Command, mergedFields.Container.Command) ||
+		!apiequality.Semantic.DeepEqual(existingFields.Container.Args, mergedFields.Container.Args) ||
+		len(existingFields.Container.EnvFrom) != len(mergedFields.Container.EnvFrom) {
+		return true, nil
+	}
+
+	existingValue, err := toJSONValue(existingFields)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to convert existing deployment")
+	}
+	mergedValue, err := toJSONValue(mergedFields)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to convert merged deployment")
+	}
+
+	return !isJSONSubset(mergedValue, existingValue), nil
+}
+
+func toJSONValue(obj interface{}) (interface{}, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal")
+	}
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal")
+	}
+	return value, nil
+}
+
+// isJSONSubset returns true if every field that is set in desired has the same value in current. Lists
+// must have the same length, and their items are compared in order.
+func isJSONSubset(desired interface{}, current interface{}) bool {
+	switch desiredValue := desired.(type) {
+	case map[string]interface{}:
+		currentValue, ok := current.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range desiredValue {
+			if !isJSONSubset(value, currentValue[key]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		currentValue, ok := current.([]interface{})
+		if !ok {
+			return len(desiredValue) == 0 && current == nil
+		}
+		if len(desiredValue) != len(currentValue) {
+			return false
+		}
+		for i := range desiredValue {
+			if !isJSONSubset(desiredValue[i], currentValue[i]) {
+				return false
+			}
+		}
+		return true
+	case nil:
+		if currentValue, ok := current.([]interface{}); ok {
+			return len(currentValue) == 0
+		}
+		return true
+	default:
+		return desired == current
+	}
+}
+
+// ignoredKotsadmExtraEnv returns the names of extra env vars that conflict with env vars that kots manages
+func ignoredKotsadmExtraEnv(deployOptions types.DeployOptions) []string {
+	managedOptions := deployOptions
+	managedOptions.ExtraEnv = nil
+	managedEnv := kotsadmDeployment(managedOptions).Spec.Template.Spec.Containers[0].Env
+
+	_, _, ignoredNames := mergeExtraEnv(managedEnv, deployOptions.ExtraEnv)
+	return ignoredNames
+}
+
+// readKotsadmExtrasFromCluster reads the options that were set on the existing kotsadm deployment and
+// service, such as extra env vars, volumes, pod annotations and ports, so that an upgrade keeps them
+func readKotsadmExtrasFromCluster(deployOptions *types.DeployOptions, clientset kubernetes.Interface) error {
+	deployment, err := clientset.AppsV1().Deployments(deployOptions.Namespace).Get("kotsadm", metav1.GetOptions{})
+	if err != nil {
+		if kuberneteserrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to get existing deployment")
+	}
+
+	extraEnvNames := map[string]bool{}
+	for _, name := range strings.Split(deployment.Annotations[types.ExtraEnvAnnotation], ",") {
+		if name != "" {
+			extraEnvNames[name] = true
+		}
+	}
+	extraVolumeNames := map[string]bool{}
+	for _, name := range strings.Split(deployment.Annotations[types.ExtraVolumesAnnotation], ",") {
+		if name != "" {
+			extraVolumeNames[name] = true
+		}
+	}
+
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		if extraVolumeNames[volume.Name] {
+			deployOptions.ExtraVolumes = append(deployOptions.ExtraVolumes, volume)
+		}
+	}
+
+	for _, key := range strings.Split(deployment.Annotations[types.PodAnnotationsAnnotation], ",") {
+		value, ok := deployment.Spec.Template.Annotations[key]
+		if key == "" || !ok {
+			continue
+		}
+		if deployOptions.PodAnnotations == nil {
+			deployOptions.PodAnnotations = map[string]string{}
+		}
+		deployOptions.PodAnnotations[key] = value
 	}
 
-	_, err = clientset.AppsV1().Deployments(deployOptions.Namespace).Update(existingDeployment)
+	// the label selector was defaulted when it was deployed, which still selects the kotsadm pods
+	deployOptions.TopologySpreadConstraints = deployment.Spec.Template.Spec.TopologySpreadConstraints
+	deployOptions.DeploymentStrategy = deployment.Spec.Strategy
+
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if container.Name != "kotsadm" {
+			continue
+		}
+		for _, env := range container.Env {
+			if extraEnvNames[env.Name] {
+				deployOptions.ExtraEnv = append(deployOptions.ExtraEnv, env)
+			}
+		}
+		for _, volumeMount := range container.VolumeMounts {
+			if extraVolumeNames[volumeMount.Name] {
+				deployOptions.ExtraVolumeMounts = append(deployOptions.ExtraVolumeMounts, volumeMount)
+			}
+		}
+		deployOptions.EnvFrom = container.EnvFrom
+		deployOptions.ContainerCommand = container.Command
+		deployOptions.ContainerArgs = container.Args
+		for _, port := range container.Ports {
+			if port.Name == "http" {
+				deployOptions.ContainerPort = port.ContainerPort
+			}
+		}
+	}
+
+	service, err := clientset.CoreV1().Services(deployOptions.Namespace).Get("kotsadm", metav1.GetOptions{})
 	if err != nil {
-		return errors.Wrap(err, "failed to update kotsadm deployment")
+		if kuberneteserrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to get existing service")
+	}
+	for _, port := range service.Spec.Ports {
+		if port.Name == "http" {
+			deployOptions.ServicePort = port.Port
+		}
 	}
 
 	return nil
 }
 
-func ensureKotsadmService(namespace string, clientset *kubernetes.Clientset) error {
-	_, err := clientset.CoreV1().Services(namespace).Get("kotsadm", metav1.GetOptions{})
+// recreateKotsadmDeployment deletes the existing deployment, waits for its pods to terminate
+// and then creates the deployment fresh. Only the deployment is recreated.
+func recreateKotsadmDeployment(deployOptions types.DeployOptions, clientset kubernetes.Interface) error {
+	propagationPolicy := metav1.DeletePropagationForeground
+	err := clientset.AppsV1().Deployments(deployOptions.Namespace).Delete("kotsadm", &metav1.DeleteOptions{
+		PropagationPolicy: &propagationPolicy,
+	})
+	if err != nil && !kuberneteserrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to delete existing deployment")
+	}
+
+	if err := waitForKotsadmDeletion(deployOptions.Namespace, clientset, kotsadmWaitTimeout(deployOptions)); err != nil {
+		return errors.Wrap(err, "failed to wait for kotsadm deployment to be deleted")
+	}
+
+	_, err = clientset.AppsV1().Deployments(deployOptions.Namespace).Create(kotsadmDeployment(deployOptions))
+	if err != nil {
+		return errors.Wrap(err, "failed to create deployment")
+	}
+
+	return nil
+}
+
+// waitForKotsadmDeletion waits until the kotsadm deployment is gone and no kotsadm pods remain, so that
+// a new deployment won't collide with pods that are still terminating
+func waitForKotsadmDeletion(namespace string, clientset kubernetes.Interface, timeout time.Duration) error {
+	start := time.Now()
+
+	for {
+		_, getErr := clientset.AppsV1().Deployments(namespace).Get("kotsadm", metav1.GetOptions{})
+		if getErr != nil && !kuberneteserrors.IsNotFound(getErr) {
+			return errors.Wrap(getErr, "failed to get deployment")
+		}
+
+		pods, err := clientset.CoreV1().Pods(namespace).List(metav1.ListOptions{LabelSelector: "app=kotsadm"})
+		if err != nil {
+			return errors.Wrap(err, "failed to list pods")
+		}
+
+		if kuberneteserrors.IsNotFound(getErr) && len(pods.Items) == 0 {
+			return nil
+		}
+
+		if time.Now().Sub(start) > timeout {
+			if len(pods.Items) == 0 {
+				return errors.New("timeout waiting for kotsadm deployment to be deleted")
+			}
+
+			podNames := []string{}
+			for _, pod := range pods.Items {
+				podNames = append(podNames, pod.Name)
+			}
+			return errors.Errorf("timeout waiting for kotsadm to be deleted, pods still terminating: %s", strings.Join(podNames, ", "))
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+// ensureKotsadmService creates the kotsadm service, or updates the port of an existing one
+func ensureKotsadmService(deployOptions types.DeployOptions, clientset kubernetes.Interface) error {
+	existingService, err := clientset.CoreV1().Services(deployOptions.Namespace).Get("kotsadm", metav1.GetOptions{})
 	if err != nil {
 		if !kuberneteserrors.IsNotFound(err) {
 			return errors.Wrap(err, "failed to get existing service")
 		}
 
-		_, err := clientset.CoreV1().Services(namespace).Create(kotsadmService(namespace))
+		_, err := clientset.CoreV1().Services(deployOptions.Namespace).Create(kotsadmService(deployOptions.Namespace, kotsadmServicePort(deployOptions)))
 		if err != nil {
 			return errors.Wrap(err, "Failed to create service")
 		}
+		return nil
+	}
+
+	mergedService := mergeKotsadmService(existingService, deployOptions)
+	if apiequality.Semantic.DeepEqual(existingService.Spec, mergedService.Spec) {
+		return nil
+	}
+
+	_, err = clientset.CoreV1().Services(deployOptions.Namespace).Update(mergedService)
+	if err != nil {
+		return errors.Wrap(err, "failed to update service")
 	}
 
 	return nil
 }
 
+// decodeApplications returns the applications in the application metadata, which can contain multiple
+// documents. Documents of other kinds are ignored, and an error is returned if there is no application.
+func decodeApplications(applicationMetadata []byte) ([]*kotsv1beta1.Application, error) {
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+
+	applications := []*kotsv1beta1.Application{}
+	docs := bytes.Split(applicationMetadata, []byte("\n---\n"))
+	for _, doc := range docs {
+		if len(bytes.TrimSpace(bytes.TrimPrefix(doc, []byte("---\n")))) == 0 {
+			continue
+		}
+
+		obj, gvk, err := decode(doc, nil, nil)
+		if err != nil {
+			// other kinds can be bundled with the application
+			if runtime.IsNotRegisteredError(err) {
+				continue
+			}
+			return nil, errors.Wrap(err, "failed to decode application metadata")
+		}
+
+		if gvk.Group != "kots.io" || gvk.Version != "v1beta1" || gvk.Kind != "Application" {
+			continue
+		}
+		applications = append(applications, obj.(*kotsv1beta1.Application))
+	}
+
+	if len(applications) == 0 {
+		return nil, errors.New("application metadata does not contain a kots.io/v1beta1 Application")
+	}
+
+	return applications, nil
+}
+
 // isKotsadmClusterScoped determines if the kotsadm pod should be running
-// with cluster-wide permissions or not
+// with cluster-wide permissions or not. The metadata can contain multiple
+// applications, and kotsadm is cluster scoped if any of them require it.
+// Documents of other kinds are ignored.
 func isKotsadmClusterScoped(applicationMetadata []byte) (bool, error) {
 	if applicationMetadata == nil {
 		return true, nil
 	}
 
-	decode := scheme.Codecs.UniversalDeserializer().Decode
-	obj, gvk, err := decode(applicationMetadata, nil, nil)
+	applications, err := decodeApplications(applicationMetadata)
 	if err != nil {
-		return false, errors.Wrap(err, "failed to decode application metadata")
+		return false, err
 	}
 
-	if gvk.Group != "kots.io" || gvk.Version != "v1beta1" || gvk.Kind != "Application" {
-		return false, errors.New("application metadata contained unepxected gvk")
-	}
-
-	application := obj.(*kotsv1beta1.Application)
-
-	// An application can request cluster scope privileges quite simply
-	if !application.Spec.RequireMinimalRBACPrivileges {
-		return true, nil
+	for _, application := range applications {
+		// An application can request cluster scope privileges quite simply
+		if !application.Spec.RequireMinimalRBACPrivileges {
+			return true, nil
+		}
 	}
 
 	return false, nil
@@ -2,6 +2,8 @@ package kotsadm
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/replicatedhq/kots/pkg/auth"
@@ -11,6 +13,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
@@ -177,12 +180,52 @@ func updateKotsadmDeployment(deployment *appsv1.Deployment, deployOptions types.
 		return errors.New("failed to find kotsadm container in deployment")
 	}
 
+	// the default strategy is restored when Recreate is no longer requested, but rolling update
+	// parameters are otherwise left alone
+	if desiredDeployment.Spec.Strategy.Type != "" {
+		deployment.Spec.Strategy = desiredDeployment.Spec.Strategy
+	} else if deployment.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+		deployment.Spec.Strategy = appsv1.DeploymentStrategy{}
+	}
+
 	// image
 	deployment.Spec.Template.Spec.Containers[containerIdx].Image = fmt.Sprintf("%s/kotsadm:%s", kotsadmRegistry(), kotsadmTag())
 
+	// constraints from a previous deploy are removed when they're no longer requested
+	deployment.Spec.Template.Spec.TopologySpreadConstraints = desiredDeployment.Spec.Template.Spec.TopologySpreadConstraints
+
+	// the http port and the readiness probe follow the container port. other ports are left alone.
+	desiredPort := desiredDeployment.Spec.Template.Spec.Containers[0].Ports[0]
+	portIdx := -1
+	for idx, port := range deployment.Spec.Template.Spec.Containers[containerIdx].Ports {
+		if port.Name == desiredPort.Name {
+			portIdx = idx
+		}
+	}
+	if portIdx == -1 {
+		deployment.Spec.Template.Spec.Containers[containerIdx].Ports = append(deployment.Spec.Template.Spec.Containers[containerIdx].Ports, desiredPort)
+	} else {
+		deployment.Spec.Template.Spec.Containers[containerIdx].Ports[portIdx].ContainerPort = desiredPort.ContainerPort
+	}
+	if probe := deployment.Spec.Template.Spec.Containers[containerIdx].ReadinessProbe; probe != nil && probe.HTTPGet != nil {
+		probe.HTTPGet.Port = desiredDeployment.Spec.Template.Spec.Containers[0].ReadinessProbe.HTTPGet.Port
+	}
+
+	// an override from a previous deploy is removed when it's no longer requested
+	deployment.Spec.Template.Spec.Containers[containerIdx].Command = desiredDeployment.Spec.Template.Spec.Containers[0].Command
+	deployment.Spec.Template.Spec.Containers[containerIdx].Args = desiredDeployment.Spec.Template.Spec.Containers[0].Args
+
 	// copy the env vars from the desired to existing. this could undo a change that the user had.
 	// we don't know which env vars we set and which are user edited. this method avoids deleting
 	// env vars that the user added, but doesn't handle edited vars
+	// extra env vars from a previous deploy that are no longer requested are removed
+	previousExtraEnv := map[string]bool{}
+	for _, name := range strings.Split(deployment.Annotations[types.ExtraEnvAnnotation], ",") {
+		if name != "" {
+			previousExtraEnv[name] = true
+		}
+	}
+
 	mergedEnvs := []corev1.EnvVar{}
 	for _, env := range desiredDeployment.Spec.Template.Spec.Containers[0].Env {
 		mergedEnvs = append(mergedEnvs, env)
@@ -195,15 +238,166 @@ func updateKotsadmDeployment(deployment *appsv1.Deployment, deployOptions types.
 			}
 		}
 
-		if isUnxpected {
+		if isUnxpected && !previousExtraEnv[existingEnv.Name] {
 			mergedEnvs = append(mergedEnvs, existingEnv)
 		}
 	}
 	deployment.Spec.Template.Spec.Containers[containerIdx].Env = mergedEnvs
+	deployment.Spec.Template.Spec.Containers[containerIdx].EnvFrom = desiredDeployment.Spec.Template.Spec.Containers[0].EnvFrom
+
+	// extra volumes from a previous deploy are replaced with the ones that are requested now
+	previousExtraVolumes := map[string]bool{}
+	for _, name := range strings.Split(deployment.Annotations[types.ExtraVolumesAnnotation], ",") {
+		if name != "" {
+			previousExtraVolumes[name] = true
+		}
+	}
+
+	mergedVolumes := []corev1.Volume{}
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		if !previousExtraVolumes[volume.Name] {
+			mergedVolumes = append(mergedVolumes, volume)
+		}
+	}
+	mergedVolumes = append(mergedVolumes, deployOptions.ExtraVolumes...)
+	deployment.Spec.Template.Spec.Volumes = mergedVolumes
+
+	mergedVolumeMounts := []corev1.VolumeMount{}
+	for _, volumeMount := range deployment.Spec.Template.Spec.Containers[containerIdx].VolumeMounts {
+		if !previousExtraVolumes[volumeMount.Name] {
+			mergedVolumeMounts = append(mergedVolumeMounts, volumeMount)
+		}
+	}
+	mergedVolumeMounts = append(mergedVolumeMounts, deployOptions.ExtraVolumeMounts...)
+	deployment.Spec.Template.Spec.Containers[containerIdx].VolumeMounts = mergedVolumeMounts
+
+	// pod annotations from a previous deploy are replaced with the ones that are requested now
+	previousPodAnnotations := map[string]bool{}
+	for _, key := range strings.Split(deployment.Annotations[types.PodAnnotationsAnnotation], ",") {
+		if key != "" {
+			previousPodAnnotations[key] = true
+		}
+	}
+
+	mergedPodAnnotations := map[string]string{}
+	for key, value := range deployment.Spec.Template.Annotations {
+		if !previousPodAnnotations[key] {
+			mergedPodAnnotations[key] = value
+		}
+	}
+	for key, value := range deployOptions.PodAnnotations {
+		mergedPodAnnotations[key] = value
+	}
+	if len(mergedPodAnnotations) > 0 {
+		deployment.Spec.Template.Annotations = mergedPodAnnotations
+	} else {
+		deployment.Spec.Template.Annotations = nil
+	}
+
+	if deployment.Annotations == nil {
+		deployment.Annotations = map[string]string{}
+	}
+	for _, annotation := range []string{types.ExtraEnvAnnotation, types.ExtraVolumesAnnotation, types.PodAnnotationsAnnotation} {
+		if value, ok := desiredDeployment.Annotations[annotation]; ok {
+			deployment.Annotations[annotation] = value
+		} else {
+			delete(deployment.Annotations, annotation)
+		}
+	}
+
+	return nil
+}
+
+// validateDeployOptions checks the deploy options that kotsadmDeployment can't be built from
+func validateDeployOptions(deployOptions types.DeployOptions) error {
+	if err := validateExtraVolumes(deployOptions); err != nil {
+		return errors.Wrap(err, "invalid extra volumes")
+	}
+	if err := validateTopologySpreadConstraints(deployOptions); err != nil {
+		return errors.Wrap(err, "invalid topology spread constraints")
+	}
+
+	return nil
+}
+
+// validateExtraVolumes checks that each extra volume mount references one of the extra volumes
+func validateExtraVolumes(deployOptions types.DeployOptions) error {
+	volumeNames := map[string]bool{}
+	for _, volume := range deployOptions.ExtraVolumes {
+		volumeNames[volume.Name] = true
+	}
+
+	for _, volumeMount := range deployOptions.ExtraVolumeMounts {
+		if !volumeNames[volumeMount.Name] {
+			return errors.Errorf("volume mount at %s references volume %q, which is not declared", volumeMount.MountPath, volumeMount.Name)
+		}
+	}
+
+	return nil
+}
+
+// validateTopologySpreadConstraints checks that the label selector of each topology spread constraint
+// selects the kotsadm pods, since the skew is counted across the pods that it selects
+func validateTopologySpreadConstraints(deployOptions types.DeployOptions) error {
+	podLabels := labels.Set(kotsadmPodLabels())
+	for i, constraint := range deployOptions.TopologySpreadConstraints {
+		if constraint.LabelSelector == nil {
+			continue
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(constraint.LabelSelector)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse the label selector of topology spread constraint %d", i)
+		}
+		if !selector.Matches(podLabels) {
+			return errors.Errorf("the label selector %q of topology spread constraint %d does not select the kotsadm pods", selector.String(), i)
+		}
+	}
 
 	return nil
 }
 
+// kotsadmTopologySpreadConstraints returns the topology spread constraints with the kotsadm pod label
+// selector defaulted
+func kotsadmTopologySpreadConstraints(deployOptions types.DeployOptions) []corev1.TopologySpreadConstraint {
+	constraints := []corev1.TopologySpreadConstraint{}
+	for _, constraint := range deployOptions.TopologySpreadConstraints {
+		if constraint.LabelSelector == nil {
+			constraint.LabelSelector = &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": "kotsadm",
+				},
+			}
+		}
+		constraints = append(constraints, constraint)
+	}
+
+	return constraints
+}
+
+// mergeExtraEnv appends the extra env vars to the env vars that kots manages. When an extra env var
+// has the same name as a managed one, the managed env var is kept and the name is returned as ignored.
+func mergeExtraEnv(managedEnv []corev1.EnvVar, extraEnv []corev1.EnvVar) ([]corev1.EnvVar, []string, []string) {
+	managedNames := map[string]bool{}
+	for _, env := range managedEnv {
+		managedNames[env.Name] = true
+	}
+
+	mergedEnv := append([]corev1.EnvVar{}, managedEnv...)
+	addedNames := []string{}
+	ignoredNames := []string{}
+	for _, env := range extraEnv {
+		if managedNames[env.Name] {
+			ignoredNames = append(ignoredNames, env.Name)
+			continue
+		}
+		mergedEnv = append(mergedEnv, env)
+		addedNames = append(addedNames, env.Name)
+	}
+
+	return mergedEnv, addedNames, ignoredNames
+}
+
 func kotsadmDeployment(deployOptions types.DeployOptions) *appsv1.Deployment {
 	var securityContext corev1.PodSecurityContext
 	if !deployOptions.IsOpenShift {
@@ -230,12 +424,10 @@ func kotsadmDeployment(deployOptions types.DeployOptions) *appsv1.Deployment {
 					"app": "kotsadm",
 				},
 			},
+			Strategy: deployOptions.DeploymentStrategy,
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app":            "kotsadm",
-						types.KotsadmKey: types.KotsadmLabelValue,
-					},
+					Labels: kotsadmPodLabels(),
 				},
 				Spec: corev1.PodSpec{
 					SecurityContext:    &securityContext,
@@ -246,10 +438,12 @@ func kotsadmDeployment(deployOptions types.DeployOptions) *appsv1.Deployment {
 							Image:           fmt.Sprintf("%s/kotsadm:%s", kotsadmRegistry(), kotsadmTag()),
 							ImagePullPolicy: corev1.PullAlways,
 							Name:            "kotsadm",
+							Command:         deployOptions.ContainerCommand,
+							Args:            deployOptions.ContainerArgs,
 							Ports: []corev1.ContainerPort{
 								{
 									Name:          "http",
-									ContainerPort: 3000,
+									ContainerPort: kotsadmContainerPort(deployOptions),
 								},
 							},
 							ReadinessProbe: &corev1.Probe{
@@ -259,7 +453,7 @@ func kotsadmDeployment(deployOptions types.DeployOptions) *appsv1.Deployment {
 								Handler: corev1.Handler{
 									HTTPGet: &corev1.HTTPGetAction{
 										Path:   "/healthz",
-										Port:   intstr.FromInt(3000),
+										Port:   intstr.FromInt(int(kotsadmContainerPort(deployOptions))),
 										Scheme: corev1.URISchemeHTTP,
 									},
 								},
@@ -363,13 +557,69 @@ func kotsadmDeployment(deployOptions types.DeployOptions) *appsv1.Deployment {
 		},
 	}
 
+	if len(deployOptions.ExtraEnv) > 0 {
+		container := &deployment.Spec.Template.Spec.Containers[0]
+		mergedEnv, addedNames, _ := mergeExtraEnv(container.Env, deployOptions.ExtraEnv)
+		container.Env = mergedEnv
+		if len(addedNames) > 0 {
+			if deployment.Annotations == nil {
+				deployment.Annotations = map[string]string{}
+			}
+			deployment.Annotations[types.ExtraEnvAnnotation] = strings.Join(addedNames, ",")
+		}
+	}
+	if len(deployOptions.EnvFrom) > 0 {
+		deployment.Spec.Template.Spec.Containers[0].EnvFrom = deployOptions.EnvFrom
+	}
+	if len(deployOptions.ExtraVolumes) > 0 {
+		volumeNames := []string{}
+		for _, volume := range deployOptions.ExtraVolumes {
+			volumeNames = append(volumeNames, volume.Name)
+		}
+		if deployment.Annotations == nil {
+			deployment.Annotations = map[string]string{}
+		}
+		deployment.Annotations[types.ExtraVolumesAnnotation] = strings.Join(volumeNames, ",")
+
+		deployment.Spec.Template.Spec.Volumes = append(deployment.Spec.Template.Spec.Volumes, deployOptions.ExtraVolumes...)
+	}
+	if len(deployOptions.ExtraVolumeMounts) > 0 {
+		container := &deployment.Spec.Template.Spec.Containers[0]
+		container.VolumeMounts = append(container.VolumeMounts, deployOptions.ExtraVolumeMounts...)
+	}
+	if len(deployOptions.PodAnnotations) > 0 {
+		keys := []string{}
+		deployment.Spec.Template.Annotations = map[string]string{}
+		for key, value := range deployOptions.PodAnnotations {
+			deployment.Spec.Template.Annotations[key] = value
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		if deployment.Annotations == nil {
+			deployment.Annotations = map[string]string{}
+		}
+		deployment.Annotations[types.PodAnnotationsAnnotation] = strings.Join(keys, ",")
+	}
+	if len(deployOptions.TopologySpreadConstraints) > 0 {
+		deployment.Spec.Template.Spec.TopologySpreadConstraints = kotsadmTopologySpreadConstraints(deployOptions)
+	}
+
 	return deployment
 }
 
-func kotsadmService(namespace string) *corev1.Service {
+// kotsadmPodLabels are the labels of the kotsadm pod template
+func kotsadmPodLabels() map[string]string {
+	return map[string]string{
+		"app":            "kotsadm",
+		types.KotsadmKey: types.KotsadmLabelValue,
+	}
+}
+
+func kotsadmService(namespace string, servicePort int32) *corev1.Service {
 	port := corev1.ServicePort{
 		Name:       "http",
-		Port:       3000,
+		Port:       servicePort,
 		TargetPort: intstr.FromString("http"),
 	}
 
@@ -397,3 +647,36 @@ func kotsadmService(namespace string) *corev1.Service {
 
 	return service
 }
+
+// defaultKotsadmPort is the service and container port of kotsadm when they aren't configured
+const defaultKotsadmPort int32 = 3000
+
+func kotsadmContainerPort(deployOptions types.DeployOptions) int32 {
+	if deployOptions.ContainerPort > 0 {
+		return deployOptions.ContainerPort
+	}
+	return defaultKotsadmPort
+}
+
+func kotsadmServicePort(deployOptions types.DeployOptions) int32 {
+	if deployOptions.ServicePort > 0 {
+		return deployOptions.ServicePort
+	}
+	return defaultKotsadmPort
+}
+
+// mergeKotsadmService returns a copy of the existing service with the port of the deploy options. Other
+// ports, and the node port of the http port, are left alone.
+func mergeKotsadmService(existingService *corev1.Service, deployOptions types.DeployOptions) *corev1.Service {
+	mergedService := existingService.DeepCopy()
+	desiredPort := kotsadmService(deployOptions.Namespace, kotsadmServicePort(deployOptions)).Spec.Ports[0]
+	for i, port := range mergedService.Spec.Ports {
+		if port.Name == desiredPort.Name {
+			mergedService.Spec.Ports[i].Port = desiredPort.Port
+			mergedService.Spec.Ports[i].TargetPort = desiredPort.TargetPort
+			return mergedService
+		}
+	}
+	mergedService.Spec.Ports = append(mergedService.Spec.Ports, desiredPort)
+	return mergedService
+}
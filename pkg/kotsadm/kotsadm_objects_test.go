@@ -0,0 +1,393 @@
+package kotsadm
+
+import (
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func envByName(env []corev1.EnvVar) map[string]corev1.EnvVar {
+	byName := map[string]corev1.EnvVar{}
+	for _, e := range env {
+		byName[e.Name] = e
+	}
+	return byName
+}
+
+func Test_kotsadmDeploymentExtraEnv(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	deployOptions := types.DeployOptions{
+		Namespace: "default",
+		ExtraEnv: []corev1.EnvVar{
+			{Name: "HTTP_PROXY", Value: "http://proxy.corp:3128"},
+			{Name: "NO_PROXY", Value: "kotsadm-minio,kotsadm-postgres"},
+			{Name: "POD_NAMESPACE", Value: "overridden"},
+		},
+		EnvFrom: []corev1.EnvFromSource{
+			{
+				ConfigMapRef: &corev1.ConfigMapEnvSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "feature-flags"},
+				},
+			},
+		},
+	}
+
+	deployment := kotsadmDeployment(deployOptions)
+	container := deployment.Spec.Template.Spec.Containers[0]
+	env := envByName(container.Env)
+
+	assert.Equal(t, "http://proxy.corp:3128", env["HTTP_PROXY"].Value)
+	assert.Equal(t, "kotsadm-minio,kotsadm-postgres", env["NO_PROXY"].Value)
+	require.NotNil(t, env["POD_NAMESPACE"].ValueFrom, "managed env var should not be overridden")
+	assert.Equal(t, deployOptions.EnvFrom, container.EnvFrom)
+	assert.Equal(t, "HTTP_PROXY,NO_PROXY", deployment.Annotations[types.ExtraEnvAnnotation])
+
+	assert.Equal(t, []string{"POD_NAMESPACE"}, ignoredKotsadmExtraEnv(deployOptions))
+}
+
+func Test_updateKotsadmDeploymentExtraEnv(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	existingDeployment := kotsadmDeployment(types.DeployOptions{
+		Namespace: "default",
+		ExtraEnv: []corev1.EnvVar{
+			{Name: "HTTP_PROXY", Value: "http://proxy.corp:3128"},
+			{Name: "NO_PROXY", Value: "kotsadm-minio"},
+		},
+	})
+	existingDeployment.Spec.Template.Spec.Containers[0].Env = append(existingDeployment.Spec.Template.Spec.Containers[0].Env,
+		corev1.EnvVar{Name: "USER_ADDED", Value: "1"})
+
+	err := updateKotsadmDeployment(existingDeployment, types.DeployOptions{
+		Namespace: "default",
+		ExtraEnv: []corev1.EnvVar{
+			{Name: "HTTPS_PROXY", Value: "http://proxy.corp:3128"},
+		},
+	})
+	req.NoError(err)
+
+	env := envByName(existingDeployment.Spec.Template.Spec.Containers[0].Env)
+	assert.Contains(t, env, "HTTPS_PROXY")
+	assert.Contains(t, env, "USER_ADDED")
+	assert.NotContains(t, env, "HTTP_PROXY")
+	assert.NotContains(t, env, "NO_PROXY")
+	assert.Equal(t, "HTTPS_PROXY", existingDeployment.Annotations[types.ExtraEnvAnnotation])
+}
+
+func Test_kotsadmDeploymentExtraVolumes(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	caVolume := corev1.Volume{
+		Name: "corporate-ca",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "corporate-ca"},
+			},
+		},
+	}
+	caVolumeMount := corev1.VolumeMount{
+		Name:      "corporate-ca",
+		MountPath: "/etc/ssl/certs",
+		ReadOnly:  true,
+	}
+
+	deployOptions := types.DeployOptions{
+		Namespace:         "default",
+		ExtraVolumes:      []corev1.Volume{caVolume},
+		ExtraVolumeMounts: []corev1.VolumeMount{caVolumeMount},
+	}
+	req.NoError(validateExtraVolumes(deployOptions))
+
+	deployment := kotsadmDeployment(deployOptions)
+	assert.Equal(t, []corev1.Volume{caVolume}, deployment.Spec.Template.Spec.Volumes)
+	assert.Equal(t, []corev1.VolumeMount{caVolumeMount}, deployment.Spec.Template.Spec.Containers[0].VolumeMounts)
+
+	// removing the volume on the next deploy removes it from the existing deployment
+	err := updateKotsadmDeployment(deployment, types.DeployOptions{Namespace: "default"})
+	req.NoError(err)
+	assert.Empty(t, deployment.Spec.Template.Spec.Volumes)
+	assert.Empty(t, deployment.Spec.Template.Spec.Containers[0].VolumeMounts)
+	assert.NotContains(t, deployment.Annotations, types.ExtraVolumesAnnotation)
+
+	err = validateExtraVolumes(types.DeployOptions{
+		ExtraVolumeMounts: []corev1.VolumeMount{caVolumeMount},
+	})
+	req.Error(err)
+	assert.Contains(t, err.Error(), `references volume "corporate-ca"`)
+}
+
+func Test_kotsadmDeploymentPodAnnotations(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	deployment := kotsadmDeployment(types.DeployOptions{
+		Namespace: "default",
+		PodAnnotations: map[string]string{
+			"sidecar.istio.io/inject": "true",
+			"linkerd.io/inject":       "disabled",
+		},
+	})
+	assert.Equal(t, "true", deployment.Spec.Template.Annotations["sidecar.istio.io/inject"])
+	assert.Equal(t, "disabled", deployment.Spec.Template.Annotations["linkerd.io/inject"])
+	assert.NotContains(t, deployment.Annotations, "sidecar.istio.io/inject")
+	assert.Equal(t, "linkerd.io/inject,sidecar.istio.io/inject", deployment.Annotations[types.PodAnnotationsAnnotation])
+
+	// annotations that were added to the pod template by something else are kept
+	deployment.Spec.Template.Annotations["kubectl.kubernetes.io/restartedAt"] = "2020-04-01T00:00:00Z"
+
+	err := updateKotsadmDeployment(deployment, types.DeployOptions{
+		Namespace: "default",
+		PodAnnotations: map[string]string{
+			"sidecar.istio.io/inject": "false",
+		},
+	})
+	req.NoError(err)
+	assert.Equal(t, map[string]string{
+		"sidecar.istio.io/inject":           "false",
+		"kubectl.kubernetes.io/restartedAt": "2020-04-01T00:00:00Z",
+	}, deployment.Spec.Template.Annotations)
+	assert.Equal(t, "sidecar.istio.io/inject", deployment.Annotations[types.PodAnnotationsAnnotation])
+
+	err = updateKotsadmDeployment(deployment, types.DeployOptions{Namespace: "default"})
+	req.NoError(err)
+	assert.Equal(t, map[string]string{
+		"kubectl.kubernetes.io/restartedAt": "2020-04-01T00:00:00Z",
+	}, deployment.Spec.Template.Annotations)
+	assert.NotContains(t, deployment.Annotations, types.PodAnnotationsAnnotation)
+}
+
+func Test_kotsadmDeploymentContainerCommand(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	// the image entrypoint is used by default
+	deployment := kotsadmDeployment(types.DeployOptions{Namespace: "default"})
+	assert.Empty(t, deployment.Spec.Template.Spec.Containers[0].Command)
+	assert.Empty(t, deployment.Spec.Template.Spec.Containers[0].Args)
+
+	overrideOptions := types.DeployOptions{
+		Namespace:        "default",
+		ContainerCommand: []string{"/bin/sh", "-c"},
+		ContainerArgs:    []string{"sleep infinity"},
+	}
+	deployment = kotsadmDeployment(overrideOptions)
+	assert.Equal(t, []string{"/bin/sh", "-c"}, deployment.Spec.Template.Spec.Containers[0].Command)
+	assert.Equal(t, []string{"sleep infinity"}, deployment.Spec.Template.Spec.Containers[0].Args)
+
+	existingDeployment := kotsadmDeployment(types.DeployOptions{Namespace: "default"})
+	req.NoError(updateKotsadmDeployment(existingDeployment, overrideOptions))
+	assert.Equal(t, []string{"/bin/sh", "-c"}, existingDeployment.Spec.Template.Spec.Containers[0].Command)
+	assert.Equal(t, []string{"sleep infinity"}, existingDeployment.Spec.Template.Spec.Containers[0].Args)
+
+	// the override is removed once it's no longer requested
+	req.NoError(updateKotsadmDeployment(existingDeployment, types.DeployOptions{Namespace: "default"}))
+	assert.Empty(t, existingDeployment.Spec.Template.Spec.Containers[0].Command)
+	assert.Empty(t, existingDeployment.Spec.Template.Spec.Containers[0].Args)
+}
+
+func Test_kotsadmDeploymentStrategy(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	// the api server defaults to RollingUpdate
+	deployment := kotsadmDeployment(types.DeployOptions{Namespace: "default"})
+	assert.Empty(t, deployment.Spec.Strategy.Type)
+
+	recreateOptions := types.DeployOptions{
+		Namespace: "default",
+		DeploymentStrategy: appsv1.DeploymentStrategy{
+			Type: appsv1.RecreateDeploymentStrategyType,
+		},
+	}
+	deployment = kotsadmDeployment(recreateOptions)
+	assert.Equal(t, appsv1.RecreateDeploymentStrategyType, deployment.Spec.Strategy.Type)
+
+	existingDeployment := kotsadmDeployment(types.DeployOptions{Namespace: "default"})
+	existingDeployment.Spec.Strategy = appsv1.DeploymentStrategy{
+		Type:          appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{},
+	}
+	req.NoError(updateKotsadmDeployment(existingDeployment, recreateOptions))
+	assert.Equal(t, appsv1.RecreateDeploymentStrategyType, existingDeployment.Spec.Strategy.Type)
+	assert.Nil(t, existingDeployment.Spec.Strategy.RollingUpdate)
+
+	// the default is restored once Recreate is no longer requested
+	req.NoError(updateKotsadmDeployment(existingDeployment, types.DeployOptions{Namespace: "default"}))
+	assert.Empty(t, existingDeployment.Spec.Strategy.Type)
+
+	// rolling update parameters are left alone when no strategy is requested
+	existingDeployment.Spec.Strategy = appsv1.DeploymentStrategy{
+		Type:          appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{},
+	}
+	req.NoError(updateKotsadmDeployment(existingDeployment, types.DeployOptions{Namespace: "default"}))
+	assert.Equal(t, appsv1.RollingUpdateDeploymentStrategyType, existingDeployment.Spec.Strategy.Type)
+	assert.NotNil(t, existingDeployment.Spec.Strategy.RollingUpdate)
+}
+
+func Test_kotsadmDeploymentTopologySpreadConstraints(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	deployment := kotsadmDeployment(types.DeployOptions{Namespace: "default"})
+	assert.Empty(t, deployment.Spec.Template.Spec.TopologySpreadConstraints)
+
+	zoneConstraint := corev1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       "topology.kubernetes.io/zone",
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+	}
+	hostnameConstraint := corev1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       "kubernetes.io/hostname",
+		WhenUnsatisfiable: corev1.DoNotSchedule,
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: map[string]string{types.KotsadmKey: types.KotsadmLabelValue},
+		},
+	}
+	constraintOptions := types.DeployOptions{
+		Namespace:                 "default",
+		TopologySpreadConstraints: []corev1.TopologySpreadConstraint{zoneConstraint, hostnameConstraint},
+	}
+	req.NoError(validateTopologySpreadConstraints(constraintOptions))
+
+	// the label selector defaults to the kotsadm pods
+	expectedZoneConstraint := zoneConstraint
+	expectedZoneConstraint.LabelSelector = &metav1.LabelSelector{
+		MatchLabels: map[string]string{"app": "kotsadm"},
+	}
+	expected := []corev1.TopologySpreadConstraint{expectedZoneConstraint, hostnameConstraint}
+
+	deployment = kotsadmDeployment(constraintOptions)
+	assert.Equal(t, expected, deployment.Spec.Template.Spec.TopologySpreadConstraints)
+	assert.Nil(t, constraintOptions.TopologySpreadConstraints[0].LabelSelector)
+
+	existingDeployment := kotsadmDeployment(types.DeployOptions{Namespace: "default"})
+	req.NoError(updateKotsadmDeployment(existingDeployment, constraintOptions))
+	assert.Equal(t, expected, existingDeployment.Spec.Template.Spec.TopologySpreadConstraints)
+
+	// the constraints are removed once they're no longer requested
+	req.NoError(updateKotsadmDeployment(existingDeployment, types.DeployOptions{Namespace: "default"}))
+	assert.Empty(t, existingDeployment.Spec.Template.Spec.TopologySpreadConstraints)
+
+	err := validateTopologySpreadConstraints(types.DeployOptions{
+		TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+			zoneConstraint,
+			{
+				MaxSkew:           1,
+				TopologyKey:       "topology.kubernetes.io/zone",
+				WhenUnsatisfiable: corev1.DoNotSchedule,
+				LabelSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"app": "other"},
+				},
+			},
+		},
+	})
+	req.Error(err)
+	assert.Equal(t, `the label selector "app=other" of topology spread constraint 1 does not select the kotsadm pods`, err.Error())
+}
+
+func Test_kotsadmPorts(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	assertPorts := func(resources *KotsadmResources, servicePort int32, containerPort int32) {
+		container := resources.Deployment.Spec.Template.Spec.Containers[0]
+		req.Len(container.Ports, 1)
+		assert.Equal(t, "http", container.Ports[0].Name)
+		assert.Equal(t, containerPort, container.Ports[0].ContainerPort)
+		assert.Equal(t, int(containerPort), container.ReadinessProbe.HTTPGet.Port.IntValue())
+
+		req.Len(resources.Service.Spec.Ports, 1)
+		assert.Equal(t, servicePort, resources.Service.Spec.Ports[0].Port)
+		assert.Equal(t, container.Ports[0].Name, resources.Service.Spec.Ports[0].TargetPort.String())
+
+		assert.Equal(t, int(containerPort), resources.NetworkPolicy.Spec.Ingress[0].Ports[0].Port.IntValue())
+	}
+
+	deployOptions := types.DeployOptions{Namespace: "default", NetworkPolicy: &types.NetworkPolicyOptions{}}
+	resources, err := BuildKotsadmResources(deployOptions)
+	req.NoError(err)
+	assertPorts(resources, 3000, 3000)
+
+	deployOptions.ServicePort = 80
+	deployOptions.ContainerPort = 8080
+	resources, err = BuildKotsadmResources(deployOptions)
+	req.NoError(err)
+	assertPorts(resources, 80, 8080)
+}
+
+func Test_ensureKotsadmPortsUpdated(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	existingService := kotsadmService("default", 3000)
+	existingService.Spec.Ports[0].NodePort = 30800
+	existingService.Spec.Ports = append(existingService.Spec.Ports, corev1.ServicePort{Name: "metrics", Port: 9090})
+	clientset := fake.NewSimpleClientset(kotsadmDeployment(types.DeployOptions{Namespace: "default"}), existingService)
+
+	deployOptions := types.DeployOptions{Namespace: "default", ServicePort: 80, ContainerPort: 8080}
+	changed, err := ensureKotsadmDeployment(deployOptions, clientset)
+	req.NoError(err)
+	assert.True(t, changed)
+	req.NoError(ensureKotsadmService(deployOptions, clientset))
+
+	deployment, err := clientset.AppsV1().Deployments("default").Get("kotsadm", metav1.GetOptions{})
+	req.NoError(err)
+	container := deployment.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, int32(8080), container.Ports[0].ContainerPort)
+	assert.Equal(t, 8080, container.ReadinessProbe.HTTPGet.Port.IntValue())
+
+	service, err := clientset.CoreV1().Services("default").Get("kotsadm", metav1.GetOptions{})
+	req.NoError(err)
+	req.Len(service.Spec.Ports, 2)
+	assert.Equal(t, int32(80), service.Spec.Ports[0].Port)
+	assert.Equal(t, "http", service.Spec.Ports[0].TargetPort.String())
+	assert.Equal(t, int32(30800), service.Spec.Ports[0].NodePort)
+	assert.Equal(t, int32(9090), service.Spec.Ports[1].Port)
+}
+
+func Test_apiDeploymentKotsadmEndpoint(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	shipAPIEndpoint := func(deployment *appsv1.Deployment) string {
+		for _, env := range deployment.Spec.Template.Spec.Containers[0].Env {
+			if env.Name == "SHIP_API_ENDPOINT" {
+				return env.Value
+			}
+		}
+		return ""
+	}
+
+	deployOptions := types.DeployOptions{Namespace: "default"}
+	assert.Equal(t, "http://kotsadm.default.svc.cluster.local:3000", shipAPIEndpoint(apiDeployment(deployOptions)))
+
+	// the api follows the kotsadm service port, including when an existing deployment is updated
+	existingDeployment := apiDeployment(deployOptions)
+	deployOptions.ServicePort = 80
+	deployOptions.ContainerPort = 8080
+	assert.Equal(t, "http://kotsadm.default.svc.cluster.local:80", shipAPIEndpoint(apiDeployment(deployOptions)))
+	req.NoError(updateApiDeployment(existingDeployment, deployOptions))
+	assert.Equal(t, "http://kotsadm.default.svc.cluster.local:80", shipAPIEndpoint(existingDeployment))
+}
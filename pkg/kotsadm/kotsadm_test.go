@@ -2,9 +2,25 @@ package kotsadm
 
 import (
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	"github.com/replicatedhq/kots/pkg/util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func Test_isKotsadmClusterScoped(t *testing.T) {
@@ -111,6 +127,78 @@ spec:
   icon: https://raw.githubusercontent.com/cncf/artwork/master/projects/kubernetes/icon/color/kubernetes-icon-color.png`),
 			expected: false,
 		},
+		{
+			name: "with multiple applications, one requesting cluster scope",
+			applicationMetadata: []byte(`apiVersion: kots.io/v1beta1
+kind: Application
+metadata:
+  name: app-slug
+spec:
+  title: App Name
+  requireMinimalRBACPrivileges: true
+---
+apiVersion: kots.io/v1beta1
+kind: Application
+metadata:
+  name: other-app-slug
+spec:
+  title: Other App Name
+  requireMinimalRBACPrivileges: false`),
+			expected: true,
+		},
+		{
+			name: "with multiple applications, all requesting minimal scope",
+			applicationMetadata: []byte(`---
+apiVersion: kots.io/v1beta1
+kind: Application
+metadata:
+  name: app-slug
+spec:
+  title: App Name
+  requireMinimalRBACPrivileges: true
+---
+apiVersion: kots.io/v1beta1
+kind: Application
+metadata:
+  name: other-app-slug
+spec:
+  title: Other App Name
+  requireMinimalRBACPrivileges: true`),
+			expected: false,
+		},
+		{
+			name: "with a config map and an application requesting minimal scope",
+			applicationMetadata: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+data:
+  key: value
+---
+apiVersion: kots.io/v1beta1
+kind: Application
+metadata:
+  name: app-slug
+spec:
+  title: App Name
+  requireMinimalRBACPrivileges: true`),
+			expected: false,
+		},
+		{
+			name: "with an unregistered kind and an application requesting cluster scope",
+			applicationMetadata: []byte(`apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+---
+apiVersion: kots.io/v1beta1
+kind: Application
+metadata:
+  name: app-slug
+spec:
+  title: App Name`),
+			expected: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -124,3 +212,710 @@ spec:
 		})
 	}
 }
+
+func Test_isKotsadmClusterScopedWithoutApplication(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	_, err := isKotsadmClusterScoped([]byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config`))
+	req.Error(err)
+	assert.Equal(t, "application metadata does not contain a kots.io/v1beta1 Application", err.Error())
+}
+
+func Test_ensureKotsadmDeploymentUnchanged(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	clientset := fake.NewSimpleClientset()
+	deployOptions := types.DeployOptions{
+		Namespace: "default",
+		ExtraEnv:  []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "http://proxy.corp:3128"}},
+	}
+
+	changed, err := ensureKotsadmDeployment(deployOptions, clientset)
+	req.NoError(err)
+	assert.True(t, changed)
+
+	// the api server defaults fields that kots doesn't set
+	deployment, err := clientset.AppsV1().Deployments("default").Get("kotsadm", metav1.GetOptions{})
+	req.NoError(err)
+	maxSurge := intstr.FromString("25%")
+	deployment.Spec.Strategy = appsv1.DeploymentStrategy{
+		Type: appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{
+			MaxSurge:       &maxSurge,
+			MaxUnavailable: &maxSurge,
+		},
+	}
+	container := &deployment.Spec.Template.Spec.Containers[0]
+	container.TerminationMessagePath = corev1.TerminationMessagePathDefault
+	container.Ports[0].Protocol = corev1.ProtocolTCP
+	container.ReadinessProbe.SuccessThreshold = 1
+	container.ReadinessProbe.TimeoutSeconds = 1
+	for _, env := range container.Env {
+		if env.ValueFrom != nil && env.ValueFrom.FieldRef != nil {
+			env.ValueFrom.FieldRef.APIVersion = "v1"
+		}
+	}
+	_, err = clientset.AppsV1().Deployments("default").Update(deployment)
+	req.NoError(err)
+
+	clientset.ClearActions()
+	changed, err = ensureKotsadmDeployment(deployOptions, clientset)
+	req.NoError(err)
+	assert.False(t, changed)
+	for _, action := range clientset.Actions() {
+		assert.NotEqual(t, "update", action.GetVerb(), "deployment should not be updated when it already matches")
+	}
+
+	deployOptions.ExtraEnv[0].Value = "http://other-proxy.corp:3128"
+	changed, err = ensureKotsadmDeployment(deployOptions, clientset)
+	req.NoError(err)
+	assert.True(t, changed)
+}
+
+func Test_isJSONSubset(t *testing.T) {
+	tests := []struct {
+		name     string
+		desired  interface{}
+		current  interface{}
+		expected bool
+	}{
+		{
+			name:     "defaulted field",
+			desired:  map[string]interface{}{"containerPort": 3000.0},
+			current:  map[string]interface{}{"containerPort": 3000.0, "protocol": "TCP"},
+			expected: true,
+		},
+		{
+			name:     "changed field",
+			desired:  map[string]interface{}{"containerPort": 8080.0},
+			current:  map[string]interface{}{"containerPort": 3000.0, "protocol": "TCP"},
+			expected: false,
+		},
+		{
+			name:     "removed item",
+			desired:  []interface{}{map[string]interface{}{"name": "A"}},
+			current:  []interface{}{map[string]interface{}{"name": "A"}, map[string]interface{}{"name": "B"}},
+			expected: false,
+		},
+		{
+			name:     "nil list",
+			desired:  nil,
+			current:  []interface{}{map[string]interface{}{"name": "A"}},
+			expected: false,
+		},
+		{
+			name:     "nil pointer",
+			desired:  nil,
+			current:  map[string]interface{}{"maxSurge": "25%"},
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+
+			assert.Equal(t, test.expected, isJSONSubset(test.desired, test.current))
+		})
+	}
+}
+
+func Test_readKotsadmExtrasFromCluster(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	installOptions := types.DeployOptions{
+		Namespace:          "default",
+		ServicePort:        80,
+		ContainerPort:      8080,
+		ContainerCommand:   []string{"/bin/kotsadm"},
+		ContainerArgs:      []string{"api", "--debug"},
+		DeploymentStrategy: appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType},
+		TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+			{MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: corev1.ScheduleAnyway},
+		},
+		PodAnnotations: map[string]string{
+			"sidecar.istio.io/inject":          "false",
+			types.OptionalContainersAnnotation: "istio-proxy",
+		},
+	}
+	clientset := fake.NewSimpleClientset(kotsadmDeployment(installOptions), kotsadmService("default", kotsadmServicePort(installOptions)))
+
+	upgradeOptions := types.DeployOptions{Namespace: "default"}
+	req.NoError(readKotsadmExtrasFromCluster(&upgradeOptions, clientset))
+	assert.Equal(t, int32(80), upgradeOptions.ServicePort)
+	assert.Equal(t, int32(8080), upgradeOptions.ContainerPort)
+	assert.Equal(t, installOptions.PodAnnotations, upgradeOptions.PodAnnotations)
+	assert.Equal(t, installOptions.ContainerCommand, upgradeOptions.ContainerCommand)
+	assert.Equal(t, installOptions.ContainerArgs, upgradeOptions.ContainerArgs)
+	assert.Equal(t, kotsadmTopologySpreadConstraints(installOptions), upgradeOptions.TopologySpreadConstraints)
+	assert.Equal(t, installOptions.DeploymentStrategy, upgradeOptions.DeploymentStrategy)
+
+	clientset.ClearActions()
+	changed, err := ensureKotsadmDeployment(upgradeOptions, clientset)
+	req.NoError(err)
+	assert.False(t, changed)
+	req.NoError(ensureKotsadmService(upgradeOptions, clientset))
+	for _, action := range clientset.Actions() {
+		assert.NotEqual(t, "update", action.GetVerb(), "upgrade should keep the existing objects")
+	}
+}
+
+func Test_waitForKotsadmDeletion(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	clientset := fake.NewSimpleClientset()
+	req.NoError(waitForKotsadmDeletion("default", clientset, time.Minute))
+
+	terminatingPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kotsadm-6c5f7d8b9-abcde",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "kotsadm"},
+		},
+	}
+	otherPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kotsadm-minio-0",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "kotsadm-minio"},
+		},
+	}
+	clientset = fake.NewSimpleClientset(terminatingPod, otherPod)
+
+	err := waitForKotsadmDeletion("default", clientset, 0)
+	req.Error(err)
+	assert.Contains(t, err.Error(), "pods still terminating: kotsadm-6c5f7d8b9-abcde")
+	assert.NotContains(t, err.Error(), "kotsadm-minio-0")
+
+	// pods in other namespaces aren't waited for
+	req.NoError(waitForKotsadmDeletion("other", clientset, 0))
+}
+
+func Test_recreateKotsadmDeployment(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	deployOptions := types.DeployOptions{Namespace: "default"}
+	existingDeployment := kotsadmDeployment(deployOptions)
+	existingDeployment.Labels["existing"] = "true"
+	existingPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kotsadm-6c5f7d8b9-abcde",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "kotsadm"},
+		},
+	}
+
+	clientset := fake.NewSimpleClientset(existingDeployment, existingPod)
+	// the pods of the deployment are removed when it's deleted
+	clientset.PrependReactor("delete", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		err := clientset.Tracker().Delete(schema.GroupVersionResource{Version: "v1", Resource: "pods"}, "default", existingPod.Name)
+		return false, nil, err
+	})
+
+	req.NoError(recreateKotsadmDeployment(deployOptions, clientset))
+
+	verbs := []string{}
+	for _, action := range clientset.Actions() {
+		verbs = append(verbs, action.GetVerb()+" "+action.GetResource().Resource)
+	}
+	assert.Equal(t, []string{"delete deployments", "get deployments", "list pods", "create deployments"}, verbs)
+
+	deployment, err := clientset.AppsV1().Deployments("default").Get("kotsadm", metav1.GetOptions{})
+	req.NoError(err)
+	assert.NotContains(t, deployment.Labels, "existing")
+}
+
+func Test_recreateKotsadmDeploymentTimeout(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	deployOptions := types.DeployOptions{Namespace: "default", WaitTimeout: time.Nanosecond}
+	terminatingPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kotsadm-6c5f7d8b9-abcde",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "kotsadm"},
+		},
+	}
+
+	clientset := fake.NewSimpleClientset(kotsadmDeployment(deployOptions), terminatingPod)
+
+	err := recreateKotsadmDeployment(deployOptions, clientset)
+	req.Error(err)
+	assert.Contains(t, err.Error(), "pods still terminating: kotsadm-6c5f7d8b9-abcde")
+
+	// the deployment isn't created while the old pods are still around
+	for _, action := range clientset.Actions() {
+		assert.NotEqual(t, "create", action.GetVerb())
+	}
+	_, err = clientset.AppsV1().Deployments("default").Get("kotsadm", metav1.GetOptions{})
+	req.True(kuberneteserrors.IsNotFound(err))
+}
+
+func Test_ensureKotsadmRBAC(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	namespaceScoped := []byte(`apiVersion: kots.io/v1beta1
+kind: Application
+spec:
+  requireMinimalRBACPrivileges: true`)
+
+	clientset := fake.NewSimpleClientset()
+
+	result, err := ensureKotsadmRBAC(types.DeployOptions{Namespace: "default", ApplicationMetadata: namespaceScoped}, clientset)
+	req.NoError(err)
+	assert.False(t, result.ClusterScoped)
+	assert.Equal(t, []string{"Role/kotsadm-role", "RoleBinding/kotsadm-rolebinding", "ServiceAccount/kotsadm"}, result.Created)
+	assert.Empty(t, result.Existing)
+
+	result, err = ensureKotsadmRBAC(types.DeployOptions{Namespace: "default", ApplicationMetadata: namespaceScoped}, clientset)
+	req.NoError(err)
+	assert.Empty(t, result.Created)
+	assert.Equal(t, []string{"Role/kotsadm-role", "RoleBinding/kotsadm-rolebinding", "ServiceAccount/kotsadm"}, result.Existing)
+
+	result, err = ensureKotsadmRBAC(types.DeployOptions{Namespace: "default"}, clientset)
+	req.NoError(err)
+	assert.True(t, result.ClusterScoped)
+	assert.Equal(t, []string{"ClusterRole/kotsadm-role", "ClusterRoleBinding/kotsadm-rolebinding"}, result.Created)
+	assert.Equal(t, []string{"ServiceAccount/kotsadm"}, result.Existing)
+	assert.False(t, result.AppendedSubject)
+
+	// a second namespace is added to the subjects of the existing binding
+	result, err = ensureKotsadmRBAC(types.DeployOptions{Namespace: "other"}, clientset)
+	req.NoError(err)
+	assert.Equal(t, []string{"ServiceAccount/kotsadm"}, result.Created)
+	assert.Equal(t, []string{"ClusterRole/kotsadm-role", "ClusterRoleBinding/kotsadm-rolebinding"}, result.Existing)
+	assert.True(t, result.AppendedSubject)
+
+	binding, err := clientset.RbacV1().ClusterRoleBindings().Get("kotsadm-rolebinding", metav1.GetOptions{})
+	req.NoError(err)
+	assert.Len(t, binding.Subjects, 2)
+
+	result, err = ensureKotsadmRBAC(types.DeployOptions{Namespace: "other"}, clientset)
+	req.NoError(err)
+	assert.False(t, result.AppendedSubject)
+}
+
+func Test_waitForKotsadmPodReconnect(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	defer func(backoff time.Duration) { watchReconnectBackoff = backoff }(watchReconnectBackoff)
+	watchReconnectBackoff = time.Millisecond
+
+	pendingPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "kotsadm-1",
+			Namespace:       "default",
+			Labels:          map[string]string{"app": "kotsadm"},
+			ResourceVersion: "1",
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodPending},
+	}
+	readyPod := pendingPod.DeepCopy()
+	readyPod.ResourceVersion = "3"
+	readyPod.Status = corev1.PodStatus{
+		Phase:             corev1.PodRunning,
+		ContainerStatuses: []corev1.ContainerStatus{{Name: "kotsadm", Ready: true}},
+	}
+	modifiedPod := pendingPod.DeepCopy()
+	modifiedPod.ResourceVersion = "2"
+
+	// the first watch sends an event and is closed by the server, the second is stale, the third sends the ready pod
+	closedWatch := watch.NewFakeWithChanSize(1, false)
+	closedWatch.Modify(modifiedPod)
+	closedWatch.Stop()
+	staleWatch := watch.NewFakeWithChanSize(1, false)
+	staleWatch.Error(&kuberneteserrors.NewResourceExpired("too old resource version").ErrStatus)
+	readyWatch := watch.NewFakeWithChanSize(1, false)
+	readyWatch.Modify(readyPod)
+	watches := []*watch.FakeWatcher{closedWatch, staleWatch, readyWatch}
+
+	clientset := fake.NewSimpleClientset(pendingPod)
+	resourceVersions := []string{}
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		resourceVersions = append(resourceVersions, action.(k8stesting.WatchAction).GetWatchRestrictions().ResourceVersion)
+		if len(watches) == 0 {
+			return true, nil, kuberneteserrors.NewGone("no more watches")
+		}
+		w := watches[0]
+		watches = watches[1:]
+		return true, w, nil
+	})
+	lists := 0
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lists++
+		return false, nil, nil
+	})
+
+	err := waitForKotsadmPod("default", "", clientset, 10*time.Second)
+	req.NoError(err)
+
+	// the watch is resumed from the last event, and relisted after the stale resource version
+	assert.Equal(t, "2", resourceVersions[1])
+	assert.Len(t, resourceVersions, 3)
+	assert.Equal(t, 2, lists)
+}
+
+func Test_waitForKotsadmPodTimeout(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	defer func(backoff time.Duration) { watchReconnectBackoff = backoff }(watchReconnectBackoff)
+	watchReconnectBackoff = 10 * time.Millisecond
+
+	clientset := fake.NewSimpleClientset()
+	watchCount := 0
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		watchCount++
+		return true, nil, kuberneteserrors.NewServiceUnavailable("unavailable")
+	})
+
+	err := waitForKotsadmPod("default", "", clientset, 200*time.Millisecond)
+	req.Error(err)
+	assert.Contains(t, err.Error(), "timeout waiting for kotsadm pod")
+	assert.Contains(t, err.Error(), "unavailable")
+
+	// reconnects back off rather than hot looping
+	assert.True(t, watchCount < 10, "watched %d times", watchCount)
+}
+
+func Test_waitForKotsadmPodRelistBackoff(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	defer func(backoff time.Duration) { watchReconnectBackoff = backoff }(watchReconnectBackoff)
+	watchReconnectBackoff = 10 * time.Millisecond
+
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		return true, nil, kuberneteserrors.NewGone("too old resource version")
+	})
+	lists := 0
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		lists++
+		return false, nil, nil
+	})
+
+	err := waitForKotsadmPod("default", "", clientset, 200*time.Millisecond)
+	req.Error(err)
+	assert.Contains(t, err.Error(), "timeout waiting for kotsadm pod")
+
+	// relists back off rather than hot looping
+	assert.True(t, lists < 10, "listed %d times", lists)
+}
+
+func Test_waitForKotsadmEndpoints(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	readyPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kotsadm-1",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "kotsadm"},
+		},
+		Status: corev1.PodStatus{
+			Phase:             corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{Name: "kotsadm", Ready: true}},
+		},
+	}
+
+	clientset := fake.NewSimpleClientset(readyPod)
+	// the endpoints don't exist on the first get, and have an address on the second
+	gets := 0
+	clientset.PrependReactor("get", "endpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if gets == 1 {
+			return false, nil, nil
+		}
+		return true, &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "kotsadm", Namespace: "default"},
+			Subsets: []corev1.EndpointSubset{
+				{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}},
+			},
+		}, nil
+	})
+
+	deployOptions := &types.DeployOptions{Namespace: "default", WaitForEndpoints: true, WaitTimeout: 10 * time.Second}
+	req.NoError(waitForKotsadm(deployOptions, clientset))
+	assert.Equal(t, 2, gets)
+}
+
+func Test_waitForKotsadmEndpointsTimeout(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	readyPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kotsadm-1",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "kotsadm"},
+		},
+		Status: corev1.PodStatus{
+			Phase:             corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{Name: "kotsadm", Ready: true}},
+		},
+	}
+	notReadyEndpoints := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "kotsadm", Namespace: "default"},
+		Subsets: []corev1.EndpointSubset{
+			{NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}},
+		},
+	}
+
+	clientset := fake.NewSimpleClientset(readyPod, notReadyEndpoints)
+
+	deployOptions := &types.DeployOptions{Namespace: "default", WaitForEndpoints: true, WaitTimeout: 100 * time.Millisecond}
+	err := waitForKotsadm(deployOptions, clientset)
+	req.Error(err)
+	assert.Contains(t, err.Error(), "timeout waiting for kotsadm service endpoints")
+}
+
+func Test_isKotsadmPodReady(t *testing.T) {
+	runningPod := func(annotations map[string]string, statuses ...corev1.ContainerStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Annotations: annotations},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: statuses},
+		}
+	}
+	optionalLogger := map[string]string{types.OptionalContainersAnnotation: "logger, metrics"}
+
+	tests := []struct {
+		name               string
+		pod                *corev1.Pod
+		readyContainerName string
+		expected           bool
+	}{
+		{
+			name:     "pending",
+			pod:      &corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodPending}},
+			expected: false,
+		},
+		{
+			name:     "kotsadm ready",
+			pod:      runningPod(nil, corev1.ContainerStatus{Name: "kotsadm", Ready: true}),
+			expected: true,
+		},
+		{
+			name:     "kotsadm not first",
+			pod:      runningPod(nil, corev1.ContainerStatus{Name: "proxy", Ready: true}, corev1.ContainerStatus{Name: "kotsadm", Ready: true}),
+			expected: true,
+		},
+		{
+			name:     "kotsadm not ready",
+			pod:      runningPod(nil, corev1.ContainerStatus{Name: "proxy", Ready: true}, corev1.ContainerStatus{Name: "kotsadm", Ready: false}),
+			expected: false,
+		},
+		{
+			name:     "no kotsadm container",
+			pod:      runningPod(nil, corev1.ContainerStatus{Name: "proxy", Ready: true}),
+			expected: false,
+		},
+		{
+			name:     "required sidecar not ready",
+			pod:      runningPod(nil, corev1.ContainerStatus{Name: "kotsadm", Ready: true}, corev1.ContainerStatus{Name: "logger", Ready: false}),
+			expected: false,
+		},
+		{
+			name:     "optional sidecar not ready",
+			pod:      runningPod(optionalLogger, corev1.ContainerStatus{Name: "kotsadm", Ready: true}, corev1.ContainerStatus{Name: "logger", Ready: false}),
+			expected: true,
+		},
+		{
+			name:               "named container ready",
+			pod:                runningPod(nil, corev1.ContainerStatus{Name: "kotsadm", Ready: false}, corev1.ContainerStatus{Name: "proxy", Ready: true}),
+			readyContainerName: "proxy",
+			expected:           true,
+		},
+		{
+			name:               "named container not ready",
+			pod:                runningPod(nil, corev1.ContainerStatus{Name: "kotsadm", Ready: true}, corev1.ContainerStatus{Name: "proxy", Ready: false}),
+			readyContainerName: "proxy",
+			expected:           false,
+		},
+		{
+			name:               "named container missing",
+			pod:                runningPod(nil, corev1.ContainerStatus{Name: "kotsadm", Ready: true}),
+			readyContainerName: "proxy",
+			expected:           false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			req.Equal(test.expected, isKotsadmPodReady(test.pod, test.readyContainerName))
+		})
+	}
+}
+
+func Test_ensureKotsadmDeploymentPreventDowngrade(t *testing.T) {
+	defer func(version string) { OverrideVersion = version }(OverrideVersion)
+
+	tests := []struct {
+		name            string
+		existingVersion string
+		desiredVersion  string
+		expectError     bool
+	}{
+		{
+			name:            "upgrade",
+			existingVersion: "v1.16.0",
+			desiredVersion:  "v1.17.1",
+		},
+		{
+			name:            "same version",
+			existingVersion: "v1.17.1",
+			desiredVersion:  "v1.17.1",
+		},
+		{
+			name:            "downgrade",
+			existingVersion: "v1.17.1",
+			desiredVersion:  "v1.16.0",
+			expectError:     true,
+		},
+		{
+			name:            "downgrade to a prerelease",
+			existingVersion: "v1.17.0",
+			desiredVersion:  "v1.17.0-beta",
+			expectError:     true,
+		},
+		{
+			name:            "from a non-semver tag",
+			existingVersion: "alpha",
+			desiredVersion:  "v1.16.0",
+		},
+		{
+			name:            "to a non-semver tag",
+			existingVersion: "v1.17.1",
+			desiredVersion:  "alpha",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			deployOptions := types.DeployOptions{Namespace: "default", PreventDowngrade: true}
+
+			OverrideVersion = test.existingVersion
+			clientset := fake.NewSimpleClientset(kotsadmDeployment(deployOptions))
+
+			OverrideVersion = test.desiredVersion
+			_, err := ensureKotsadmDeployment(deployOptions, clientset)
+
+			deployment, getErr := clientset.AppsV1().Deployments("default").Get("kotsadm", metav1.GetOptions{})
+			req.NoError(getErr)
+			tag := imageTag(deployment.Spec.Template.Spec.Containers[0].Image)
+
+			if test.expectError {
+				req.Error(err)
+				assert.IsType(t, util.ActionableError{}, err)
+				assert.Contains(t, err.Error(), test.existingVersion)
+				assert.Contains(t, err.Error(), test.desiredVersion)
+				assert.Equal(t, test.existingVersion, tag)
+
+				// without the option, the older version is deployed
+				deployOptions.PreventDowngrade = false
+				_, err = ensureKotsadmDeployment(deployOptions, clientset)
+				req.NoError(err)
+				deployment, err = clientset.AppsV1().Deployments("default").Get("kotsadm", metav1.GetOptions{})
+				req.NoError(err)
+				tag = imageTag(deployment.Spec.Template.Spec.Containers[0].Image)
+			} else {
+				req.NoError(err)
+			}
+			assert.Equal(t, test.desiredVersion, tag)
+		})
+	}
+}
+
+func Test_ensureKotsadmClusterRBACFailures(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	// a cluster role that was just created is removed when the binding can't be created
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "clusterrolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kuberneteserrors.NewForbidden(schema.GroupResource{Group: "rbac.authorization.k8s.io", Resource: "clusterrolebindings"}, "kotsadm-rolebinding", nil)
+	})
+
+	_, err := ensureKotsadmClusterRBAC(types.DeployOptions{Namespace: "default"}, clientset)
+	req.Error(err)
+	_, err = clientset.RbacV1().ClusterRoles().Get("kotsadm-role", metav1.GetOptions{})
+	assert.True(t, kuberneteserrors.IsNotFound(err), "cluster role was not removed")
+
+	// an existing cluster role is left alone when the binding update fails, and the binding is unchanged
+	clientset = fake.NewSimpleClientset(kotsadmClusterRole(), kotsadmClusterRoleBinding("first"))
+	clientset.PrependReactor("update", "clusterrolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, kuberneteserrors.NewInternalError(errors.New("etcd unavailable"))
+	})
+
+	_, err = ensureKotsadmClusterRBAC(types.DeployOptions{Namespace: "second"}, clientset)
+	req.Error(err)
+	_, err = clientset.RbacV1().ClusterRoles().Get("kotsadm-role", metav1.GetOptions{})
+	req.NoError(err)
+	binding, err := clientset.RbacV1().ClusterRoleBindings().Get("kotsadm-rolebinding", metav1.GetOptions{})
+	req.NoError(err)
+	assert.Equal(t, kotsadmClusterRoleBinding("first").Subjects, binding.Subjects)
+
+	// an update that drops another namespace's subject is detected
+	clientset = fake.NewSimpleClientset(kotsadmClusterRole(), kotsadmClusterRoleBinding("first"))
+	clientset.PrependReactor("update", "clusterrolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		binding := action.(k8stesting.UpdateAction).GetObject().(*rbacv1.ClusterRoleBinding).DeepCopy()
+		binding.Subjects = binding.Subjects[len(binding.Subjects)-1:]
+		gvr := rbacv1.SchemeGroupVersion.WithResource("clusterrolebindings")
+		return true, binding, clientset.Tracker().Update(gvr, binding, "")
+	})
+
+	_, err = ensureKotsadmClusterRBAC(types.DeployOptions{Namespace: "second"}, clientset)
+	req.Error(err)
+	assert.Contains(t, err.Error(), "first/kotsadm")
+
+	// conflicts are retried
+	clientset = fake.NewSimpleClientset(kotsadmClusterRole(), kotsadmClusterRoleBinding("first"))
+	conflicts := 0
+	clientset.PrependReactor("update", "clusterrolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts == 0 {
+			conflicts++
+			return true, nil, kuberneteserrors.NewConflict(schema.GroupResource{Group: "rbac.authorization.k8s.io", Resource: "clusterrolebindings"}, "kotsadm-rolebinding", errors.New("modified"))
+		}
+		return false, nil, nil
+	})
+
+	result, err := ensureKotsadmClusterRBAC(types.DeployOptions{Namespace: "second"}, clientset)
+	req.NoError(err)
+	assert.True(t, result.AppendedSubject)
+	binding, err = clientset.RbacV1().ClusterRoleBindings().Get("kotsadm-rolebinding", metav1.GetOptions{})
+	req.NoError(err)
+	assert.Len(t, binding.Subjects, 2)
+}
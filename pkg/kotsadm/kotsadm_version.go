@@ -5,7 +5,9 @@ import (
 	"strings"
 
 	"github.com/Masterminds/semver"
+	"github.com/replicatedhq/kots/pkg/util"
 	"github.com/replicatedhq/kots/pkg/version"
+	appsv1 "k8s.io/api/apps/v1"
 )
 
 var (
@@ -21,7 +23,7 @@ func kotsadmTag() string {
 		return OverrideVersion
 	}
 
-	kotsVersion := version.Version()
+	kotsVersion := version.Get().Version
 
 	return kotsadmTagForVersionString(kotsVersion)
 }
@@ -58,3 +60,47 @@ func kotsadmRegistry() string {
 
 	return fmt.Sprintf("%s/%s", OverrideRegistry, OverrideNamespace)
 }
+
+// imageTag returns the tag of image, or "" when it doesn't have one or is referenced by digest
+func imageTag(image string) string {
+	if strings.Contains(image, "@") {
+		return ""
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	if idx := strings.LastIndex(name, ":"); idx != -1 {
+		return name[idx+1:]
+	}
+	return ""
+}
+
+// isKotsadmDowngrade returns true when existingTag is a newer version than desiredTag. Tags that aren't
+// semver, such as alpha, can't be ordered, so changing from or to one is never a downgrade.
+func isKotsadmDowngrade(existingTag string, desiredTag string) bool {
+	existingVersion, err := semver.NewVersion(existingTag)
+	if err != nil {
+		return false
+	}
+	desiredVersion, err := semver.NewVersion(desiredTag)
+	if err != nil {
+		return false
+	}
+	return existingVersion.GreaterThan(desiredVersion)
+}
+
+// checkKotsadmDowngrade returns an error if the kotsadm container of the existing deployment has a
+// newer version than the one that would be deployed
+func checkKotsadmDowngrade(existingDeployment *appsv1.Deployment) error {
+	desiredTag := kotsadmTag()
+	for _, c := range existingDeployment.Spec.Template.Spec.Containers {
+		if c.Name != "kotsadm" {
+			continue
+		}
+		existingTag := imageTag(c.Image)
+		if isKotsadmDowngrade(existingTag, desiredTag) {
+			return util.ActionableError{
+				Message: fmt.Sprintf("kotsadm %s is already deployed, which is newer than %s. Use a newer version of kots to update it.", existingTag, desiredTag),
+			}
+		}
+	}
+	return nil
+}
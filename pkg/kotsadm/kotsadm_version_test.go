@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
 	_ "go.undefinedlabs.com/scopeagent/autoinstrument"
 )
 
@@ -37,3 +39,41 @@ func Test_kotsadmTagForVersionString(t *testing.T) {
 		})
 	}
 }
+
+func Test_imageTag(t *testing.T) {
+	tests := []struct {
+		image  string
+		expect string
+	}{
+		{
+			image:  "kotsadm/kotsadm:v1.17.1",
+			expect: "v1.17.1",
+		},
+		{
+			image:  "localhost:5000/kotsadm/kotsadm:alpha",
+			expect: "alpha",
+		},
+		{
+			image:  "localhost:5000/kotsadm/kotsadm",
+			expect: "",
+		},
+		{
+			image:  "kotsadm/kotsadm@sha256:0123456789abcdef",
+			expect: "",
+		},
+		{
+			image:  "registry.somebigbank.com/my-namespace/kots",
+			expect: "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.image, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			req.Equal(test.expect, imageTag(test.image))
+		})
+	}
+}
@@ -125,31 +125,49 @@ func Upgrade(upgradeOptions types.UpgradeOptions) error {
 	return nil
 }
 
-func Deploy(deployOptions types.DeployOptions) error {
+// DeployResult describes where the admin console was deployed
+type DeployResult struct {
+	Namespace string
+	// KubernetesVersion is the version of the api server that the admin console was deployed to
+	KubernetesVersion string
+}
+
+// Deploy installs the admin console. It returns a DeployResult instead of the namespace that it used to
+// return, since the namespace can be generated.
+func Deploy(deployOptions types.DeployOptions) (*DeployResult, error) {
 	clientset, err := k8sutil.GetClientset(deployOptions.KubernetesConfigFlags)
 	if err != nil {
-		return errors.Wrap(err, "failed to get clientset")
+		return nil, errors.Wrap(err, "failed to get clientset")
 	}
 
 	log := logger.NewLogger()
-	namespace := &corev1.Namespace{
-		TypeMeta: metav1.TypeMeta{
-			APIVersion: "v1",
-			Kind:       "Namespace",
-		},
-		ObjectMeta: metav1.ObjectMeta{
-			Name: deployOptions.Namespace,
-		},
+
+	// a generated namespace doesn't exist yet, so namespaced permissions are checked across all namespaces
+	if !deployOptions.SkipRBACPreflight {
+		log.ChildActionWithSpinner("Checking permissions")
+		if err := checkRBACPermissions(deployOptions, clientset); err != nil {
+			log.FinishSpinnerWithError()
+			return nil, errors.Wrap(err, "failed rbac preflight")
+		}
+		log.FinishChildSpinner()
 	}
 
+	log.ChildActionWithSpinner("Checking Kubernetes version")
+	kubernetesVersion, err := getKubernetesVersion(clientset)
+	if err != nil {
+		log.FinishSpinnerWithError()
+		return nil, errors.Wrap(err, "failed to get kubernetes version")
+	}
+	if err := checkKubernetesVersion(kubernetesVersion); err != nil {
+		log.FinishSpinnerWithError()
+		return nil, errors.Wrap(err, "failed kubernetes version preflight")
+	}
+	log.FinishChildSpinner()
+
 	log.ChildActionWithSpinner("Creating namespace")
-	_, err = clientset.CoreV1().Namespaces().Create(namespace)
-	if err != nil && !kuberneteserrors.IsAlreadyExists(err) {
-		// Can't create namespace, but this might be a role restriction and namespace might already exist.
-		_, err := clientset.CoreV1().Pods(deployOptions.Namespace).List(metav1.ListOptions{})
-		if err != nil {
-			return errors.Wrap(err, "failed to verify access to namespace")
-		}
+	if err := ensureNamespace(&deployOptions, clientset); err != nil {
+		log.FinishSpinnerWithError()
+		return nil, errors.Wrap(err, "failed to ensure namespace")
 	}
 	log.FinishChildSpinner()
 
@@ -159,14 +177,63 @@ func Deploy(deployOptions types.DeployOptions) error {
 
 	limitRange, err := maybeGetNamespaceLimitRanges(clientset, deployOptions.Namespace)
 	if err != nil {
-		return errors.Wrap(err, "failed to get limit ranges for namespace")
+		return nil, errors.Wrap(err, "failed to get limit ranges for namespace")
 	}
 	deployOptions.LimitRange = limitRange
 
 	deployOptions.IsOpenShift = isOpenshift(clientset)
 
 	if err := ensureKotsadm(deployOptions, clientset, log); err != nil {
-		return errors.Wrap(err, "failed to deploy admin console")
+		return nil, errors.Wrap(err, "failed to deploy admin console")
+	}
+
+	return &DeployResult{
+		Namespace:         deployOptions.Namespace,
+		KubernetesVersion: kubernetesVersion.String(),
+	}, nil
+}
+
+// ensureNamespace creates the namespace to deploy to. When the namespace is generated, deployOptions
+// is updated with the name that the api server assigned.
+func ensureNamespace(deployOptions *types.DeployOptions, clientset kubernetes.Interface) error {
+	namespace := &corev1.Namespace{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Namespace",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: deployOptions.Namespace,
+		},
+	}
+
+	if deployOptions.GenerateNamespace {
+		prefix := deployOptions.GenerateNamespacePrefix
+		if prefix == "" {
+			prefix = "kotsadm-"
+		}
+		namespace.ObjectMeta = metav1.ObjectMeta{
+			GenerateName: prefix,
+		}
+
+		created, err := clientset.CoreV1().Namespaces().Create(namespace)
+		if err != nil {
+			return errors.Wrap(err, "failed to create namespace")
+		}
+		if created.Name == "" {
+			return errors.New("api server did not assign a namespace name")
+		}
+
+		deployOptions.Namespace = created.Name
+		return nil
+	}
+
+	_, err := clientset.CoreV1().Namespaces().Create(namespace)
+	if err != nil && !kuberneteserrors.IsAlreadyExists(err) {
+		// Can't create namespace, but this might be a role restriction and namespace might already exist.
+		_, err := clientset.CoreV1().Pods(deployOptions.Namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return errors.Wrap(err, "failed to verify access to namespace")
+		}
 	}
 
 	return nil
@@ -259,9 +326,23 @@ func ensureKotsadm(deployOptions types.DeployOptions, clientset *kubernetes.Clie
 		return errors.Wrap(err, "failed to ensure secrets exist")
 	}
 
-	if err := ensureKotsadmComponent(&deployOptions, clientset); err != nil {
+	for _, name := range ignoredKotsadmExtraEnv(deployOptions) {
+		log.Info("Ignoring extra env var %s, it is managed by the Admin Console", name)
+	}
+
+	rbacResult, err := ensureKotsadmComponent(&deployOptions, clientset)
+	if err != nil {
 		return errors.Wrap(err, "failed to ensure kotsadm exists")
 	}
+	if rbacResult.ClusterScoped {
+		log.Info("Admin Console RBAC is cluster scoped")
+	} else {
+		log.Info("Admin Console RBAC is namespace scoped")
+	}
+	log.Debug("Created RBAC resources: %v, existing: %v", rbacResult.Created, rbacResult.Existing)
+	if rbacResult.AppendedSubject {
+		log.Debug("Added the kotsadm service account in %s to the existing cluster role binding", deployOptions.Namespace)
+	}
 
 	if err := ensureAPI(&deployOptions, clientset); err != nil {
 		return errors.Wrap(err, "failed to ensure api exists")
@@ -382,5 +463,9 @@ func readDeployOptionsFromCluster(namespace string, kubernetesConfigFlags *gener
 		deployOptions.AutoCreateClusterToken = autocreateClusterToken
 	}
 
+	if err := readKotsadmExtrasFromCluster(&deployOptions, clientset); err != nil {
+		return nil, errors.Wrap(err, "failed to read kotsadm extras")
+	}
+
 	return &deployOptions, nil
 }
@@ -4,9 +4,54 @@ import (
 	"os"
 	"testing"
 
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.undefinedlabs.com/scopeagent"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestMain(m *testing.M) {
 	os.Exit(scopeagent.Run(m))
 }
+
+func Test_ensureNamespace(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	clientset := fake.NewSimpleClientset()
+	// the fake clientset doesn't generate names like the api server does
+	clientset.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		namespace := action.(k8stesting.CreateAction).GetObject().(*corev1.Namespace)
+		if namespace.Name == "" && namespace.GenerateName != "" {
+			namespace.Name = namespace.GenerateName + "x7k2p"
+		}
+		return false, nil, nil
+	})
+
+	deployOptions := types.DeployOptions{Namespace: "default"}
+	req.NoError(ensureNamespace(&deployOptions, clientset))
+	assert.Equal(t, "default", deployOptions.Namespace)
+	// an existing namespace is not an error
+	req.NoError(ensureNamespace(&deployOptions, clientset))
+
+	deployOptions = types.DeployOptions{GenerateNamespace: true}
+	req.NoError(ensureNamespace(&deployOptions, clientset))
+	assert.Equal(t, "kotsadm-x7k2p", deployOptions.Namespace)
+
+	_, err := clientset.CoreV1().Namespaces().Get("kotsadm-x7k2p", metav1.GetOptions{})
+	req.NoError(err)
+
+	deployOptions = types.DeployOptions{GenerateNamespace: true, GenerateNamespacePrefix: "ci-"}
+	req.NoError(ensureNamespace(&deployOptions, clientset))
+	assert.Equal(t, "ci-x7k2p", deployOptions.Namespace)
+
+	// cluster scoped rbac refers to the generated namespace
+	clusterRoleBinding := kotsadmClusterRoleBinding(deployOptions.Namespace)
+	assert.Equal(t, "ci-x7k2p", clusterRoleBinding.Subjects[0].Namespace)
+}
@@ -0,0 +1,154 @@
+package kotsadm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	"github.com/replicatedhq/kots/pkg/util"
+	"k8s.io/apimachinery/pkg/runtime/serializer/json"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// WriteKotsadmManifests renders the kotsadm manifests and writes them to dir, which is created if it
+// doesn't exist. The manifests have the same file names on every run, and the written paths are returned
+// in sorted order. Existing files are only replaced when deployOptions.OverwriteManifests is set, which
+// also removes the kotsadm manifests in dir that this render doesn't have, such as the role after
+// switching to cluster scope. Each file is renamed into place from a temporary dir in dir, so no file is
+// partially written, but a failure partway through can leave a mix of new and old files.
+func WriteKotsadmManifests(deployOptions types.DeployOptions, dir string) ([]string, error) {
+	docs, err := renderKotsadmManifests(deployOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to render kotsadm manifests")
+	}
+
+	filenames := []string{}
+	for filename := range docs {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	existing := []string{}
+	for _, filename := range filenames {
+		filePath := filepath.Join(dir, filename)
+		fi, err := os.Lstat(filePath)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to stat %s", filePath)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil, util.ActionableError{Message: filePath + " exists and is not a regular file"}
+		}
+		existing = append(existing, filePath)
+	}
+	if len(existing) > 0 && !deployOptions.OverwriteManifests {
+		return nil, util.ActionableError{
+			Message: "refusing to overwrite existing files: " + strings.Join(existing, ", "),
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s", dir)
+	}
+
+	tmpDir, err := ioutil.TempDir(dir, ".kotsadm-manifests")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, filename := range filenames {
+		tmpPath := filepath.Join(tmpDir, filename)
+		if err := ioutil.WriteFile(tmpPath, docs[filename], 0644); err != nil {
+			return nil, errors.Wrapf(err, "failed to write %s", filepath.Join(dir, filename))
+		}
+	}
+
+	written := []string{}
+	for _, filename := range filenames {
+		filePath := filepath.Join(dir, filename)
+		if err := os.Rename(filepath.Join(tmpDir, filename), filePath); err != nil {
+			return nil, errors.Wrapf(err, "failed to write %s", filePath)
+		}
+		written = append(written, filePath)
+	}
+
+	if deployOptions.OverwriteManifests {
+		if err := removeStaleKotsadmManifests(dir, docs); err != nil {
+			return nil, errors.Wrap(err, "failed to remove stale manifests")
+		}
+	}
+
+	return written, nil
+}
+
+// kotsadmManifestsGlob matches the names of all the manifests that renderKotsadmManifests can return
+const kotsadmManifestsGlob = "kotsadm-*.yaml"
+
+// removeStaleKotsadmManifests removes the kotsadm manifests in dir that aren't in docs
+func removeStaleKotsadmManifests(dir string, docs map[string][]byte) error {
+	matches, err := filepath.Glob(filepath.Join(dir, kotsadmManifestsGlob))
+	if err != nil {
+		return errors.Wrap(err, "failed to list manifests")
+	}
+
+	for _, match := range matches {
+		if _, ok := docs[filepath.Base(match)]; ok {
+			continue
+		}
+		fi, err := os.Lstat(match)
+		if err != nil {
+			return errors.Wrapf(err, "failed to stat %s", match)
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		if err := os.Remove(match); err != nil {
+			return errors.Wrapf(err, "failed to remove %s", match)
+		}
+	}
+
+	return nil
+}
+
+// renderKotsadmManifests returns the kotsadm manifests, with a cluster role and cluster role binding
+// in place of the role and role binding when kotsadm is cluster scoped
+func renderKotsadmManifests(deployOptions types.DeployOptions) (map[string][]byte, error) {
+	docs, err := getKotsadmYAML(deployOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get kotsadm yaml")
+	}
+
+	isClusterScoped, err := isKotsadmClusterScoped(deployOptions.ApplicationMetadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check if kotsadm is cluster scoped")
+	}
+	if !isClusterScoped {
+		return docs, nil
+	}
+
+	delete(docs, "kotsadm-role.yaml")
+	delete(docs, "kotsadm-rolebinding.yaml")
+
+	s := json.NewYAMLSerializer(json.DefaultMetaFactory, scheme.Scheme, scheme.Scheme)
+
+	var clusterRole bytes.Buffer
+	if err := s.Encode(kotsadmClusterRole(), &clusterRole); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal kotsadm cluster role")
+	}
+	docs["kotsadm-clusterrole.yaml"] = clusterRole.Bytes()
+
+	var clusterRoleBinding bytes.Buffer
+	if err := s.Encode(kotsadmClusterRoleBinding(deployOptions.Namespace), &clusterRoleBinding); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal kotsadm cluster role binding")
+	}
+	docs["kotsadm-clusterrolebinding.yaml"] = clusterRoleBinding.Bytes()
+
+	return docs, nil
+}
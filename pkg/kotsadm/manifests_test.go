@@ -0,0 +1,124 @@
+package kotsadm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+var namespacedApplicationMetadata = []byte(`apiVersion: kots.io/v1beta1
+kind: Application
+metadata:
+  name: app-slug
+spec:
+  title: App Name
+  requireMinimalRBACPrivileges: true`)
+
+func Test_WriteKotsadmManifests(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "kotsadm-manifests")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	dir := filepath.Join(tmpDir, "manifests")
+	deployOptions := types.DeployOptions{Namespace: "default", ApplicationMetadata: namespacedApplicationMetadata}
+
+	written, err := WriteKotsadmManifests(deployOptions, dir)
+	req.NoError(err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "kotsadm-deployment.yaml"),
+		filepath.Join(dir, "kotsadm-role.yaml"),
+		filepath.Join(dir, "kotsadm-rolebinding.yaml"),
+		filepath.Join(dir, "kotsadm-service.yaml"),
+		filepath.Join(dir, "kotsadm-serviceaccount.yaml"),
+	}, written)
+
+	// the temp dir the manifests are staged in is removed
+	entries, err := ioutil.ReadDir(dir)
+	req.NoError(err)
+	assert.Len(t, entries, len(written))
+
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "kotsadm-role.yaml"))
+	req.NoError(err)
+	assert.Contains(t, string(contents), "kind: Role\n")
+
+	// existing files are not replaced without the overwrite flag
+	req.NoError(ioutil.WriteFile(filepath.Join(dir, "kotsadm-service.yaml"), []byte("edited"), 0644))
+	_, err = WriteKotsadmManifests(deployOptions, dir)
+	req.Error(err)
+	assert.IsType(t, util.ActionableError{}, err)
+	contents, err = ioutil.ReadFile(filepath.Join(dir, "kotsadm-service.yaml"))
+	req.NoError(err)
+	assert.Equal(t, "edited", string(contents))
+
+	deployOptions.OverwriteManifests = true
+	rewritten, err := WriteKotsadmManifests(deployOptions, dir)
+	req.NoError(err)
+	assert.Equal(t, written, rewritten)
+	contents, err = ioutil.ReadFile(filepath.Join(dir, "kotsadm-service.yaml"))
+	req.NoError(err)
+	assert.Contains(t, string(contents), "kind: Service\n")
+
+	// a directory in place of a manifest is never replaced
+	req.NoError(os.Remove(filepath.Join(dir, "kotsadm-service.yaml")))
+	req.NoError(os.Mkdir(filepath.Join(dir, "kotsadm-service.yaml"), 0755))
+	_, err = WriteKotsadmManifests(deployOptions, dir)
+	req.Error(err)
+	assert.IsType(t, util.ActionableError{}, err)
+}
+
+func Test_WriteKotsadmManifestsClusterScoped(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	dir, err := ioutil.TempDir("", "kotsadm-manifests")
+	req.NoError(err)
+	defer os.RemoveAll(dir)
+
+	written, err := WriteKotsadmManifests(types.DeployOptions{Namespace: "kots"}, dir)
+	req.NoError(err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "kotsadm-clusterrole.yaml"),
+		filepath.Join(dir, "kotsadm-clusterrolebinding.yaml"),
+		filepath.Join(dir, "kotsadm-deployment.yaml"),
+		filepath.Join(dir, "kotsadm-service.yaml"),
+		filepath.Join(dir, "kotsadm-serviceaccount.yaml"),
+	}, written)
+
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "kotsadm-clusterrolebinding.yaml"))
+	req.NoError(err)
+	assert.Contains(t, string(contents), "namespace: kots\n")
+
+	// overwriting with a namespaced render removes the cluster scoped manifests, but not other files
+	req.NoError(ioutil.WriteFile(filepath.Join(dir, "other.yaml"), []byte("other"), 0644))
+	deployOptions := types.DeployOptions{Namespace: "kots", ApplicationMetadata: namespacedApplicationMetadata, OverwriteManifests: true}
+	written, err = WriteKotsadmManifests(deployOptions, dir)
+	req.NoError(err)
+
+	entries, err := ioutil.ReadDir(dir)
+	req.NoError(err)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Equal(t, []string{
+		"kotsadm-deployment.yaml",
+		"kotsadm-role.yaml",
+		"kotsadm-rolebinding.yaml",
+		"kotsadm-service.yaml",
+		"kotsadm-serviceaccount.yaml",
+		"other.yaml",
+	}, names)
+	assert.Len(t, written, 5)
+}
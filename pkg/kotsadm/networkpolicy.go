@@ -0,0 +1,63 @@
+package kotsadm
+
+import (
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+func ensureKotsadmNetworkPolicy(deployOptions types.DeployOptions, clientset kubernetes.Interface) error {
+	if deployOptions.NetworkPolicy == nil {
+		if deployOptions.RemoveNetworkPolicy {
+			return deleteKotsadmNetworkPolicy(deployOptions.Namespace, clientset)
+		}
+		return nil
+	}
+
+	desiredNetworkPolicy := kotsadmNetworkPolicy(deployOptions.Namespace, *deployOptions.NetworkPolicy, kotsadmContainerPort(deployOptions))
+
+	existingNetworkPolicy, err := clientset.NetworkingV1().NetworkPolicies(deployOptions.Namespace).Get(desiredNetworkPolicy.Name, metav1.GetOptions{})
+	if err != nil {
+		if !kuberneteserrors.IsNotFound(err) {
+			return errors.Wrap(err, "failed to get existing network policy")
+		}
+
+		_, err := clientset.NetworkingV1().NetworkPolicies(deployOptions.Namespace).Create(desiredNetworkPolicy)
+		if err != nil {
+			return errors.Wrap(err, "failed to create network policy")
+		}
+
+		return nil
+	}
+
+	existingNetworkPolicy.Spec = desiredNetworkPolicy.Spec
+	if _, err := clientset.NetworkingV1().NetworkPolicies(deployOptions.Namespace).Update(existingNetworkPolicy); err != nil {
+		return errors.Wrap(err, "failed to update network policy")
+	}
+
+	return nil
+}
+
+// deleteKotsadmNetworkPolicy removes a network policy created by a previous deploy
+func deleteKotsadmNetworkPolicy(namespace string, clientset kubernetes.Interface) error {
+	existingNetworkPolicy, err := clientset.NetworkingV1().NetworkPolicies(namespace).Get("kotsadm", metav1.GetOptions{})
+	if err != nil {
+		if kuberneteserrors.IsNotFound(err) {
+			return nil
+		}
+		return errors.Wrap(err, "failed to get existing network policy")
+	}
+
+	if existingNetworkPolicy.Labels[types.KotsadmKey] != types.KotsadmLabelValue {
+		return nil
+	}
+
+	err = clientset.NetworkingV1().NetworkPolicies(namespace).Delete(existingNetworkPolicy.Name, &metav1.DeleteOptions{})
+	if err != nil && !kuberneteserrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to delete network policy")
+	}
+
+	return nil
+}
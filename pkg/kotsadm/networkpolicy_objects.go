@@ -0,0 +1,131 @@
+package kotsadm
+
+import (
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func kotsadmNetworkPolicy(namespace string, options types.NetworkPolicyOptions, containerPort int32) *networkingv1.NetworkPolicy {
+	tcp := corev1.ProtocolTCP
+	udp := corev1.ProtocolUDP
+	httpPort := intstr.FromInt(int(containerPort))
+	dnsPort := intstr.FromInt(53)
+	httpsPort := intstr.FromInt(443)
+	apiServerPort := intstr.FromInt(6443)
+
+	ingressPeers := []networkingv1.NetworkPolicyPeer{
+		{
+			PodSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					types.KotsadmKey: types.KotsadmLabelValue,
+				},
+			},
+		},
+	}
+	for i := range options.IngressNamespaceSelectors {
+		ingressPeers = append(ingressPeers, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &options.IngressNamespaceSelectors[i],
+		})
+	}
+	for i := range options.IngressPodSelectors {
+		ingressPeers = append(ingressPeers, networkingv1.NetworkPolicyPeer{
+			PodSelector: &options.IngressPodSelectors[i],
+		})
+	}
+	for _, cidr := range options.IngressCIDRs {
+		ingressPeers = append(ingressPeers, networkingv1.NetworkPolicyPeer{
+			IPBlock: &networkingv1.IPBlock{
+				CIDR: cidr,
+			},
+		})
+	}
+
+	networkPolicy := &networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "networking.k8s.io/v1",
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kotsadm",
+			Namespace: namespace,
+			Labels: map[string]string{
+				types.KotsadmKey: types.KotsadmLabelValue,
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": "kotsadm",
+				},
+			},
+			PolicyTypes: []networkingv1.PolicyType{
+				networkingv1.PolicyTypeIngress,
+			},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{
+					From: ingressPeers,
+					Ports: []networkingv1.NetworkPolicyPort{
+						{
+							Protocol: &tcp,
+							Port:     &httpPort,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if options.RestrictEgress {
+		egressPeers := []networkingv1.NetworkPolicyPeer{
+			{
+				PodSelector: &metav1.LabelSelector{},
+			},
+		}
+		for _, cidr := range options.EgressCIDRs {
+			egressPeers = append(egressPeers, networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{
+					CIDR: cidr,
+				},
+			})
+		}
+
+		networkPolicy.Spec.PolicyTypes = append(networkPolicy.Spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+		networkPolicy.Spec.Egress = []networkingv1.NetworkPolicyEgressRule{
+			{
+				To: egressPeers,
+			},
+			{
+				// dns
+				Ports: []networkingv1.NetworkPolicyPort{
+					{
+						Protocol: &udp,
+						Port:     &dnsPort,
+					},
+					{
+						Protocol: &tcp,
+						Port:     &dnsPort,
+					},
+				},
+			},
+			{
+				// the kube-apiserver and the upstream (replicated.app). network policies can't select
+				// hostnames, so https is allowed to any destination
+				Ports: []networkingv1.NetworkPolicyPort{
+					{
+						Protocol: &tcp,
+						Port:     &httpsPort,
+					},
+					{
+						Protocol: &tcp,
+						Port:     &apiServerPort,
+					},
+				},
+			},
+		}
+	}
+
+	return networkPolicy
+}
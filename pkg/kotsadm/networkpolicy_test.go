@@ -0,0 +1,117 @@
+package kotsadm
+
+import (
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+	networkingv1 "k8s.io/api/networking/v1"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_ensureKotsadmNetworkPolicy(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	clientset := fake.NewSimpleClientset()
+
+	// no network policy by default
+	req.NoError(ensureKotsadmNetworkPolicy(types.DeployOptions{Namespace: "default"}, clientset))
+	_, err := clientset.NetworkingV1().NetworkPolicies("default").Get("kotsadm", metav1.GetOptions{})
+	req.True(kuberneteserrors.IsNotFound(err))
+
+	deployOptions := types.DeployOptions{
+		Namespace: "default",
+		NetworkPolicy: &types.NetworkPolicyOptions{
+			IngressNamespaceSelectors: []metav1.LabelSelector{
+				{MatchLabels: map[string]string{"name": "ingress-nginx"}},
+			},
+			IngressCIDRs: []string{"10.0.0.0/8"},
+		},
+	}
+	req.NoError(ensureKotsadmNetworkPolicy(deployOptions, clientset))
+
+	networkPolicy, err := clientset.NetworkingV1().NetworkPolicies("default").Get("kotsadm", metav1.GetOptions{})
+	req.NoError(err)
+	assert.Equal(t, map[string]string{"app": "kotsadm"}, networkPolicy.Spec.PodSelector.MatchLabels)
+	assert.Equal(t, []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}, networkPolicy.Spec.PolicyTypes)
+	req.Len(networkPolicy.Spec.Ingress, 1)
+	req.Len(networkPolicy.Spec.Ingress[0].Ports, 1)
+	assert.Equal(t, 3000, networkPolicy.Spec.Ingress[0].Ports[0].Port.IntValue())
+	req.Len(networkPolicy.Spec.Ingress[0].From, 3)
+	assert.Equal(t, "ingress-nginx", networkPolicy.Spec.Ingress[0].From[1].NamespaceSelector.MatchLabels["name"])
+	assert.Equal(t, "10.0.0.0/8", networkPolicy.Spec.Ingress[0].From[2].IPBlock.CIDR)
+
+	// changing the options updates the existing policy
+	deployOptions.NetworkPolicy.RestrictEgress = true
+	req.NoError(ensureKotsadmNetworkPolicy(deployOptions, clientset))
+
+	networkPolicy, err = clientset.NetworkingV1().NetworkPolicies("default").Get("kotsadm", metav1.GetOptions{})
+	req.NoError(err)
+	assert.Contains(t, networkPolicy.Spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+	req.Len(networkPolicy.Spec.Egress, 3)
+	req.Len(networkPolicy.Spec.Egress[2].Ports, 2)
+	assert.Empty(t, networkPolicy.Spec.Egress[2].To)
+	assert.Equal(t, 443, networkPolicy.Spec.Egress[2].Ports[0].Port.IntValue())
+	assert.Equal(t, 6443, networkPolicy.Spec.Egress[2].Ports[1].Port.IntValue())
+
+	// unsetting the options leaves the policy alone
+	req.NoError(ensureKotsadmNetworkPolicy(types.DeployOptions{Namespace: "default"}, clientset))
+	_, err = clientset.NetworkingV1().NetworkPolicies("default").Get("kotsadm", metav1.GetOptions{})
+	req.NoError(err)
+
+	// removing it has to be requested
+	req.NoError(ensureKotsadmNetworkPolicy(types.DeployOptions{Namespace: "default", RemoveNetworkPolicy: true}, clientset))
+	_, err = clientset.NetworkingV1().NetworkPolicies("default").Get("kotsadm", metav1.GetOptions{})
+	req.True(kuberneteserrors.IsNotFound(err))
+}
+
+func Test_upgradeKeepsNetworkPolicy(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	installOptions := types.DeployOptions{
+		Namespace: "default",
+		NetworkPolicy: &types.NetworkPolicyOptions{
+			IngressCIDRs: []string{"10.0.0.0/8"},
+		},
+	}
+	existingNetworkPolicy := kotsadmNetworkPolicy("default", *installOptions.NetworkPolicy, kotsadmContainerPort(installOptions))
+	clientset := fake.NewSimpleClientset(kotsadmDeployment(installOptions), existingNetworkPolicy)
+
+	// upgrade reads the deploy options back from the cluster
+	upgradeOptions := types.DeployOptions{Namespace: "default"}
+	req.NoError(readKotsadmExtrasFromCluster(&upgradeOptions, clientset))
+
+	diff, err := planKotsadmNetworkPolicy(upgradeOptions, clientset)
+	req.NoError(err)
+	req.Nil(diff)
+
+	req.NoError(ensureKotsadmNetworkPolicy(upgradeOptions, clientset))
+	networkPolicy, err := clientset.NetworkingV1().NetworkPolicies("default").Get("kotsadm", metav1.GetOptions{})
+	req.NoError(err)
+	assert.Equal(t, existingNetworkPolicy.Spec, networkPolicy.Spec)
+}
+
+func Test_ensureKotsadmNetworkPolicyKeepsUnmanaged(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	clientset := fake.NewSimpleClientset(&networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kotsadm",
+			Namespace: "default",
+		},
+	})
+
+	req.NoError(ensureKotsadmNetworkPolicy(types.DeployOptions{Namespace: "default", RemoveNetworkPolicy: true}, clientset))
+	_, err := clientset.NetworkingV1().NetworkPolicies("default").Get("kotsadm", metav1.GetOptions{})
+	req.NoError(err)
+}
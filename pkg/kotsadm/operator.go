@@ -4,7 +4,6 @@ import (
 	"bytes"
 
 	"github.com/pkg/errors"
-	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
 	"github.com/replicatedhq/kots/pkg/kotsadm/types"
 	rbacv1 "k8s.io/api/rbac/v1"
 	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
@@ -100,24 +99,20 @@ func ensureOperatorRBAC(deployOptions types.DeployOptions, clientset *kubernetes
 		return errors.Wrap(err, "failed to ensure operator role binding")
 	}
 
-	decode := scheme.Codecs.UniversalDeserializer().Decode
-	obj, gvk, err := decode(deployOptions.ApplicationMetadata, nil, nil)
+	applications, err := decodeApplications(deployOptions.ApplicationMetadata)
 	if err != nil {
-		return errors.Wrap(err, "failed to decode application metadata")
+		return err
 	}
 
-	if gvk.Group != "kots.io" || gvk.Version != "v1beta1" || gvk.Kind != "Application" {
-		return errors.New("application metadata contained unepxected gvk")
-	}
-
-	application := obj.(*kotsv1beta1.Application)
-	for _, additionalNamespace := range application.Spec.AdditionalNamespaces {
-		if err = ensureOperatorRole(additionalNamespace, clientset); err != nil {
-			return errors.Wrap(err, "failed to ensure operator additional namespace role")
-		}
+	for _, application := range applications {
+		for _, additionalNamespace := range application.Spec.AdditionalNamespaces {
+			if err = ensureOperatorRole(additionalNamespace, clientset); err != nil {
+				return errors.Wrap(err, "failed to ensure operator additional namespace role")
+			}
 
-		if err = ensureOperatorRoleBinding(additionalNamespace, clientset); err != nil {
-			return errors.Wrap(err, "failed to ensure operator additional namespace role binding")
+			if err = ensureOperatorRoleBinding(additionalNamespace, clientset); err != nil {
+				return errors.Wrap(err, "failed to ensure operator additional namespace role binding")
+			}
 		}
 	}
 
@@ -234,27 +229,22 @@ func isOperatorClusterScoped(applicationMetadata []byte) (bool, error) {
 		return true, nil
 	}
 
-	decode := scheme.Codecs.UniversalDeserializer().Decode
-	obj, gvk, err := decode(applicationMetadata, nil, nil)
+	applications, err := decodeApplications(applicationMetadata)
 	if err != nil {
-		return false, errors.Wrap(err, "failed to decode application metadata")
+		return false, err
 	}
 
-	if gvk.Group != "kots.io" || gvk.Version != "v1beta1" || gvk.Kind != "Application" {
-		return false, errors.New("application metadata contained unepxected gvk")
-	}
-
-	application := obj.(*kotsv1beta1.Application)
-
-	// An application can request cluster scope privileges quite simply
-	if !application.Spec.RequireMinimalRBACPrivileges {
-		return true, nil
-	}
-
-	for _, additionalNamespace := range application.Spec.AdditionalNamespaces {
-		if additionalNamespace == "*" {
+	for _, application := range applications {
+		// An application can request cluster scope privileges quite simply
+		if !application.Spec.RequireMinimalRBACPrivileges {
 			return true, nil
 		}
+
+		for _, additionalNamespace := range application.Spec.AdditionalNamespaces {
+			if additionalNamespace == "*" {
+				return true, nil
+			}
+		}
 	}
 
 	return false, nil
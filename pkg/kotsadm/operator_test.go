@@ -105,6 +105,49 @@ spec:
 			),
 			expected: true,
 		},
+		{
+			name: "with wildcard namespace and other kinds",
+			applicationMetadata: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+---
+apiVersion: kots.io/v1beta1
+kind: Application
+metadata:
+  name: app-slug
+spec:
+  title: App Name
+  requireMinimalRBACPrivileges: true
+  additionalNamespaces:
+    - "*"
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget`,
+			),
+			expected: true,
+		},
+		{
+			name: "with static namespace and other kinds",
+			applicationMetadata: []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+---
+apiVersion: kots.io/v1beta1
+kind: Application
+metadata:
+  name: app-slug
+spec:
+  title: App Name
+  requireMinimalRBACPrivileges: true
+  additionalNamespaces:
+    - other1`,
+			),
+			expected: false,
+		},
 	}
 
 	for _, test := range tests {
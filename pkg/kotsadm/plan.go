@@ -0,0 +1,422 @@
+package kotsadm
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/k8sutil"
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ResourceAction is what deploying would do to a resource
+type ResourceAction string
+
+const (
+	ResourceActionCreate    ResourceAction = "create"
+	ResourceActionUpdate    ResourceAction = "update"
+	ResourceActionRecreate  ResourceAction = "recreate"
+	ResourceActionUnchanged ResourceAction = "unchanged"
+	ResourceActionDelete    ResourceAction = "delete"
+)
+
+// ResourceDiff is what deploying would do to one of the kotsadm resources. Fields lists the changes
+// to an existing resource, and is empty when the resource would be created.
+type ResourceDiff struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Action    ResourceAction
+	Fields    []FieldDiff
+}
+
+// FieldDiff is a changed field, with the json path of the field such as spec.replicas or
+// metadata.annotations["kots.io/extra-env"]. Current or Desired is nil when the field is added or removed.
+type FieldDiff struct {
+	Path    string
+	Current interface{}
+	Desired interface{}
+}
+
+// Plan returns the changes that deploying kotsadm with deployOptions would make to the kotsadm
+// deployment, service, rbac, application metadata and network policy, without applying them
+func Plan(deployOptions types.DeployOptions) ([]ResourceDiff, error) {
+	clientset, err := k8sutil.GetClientset(deployOptions.KubernetesConfigFlags)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get clientset")
+	}
+
+	deployOptions.IsOpenShift = isOpenshift(clientset)
+
+	return planKotsadmComponent(deployOptions, clientset)
+}
+
+// planKotsadmComponent mirrors ensureKotsadmComponent, using the same merge logic to compute the
+// desired state of existing resources
+func planKotsadmComponent(deployOptions types.DeployOptions, clientset kubernetes.Interface) ([]ResourceDiff, error) {
+	if err := validateDeployOptions(deployOptions); err != nil {
+		return nil, err
+	}
+
+	diffs, err := planKotsadmRBAC(deployOptions, clientset)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to plan kotsadm rbac")
+	}
+
+	planners := []func(types.DeployOptions, kubernetes.Interface) (*ResourceDiff, error){
+		planApplicationMetadata,
+		planKotsadmDeployment,
+		planKotsadmService,
+		planKotsadmNetworkPolicy,
+	}
+	for _, planner := range planners {
+		diff, err := planner(deployOptions, clientset)
+		if err != nil {
+			return nil, err
+		}
+		if diff != nil {
+			diffs = append(diffs, *diff)
+		}
+	}
+
+	return diffs, nil
+}
+
+func planKotsadmRBAC(deployOptions types.DeployOptions, clientset kubernetes.Interface) ([]ResourceDiff, error) {
+	isClusterScoped, err := isKotsadmClusterScoped(deployOptions.ApplicationMetadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check if kotsadm is cluster scoped")
+	}
+
+	namespace := deployOptions.Namespace
+	diffs := []ResourceDiff{}
+
+	if isClusterScoped {
+		diff, err := planCreateOnly("ServiceAccount", namespace, "kotsadm", func() error {
+			_, err := clientset.CoreV1().ServiceAccounts(namespace).Get("kotsadm", metav1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, *diff)
+
+		diff, err = planCreateOnly("ClusterRole", "", "kotsadm-role", func() error {
+			_, err := clientset.RbacV1().ClusterRoles().Get("kotsadm-role", metav1.GetOptions{})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, *diff)
+
+		diff, err = planKotsadmClusterRoleBinding(namespace, clientset)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, *diff)
+
+		return diffs, nil
+	}
+
+	diff, err := planKotsadmRole(namespace, clientset)
+	if err != nil {
+		return nil, err
+	}
+	diffs = append(diffs, *diff)
+
+	diff, err = planCreateOnly("RoleBinding", namespace, "kotsadm-rolebinding", func() error {
+		_, err := clientset.RbacV1().RoleBindings(namespace).Get("kotsadm-rolebinding", metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	diffs = append(diffs, *diff)
+
+	diff, err = planCreateOnly("ServiceAccount", namespace, "kotsadm", func() error {
+		_, err := clientset.CoreV1().ServiceAccounts(namespace).Get("kotsadm", metav1.GetOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	diffs = append(diffs, *diff)
+
+	return diffs, nil
+}
+
+// planCreateOnly is for resources that are created when they're missing, and otherwise left as they are
+func planCreateOnly(kind string, namespace string, name string, get func() error) (*ResourceDiff, error) {
+	diff := &ResourceDiff{Kind: kind, Namespace: namespace, Name: name, Action: ResourceActionUnchanged}
+	if err := get(); err != nil {
+		if !kuberneteserrors.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "failed to get %s", strings.ToLower(kind))
+		}
+		diff.Action = ResourceActionCreate
+	}
+	return diff, nil
+}
+
+func planKotsadmRole(namespace string, clientset kubernetes.Interface) (*ResourceDiff, error) {
+	diff := &ResourceDiff{Kind: "Role", Namespace: namespace, Name: "kotsadm-role"}
+
+	currentRole, err := clientset.RbacV1().Roles(namespace).Get("kotsadm-role", metav1.GetOptions{})
+	if err != nil {
+		if !kuberneteserrors.IsNotFound(err) {
+			return nil, errors.Wrap(err, "failed to get role")
+		}
+		diff.Action = ResourceActionCreate
+		return diff, nil
+	}
+
+	desiredRole := currentRole.DeepCopy()
+	k8sutil.UpdateRole(desiredRole, kotsadmRole(namespace))
+
+	if err := diff.setFields(currentRole, desiredRole); err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+func planKotsadmClusterRoleBinding(serviceAccountNamespace string, clientset kubernetes.Interface) (*ResourceDiff, error) {
+	diff := &ResourceDiff{Kind: "ClusterRoleBinding", Name: "kotsadm-rolebinding"}
+
+	currentClusterRoleBinding, err := clientset.RbacV1().ClusterRoleBindings().Get("kotsadm-rolebinding", metav1.GetOptions{})
+	if err != nil {
+		if !kuberneteserrors.IsNotFound(err) {
+			return nil, errors.Wrap(err, "failed to get cluster rolebinding")
+		}
+		diff.Action = ResourceActionCreate
+		return diff, nil
+	}
+
+	kotsadmSubject := rbacv1.Subject{
+		Kind:      "ServiceAccount",
+		Name:      "kotsadm",
+		Namespace: serviceAccountNamespace,
+	}
+	desiredClusterRoleBinding := currentClusterRoleBinding.DeepCopy()
+	if !hasSubject(desiredClusterRoleBinding.Subjects, kotsadmSubject) {
+		desiredClusterRoleBinding.Subjects = append(desiredClusterRoleBinding.Subjects, kotsadmSubject)
+	}
+
+	if err := diff.setFields(currentClusterRoleBinding, desiredClusterRoleBinding); err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+func planApplicationMetadata(deployOptions types.DeployOptions, clientset kubernetes.Interface) (*ResourceDiff, error) {
+	desiredConfigMap := applicationMetadataConfig(deployOptions.ApplicationMetadata, deployOptions.Namespace)
+	diff := &ResourceDiff{Kind: "ConfigMap", Namespace: deployOptions.Namespace, Name: desiredConfigMap.Name}
+
+	currentConfigMap, err := clientset.CoreV1().ConfigMaps(deployOptions.Namespace).Get(desiredConfigMap.Name, metav1.GetOptions{})
+	if err != nil {
+		if !kuberneteserrors.IsNotFound(err) {
+			return nil, errors.Wrap(err, "failed to get existing metadata config map")
+		}
+		diff.Action = ResourceActionCreate
+		return diff, nil
+	}
+
+	mergedConfigMap := currentConfigMap.DeepCopy()
+	if deployOptions.ApplicationMetadata != nil && currentConfigMap.Data["application.yaml"] != desiredConfigMap.Data["application.yaml"] {
+		mergedConfigMap.Data = desiredConfigMap.Data
+	}
+
+	if err := diff.setFields(currentConfigMap, mergedConfigMap); err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+func planKotsadmDeployment(deployOptions types.DeployOptions, clientset kubernetes.Interface) (*ResourceDiff, error) {
+	diff := &ResourceDiff{Kind: "Deployment", Namespace: deployOptions.Namespace, Name: "kotsadm"}
+
+	existingDeployment, err := clientset.AppsV1().Deployments(deployOptions.Namespace).Get("kotsadm", metav1.GetOptions{})
+	if err != nil {
+		if !kuberneteserrors.IsNotFound(err) {
+			return nil, errors.Wrap(err, "failed to get existing deployment")
+		}
+		diff.Action = ResourceActionCreate
+		return diff, nil
+	}
+
+	mergedDeployment, changed, err := mergeKotsadmDeployment(existingDeployment, deployOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	// fields that only differ by api server defaults aren't updated
+	if !changed && !deployOptions.RecreateDeployment {
+		diff.Action = ResourceActionUnchanged
+		return diff, nil
+	}
+	if err := diff.setFields(existingDeployment, mergedDeployment); err != nil {
+		return nil, err
+	}
+
+	// the fields are what the recreated deployment changes compared to the existing one
+	if deployOptions.RecreateDeployment {
+		diff.Action = ResourceActionRecreate
+	}
+
+	return diff, nil
+}
+
+func planKotsadmService(deployOptions types.DeployOptions, clientset kubernetes.Interface) (*ResourceDiff, error) {
+	diff := &ResourceDiff{Kind: "Service", Namespace: deployOptions.Namespace, Name: "kotsadm"}
+
+	existingService, err := clientset.CoreV1().Services(deployOptions.Namespace).Get("kotsadm", metav1.GetOptions{})
+	if err != nil {
+		if !kuberneteserrors.IsNotFound(err) {
+			return nil, errors.Wrap(err, "failed to get existing service")
+		}
+		diff.Action = ResourceActionCreate
+		return diff, nil
+	}
+
+	if err := diff.setFields(existingService, mergeKotsadmService(existingService, deployOptions)); err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+func planKotsadmNetworkPolicy(deployOptions types.DeployOptions, clientset kubernetes.Interface) (*ResourceDiff, error) {
+	if deployOptions.NetworkPolicy == nil {
+		if !deployOptions.RemoveNetworkPolicy {
+			return nil, nil
+		}
+		existingNetworkPolicy, err := clientset.NetworkingV1().NetworkPolicies(deployOptions.Namespace).Get("kotsadm", metav1.GetOptions{})
+		if err != nil {
+			if kuberneteserrors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, errors.Wrap(err, "failed to get existing network policy")
+		}
+		if existingNetworkPolicy.Labels[types.KotsadmKey] != types.KotsadmLabelValue {
+			return nil, nil
+		}
+		return &ResourceDiff{Kind: "NetworkPolicy", Namespace: deployOptions.Namespace, Name: existingNetworkPolicy.Name, Action: ResourceActionDelete}, nil
+	}
+
+	desiredNetworkPolicy := kotsadmNetworkPolicy(deployOptions.Namespace, *deployOptions.NetworkPolicy, kotsadmContainerPort(deployOptions))
+	diff := &ResourceDiff{Kind: "NetworkPolicy", Namespace: deployOptions.Namespace, Name: desiredNetworkPolicy.Name}
+
+	existingNetworkPolicy, err := clientset.NetworkingV1().NetworkPolicies(deployOptions.Namespace).Get(desiredNetworkPolicy.Name, metav1.GetOptions{})
+	if err != nil {
+		if !kuberneteserrors.IsNotFound(err) {
+			return nil, errors.Wrap(err, "failed to get existing network policy")
+		}
+		diff.Action = ResourceActionCreate
+		return diff, nil
+	}
+
+	mergedNetworkPolicy := existingNetworkPolicy.DeepCopy()
+	mergedNetworkPolicy.Spec = desiredNetworkPolicy.Spec
+
+	if err := diff.setFields(existingNetworkPolicy, mergedNetworkPolicy); err != nil {
+		return nil, err
+	}
+
+	return diff, nil
+}
+
+// setFields sets the fields that differ between the current and desired objects, and the action to
+// update if there are any
+func (d *ResourceDiff) setFields(current runtime.Object, desired runtime.Object) error {
+	d.Action = ResourceActionUnchanged
+	if apiequality.Semantic.DeepEqual(current, desired) {
+		return nil
+	}
+
+	currentFields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(current)
+	if err != nil {
+		return errors.Wrapf(err, "failed to convert current %s", strings.ToLower(d.Kind))
+	}
+	desiredFields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(desired)
+	if err != nil {
+		return errors.Wrapf(err, "failed to convert desired %s", strings.ToLower(d.Kind))
+	}
+
+	d.Fields = diffFields("", currentFields, desiredFields)
+	if len(d.Fields) > 0 {
+		d.Action = ResourceActionUpdate
+	}
+
+	return nil
+}
+
+// diffFields compares unstructured values. Maps are compared by key and lists by index, so an item
+// inserted in the middle of a list shows as a change to each item after it. A missing map or list is
+// compared as an empty one, so that each added or removed item is its own field.
+func diffFields(path string, current interface{}, desired interface{}) []FieldDiff {
+	currentMap, currentIsMap := current.(map[string]interface{})
+	desiredMap, desiredIsMap := desired.(map[string]interface{})
+	if (currentIsMap || current == nil) && (desiredIsMap || desired == nil) && (currentIsMap || desiredIsMap) {
+		keys := map[string]bool{}
+		for key := range currentMap {
+			keys[key] = true
+		}
+		for key := range desiredMap {
+			keys[key] = true
+		}
+		sortedKeys := []string{}
+		for key := range keys {
+			sortedKeys = append(sortedKeys, key)
+		}
+		sort.Strings(sortedKeys)
+
+		diffs := []FieldDiff{}
+		for _, key := range sortedKeys {
+			diffs = append(diffs, diffFields(fieldPath(path, key), currentMap[key], desiredMap[key])...)
+		}
+		return diffs
+	}
+
+	currentList, currentIsList := current.([]interface{})
+	desiredList, desiredIsList := desired.([]interface{})
+	if (currentIsList || current == nil) && (desiredIsList || desired == nil) && (currentIsList || desiredIsList) {
+		diffs := []FieldDiff{}
+		for i := 0; i < len(currentList) || i < len(desiredList); i++ {
+			var currentItem, desiredItem interface{}
+			if i < len(currentList) {
+				currentItem = currentList[i]
+			}
+			if i < len(desiredList) {
+				desiredItem = desiredList[i]
+			}
+			diffs = append(diffs, diffFields(fmt.Sprintf("%s[%d]", path, i), currentItem, desiredItem)...)
+		}
+		return diffs
+	}
+
+	if reflect.DeepEqual(current, desired) {
+		return nil
+	}
+	return []FieldDiff{{Path: path, Current: current, Desired: desired}}
+}
+
+// fieldPath quotes keys that can't be written as a plain path segment, such as annotation keys
+func fieldPath(path string, key string) string {
+	if strings.ContainsAny(key, "./[]\" ") || key == "" {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
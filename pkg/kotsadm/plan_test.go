@@ -0,0 +1,141 @@
+package kotsadm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func actionsByResource(diffs []ResourceDiff) map[string]ResourceAction {
+	actions := map[string]ResourceAction{}
+	for _, diff := range diffs {
+		actions[diff.Kind+"/"+diff.Name] = diff.Action
+	}
+	return actions
+}
+
+func Test_planKotsadmComponentNewInstall(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	clientset := fake.NewSimpleClientset()
+	diffs, err := planKotsadmComponent(types.DeployOptions{Namespace: "default"}, clientset)
+	req.NoError(err)
+
+	assert.Equal(t, map[string]ResourceAction{
+		"ServiceAccount/kotsadm":                 ResourceActionCreate,
+		"ClusterRole/kotsadm-role":               ResourceActionCreate,
+		"ClusterRoleBinding/kotsadm-rolebinding": ResourceActionCreate,
+		"ConfigMap/kotsadm-application-metadata": ResourceActionCreate,
+		"Deployment/kotsadm":                     ResourceActionCreate,
+		"Service/kotsadm":                        ResourceActionCreate,
+	}, actionsByResource(diffs))
+
+	for _, action := range clientset.Actions() {
+		assert.Equal(t, "get", action.GetVerb())
+	}
+}
+
+func Test_planKotsadmComponentExistingInstall(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	existingOptions := types.DeployOptions{Namespace: "default"}
+	clusterRoleBinding := kotsadmClusterRoleBinding("other")
+	clientset := fake.NewSimpleClientset(
+		kotsadmServiceAccount("default"),
+		kotsadmClusterRole(),
+		clusterRoleBinding,
+		applicationMetadataConfig(nil, "default"),
+		kotsadmDeployment(existingOptions),
+		kotsadmService("default", 3000),
+	)
+
+	// nothing changes when the options are the same
+	diffs, err := planKotsadmComponent(existingOptions, clientset)
+	req.NoError(err)
+	for _, diff := range diffs {
+		if diff.Kind == "ClusterRoleBinding" {
+			continue
+		}
+		assert.Equal(t, ResourceActionUnchanged, diff.Action, diff.Kind)
+		assert.Empty(t, diff.Fields, diff.Kind)
+	}
+
+	deployOptions := types.DeployOptions{
+		Namespace: "default",
+		ExtraEnv:  []corev1.EnvVar{{Name: "FOO", Value: "bar"}},
+	}
+	diffs, err = planKotsadmComponent(deployOptions, clientset)
+	req.NoError(err)
+
+	actions := actionsByResource(diffs)
+	assert.Equal(t, ResourceActionUpdate, actions["ClusterRoleBinding/kotsadm-rolebinding"])
+	assert.Equal(t, ResourceActionUpdate, actions["Deployment/kotsadm"])
+	assert.Equal(t, ResourceActionUnchanged, actions["Service/kotsadm"])
+
+	fields := map[string]FieldDiff{}
+	for _, diff := range diffs {
+		for _, field := range diff.Fields {
+			fields[diff.Kind+" "+field.Path] = field
+		}
+	}
+
+	subjectPath := "ClusterRoleBinding subjects[1]"
+	req.Contains(fields, subjectPath)
+	assert.Nil(t, fields[subjectPath].Current)
+	assert.Equal(t, map[string]interface{}{"kind": rbacv1.ServiceAccountKind, "name": "kotsadm", "namespace": "default"}, fields[subjectPath].Desired)
+
+	annotationPath := `Deployment metadata.annotations["kots.io/extra-env"]`
+	req.Contains(fields, annotationPath)
+	assert.Nil(t, fields[annotationPath].Current)
+	assert.Equal(t, "FOO", fields[annotationPath].Desired)
+
+	envCount := len(kotsadmDeployment(existingOptions).Spec.Template.Spec.Containers[0].Env)
+	envPath := fmt.Sprintf("Deployment spec.template.spec.containers[0].env[%d]", envCount)
+	req.Contains(fields, envPath)
+	assert.Equal(t, map[string]interface{}{"name": "FOO", "value": "bar"}, fields[envPath].Desired)
+
+	// the plan doesn't change anything
+	for _, action := range clientset.Actions() {
+		assert.Equal(t, "get", action.GetVerb())
+	}
+}
+
+func Test_diffFields(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	current := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": int64(1),
+			"items":    []interface{}{"a", "b"},
+		},
+	}
+	desired := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{"kots.io/key": "value"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(2),
+			"items":    []interface{}{"a"},
+		},
+	}
+
+	assert.Equal(t, []FieldDiff{
+		{Path: `metadata.annotations["kots.io/key"]`, Current: nil, Desired: "value"},
+		{Path: "spec.items[1]", Current: "b", Desired: nil},
+		{Path: "spec.replicas", Current: int64(1), Desired: int64(2)},
+	}, diffFields("", current, desired))
+
+	assert.Empty(t, diffFields("", current, current))
+}
@@ -0,0 +1,110 @@
+package kotsadm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	"github.com/replicatedhq/kots/pkg/util"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+type requiredPermission struct {
+	Group      string
+	Resource   string
+	Namespaced bool
+}
+
+func (p requiredPermission) String() string {
+	if p.Group == "" {
+		return p.Resource
+	}
+	return fmt.Sprintf("%s.%s", p.Resource, p.Group)
+}
+
+// requiredPermissions returns the resources that deploying the admin console creates or updates
+func requiredPermissions(deployOptions types.DeployOptions) ([]requiredPermission, error) {
+	permissions := []requiredPermission{
+		{Group: "", Resource: "secrets", Namespaced: true},
+		{Group: "", Resource: "configmaps", Namespaced: true},
+		{Group: "", Resource: "services", Namespaced: true},
+		{Group: "", Resource: "serviceaccounts", Namespaced: true},
+		{Group: "", Resource: "pods", Namespaced: true},
+		{Group: "apps", Resource: "deployments", Namespaced: true},
+		{Group: "apps", Resource: "statefulsets", Namespaced: true},
+	}
+
+	if deployOptions.NetworkPolicy != nil {
+		permissions = append(permissions, requiredPermission{Group: "networking.k8s.io", Resource: "networkpolicies", Namespaced: true})
+	}
+
+	isKotsadmClusterScoped, err := isKotsadmClusterScoped(deployOptions.ApplicationMetadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check if kotsadm is cluster scoped")
+	}
+	isOperatorClusterScoped, err := isOperatorClusterScoped(deployOptions.ApplicationMetadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check if operator is cluster scoped")
+	}
+
+	if isKotsadmClusterScoped || isOperatorClusterScoped {
+		permissions = append(permissions,
+			requiredPermission{Group: "rbac.authorization.k8s.io", Resource: "clusterroles"},
+			requiredPermission{Group: "rbac.authorization.k8s.io", Resource: "clusterrolebindings"},
+		)
+	}
+	if !isKotsadmClusterScoped || !isOperatorClusterScoped {
+		permissions = append(permissions,
+			requiredPermission{Group: "rbac.authorization.k8s.io", Resource: "roles", Namespaced: true},
+			requiredPermission{Group: "rbac.authorization.k8s.io", Resource: "rolebindings", Namespaced: true},
+		)
+	}
+
+	return permissions, nil
+}
+
+// checkRBACPermissions uses self subject access reviews to check that the deploy will not fail partway
+// through because of missing permissions. All missing permissions are reported together.
+func checkRBACPermissions(deployOptions types.DeployOptions, clientset kubernetes.Interface) error {
+	permissions, err := requiredPermissions(deployOptions)
+	if err != nil {
+		return errors.Wrap(err, "failed to get required permissions")
+	}
+
+	missing := []string{}
+	for _, permission := range permissions {
+		for _, verb := range []string{"create", "update"} {
+			resourceAttributes := &authorizationv1.ResourceAttributes{
+				Verb:     verb,
+				Group:    permission.Group,
+				Resource: permission.Resource,
+			}
+			if permission.Namespaced {
+				resourceAttributes.Namespace = deployOptions.Namespace
+			}
+
+			review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(&authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: resourceAttributes,
+				},
+			})
+			if err != nil {
+				return errors.Wrapf(err, "failed to check permission to %s %s", verb, permission)
+			}
+
+			if !review.Status.Allowed {
+				missing = append(missing, fmt.Sprintf("%s %s", verb, permission))
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return util.ActionableError{
+			Message: fmt.Sprintf("Missing permissions required to deploy the Admin Console:\n  %s", strings.Join(missing, "\n  ")),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package kotsadm
+
+import (
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_checkRBACPermissions(t *testing.T) {
+	tests := []struct {
+		name            string
+		deniedResources []string
+		expectMissing   []string
+	}{
+		{
+			name:            "all allowed",
+			deniedResources: nil,
+		},
+		{
+			name:            "clusterrole creation denied",
+			deniedResources: []string{"clusterroles"},
+			expectMissing: []string{
+				"create clusterroles.rbac.authorization.k8s.io",
+				"update clusterroles.rbac.authorization.k8s.io",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			clientset := fake.NewSimpleClientset()
+			clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				review.Status.Allowed = true
+				for _, denied := range test.deniedResources {
+					if review.Spec.ResourceAttributes.Resource == denied {
+						review.Status.Allowed = false
+					}
+				}
+				return true, review, nil
+			})
+
+			err := checkRBACPermissions(types.DeployOptions{Namespace: "default"}, clientset)
+			if len(test.expectMissing) == 0 {
+				req.NoError(err)
+				return
+			}
+
+			req.Error(err)
+			for _, missing := range test.expectMissing {
+				assert.Contains(t, err.Error(), missing)
+			}
+			assert.NotContains(t, err.Error(), "secrets")
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package kotsadm
+
+import (
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// KotsadmResources are the objects that make up the kotsadm component, built the same way that
+// Deploy builds them. Callers can change them before applying them to the cluster themselves.
+type KotsadmResources struct {
+	ServiceAccount *corev1.ServiceAccount
+	// Role and RoleBinding are only set when kotsadm is namespace scoped
+	Role        *rbacv1.Role
+	RoleBinding *rbacv1.RoleBinding
+	// ClusterRole and ClusterRoleBinding are only set when kotsadm is cluster scoped
+	ClusterRole        *rbacv1.ClusterRole
+	ClusterRoleBinding *rbacv1.ClusterRoleBinding
+	Deployment         *appsv1.Deployment
+	Service            *corev1.Service
+	// NetworkPolicy is only set when deployOptions.NetworkPolicy is set
+	NetworkPolicy *networkingv1.NetworkPolicy
+}
+
+// BuildKotsadmResources returns the kotsadm objects for deployOptions without creating them
+func BuildKotsadmResources(deployOptions types.DeployOptions) (*KotsadmResources, error) {
+	if err := validateDeployOptions(deployOptions); err != nil {
+		return nil, err
+	}
+
+	isClusterScoped, err := isKotsadmClusterScoped(deployOptions.ApplicationMetadata)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check if kotsadm is cluster scoped")
+	}
+
+	resources := &KotsadmResources{
+		ServiceAccount: kotsadmServiceAccount(deployOptions.Namespace),
+		Deployment:     kotsadmDeployment(deployOptions),
+		Service:        kotsadmService(deployOptions.Namespace, kotsadmServicePort(deployOptions)),
+	}
+
+	if isClusterScoped {
+		resources.ClusterRole = kotsadmClusterRole()
+		resources.ClusterRoleBinding = kotsadmClusterRoleBinding(deployOptions.Namespace)
+	} else {
+		resources.Role = kotsadmRole(deployOptions.Namespace)
+		resources.RoleBinding = kotsadmRoleBinding(deployOptions.Namespace)
+	}
+
+	if deployOptions.NetworkPolicy != nil {
+		resources.NetworkPolicy = kotsadmNetworkPolicy(deployOptions.Namespace, *deployOptions.NetworkPolicy, kotsadmContainerPort(deployOptions))
+	}
+
+	return resources, nil
+}
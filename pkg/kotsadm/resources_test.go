@@ -0,0 +1,52 @@
+package kotsadm
+
+import (
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/kotsadm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_BuildKotsadmResources(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	resources, err := BuildKotsadmResources(types.DeployOptions{Namespace: "kots"})
+	req.NoError(err)
+	req.NotNil(resources.ClusterRole)
+	assert.Equal(t, "kots", resources.ClusterRoleBinding.Subjects[0].Namespace)
+	assert.Nil(t, resources.Role)
+	assert.Nil(t, resources.RoleBinding)
+	assert.Nil(t, resources.NetworkPolicy)
+	assert.Equal(t, "kots", resources.ServiceAccount.Namespace)
+	assert.Equal(t, "kots", resources.Deployment.Namespace)
+	assert.Equal(t, "kots", resources.Service.Namespace)
+
+	resources, err = BuildKotsadmResources(types.DeployOptions{
+		Namespace: "kots",
+		ApplicationMetadata: []byte(`apiVersion: kots.io/v1beta1
+kind: Application
+metadata:
+  name: app-slug
+spec:
+  title: App Name
+  requireMinimalRBACPrivileges: true`),
+		NetworkPolicy: &types.NetworkPolicyOptions{},
+	})
+	req.NoError(err)
+	assert.Nil(t, resources.ClusterRole)
+	assert.Nil(t, resources.ClusterRoleBinding)
+	assert.Equal(t, "kots", resources.Role.Namespace)
+	assert.Equal(t, "kots", resources.RoleBinding.Namespace)
+	req.NotNil(resources.NetworkPolicy)
+
+	_, err = BuildKotsadmResources(types.DeployOptions{
+		Namespace:         "kots",
+		ExtraVolumeMounts: []corev1.VolumeMount{{Name: "missing", MountPath: "/missing"}},
+	})
+	req.Error(err)
+}
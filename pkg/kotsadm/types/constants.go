@@ -3,4 +3,19 @@ package types
 const KotsadmKey = "kots.io/kotsadm"
 const KotsadmLabelValue = "true"
 
+// ExtraEnvAnnotation lists the names of the extra env vars that were added to the kotsadm container,
+// so that they can be removed when they are no longer in the deploy options
+const ExtraEnvAnnotation = "kots.io/extra-env"
+
+// ExtraVolumesAnnotation lists the names of the extra volumes that were added to the kotsadm pod
+const ExtraVolumesAnnotation = "kots.io/extra-volumes"
+
+// PodAnnotationsAnnotation lists the keys of the pod annotations that were added to the kotsadm pod
+// template, so that they can be removed when they are no longer in the deploy options
+const PodAnnotationsAnnotation = "kots.io/pod-annotations"
+
+// OptionalContainersAnnotation lists the names of sidecar containers in the kotsadm pod that kotsadm
+// doesn't wait for to be ready
+const OptionalContainersAnnotation = "kots.io/optional-containers"
+
 const ClusterTokenSecret = "kotsadm-cluster-token"
@@ -1,8 +1,12 @@
 package types
 
 import (
+	"time"
+
 	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
@@ -27,4 +31,79 @@ type DeployOptions struct {
 	LimitRange             *corev1.LimitRange
 	IsOpenShift            bool
 	License                *kotsv1beta1.License
+	// RecreateDeployment will delete and recreate the kotsadm deployment instead of updating it.
+	// Only the deployment is recreated, volumes and pvcs are not affected.
+	RecreateDeployment bool
+	// WaitTimeout is how long to wait for kotsadm to be ready after deploying it, 2 minutes when it's 0
+	WaitTimeout time.Duration
+	// WaitForEndpoints will also wait for the kotsadm service to have a ready endpoint
+	WaitForEndpoints bool
+	// ExtraEnv is added to the kotsadm container. Env vars that kots manages take precedence.
+	ExtraEnv []corev1.EnvVar
+	EnvFrom  []corev1.EnvFromSource
+	// ExtraVolumes are added to the kotsadm pod, and ExtraVolumeMounts to the kotsadm container.
+	// Each mount must reference one of the extra volumes.
+	ExtraVolumes      []corev1.Volume
+	ExtraVolumeMounts []corev1.VolumeMount
+	// PodAnnotations are set on the kotsadm pod template, such as sidecar.istio.io/inject. They are
+	// kept on every deploy, and removed once they are no longer requested. Annotations that were added
+	// to the pod template some other way are left alone.
+	PodAnnotations map[string]string
+	// ContainerCommand and ContainerArgs replace the kotsadm image's entrypoint and arguments when they
+	// are set. This is an advanced option for debugging or custom entrypoints, kotsadm may not start
+	// with other values. The image defaults are restored on the next deploy without them, and an upgrade
+	// keeps them.
+	ContainerCommand []string
+	ContainerArgs    []string
+	// SkipRBACPreflight disables the self subject access review checks that run before deploying,
+	// for clusters where they aren't available
+	SkipRBACPreflight bool
+	// NetworkPolicy restricts access to kotsadm when set. When it's nil, a network policy created by a
+	// previous deploy is left alone, unless RemoveNetworkPolicy is set.
+	NetworkPolicy *NetworkPolicyOptions
+	// GenerateNamespace creates a new namespace with a name generated from GenerateNamespacePrefix,
+	// instead of using Namespace. Namespace is set to the generated name before anything is deployed.
+	GenerateNamespace       bool
+	GenerateNamespacePrefix string
+	// DeploymentStrategy is the strategy of the kotsadm deployment, which is RollingUpdate when it's not
+	// set. Recreate stops the old kotsadm pod before the new one starts, and is advisable when kotsadm
+	// uses a backend that only allows a single writer, at the cost of downtime during upgrades.
+	DeploymentStrategy appsv1.DeploymentStrategy
+	// TopologySpreadConstraints are set on the kotsadm pod template, such as to spread replicas across
+	// zones. A constraint without a label selector selects the kotsadm pods, and a label selector that
+	// is set must match them.
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint
+	// ReadyContainerName is the container in the kotsadm pod that is waited on to be ready. When it's
+	// empty, the kotsadm container and any sidecars that aren't in OptionalContainersAnnotation are.
+	ReadyContainerName string
+	// OverwriteManifests lets WriteKotsadmManifests replace manifests that were written before
+	OverwriteManifests bool
+	// PreventDowngrade fails the deploy instead of updating a kotsadm deployment that runs a newer
+	// version than this one would deploy
+	PreventDowngrade bool
+	// MaxRetries is how many times a step of ensuring the kotsadm resources is retried after a transient
+	// api server error, such as a conflict or a server timeout. It defaults to 3, and a negative value
+	// disables retries. RetryBackoff is the delay before the first retry, which doubles for each one after
+	// it, and defaults to 500ms.
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// ServicePort is the port of the kotsadm service, and ContainerPort is the port of the kotsadm container,
+	// which the service, the readiness probe and the network policy target. Both default to 3000. Nothing
+	// tells kotsadm to listen on ContainerPort, the image must already listen on it.
+	ServicePort   int32
+	ContainerPort int32
+	// RemoveNetworkPolicy deletes the network policy created by a previous deploy when NetworkPolicy is nil
+	RemoveNetworkPolicy bool
+}
+
+// NetworkPolicyOptions configures the sources that can reach kotsadm. Admin Console components in the
+// same namespace are always allowed.
+type NetworkPolicyOptions struct {
+	IngressNamespaceSelectors []metav1.LabelSelector
+	IngressPodSelectors       []metav1.LabelSelector
+	IngressCIDRs              []string
+	// RestrictEgress limits egress from kotsadm to the same namespace, dns, https and the kube-apiserver,
+	// and EgressCIDRs
+	RestrictEgress bool
+	EgressCIDRs    []string
 }
@@ -0,0 +1,373 @@
+package kotsadmclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/auth"
+	"github.com/replicatedhq/kots/pkg/k8sutil"
+	"github.com/replicatedhq/kots/pkg/logger"
+	"github.com/replicatedhq/kots/pkg/util"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// Options are how to reach the kotsadm pod
+type Options struct {
+	Namespace             string
+	KubernetesConfigFlags *genericclioptions.ConfigFlags
+	// KubeconfigPath or KubeconfigBytes connect with an explicit kubeconfig instead of KubernetesConfigFlags
+	KubeconfigPath  string
+	KubeconfigBytes []byte
+	// TempDir is where KubeconfigBytes are written to. It defaults to the system temp dir.
+	TempDir string
+	// HTTPS connects to kotsadm with tls, for when kotsadm terminates tls itself. The certificate is
+	// verified against CABundle when it's set, without checking the hostname because the connection is
+	// to localhost. Without a CABundle, the certificate isn't verified.
+	HTTPS    bool
+	CABundle []byte
+	// PodSelector is the label selector of the kotsadm pod, it defaults to k8sutil.KotsadmPodSelector
+	PodSelector string
+	Log         logger.Interface
+	// RemotePort is the port in the kotsadm pod to forward to. It defaults to the http port of the kotsadm
+	// container, or 3000 if the container doesn't name one.
+	RemotePort int
+}
+
+// Client is a port forward to the kotsadm pod, and the auth slug to make requests with
+type Client struct {
+	// LocalPort is the port on localhost that kotsadm is forwarded to
+	LocalPort int
+	// PodName and RemotePort are the kotsadm pod and port that are forwarded to, they're empty for a
+	// client from NewClient
+	PodName    string
+	RemotePort int
+
+	authSlug    string
+	scheme      string
+	httpClient  *http.Client
+	configFlags *genericclioptions.ConfigFlags
+	namespace   string
+	log         logger.Interface
+	// ctx is cancelled when the port forward fails
+	ctx              context.Context
+	portForwardError func(err error) error
+	close            func()
+}
+
+// Connect port forwards to the kotsadm pod in opts.Namespace and reads the auth slug. The client can
+// be used for multiple requests, and must be closed when done.
+func Connect(opts Options) (*Client, error) {
+	log := opts.Log
+	if log == nil {
+		log = logger.NewLogger()
+	}
+
+	scheme := "http"
+	httpClient := http.DefaultClient
+	if opts.HTTPS {
+		c, err := newTLSClient(opts.CABundle)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create tls client")
+		}
+		scheme = "https"
+		httpClient = c
+	}
+
+	configFlags, cleanupConfigFlags, err := kubernetesConfigFlags(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get kubeconfig")
+	}
+
+	clientset, err := k8sutil.GetClientset(configFlags)
+	if err != nil {
+		cleanupConfigFlags()
+		return nil, errors.Wrap(err, "failed to get clientset")
+	}
+
+	podName, err := k8sutil.FindKotsadm(clientset, opts.Namespace, opts.PodSelector)
+	if err != nil {
+		cleanupConfigFlags()
+		return nil, errors.Wrap(err, "failed to find kotsadm pod")
+	}
+
+	remotePort := opts.RemotePort
+	if remotePort == 0 {
+		remotePort, err = k8sutil.KotsadmHTTPPort(clientset, opts.Namespace, podName)
+		if err != nil {
+			cleanupConfigFlags()
+			return nil, errors.Wrap(err, "failed to find kotsadm port")
+		}
+	}
+
+	stopCh := make(chan struct{})
+	closeClient := func() {
+		close(stopCh)
+		cleanupConfigFlags()
+	}
+
+	// the port forward only logs when polling for additional ports
+	localPort, errChan, err := k8sutil.PortForward(configFlags, 0, remotePort, opts.Namespace, podName, false, stopCh, nil)
+	if err != nil {
+		closeClient()
+		return nil, errors.Wrap(err, "failed to start port forwarding")
+	}
+
+	ctx, portForwardError := watchPortForward(errChan, stopCh)
+
+	authSlug, err := auth.GetOrCreateAuthSlug(configFlags, opts.Namespace)
+	if err != nil {
+		closeClient()
+		return nil, errors.Wrap(err, "failed to get kotsadm auth slug")
+	}
+
+	client := &Client{
+		LocalPort:        localPort,
+		PodName:          podName,
+		RemotePort:       remotePort,
+		authSlug:         authSlug,
+		scheme:           scheme,
+		httpClient:       httpClient,
+		configFlags:      configFlags,
+		namespace:        opts.Namespace,
+		log:              log,
+		ctx:              ctx,
+		portForwardError: portForwardError,
+		close:            closeClient,
+	}
+
+	return client, nil
+}
+
+// NewClient returns a client for a kotsadm that's already reachable on localPort, without a port
+// forward. The auth slug isn't refreshed if kotsadm rejects it.
+func NewClient(localPort int, authSlug string) *Client {
+	return &Client{
+		LocalPort:        localPort,
+		authSlug:         authSlug,
+		scheme:           "http",
+		httpClient:       http.DefaultClient,
+		log:              logger.NewLogger(),
+		ctx:              context.Background(),
+		portForwardError: func(err error) error { return err },
+		close:            func() {},
+	}
+}
+
+// URL returns the url of path on kotsadm
+func (c *Client) URL(path string) string {
+	return fmt.Sprintf("%s://localhost:%d%s", c.scheme, c.LocalPort, path)
+}
+
+// AuthHeader is the value of the Authorization header for requests to kotsadm
+func (c *Client) AuthHeader() string {
+	return c.authSlug
+}
+
+// Context is cancelled when the port forward fails, so that requests made with it are aborted
+// instead of hanging
+func (c *Client) Context() context.Context {
+	return c.ctx
+}
+
+// Do sends the request from newRequest with the client's auth header. The auth slug may have been
+// rotated since it was read, so if kotsadm rejects it, it's read again and the request is sent once more.
+func (c *Client) Do(newRequest func(authHeader string) (*http.Request, error)) (*http.Response, error) {
+	var refreshAuthSlug func() (string, error)
+	if c.configFlags != nil {
+		refreshAuthSlug = func() (string, error) {
+			c.log.Debug("kotsadm rejected the auth slug, reading it again")
+			authSlug, err := auth.RefreshAuthSlug(c.configFlags, c.namespace)
+			if err != nil {
+				return "", err
+			}
+			c.authSlug = authSlug
+			return authSlug, nil
+		}
+	}
+
+	return doAuthenticatedRequest(c.httpClient, newRequest, c.authSlug, refreshAuthSlug)
+}
+
+// CheckReady polls the kotsadm healthz endpoint until it responds with 200, and returns an actionable
+// "kotsadm is not ready" error if it doesn't within timeout. This separates a kotsadm that's still
+// starting from a failed request.
+func (c *Client) CheckReady(timeout time.Duration) error {
+	url := c.URL("/healthz")
+
+	var lastErr error
+	start := time.Now()
+	for {
+		lastErr = c.checkHealthz(url)
+		if lastErr == nil {
+			return nil
+		}
+		c.log.Debug("kotsadm is not ready: %s", lastErr.Error())
+
+		if time.Since(start) > timeout {
+			break
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return c.PortForwardError(lastErr)
+		case <-time.After(healthzInterval):
+		}
+	}
+
+	return util.ActionableError{
+		Message: fmt.Sprintf("kotsadm is not ready, it did not respond within %s: %s", timeout, lastErr.Error()),
+	}
+}
+
+// healthzInterval is how long CheckReady waits between requests
+const healthzInterval = 500 * time.Millisecond
+
+func (c *Client) checkHealthz(url string) error {
+	ctx, cancel := context.WithTimeout(c.ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create healthz request")
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to get healthz")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("healthz responded with status %s", resp.Status)
+	}
+	return nil
+}
+
+// PortForwardError replaces an error from a request that was aborted by a failed port forward with
+// the port forward error
+func (c *Client) PortForwardError(err error) error {
+	return c.portForwardError(err)
+}
+
+// Close stops the port forward
+func (c *Client) Close() {
+	c.close()
+}
+
+// IsAuthError returns true if kotsadm rejected the request's auth header
+func IsAuthError(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
+}
+
+// doAuthenticatedRequest sends the request with authSlug, and if kotsadm rejects it, once more with
+// the slug from refreshAuthSlug. The response is returned as is when refreshAuthSlug is nil.
+func doAuthenticatedRequest(httpClient *http.Client, newRequest func(authSlug string) (*http.Request, error), authSlug string, refreshAuthSlug func() (string, error)) (*http.Response, error) {
+	req, err := newRequest(authSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if !IsAuthError(resp.StatusCode) || refreshAuthSlug == nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	refreshedAuthSlug, err := refreshAuthSlug()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to refresh kotsadm auth slug")
+	}
+
+	req, err = newRequest(refreshedAuthSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	return httpClient.Do(req)
+}
+
+// newTLSClient returns a client that verifies the server certificate against caBundle, or doesn't
+// verify it when caBundle is empty. The hostname isn't verified, the certificate won't be for localhost.
+func newTLSClient(caBundle []byte) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		// verification is done by VerifyPeerCertificate instead, when there's a ca bundle
+		InsecureSkipVerify: true,
+	}
+
+	if len(caBundle) > 0 {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(caBundle) {
+			return nil, errors.New("no certificates found in ca bundle")
+		}
+
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			certs := []*x509.Certificate{}
+			for _, rawCert := range rawCerts {
+				cert, err := x509.ParseCertificate(rawCert)
+				if err != nil {
+					return errors.Wrap(err, "failed to parse kotsadm certificate")
+				}
+				certs = append(certs, cert)
+			}
+			if len(certs) == 0 {
+				return errors.New("kotsadm did not present a certificate")
+			}
+
+			intermediates := x509.NewCertPool()
+			for _, cert := range certs[1:] {
+				intermediates.AddCert(cert)
+			}
+
+			_, err := certs[0].Verify(x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: intermediates,
+			})
+			return errors.Wrap(err, "failed to verify kotsadm certificate")
+		}
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
+
+// watchPortForward returns a context that is cancelled as soon as the port forward fails, so that
+// a request through it is aborted instead of hanging. The returned function replaces an error from
+// the aborted request with the port forward error. The watch ends when stopCh is closed.
+func watchPortForward(errChan <-chan error, stopCh <-chan struct{}) (context.Context, func(err error) error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	forwardErrCh := make(chan error, 1)
+
+	go func() {
+		defer cancel()
+		select {
+		case err := <-errChan:
+			if err != nil {
+				forwardErrCh <- err
+			}
+		case <-stopCh:
+		}
+	}()
+
+	portForwardError := func(err error) error {
+		select {
+		case forwardErr := <-forwardErrCh:
+			return errors.Wrap(forwardErr, "port forward failed")
+		default:
+			return err
+		}
+	}
+
+	return ctx, portForwardError
+}
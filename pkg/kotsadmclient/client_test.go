@@ -0,0 +1,324 @@
+package kotsadmclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func Test_watchPortForward(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	// a download that stalls partway through
+	stalled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-stalled
+	}))
+	defer server.Close()
+	defer close(stalled)
+
+	errChan := make(chan error, 1)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	ctx, portForwardError := watchPortForward(errChan, stopCh)
+
+	request, err := http.NewRequest("GET", server.URL, nil)
+	req.NoError(err)
+	resp, err := http.DefaultClient.Do(request.WithContext(ctx))
+	req.NoError(err)
+	defer resp.Body.Close()
+
+	errChan <- errors.New("lost connection to pod")
+
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	req.Error(err)
+	err = portForwardError(err)
+	assert.Contains(t, err.Error(), "port forward failed")
+	assert.Contains(t, err.Error(), "lost connection to pod")
+}
+
+func Test_watchPortForwardStop(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	stopCh := make(chan struct{})
+	ctx, portForwardError := watchPortForward(make(chan error), stopCh)
+	close(stopCh)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch did not end when stopped")
+	}
+
+	err := errors.New("failed to write archive")
+	assert.Equal(t, err, portForwardError(err))
+}
+
+func Test_doAuthenticatedRequest(t *testing.T) {
+	tests := []struct {
+		name            string
+		refreshedSlug   string
+		expectStatus    int
+		expectRefreshes int
+	}{
+		{
+			name:            "rotated auth slug",
+			refreshedSlug:   "Kots rotated",
+			expectStatus:    http.StatusOK,
+			expectRefreshes: 1,
+		},
+		{
+			name:            "still unauthorized after refresh",
+			refreshedSlug:   "Kots revoked",
+			expectStatus:    http.StatusUnauthorized,
+			expectRefreshes: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if r.Header.Get("Authorization") != "Kots rotated" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Write([]byte("archive"))
+			}))
+			defer server.Close()
+
+			newRequest := func(authSlug string) (*http.Request, error) {
+				r, err := http.NewRequest("GET", server.URL, nil)
+				if err != nil {
+					return nil, err
+				}
+				r.Header.Set("Authorization", authSlug)
+				return r, nil
+			}
+
+			refreshes := 0
+			refreshAuthSlug := func() (string, error) {
+				refreshes++
+				return test.refreshedSlug, nil
+			}
+
+			resp, err := doAuthenticatedRequest(http.DefaultClient, newRequest, "Kots stale", refreshAuthSlug)
+			req.NoError(err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, test.expectStatus, resp.StatusCode)
+			assert.Equal(t, test.expectRefreshes, refreshes)
+			assert.Equal(t, 2, requests, "the request should only be retried once")
+		})
+	}
+}
+
+func Test_kubernetesConfigFlags(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	kubeconfig := []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: other
+  cluster:
+    server: https://other.example.com:6443
+contexts:
+- name: other
+  context:
+    cluster: other
+current-context: other
+`)
+
+	ambientFlags := genericclioptions.NewConfigFlags(false)
+	configFlags, cleanup, err := kubernetesConfigFlags(Options{KubernetesConfigFlags: ambientFlags})
+	req.NoError(err)
+	cleanup()
+	assert.Equal(t, ambientFlags, configFlags)
+
+	configFlags, cleanup, err = kubernetesConfigFlags(Options{
+		KubernetesConfigFlags: ambientFlags,
+		KubeconfigBytes:       kubeconfig,
+	})
+	req.NoError(err)
+	kubeconfigPath := *configFlags.KubeConfig
+	restConfig, err := configFlags.ToRESTConfig()
+	req.NoError(err)
+	assert.Equal(t, "https://other.example.com:6443", restConfig.Host)
+
+	cleanup()
+	_, err = os.Stat(kubeconfigPath)
+	assert.True(t, os.IsNotExist(err))
+
+	_, _, err = kubernetesConfigFlags(Options{KubeconfigBytes: []byte("not: [a kubeconfig")})
+	req.Error(err)
+}
+
+func Test_newTLSClient(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// the test certificate isn't valid for localhost, so this also checks that the hostname is ignored
+	serverURL, err := url.Parse(server.URL)
+	req.NoError(err)
+	localhostURL := fmt.Sprintf("https://localhost:%s", serverURL.Port())
+
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	otherCA := testSelfSignedCert(t)
+
+	tests := []struct {
+		name           string
+		caBundle       []byte
+		wantClientErr  bool
+		wantRequestErr bool
+	}{
+		{
+			name:     "no ca bundle",
+			caBundle: nil,
+		},
+		{
+			name:     "server ca",
+			caBundle: serverCA,
+		},
+		{
+			name:     "server ca in a bundle",
+			caBundle: append(append([]byte{}, otherCA...), serverCA...),
+		},
+		{
+			name:           "untrusted ca",
+			caBundle:       otherCA,
+			wantRequestErr: true,
+		},
+		{
+			name:          "invalid ca bundle",
+			caBundle:      []byte("not a certificate"),
+			wantClientErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			client, err := newTLSClient(test.caBundle)
+			if test.wantClientErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+
+			resp, err := client.Get(localhostURL)
+			if test.wantRequestErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			req.NoError(err)
+			req.Equal("ok", string(body))
+		})
+	}
+}
+
+func testSelfSignedCert(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+}
+
+func Test_CheckReady(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	// kotsadm is up but the api isn't serving for the first two requests
+	requests := 0
+	ready := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/healthz", r.URL.Path)
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ready.Close()
+
+	client := NewClient(testServerPort(t, ready), "auth-slug")
+	req.NoError(client.CheckReady(5 * time.Second))
+	assert.Equal(t, 3, requests)
+
+	notReady := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer notReady.Close()
+
+	client = NewClient(testServerPort(t, notReady), "auth-slug")
+	err := client.CheckReady(time.Millisecond)
+	req.Error(err)
+	assert.IsType(t, util.ActionableError{}, err)
+	assert.Contains(t, err.Error(), "kotsadm is not ready")
+}
+
+func testServerPort(t *testing.T, server *httptest.Server) int {
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(serverURL.Port())
+	require.NoError(t, err)
+	return port
+}
@@ -0,0 +1,50 @@
+package kotsadmclient
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubernetesConfigFlags returns the config flags to connect to the cluster with. An explicit
+// kubeconfig gets its own flags, so that the ambient kubeconfig and KUBECONFIG env var are ignored
+// and concurrent clients can target different clusters. Kubeconfig bytes are written to a file
+// in TempDir that is removed by the returned cleanup func.
+func kubernetesConfigFlags(opts Options) (*genericclioptions.ConfigFlags, func(), error) {
+	cleanup := func() {}
+
+	kubeconfigPath := opts.KubeconfigPath
+	if len(opts.KubeconfigBytes) > 0 {
+		if _, err := clientcmd.Load(opts.KubeconfigBytes); err != nil {
+			return nil, cleanup, errors.Wrap(err, "failed to parse kubeconfig")
+		}
+
+		kubeconfigFile, err := ioutil.TempFile(opts.TempDir, "kubeconfig")
+		if err != nil {
+			return nil, cleanup, errors.Wrap(err, "failed to create kubeconfig file")
+		}
+		cleanup = func() {
+			os.Remove(kubeconfigFile.Name())
+		}
+
+		_, err = kubeconfigFile.Write(opts.KubeconfigBytes)
+		kubeconfigFile.Close()
+		if err != nil {
+			cleanup()
+			return nil, func() {}, errors.Wrap(err, "failed to write kubeconfig file")
+		}
+
+		kubeconfigPath = kubeconfigFile.Name()
+	}
+
+	if kubeconfigPath == "" {
+		return opts.KubernetesConfigFlags, cleanup, nil
+	}
+
+	configFlags := genericclioptions.NewConfigFlags(false)
+	configFlags.KubeConfig = &kubeconfigPath
+	return configFlags, cleanup, nil
+}
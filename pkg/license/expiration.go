@@ -0,0 +1,38 @@
+package license
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+)
+
+// GetExpiration returns the time in the expires_at entitlement of the license, or nil if the license
+// doesn't expire
+func GetExpiration(license *kotsv1beta1.License) (*time.Time, error) {
+	val, found := license.Spec.Entitlements["expires_at"]
+	if !found {
+		return nil, nil
+	}
+	if val.ValueType != "" && val.ValueType != "String" {
+		return nil, errors.Errorf("expires_at must be type String: %s", val.ValueType)
+	}
+	if val.Value.StrVal == "" {
+		return nil, nil
+	}
+
+	expiration, err := time.Parse(time.RFC3339, val.Value.StrVal)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse expiration time")
+	}
+	return &expiration, nil
+}
+
+// IsExpired returns true if the license expired before now
+func IsExpired(license *kotsv1beta1.License, now time.Time) (bool, error) {
+	expiration, err := GetExpiration(license)
+	if err != nil {
+		return false, err
+	}
+	return expiration != nil && expiration.Before(now), nil
+}
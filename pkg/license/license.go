@@ -13,13 +13,22 @@ import (
 )
 
 func GetLatestLicense(license *kotsv1beta1.License) (*kotsv1beta1.License, error) {
+	return GetLatestLicenseWithUserAgent(license, "")
+}
+
+// GetLatestLicenseWithUserAgent is GetLatestLicense with the user agent of the request, which defaults
+// to the kots user agent when it's empty
+func GetLatestLicenseWithUserAgent(license *kotsv1beta1.License, userAgent string) (*kotsv1beta1.License, error) {
 	url := fmt.Sprintf("%s/license/%s", license.Spec.Endpoint, license.Spec.AppSlug)
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to call newrequest")
 	}
-	req.Header.Add("User-Agent", fmt.Sprintf("KOTS/%s", version.Version()))
+	if userAgent == "" {
+		userAgent = version.UserAgent()
+	}
+	req.Header.Set("User-Agent", userAgent)
 	req.Header.Set("Authorization", fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", license.Spec.LicenseID, license.Spec.LicenseID)))))
 
 	resp, err := http.DefaultClient.Do(req)
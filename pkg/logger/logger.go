@@ -10,6 +10,20 @@ import (
 	"github.com/tj/go-spin"
 )
 
+// Interface is the part of Logger used by the download and fetch paths, so that embedding
+// programs can supply their own logger
+type Interface interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	ActionWithoutSpinner(msg string, args ...interface{})
+	ActionWithSpinner(msg string, args ...interface{})
+	FinishSpinner()
+	FinishSpinnerWithError()
+	Error(err error)
+}
+
+var _ Interface = &Logger{}
+
 type Logger struct {
 	spinnerStopCh chan bool
 	spinnerMsg    string
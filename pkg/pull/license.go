@@ -0,0 +1,60 @@
+package pull
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// LicenseFileStdin is the license file name that reads the license from stdin
+const LicenseFileStdin = "-"
+
+// licenseStdin is where the license is read from when the license file is LicenseFileStdin
+var licenseStdin io.Reader = os.Stdin
+
+// readLicense returns the license from licenseData or licenseFile, or nil if neither is set. Setting
+// both is an error, since it's ambiguous which license should be used.
+func readLicense(licenseFile string, licenseData []byte) (*kotsv1beta1.License, error) {
+	if len(licenseData) > 0 && licenseFile != "" {
+		return nil, util.ActionableError{Message: "only one of a license file or license data can be used"}
+	}
+
+	contents := licenseData
+	switch {
+	case len(licenseData) > 0:
+	case licenseFile == LicenseFileStdin:
+		c, err := ioutil.ReadAll(licenseStdin)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read license from stdin")
+		}
+		if len(c) == 0 {
+			return nil, util.ActionableError{Message: "no license was read from stdin"}
+		}
+		contents = c
+	case licenseFile != "":
+		c, err := ioutil.ReadFile(licenseFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read license file")
+		}
+		contents = c
+	default:
+		return nil, nil
+	}
+
+	license, err := ParseLicense(contents)
+	if err != nil {
+		if errors.Cause(err) == ErrSignatureInvalid {
+			return nil, ErrSignatureInvalid
+		}
+		if errors.Cause(err) == ErrSignatureMissing {
+			return nil, ErrSignatureMissing
+		}
+		return nil, errors.Wrap(err, "failed to parse license")
+	}
+
+	return license, nil
+}
@@ -14,6 +14,9 @@ type GetUpdatesOptions struct {
 	CurrentCursor  string
 	CurrentChannel string
 	Silent         bool
+	// LicenseData is the license itself. Only one of LicenseFile and LicenseData can be set, and a
+	// LicenseFile of "-" reads the license from stdin.
+	LicenseData []byte
 }
 
 // GetUpdates will retrieve all later versions of the application specified in upstreamURI
@@ -33,20 +36,14 @@ func GetUpdates(upstreamURI string, getUpdatesOptions GetUpdatesOptions) ([]upst
 	fetchOptions.CurrentCursor = getUpdatesOptions.CurrentCursor
 	fetchOptions.CurrentChannel = getUpdatesOptions.CurrentChannel
 
-	if getUpdatesOptions.LicenseFile != "" {
-		license, err := ParseLicenseFromFile(getUpdatesOptions.LicenseFile)
-		if err != nil {
-			if errors.Cause(err) == ErrSignatureInvalid {
-				return nil, ErrSignatureInvalid
-			}
-			if errors.Cause(err) == ErrSignatureMissing {
-				return nil, ErrSignatureMissing
-			}
-			return nil, errors.Wrap(err, "failed to parse license from file")
+	license, err := readLicense(getUpdatesOptions.LicenseFile, getUpdatesOptions.LicenseData)
+	if err != nil {
+		if errors.Cause(err) == ErrSignatureInvalid || errors.Cause(err) == ErrSignatureMissing {
+			return nil, err
 		}
-
-		fetchOptions.License = license
+		return nil, errors.Wrap(err, "failed to read license")
 	}
+	fetchOptions.License = license
 
 	log.ActionWithSpinner("Listing releases")
 	v, err := upstream.GetUpdatesUpstream(upstreamURI, &fetchOptions)
@@ -1,6 +1,7 @@
 package pull
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -15,6 +16,7 @@ import (
 	"github.com/replicatedhq/kots/pkg/docker/registry"
 	"github.com/replicatedhq/kots/pkg/downstream"
 	"github.com/replicatedhq/kots/pkg/k8sdoc"
+	kotslicense "github.com/replicatedhq/kots/pkg/license"
 	"github.com/replicatedhq/kots/pkg/logger"
 	"github.com/replicatedhq/kots/pkg/midstream"
 	"github.com/replicatedhq/kots/pkg/upstream"
@@ -45,6 +47,9 @@ type PullOptions struct {
 	RewriteImageOptions RewriteImageOptions
 	HelmOptions         []string
 	ReportWriter        io.Writer
+	// LicenseData is the license itself, for when it isn't in a file. Only one of LicenseFile and
+	// LicenseData can be set, and a LicenseFile of "-" reads the license from stdin.
+	LicenseData []byte
 }
 
 type RewriteImageOptions struct {
@@ -102,6 +107,7 @@ func Pull(upstreamURI string, pullOptions PullOptions) (string, error) {
 	fetchOptions.UseAppDir = pullOptions.CreateAppDir
 	fetchOptions.LocalPath = pullOptions.LocalPath
 	fetchOptions.CurrentCursor = pullOptions.UpdateCursor
+	fetchOptions.Log = log
 
 	var installation *kotsv1beta1.Installation
 
@@ -110,18 +116,14 @@ func Pull(upstreamURI string, pullOptions PullOptions) (string, error) {
 		return "", errors.Wrap(err, "failed to find config files in local path")
 	}
 
-	if pullOptions.LicenseFile != "" {
-		license, err := ParseLicenseFromFile(pullOptions.LicenseFile)
-		if err != nil {
-			if errors.Cause(err) == ErrSignatureInvalid {
-				return "", ErrSignatureInvalid
-			}
-			if errors.Cause(err) == ErrSignatureMissing {
-				return "", ErrSignatureMissing
-			}
-			return "", errors.Wrap(err, "failed to parse license from file")
+	license, err := readLicense(pullOptions.LicenseFile, pullOptions.LicenseData)
+	if err != nil {
+		if errors.Cause(err) == ErrSignatureInvalid || errors.Cause(err) == ErrSignatureMissing {
+			return "", err
 		}
-
+		return "", errors.Wrap(err, "failed to read license")
+	}
+	if license != nil {
 		fetchOptions.License = license
 	} else {
 		fetchOptions.License = localLicense
@@ -157,7 +159,7 @@ func Pull(upstreamURI string, pullOptions PullOptions) (string, error) {
 	}
 
 	if pullOptions.AirgapRoot != "" {
-		if expired, err := licenseIsExpired(fetchOptions.License); err != nil {
+		if expired, err := kotslicense.IsExpired(fetchOptions.License, time.Now()); err != nil {
 			return "", errors.Wrap(err, "failed to check license expiration")
 		} else if expired {
 			return "", util.ActionableError{Message: "License is expired"}
@@ -462,18 +464,44 @@ func ParseLicenseFromFile(filename string) (*kotsv1beta1.License, error) {
 		return nil, errors.Wrap(err, "failed to read license file")
 	}
 
+	return ParseLicense(contents)
+}
+
+// ParseLicense decodes a license and verifies its signature. The license can be one of several documents
+// in a multi-document yaml file, and the other documents are ignored.
+func ParseLicense(contents []byte) (*kotsv1beta1.License, error) {
 	decode := scheme.Codecs.UniversalDeserializer().Decode
-	decoded, gvk, err := decode(contents, nil, nil)
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to decode license file")
+
+	licenses := []*kotsv1beta1.License{}
+	var decodeErr error
+	docs := bytes.Split(contents, []byte("\n---\n"))
+	for _, doc := range docs {
+		if len(bytes.TrimSpace(bytes.TrimPrefix(doc, []byte("---\n")))) == 0 {
+			continue
+		}
+
+		decoded, gvk, err := decode(doc, nil, nil)
+		if err != nil {
+			// other kinds can be bundled with the license
+			decodeErr = err
+			continue
+		}
+		if gvk.Group == "kots.io" && gvk.Version == "v1beta1" && gvk.Kind == "License" {
+			licenses = append(licenses, decoded.(*kotsv1beta1.License))
+		}
 	}
 
-	if gvk.Group != "kots.io" || gvk.Version != "v1beta1" || gvk.Kind != "License" {
+	if len(licenses) > 1 {
+		return nil, util.ActionableError{Message: fmt.Sprintf("found %d licenses in the license file, only one can be used", len(licenses))}
+	}
+	if len(licenses) == 0 {
+		if decodeErr != nil && len(docs) == 1 {
+			return nil, errors.Wrap(decodeErr, "unable to decode license file")
+		}
 		return nil, errors.New("not an application license")
 	}
 
-	license := decoded.(*kotsv1beta1.License)
-	verifiedLicense, err := VerifySignature(license)
+	verifiedLicense, err := VerifySignature(licenses[0])
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to verify signature")
 	}
@@ -597,25 +625,6 @@ func publicKeysMatch(license *kotsv1beta1.License, airgap *kotsv1beta1.Airgap) e
 	return nil
 }
 
-func licenseIsExpired(license *kotsv1beta1.License) (bool, error) {
-	val, found := license.Spec.Entitlements["expires_at"]
-	if !found {
-		return false, nil
-	}
-	if val.ValueType != "" && val.ValueType != "String" {
-		return false, errors.Errorf("expires_at must be type String: %s", val.ValueType)
-	}
-	if val.Value.StrVal == "" {
-		return false, nil
-	}
-
-	partsed, err := time.Parse(time.RFC3339, val.Value.StrVal)
-	if err != nil {
-		return false, errors.Wrap(err, "failed to parse expiration time")
-	}
-	return partsed.Before(time.Now()), nil
-}
-
 func findConfig(localPath string) (*kotsv1beta1.Config, *kotsv1beta1.ConfigValues, *kotsv1beta1.License, *kotsv1beta1.Installation, error) {
 	if localPath == "" {
 		return nil, nil, nil, nil, nil
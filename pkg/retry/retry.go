@@ -0,0 +1,138 @@
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+const (
+	defaultInitialInterval = 500 * time.Millisecond
+	defaultMaxInterval     = 10 * time.Second
+)
+
+// Options configure Do. Retries back off exponentially from InitialInterval up to MaxInterval, and
+// the delay before each one is chosen at random between zero and that limit, so that many clients
+// failing at once don't retry together.
+type Options struct {
+	// MaxAttempts is the number of attempts, including the first. Zero or one doesn't retry.
+	MaxAttempts int
+	// InitialInterval defaults to 500ms
+	InitialInterval time.Duration
+	// MaxInterval defaults to 10s
+	MaxInterval time.Duration
+	// MaxElapsedTime stops retrying once the next attempt would start after it, counted from the
+	// first attempt. Zero has no limit other than MaxAttempts.
+	MaxElapsedTime time.Duration
+}
+
+// these are replaced in tests
+var (
+	sleep  = time.Sleep
+	now    = time.Now
+	random = rand.Int63n
+)
+
+// Do calls fn until it succeeds, it returns an error that isn't retryable, or the attempts or time
+// in opts are used up. The last error is returned.
+func Do(opts Options, retryable func(error) bool, fn func() error) error {
+	start := now()
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if attempt >= opts.MaxAttempts || !retryable(err) {
+			return err
+		}
+
+		delay := opts.delay(attempt)
+		if opts.MaxElapsedTime > 0 && now().Add(delay).Sub(start) > opts.MaxElapsedTime {
+			return err
+		}
+		sleep(delay)
+	}
+}
+
+// delay returns the jittered delay before the retry that follows attempt
+func (o Options) delay(attempt int) time.Duration {
+	limit := o.maxDelay(attempt)
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(random(int64(limit)))
+}
+
+// maxDelay is the exponential backoff limit after attempt
+func (o Options) maxDelay(attempt int) time.Duration {
+	initial := o.InitialInterval
+	if initial <= 0 {
+		initial = defaultInitialInterval
+	}
+	max := o.MaxInterval
+	if max <= 0 {
+		max = defaultMaxInterval
+	}
+
+	limit := initial
+	for i := 1; i < attempt; i++ {
+		limit *= 2
+		if limit >= max || limit <= 0 {
+			return max
+		}
+	}
+	if limit > max {
+		return max
+	}
+	return limit
+}
+
+// IsTemporary returns true for http status errors that may succeed when they're retried, such as 502
+// and 503, and for network errors that timed out, are temporary or reset the connection. Canceled
+// requests and dns lookups of hosts that don't exist are not retried.
+func IsTemporary(err error) bool {
+	cause := errors.Cause(err)
+
+	if statusErr, ok := cause.(util.HTTPStatusError); ok {
+		return statusErr.StatusCode >= http.StatusInternalServerError || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	if cause == context.Canceled {
+		return false
+	}
+	if urlErr, ok := cause.(*url.Error); ok && urlErr.Err == context.Canceled {
+		return false
+	}
+	if isConnectionReset(cause) {
+		return true
+	}
+	if netErr, ok := cause.(net.Error); ok {
+		return netErr.Timeout() || netErr.Temporary()
+	}
+	return false
+}
+
+// isConnectionReset returns true if err is a connection reset by the peer
+func isConnectionReset(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		default:
+			return err == syscall.ECONNRESET
+		}
+	}
+	return false
+}
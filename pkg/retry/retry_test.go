@@ -0,0 +1,134 @@
+package retry
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_delayIsBoundedAndJittered(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	opts := Options{InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second}
+
+	assert.Equal(t, 100*time.Millisecond, opts.maxDelay(1))
+	assert.Equal(t, 200*time.Millisecond, opts.maxDelay(2))
+	assert.Equal(t, 800*time.Millisecond, opts.maxDelay(4))
+	assert.Equal(t, time.Second, opts.maxDelay(5))
+	assert.Equal(t, time.Second, opts.maxDelay(100))
+
+	for attempt := 1; attempt <= 8; attempt++ {
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 50; i++ {
+			delay := opts.delay(attempt)
+			assert.True(t, delay >= 0 && delay < opts.maxDelay(attempt), "delay %s for attempt %d", delay, attempt)
+			seen[delay] = true
+		}
+		assert.True(t, len(seen) > 1, "delays for attempt %d are not jittered", attempt)
+	}
+
+	assert.Equal(t, defaultMaxInterval, Options{}.maxDelay(10))
+}
+
+func Test_Do(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	defer func() {
+		sleep = time.Sleep
+		now = time.Now
+	}()
+
+	// a fake clock that only moves when sleeping
+	clock := time.Now()
+	now = func() time.Time { return clock }
+	slept := []time.Duration{}
+	sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		clock = clock.Add(d)
+	}
+
+	temporary := errors.New("temporary")
+	retryable := func(err error) bool { return err == temporary }
+
+	calls := 0
+	err := Do(Options{MaxAttempts: 5}, retryable, func() error {
+		calls++
+		if calls < 3 {
+			return temporary
+		}
+		return nil
+	})
+	req.NoError(err)
+	assert.Equal(t, 3, calls)
+	assert.Len(t, slept, 2)
+
+	// errors that aren't retryable are returned immediately
+	calls = 0
+	permanent := errors.New("permanent")
+	err = Do(Options{MaxAttempts: 5}, retryable, func() error {
+		calls++
+		return permanent
+	})
+	assert.Equal(t, permanent, err)
+	assert.Equal(t, 1, calls)
+
+	// no retries by default
+	calls = 0
+	err = Do(Options{}, retryable, func() error {
+		calls++
+		return temporary
+	})
+	assert.Equal(t, temporary, err)
+	assert.Equal(t, 1, calls)
+
+	// the total time is capped, even with attempts left
+	calls = 0
+	slept = []time.Duration{}
+	opts := Options{MaxAttempts: 1000, InitialInterval: time.Second, MaxInterval: time.Second, MaxElapsedTime: 5 * time.Second}
+	start := clock
+	err = Do(opts, retryable, func() error {
+		calls++
+		return temporary
+	})
+	assert.Equal(t, temporary, err)
+	assert.True(t, calls > 1)
+	assert.True(t, clock.Sub(start) <= opts.MaxElapsedTime, "waited %s", clock.Sub(start))
+}
+
+func Test_IsTemporary(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	assert.True(t, IsTemporary(errors.Wrap(util.HTTPStatusError{StatusCode: http.StatusServiceUnavailable}, "failed")))
+	assert.True(t, IsTemporary(util.HTTPStatusError{StatusCode: http.StatusTooManyRequests}))
+	assert.False(t, IsTemporary(util.HTTPStatusError{StatusCode: http.StatusNotFound}))
+	assert.False(t, IsTemporary(util.ActionableError{Message: "no"}))
+
+	timeoutErr := &url.Error{Op: "Get", URL: "http://example.com", Err: &net.DNSError{Err: "timeout", Name: "example.com", IsTimeout: true}}
+	assert.True(t, IsTemporary(errors.Wrap(timeoutErr, "failed")))
+
+	resetErr := &url.Error{Op: "Get", URL: "http://example.com", Err: &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}
+	assert.True(t, IsTemporary(errors.Wrap(resetErr, "failed")))
+
+	// permanent failures aren't retried
+	assert.False(t, IsTemporary(errors.Wrap(context.Canceled, "failed")))
+	assert.False(t, IsTemporary(&url.Error{Op: "Get", URL: "http://example.com", Err: context.Canceled}))
+	assert.False(t, IsTemporary(&net.DNSError{Err: "no such host", Name: "missing.example.com"}))
+
+	_, err := http.Get("http://127.0.0.1:1")
+	assert.False(t, IsTemporary(errors.Wrap(err, "failed")))
+}
@@ -93,7 +93,7 @@ func (b *Builder) newConfigContext(configGroups []kotsv1beta1.ConfigGroup, exist
 			if configItem.Type == "password" {
 				existingVal, ok := existingValues[configItem.Name]
 				if ok && existingVal.HasValue() {
-					val, err := decrypt(existingVal.ValueStr(), cipher)
+					val, err := cipher.DecryptBase64(existingVal.ValueStr())
 					if err == nil {
 						existingVal.Value = val
 						existingValues[configItem.Name] = existingVal
@@ -338,21 +338,3 @@ func (ctx ConfigCtx) getConfigOptionValue(itemName string) (string, error) {
 
 	return val.DefaultStr(), nil
 }
-
-func decrypt(input string, cipher *crypto.AESCipher) (string, error) {
-	if cipher == nil {
-		return "", errors.New("cipher not defined")
-	}
-
-	decoded, err := base64.StdEncoding.DecodeString(input)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to base64 decode")
-	}
-
-	decrypted, err := cipher.Decrypt(decoded)
-	if err != nil {
-		return "", errors.Wrap(err, "failed to decrypt")
-	}
-
-	return string(decrypted), nil
-}
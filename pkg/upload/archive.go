@@ -12,7 +12,10 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 )
 
-func createUploadableArchive(rootPath string) (string, error) {
+// CreateUploadableArchive writes the upstream, base and overlays of the app at rootPath to a tar gz
+// under a top level folder, which is the format that kotsadm expects, and returns the path of the
+// archive. The caller is responsible for deleting it.
+func CreateUploadableArchive(rootPath string) (string, error) {
 	if strings.HasSuffix(rootPath, string(os.PathSeparator)) {
 		rootPath = strings.TrimSuffix(rootPath, string(os.PathSeparator))
 	}
@@ -42,30 +45,32 @@ func createUploadableArchive(rootPath string) (string, error) {
 	return path.Join(tempDir, "kots-uploadable-archive.tar.gz"), nil
 }
 
-func findUpdateCursor(rootPath string) (string, error) {
+// findInstallation returns the spec of the installation of the app at rootPath, or an empty spec if
+// the app doesn't have one
+func findInstallation(rootPath string) (*kotsv1beta1.InstallationSpec, error) {
 	installationFilePath := path.Join(rootPath, "upstream", "userdata", "installation.yaml")
 	_, err := os.Stat(installationFilePath)
 	if os.IsNotExist(err) {
-		return "", nil
+		return &kotsv1beta1.InstallationSpec{}, nil
 	}
 	if err != nil {
-		return "", errors.Wrap(err, "failed to open file")
+		return nil, errors.Wrap(err, "failed to open file")
 	}
 
 	installationData, err := ioutil.ReadFile(installationFilePath)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to read update installation file")
+		return nil, errors.Wrap(err, "failed to read update installation file")
 	}
 
 	decode := scheme.Codecs.UniversalDeserializer().Decode
 	obj, _, err := decode([]byte(installationData), nil, nil)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to decode installation data")
+		return nil, errors.Wrap(err, "failed to decode installation data")
 	}
 
 	installation := obj.(*kotsv1beta1.Installation)
 
-	return installation.Spec.UpdateCursor, nil
+	return &installation.Spec, nil
 }
 
 func findLicense(rootPath string) (*string, error) {
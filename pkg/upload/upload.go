@@ -3,7 +3,6 @@ package upload
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
@@ -16,8 +15,8 @@ import (
 	"github.com/manifoldco/promptui"
 	"github.com/pkg/errors"
 	kotsscheme "github.com/replicatedhq/kots/kotskinds/client/kotsclientset/scheme"
-	"github.com/replicatedhq/kots/pkg/auth"
 	"github.com/replicatedhq/kots/pkg/docker/registry"
+	"github.com/replicatedhq/kots/pkg/kotsadmclient"
 	"github.com/replicatedhq/kots/pkg/logger"
 	"github.com/replicatedhq/kots/pkg/util"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
@@ -31,7 +30,6 @@ type UploadOptions struct {
 	ExistingAppSlug       string
 	NewAppName            string
 	RegistryOptions       registry.RegistryOptions
-	Endpoint              string
 	Silent                bool
 	updateCursor          string
 	license               *string
@@ -45,22 +43,11 @@ func init() {
 // Upload will upload the application version at path
 // using the options in uploadOptions
 func Upload(path string, uploadOptions UploadOptions) error {
-	license, err := findLicense(path)
-	if err != nil {
-		return errors.Wrap(err, "failed to find license")
+	if err := ReadUploadMetadata(path, &uploadOptions); err != nil {
+		return err
 	}
-	uploadOptions.license = license
 
-	updateCursor, err := findUpdateCursor(path)
-	if err != nil {
-		return errors.Wrap(err, "failed to find update cursor")
-	}
-	if updateCursor == "" {
-		return errors.New("no update cursor found. this is not yet supported")
-	}
-	uploadOptions.updateCursor = updateCursor
-
-	archiveFilename, err := createUploadableArchive(path)
+	archiveFilename, err := CreateUploadableArchive(path)
 	if err != nil {
 		return errors.Wrap(err, "failed to create uploadable archive")
 	}
@@ -108,16 +95,38 @@ func Upload(path string, uploadOptions UploadOptions) error {
 
 	log.ActionWithSpinner("Uploading local application to Admin Console")
 
-	// upload using http to the pod directly
-	req, err := createUploadRequest(archiveFilename, uploadOptions, fmt.Sprintf("%s/api/v1/upload", uploadOptions.Endpoint))
+	conn, err := kotsadmclient.Connect(kotsadmclient.Options{
+		Namespace:             uploadOptions.Namespace,
+		KubernetesConfigFlags: uploadOptions.KubernetesConfigFlags,
+		Log:                   log,
+	})
+	if err != nil {
+		log.FinishSpinnerWithError()
+		return errors.Wrap(err, "failed to connect to kotsadm")
+	}
+	defer conn.Close()
+
+	requestBody, err := NewUploadRequestBody(archiveFilename, uploadOptions)
 	if err != nil {
 		log.FinishSpinnerWithError()
 		return errors.Wrap(err, "failed to create upload request")
 	}
-	resp, err := http.DefaultClient.Do(req)
+
+	// upload using http to the pod directly
+	uploadURL := conn.URL("/api/v1/upload")
+	resp, err := conn.Do(func(authSlug string) (*http.Request, error) {
+		req, err := http.NewRequest(requestBody.Method, uploadURL, bytes.NewReader(requestBody.Body))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create new request")
+		}
+		req = req.WithContext(conn.Context())
+		req.Header.Set("Authorization", authSlug)
+		req.Header.Set("Content-Type", requestBody.ContentType)
+		return req, nil
+	})
 	if err != nil {
 		log.FinishSpinnerWithError()
-		return errors.Wrap(err, "failed to execute request")
+		return conn.PortForwardError(errors.Wrap(err, "failed to execute request"))
 	}
 	defer resp.Body.Close()
 
@@ -145,7 +154,38 @@ func Upload(path string, uploadOptions UploadOptions) error {
 	return nil
 }
 
-func createUploadRequest(path string, uploadOptions UploadOptions, uri string) (*http.Request, error) {
+// ReadUploadMetadata sets the license, update cursor and version label in uploadOptions from the
+// userdata of the app at path
+func ReadUploadMetadata(path string, uploadOptions *UploadOptions) error {
+	license, err := findLicense(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to find license")
+	}
+	uploadOptions.license = license
+
+	installation, err := findInstallation(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to find update cursor")
+	}
+	if installation.UpdateCursor == "" {
+		return errors.New("no update cursor found. this is not yet supported")
+	}
+	uploadOptions.updateCursor = installation.UpdateCursor
+	uploadOptions.versionLabel = installation.VersionLabel
+
+	return nil
+}
+
+// UploadRequestBody is the multipart body of a request to the kotsadm upload endpoint
+type UploadRequestBody struct {
+	// Method is PUT for an existing app and POST for a new app
+	Method      string
+	ContentType string
+	Body        []byte
+}
+
+// NewUploadRequestBody returns the body to upload the archive at path with, and the metadata in uploadOptions
+func NewUploadRequestBody(path string, uploadOptions UploadOptions) (*UploadRequestBody, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open file")
@@ -219,19 +259,11 @@ func createUploadRequest(path string, uploadOptions UploadOptions, uri string) (
 		return nil, errors.Wrap(err, "failed to close writer")
 	}
 
-	authSlug, err := auth.GetOrCreateAuthSlug(uploadOptions.KubernetesConfigFlags, uploadOptions.Namespace)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to get auth slug")
-	}
-
-	req, err := http.NewRequest(method, uri, body)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create new request")
-	}
-
-	req.Header.Set("Authorization", authSlug)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	return req, nil
+	return &UploadRequestBody{
+		Method:      method,
+		ContentType: writer.FormDataContentType(),
+		Body:        body.Bytes(),
+	}, nil
 }
 
 func relentlesslyPromptForAppName(defaultAppName string) (string, error) {
@@ -3,13 +3,11 @@ package upload
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/pkg/errors"
-	"github.com/replicatedhq/kots/pkg/auth"
-	"github.com/replicatedhq/kots/pkg/k8sutil"
+	"github.com/replicatedhq/kots/pkg/kotsadmclient"
 	"github.com/replicatedhq/kots/pkg/logger"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
@@ -41,47 +39,41 @@ func UploadLicense(path string, uploadLicenseOptions UploadLicenseOptions) error
 	log := logger.NewLogger()
 	log.ActionWithSpinner("Uploading license to Admin Console")
 
-	clientset, err := k8sutil.GetClientset(uploadLicenseOptions.KubernetesConfigFlags)
+	conn, err := kotsadmclient.Connect(kotsadmclient.Options{
+		Namespace:             uploadLicenseOptions.Namespace,
+		KubernetesConfigFlags: uploadLicenseOptions.KubernetesConfigFlags,
+		Log:                   log,
+	})
 	if err != nil {
 		log.FinishSpinnerWithError()
-		return errors.Wrap(err, "failed to get clisnetset")
+		return errors.Wrap(err, "failed to connect to kotsadm")
 	}
+	defer conn.Close()
 
-	podName, err := k8sutil.FindKotsadm(clientset, uploadLicenseOptions.Namespace)
-	if err != nil {
-		log.FinishSpinnerWithError()
-		return errors.Wrap(err, "failed to find kotsadm pod")
-	}
-
-	stopCh := make(chan struct{})
-	defer close(stopCh)
-
-	localPort, errChan, err := k8sutil.PortForward(uploadLicenseOptions.KubernetesConfigFlags, 0, 3000, uploadLicenseOptions.Namespace, podName, false, stopCh, log)
+	body, err := json.Marshal(map[string]string{
+		"name":    uploadLicenseOptions.NewAppName,
+		"license": license,
+	})
 	if err != nil {
 		log.FinishSpinnerWithError()
-		return errors.Wrap(err, "failed to start port forwarding")
+		return errors.Wrap(err, "failed to marshal json")
 	}
 
-	go func() {
-		select {
-		case err := <-errChan:
-			if err != nil {
-				log.Error(err)
-			}
-		case <-stopCh:
-		}
-	}()
-
 	// upload using http to the pod directly
-	req, err := createUploadLicenseRequest(license, uploadLicenseOptions, fmt.Sprintf("http://localhost:%d/api/v1/kots/license", localPort))
-	if err != nil {
-		log.FinishSpinnerWithError()
-		return errors.Wrap(err, "failed to create upload request")
-	}
-	resp, err := http.DefaultClient.Do(req)
+	url := conn.URL("/api/v1/kots/license")
+	resp, err := conn.Do(func(authSlug string) (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create new request")
+		}
+		req = req.WithContext(conn.Context())
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", authSlug)
+		return req, nil
+	})
 	if err != nil {
 		log.FinishSpinnerWithError()
-		return errors.Wrap(err, "failed to execute request")
+		return conn.PortForwardError(errors.Wrap(err, "failed to execute request"))
 	}
 	defer resp.Body.Close()
 
@@ -108,29 +100,3 @@ func UploadLicense(path string, uploadLicenseOptions UploadLicenseOptions) error
 
 	return nil
 }
-
-func createUploadLicenseRequest(license string, uploadLicenseOptions UploadLicenseOptions, uri string) (*http.Request, error) {
-	body := map[string]string{
-		"name":    uploadLicenseOptions.NewAppName,
-		"license": license,
-	}
-
-	b, err := json.Marshal(body)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal json")
-	}
-
-	authSlug, err := auth.GetOrCreateAuthSlug(uploadLicenseOptions.KubernetesConfigFlags, uploadLicenseOptions.Namespace)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to get kotsadm auth slug")
-	}
-
-	req, err := http.NewRequest("POST", uri, bytes.NewBuffer(b))
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create new request")
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", authSlug)
-	return req, nil
-}
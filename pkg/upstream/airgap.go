@@ -0,0 +1,161 @@
+package upstream
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+const AirgapUpstreamScheme = "airgap"
+
+// airgapAppArchiveName is the name of the archive in an airgap bundle that holds the app manifests
+const airgapAppArchiveName = "app.tar.gz"
+
+func airgapDownloader(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	bundlePath := strings.TrimPrefix(upstreamURI, AirgapUpstreamScheme+"://")
+	if bundlePath == "" {
+		return nil, errors.New("airgap uri does not include a bundle path")
+	}
+
+	return downloadAirgap(bundlePath)
+}
+
+// downloadAirgap reads the app manifests from an airgap bundle. The bundle is a tar (or tar.gz) that
+// contains the app archive and the airgap metadata alongside the images, which are skipped.
+func downloadAirgap(bundlePath string) (*types.Upstream, error) {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open airgap bundle")
+	}
+	defer f.Close()
+
+	appFiles, airgap, err := readAirgapBundle(f)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read airgap bundle")
+	}
+
+	if appFiles == nil {
+		return nil, errors.Errorf("airgap bundle %s does not contain app manifests (%s)", bundlePath, airgapAppArchiveName)
+	}
+	if len(appFiles) == 0 {
+		return nil, errors.Errorf("app manifests in airgap bundle %s are empty", bundlePath)
+	}
+
+	upstream := &types.Upstream{
+		URI:   AirgapUpstreamScheme + "://" + bundlePath,
+		Name:  strings.TrimSuffix(filepath.Base(bundlePath), filepath.Ext(bundlePath)),
+		Type:  "airgap",
+		Files: appFiles,
+	}
+
+	if airgap != nil {
+		upstream.VersionLabel = airgap.Spec.VersionLabel
+		upstream.ReleaseNotes = airgap.Spec.ReleaseNotes
+		upstream.UpdateCursor = airgap.Spec.UpdateCursor
+		upstream.ChannelName = airgap.Spec.ChannelName
+
+		appSlug := airgap.Spec.AppSlug
+		if appSlug == "" {
+			appSlug = airgap.Name
+		}
+		if appSlug != "" {
+			upstream.Name = appSlug
+		}
+
+		upstream.Resolved = types.ResolvedUpstream{
+			AppSlug:      appSlug,
+			VersionLabel: airgap.Spec.VersionLabel,
+			ChannelName:  airgap.Spec.ChannelName,
+			Cursor:       airgap.Spec.UpdateCursor,
+		}
+	}
+
+	return upstream, nil
+}
+
+// readAirgapBundle returns the files in the app archive and the airgap metadata, if found.
+// A nil slice of files means that the bundle has no app archive.
+func readAirgapBundle(r io.Reader) ([]types.UpstreamFile, *kotsv1beta1.Airgap, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, nil, errors.Wrap(err, "failed to read bundle")
+	}
+
+	var bundleReader io.Reader = br
+	if isGzip(header) {
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to create gzip reader")
+		}
+		defer gzr.Close()
+		bundleReader = gzr
+	}
+
+	var appFiles []types.UpstreamFile
+	var airgap *kotsv1beta1.Airgap
+
+	tarReader := tar.NewReader(bundleReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to advance in bundle")
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name, err := util.SanitizeArchivePath(header.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// only the top level of the bundle is of interest, images are in subdirectories
+		if strings.Contains(name, "/") {
+			continue
+		}
+
+		switch {
+		case name == airgapAppArchiveName:
+			files, err := readFilesFromReader(tarReader)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "failed to read app archive")
+			}
+			appFiles = files
+
+		case path.Ext(name) == ".yaml" || path.Ext(name) == ".yml":
+			content, err := ioutil.ReadAll(tarReader)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "failed to read %s", name)
+			}
+
+			decode := scheme.Codecs.UniversalDeserializer().Decode
+			obj, gvk, err := decode(content, nil, nil)
+			if err != nil {
+				continue
+			}
+
+			if gvk.Group == "kots.io" && gvk.Version == "v1beta1" && gvk.Kind == "Airgap" {
+				airgap = obj.(*kotsv1beta1.Airgap)
+			}
+		}
+	}
+
+	return appFiles, airgap, nil
+}
@@ -0,0 +1,102 @@
+package upstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_downloadAirgap(t *testing.T) {
+	airgapYAML := []byte(`apiVersion: kots.io/v1beta1
+kind: Airgap
+metadata:
+  name: my-app
+spec:
+  appSlug: my-app
+  channelName: Stable
+  updateCursor: "12"
+  versionLabel: 1.0.1
+`)
+	manifest := []byte("apiVersion: v1\nkind: ConfigMap\n")
+
+	var appTarGz bytes.Buffer
+	gzw := gzip.NewWriter(&appTarGz)
+	tw := tar.NewWriter(gzw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "configmap.yaml", Mode: 0644, Size: int64(len(manifest)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write(manifest)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+
+	tests := []struct {
+		name        string
+		files       map[string][]byte
+		expectError string
+	}{
+		{
+			name: "complete bundle",
+			files: map[string][]byte{
+				"airgap.yaml":           airgapYAML,
+				"app.tar.gz":            appTarGz.Bytes(),
+				"images/docker-archive": []byte("not a manifest"),
+			},
+		},
+		{
+			name: "missing app manifests",
+			files: map[string][]byte{
+				"airgap.yaml": airgapYAML,
+			},
+			expectError: "does not contain app manifests",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			tmpDir, err := ioutil.TempDir("", "kots")
+			req.NoError(err)
+			defer os.RemoveAll(tmpDir)
+
+			var bundle bytes.Buffer
+			tw := tar.NewWriter(&bundle)
+			for name, content := range test.files {
+				req.NoError(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+				_, err := tw.Write(content)
+				req.NoError(err)
+			}
+			req.NoError(tw.Close())
+
+			bundlePath := filepath.Join(tmpDir, "my-app.airgap")
+			req.NoError(ioutil.WriteFile(bundlePath, bundle.Bytes(), 0644))
+
+			u, err := FetchUpstream("airgap://"+bundlePath, &FetchOptions{})
+			if test.expectError != "" {
+				req.Error(err)
+				assert.Contains(t, err.Error(), test.expectError)
+				return
+			}
+			req.NoError(err)
+
+			assert.Equal(t, "airgap", u.Type)
+			assert.Equal(t, "my-app", u.Name)
+			assert.Equal(t, "1.0.1", u.VersionLabel)
+			assert.Equal(t, "my-app", u.Resolved.AppSlug)
+			assert.Equal(t, "1.0.1", u.Resolved.VersionLabel)
+			assert.Equal(t, "12", u.Resolved.Cursor)
+			req.Len(u.Files, 1)
+			assert.Equal(t, "configmap.yaml", u.Files[0].Path)
+			assert.Equal(t, manifest, u.Files[0].Content)
+		})
+	}
+}
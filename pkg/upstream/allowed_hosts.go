@@ -0,0 +1,76 @@
+package upstream
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// scpLikeGitURLRegexp matches git urls in the scp form, such as git@github.com:org/repo.git
+var scpLikeGitURLRegexp = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):`)
+
+// HostNotAllowedError is returned when an upstream would be fetched from a host that isn't in
+// FetchOptions.AllowedHosts
+type HostNotAllowedError struct {
+	Host string
+}
+
+func (e HostNotAllowedError) Error() string {
+	return fmt.Sprintf("host %q is not allowed", e.Host)
+}
+
+// checkAllowedHost returns a HostNotAllowedError if the host of rawURL doesn't match any of
+// allowedHosts. Every host is allowed when allowedHosts is empty.
+func checkAllowedHost(rawURL string, allowedHosts []string) error {
+	if len(allowedHosts) == 0 {
+		return nil
+	}
+
+	host, err := urlHost(rawURL)
+	if err != nil {
+		return err
+	}
+	if !isHostAllowed(host, allowedHosts) {
+		return HostNotAllowedError{Host: host}
+	}
+
+	return nil
+}
+
+// urlHost returns the host of a url, including the port if there is one. Git urls in the scp form are
+// also accepted.
+func urlHost(rawURL string) (string, error) {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return strings.ToLower(u.Host), nil
+	}
+	if matches := scpLikeGitURLRegexp.FindStringSubmatch(rawURL); matches != nil {
+		return strings.ToLower(matches[1]), nil
+	}
+	return "", errors.Errorf("failed to find the host in %s", redactURI(rawURL))
+}
+
+// isHostAllowed matches host against allowedHosts, which are exact hosts or path.Match patterns
+// such as *.example.com. A pattern without a port matches the host on any port.
+func isHostAllowed(host string, allowedHosts []string) bool {
+	hostname := host
+	if u, err := url.Parse("//" + host); err == nil {
+		hostname = u.Hostname()
+	}
+
+	for _, allowedHost := range allowedHosts {
+		allowedHost = strings.ToLower(allowedHost)
+
+		target := hostname
+		if strings.Contains(allowedHost, ":") {
+			target = host
+		}
+		if matched, err := path.Match(allowedHost, target); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package upstream
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_checkAllowedHost(t *testing.T) {
+	tests := []struct {
+		name         string
+		rawURL       string
+		allowedHosts []string
+		expectHost   string
+	}{
+		{
+			name:   "no allowed hosts",
+			rawURL: "https://charts.example.com/stable",
+		},
+		{
+			name:         "exact host",
+			rawURL:       "https://charts.example.com/stable",
+			allowedHosts: []string{"charts.example.com"},
+		},
+		{
+			name:         "wildcard host",
+			rawURL:       "https://Charts.Example.com:8443/stable",
+			allowedHosts: []string{"*.example.com"},
+		},
+		{
+			name:         "host with port",
+			rawURL:       "https://charts.example.com:8443/stable",
+			allowedHosts: []string{"charts.example.com:443"},
+			expectHost:   "charts.example.com:8443",
+		},
+		{
+			name:         "scp like git url",
+			rawURL:       "git@github.com:org/repo.git",
+			allowedHosts: []string{"github.com"},
+		},
+		{
+			name:         "denied host",
+			rawURL:       "http://169.254.169.254/latest/meta-data",
+			allowedHosts: []string{"*.example.com"},
+			expectHost:   "169.254.169.254",
+		},
+		{
+			name:         "wildcard doesn't match a suffix",
+			rawURL:       "https://example.com.attacker.io/chart",
+			allowedHosts: []string{"*.example.com", "example.com"},
+			expectHost:   "example.com.attacker.io",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			err := checkAllowedHost(test.rawURL, test.allowedHosts)
+			if test.expectHost == "" {
+				req.NoError(err)
+				return
+			}
+
+			req.Error(err)
+			assert.Equal(t, HostNotAllowedError{Host: test.expectHost}, err)
+		})
+	}
+}
+
+func Test_downloadHttpAllowedHosts(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://denied.example.com/manifest.yaml", http.StatusFound)
+			return
+		}
+		w.Write([]byte("apiVersion: v1\nkind: ConfigMap\n"))
+	}))
+	defer server.Close()
+
+	serverURL, err := url.Parse(server.URL)
+	req.NoError(err)
+
+	// a denied host is rejected before any request is made
+	_, err = downloadHttp(server.URL+"/manifest.yaml", &FetchOptions{AllowedHosts: []string{"charts.example.com"}})
+	req.Error(err)
+	assert.Equal(t, HostNotAllowedError{Host: serverURL.Host}, err)
+	assert.Equal(t, 0, requests)
+
+	u, err := downloadHttp(server.URL+"/manifest.yaml", &FetchOptions{AllowedHosts: []string{serverURL.Hostname()}})
+	req.NoError(err)
+	req.Len(u.Files, 1)
+	assert.Equal(t, 1, requests)
+
+	// redirects to a denied host aren't followed
+	_, err = downloadHttp(server.URL+"/redirect", &FetchOptions{AllowedHosts: []string{serverURL.Hostname()}})
+	req.Error(err)
+	assert.IsType(t, HostNotAllowedError{}, errors.Cause(err).(*url.Error).Err)
+	assert.Equal(t, 2, requests)
+}
+
+func Test_gitDownloaderAllowedHosts(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	_, err := gitDownloader("https://github.com/org/repo//charts/app?ref=v1.0.0", &FetchOptions{AllowedHosts: []string{"git.example.com"}})
+	req.Error(err)
+	assert.Equal(t, HostNotAllowedError{Host: "github.com"}, err)
+}
@@ -0,0 +1,87 @@
+package upstream
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const ConfigMapUpstreamScheme = "configmap"
+
+func configMapDownloader(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	namespace, name, err := parseConfigMapURI(upstreamURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse configmap uri")
+	}
+
+	if fetchOptions.Clientset == nil {
+		return nil, errors.New("a kubernetes clientset is required to read a configmap upstream")
+	}
+
+	return downloadConfigMap(fetchOptions.Clientset, namespace, name)
+}
+
+// parseConfigMapURI parses a configmap://namespace/name uri
+func parseConfigMapURI(upstreamURI string) (string, string, error) {
+	ref := strings.TrimPrefix(upstreamURI, ConfigMapUpstreamScheme+"://")
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.Errorf("expected configmap://namespace/name, got %s", upstreamURI)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// downloadConfigMap reads each data and binary data key in the configmap as an upstream file
+func downloadConfigMap(clientset kubernetes.Interface, namespace string, name string) (*types.Upstream, error) {
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		if kuberneteserrors.IsForbidden(err) {
+			return nil, errors.Wrapf(err, "permission to get configmap %s in namespace %s is required", name, namespace)
+		}
+		if !kuberneteserrors.IsNotFound(err) {
+			return nil, errors.Wrap(err, "failed to get configmap")
+		}
+
+		_, nsErr := clientset.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+		if kuberneteserrors.IsNotFound(nsErr) {
+			return nil, errors.Errorf("namespace %s does not exist", namespace)
+		}
+		return nil, errors.Errorf("configmap %s does not exist in namespace %s", name, namespace)
+	}
+
+	files := []types.UpstreamFile{}
+	for key, value := range configMap.Data {
+		files = append(files, types.UpstreamFile{
+			Path:    key,
+			Content: []byte(value),
+		})
+	}
+	for key, value := range configMap.BinaryData {
+		files = append(files, types.UpstreamFile{
+			Path:    key,
+			Content: value,
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+
+	if len(files) == 0 {
+		return nil, errors.Errorf("configmap %s in namespace %s has no data", name, namespace)
+	}
+
+	upstream := &types.Upstream{
+		URI:   ConfigMapUpstreamScheme + "://" + namespace + "/" + name,
+		Name:  name,
+		Type:  "configmap",
+		Files: files,
+	}
+
+	return upstream, nil
+}
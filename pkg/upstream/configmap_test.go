@@ -0,0 +1,79 @@
+package upstream
+
+import (
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_configMapDownloader(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "gitops"}},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "rendered", Namespace: "gitops"},
+			Data: map[string]string{
+				"service.yaml":    "kind: Service",
+				"deployment.yaml": "kind: Deployment",
+			},
+			BinaryData: map[string][]byte{
+				"logo.png": {0x89, 0x50, 0x4e, 0x47},
+			},
+		},
+	)
+
+	tests := []struct {
+		name        string
+		upstreamURI string
+		expected    []types.UpstreamFile
+		expectError string
+	}{
+		{
+			name:        "data and binary data",
+			upstreamURI: "configmap://gitops/rendered",
+			expected: []types.UpstreamFile{
+				{Path: "deployment.yaml", Content: []byte("kind: Deployment"), Source: "configmap", SourceURI: "configmap://gitops/rendered"},
+				{Path: "logo.png", Content: []byte{0x89, 0x50, 0x4e, 0x47}, Source: "configmap", SourceURI: "configmap://gitops/rendered"},
+				{Path: "service.yaml", Content: []byte("kind: Service"), Source: "configmap", SourceURI: "configmap://gitops/rendered"},
+			},
+		},
+		{
+			name:        "missing configmap",
+			upstreamURI: "configmap://gitops/missing",
+			expectError: "configmap missing does not exist in namespace gitops",
+		},
+		{
+			name:        "missing namespace",
+			upstreamURI: "configmap://missing/rendered",
+			expectError: "namespace missing does not exist",
+		},
+		{
+			name:        "no name",
+			upstreamURI: "configmap://gitops",
+			expectError: "expected configmap://namespace/name",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			upstream, err := FetchUpstream(test.upstreamURI, &FetchOptions{Clientset: clientset})
+			if test.expectError != "" {
+				req.Error(err)
+				assert.Contains(t, err.Error(), test.expectError)
+				return
+			}
+			req.NoError(err)
+			assert.Equal(t, "rendered", upstream.Name)
+			assert.Equal(t, test.expected, upstream.Files)
+		})
+	}
+}
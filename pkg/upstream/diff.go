@@ -0,0 +1,145 @@
+package upstream
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+)
+
+type FileChangeType string
+
+const (
+	FileAdded    FileChangeType = "added"
+	FileRemoved  FileChangeType = "removed"
+	FileModified FileChangeType = "modified"
+)
+
+// FileChange is a file that is different between two upstreams
+type FileChange struct {
+	Path string
+	Type FileChangeType
+	// Binary files are only compared, they don't have a Diff
+	Binary bool
+	// Diff is a unified diff of a text file, from the first upstream to the second
+	Diff string
+}
+
+// DiffUpstreams returns the files that were added, removed or modified in b compared to a, sorted by path.
+// Paths are compared after cleaning them, and files that are the same in both aren't included.
+func DiffUpstreams(a, b *types.Upstream) ([]FileChange, error) {
+	if a == nil || b == nil {
+		return nil, errors.New("both upstreams are required")
+	}
+
+	aFiles := upstreamFileMap(a)
+	bFiles := upstreamFileMap(b)
+
+	changes := []FileChange{}
+	for filePath, aContent := range aFiles {
+		bContent, ok := bFiles[filePath]
+		if !ok {
+			change, err := newFileChange(filePath, FileRemoved, aContent, nil)
+			if err != nil {
+				return nil, err
+			}
+			changes = append(changes, *change)
+			continue
+		}
+		if bytes.Equal(aContent, bContent) {
+			continue
+		}
+
+		change, err := newFileChange(filePath, FileModified, aContent, bContent)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+	for filePath, bContent := range bFiles {
+		if _, ok := aFiles[filePath]; ok {
+			continue
+		}
+
+		change, err := newFileChange(filePath, FileAdded, nil, bContent)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, *change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes, nil
+}
+
+func upstreamFileMap(u *types.Upstream) map[string][]byte {
+	files := map[string][]byte{}
+	for _, file := range u.Files {
+		files[path.Clean(file.Path)] = file.Content
+	}
+	return files
+}
+
+func newFileChange(filePath string, changeType FileChangeType, a []byte, b []byte) (*FileChange, error) {
+	change := FileChange{
+		Path: filePath,
+		Type: changeType,
+	}
+
+	if isBinaryContent(a) || isBinaryContent(b) {
+		change.Binary = true
+		return &change, nil
+	}
+
+	fromFile := "a/" + filePath
+	toFile := "b/" + filePath
+	if changeType == FileAdded {
+		fromFile = "/dev/null"
+	}
+	if changeType == FileRemoved {
+		toFile = "/dev/null"
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitDiffLines(a),
+		B:        splitDiffLines(b),
+		FromFile: fromFile,
+		ToFile:   toFile,
+		Context:  3,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to diff %s", filePath)
+	}
+	change.Diff = diff
+
+	return &change, nil
+}
+
+// splitDiffLines splits content into lines that each end with a newline, as the unified diff expects.
+// Unlike difflib.SplitLines, empty content has no lines.
+func splitDiffLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] += "\n"
+	}
+	return lines
+}
+
+// isBinaryContent treats content with a nul byte, or that isn't utf8, as binary
+func isBinaryContent(content []byte) bool {
+	return bytes.IndexByte(content, 0) >= 0 || !utf8.Valid(content)
+}
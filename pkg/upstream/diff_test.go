@@ -0,0 +1,73 @@
+package upstream
+
+import (
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_DiffUpstreams(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	a := &types.Upstream{
+		Files: []types.UpstreamFile{
+			{Path: "deployment.yaml", Content: []byte("kind: Deployment\nspec:\n  replicas: 1\n")},
+			{Path: "service.yaml", Content: []byte("kind: Service\n")},
+			{Path: "./configmap.yaml", Content: []byte("kind: ConfigMap\n")},
+			{Path: "icon.png", Content: []byte{0x89, 'P', 'N', 'G', 0x00, 0x01}},
+		},
+	}
+	b := &types.Upstream{
+		Files: []types.UpstreamFile{
+			{Path: "deployment.yaml", Content: []byte("kind: Deployment\nspec:\n  replicas: 2\n")},
+			{Path: "configmap.yaml", Content: []byte("kind: ConfigMap\n")},
+			{Path: "secret.yaml", Content: []byte("kind: Secret\n")},
+			{Path: "icon.png", Content: []byte{0x89, 'P', 'N', 'G', 0x00, 0x02}},
+		},
+	}
+
+	changes, err := DiffUpstreams(a, b)
+	req.NoError(err)
+	req.Len(changes, 4)
+
+	assert.Equal(t, "deployment.yaml", changes[0].Path)
+	assert.Equal(t, FileModified, changes[0].Type)
+	assert.False(t, changes[0].Binary)
+	assert.Contains(t, changes[0].Diff, "--- a/deployment.yaml")
+	assert.Contains(t, changes[0].Diff, "+++ b/deployment.yaml")
+	assert.Contains(t, changes[0].Diff, "-  replicas: 1\n")
+	assert.Contains(t, changes[0].Diff, "+  replicas: 2\n")
+
+	assert.Equal(t, FileChange{Path: "icon.png", Type: FileModified, Binary: true}, changes[1])
+
+	assert.Equal(t, "secret.yaml", changes[2].Path)
+	assert.Equal(t, FileAdded, changes[2].Type)
+	assert.Contains(t, changes[2].Diff, "--- /dev/null")
+	assert.Contains(t, changes[2].Diff, "+kind: Secret\n")
+	assert.NotContains(t, changes[2].Diff, "\n-")
+
+	assert.Equal(t, "service.yaml", changes[3].Path)
+	assert.Equal(t, FileRemoved, changes[3].Type)
+	assert.Contains(t, changes[3].Diff, "-kind: Service\n")
+
+	changes, err = DiffUpstreams(a, a)
+	req.NoError(err)
+	assert.Empty(t, changes)
+
+	_, err = DiffUpstreams(a, nil)
+	req.Error(err)
+}
+
+func Test_splitDiffLines(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	assert.Nil(t, splitDiffLines(nil))
+	assert.Equal(t, []string{"a\n", "b\n"}, splitDiffLines([]byte("a\nb\n")))
+	assert.Equal(t, []string{"a\n", "b\n"}, splitDiffLines([]byte("a\nb")))
+}
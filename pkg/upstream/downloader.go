@@ -0,0 +1,149 @@
+package upstream
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/crypto"
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// Downloader fetches an upstream for the uri schemes that it's registered for
+type Downloader interface {
+	Download(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error)
+}
+
+// DownloaderFunc allows a function to be registered as a Downloader
+type DownloaderFunc func(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error)
+
+func (f DownloaderFunc) Download(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	return f(upstreamURI, fetchOptions)
+}
+
+var (
+	downloadersMutex sync.RWMutex
+	downloaders      = map[string]Downloader{}
+)
+
+func init() {
+	RegisterDownloader("helm", DownloaderFunc(helmDownloader))
+	RegisterDownloader("replicated", DownloaderFunc(replicatedDownloader))
+	RegisterDownloader("file", DownloaderFunc(fileDownloader))
+	RegisterDownloader("git", DownloaderFunc(gitDownloader))
+	RegisterDownloader("http", DownloaderFunc(httpDownloader))
+	RegisterDownloader("https", DownloaderFunc(httpDownloader))
+	RegisterDownloader(AirgapUpstreamScheme, DownloaderFunc(airgapDownloader))
+	RegisterDownloader(ConfigMapUpstreamScheme, DownloaderFunc(configMapDownloader))
+}
+
+// RegisterDownloader registers the downloader for a uri scheme, replacing any
+// downloader that was previously registered for that scheme
+func RegisterDownloader(scheme string, downloader Downloader) {
+	downloadersMutex.Lock()
+	defer downloadersMutex.Unlock()
+
+	downloaders[scheme] = downloader
+}
+
+func getDownloader(scheme string) Downloader {
+	downloadersMutex.RLock()
+	defer downloadersMutex.RUnlock()
+
+	return downloaders[scheme]
+}
+
+func helmDownloader(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	u, err := url.ParseRequestURI(upstreamURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse request uri failed")
+	}
+
+	return downloadHelm(u, fetchOptions)
+}
+
+func replicatedDownloader(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	u, err := url.ParseRequestURI(upstreamURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse request uri failed")
+	}
+
+	var cipher *crypto.AESCipher
+	if fetchOptions.EncryptionKey != "" {
+		c, err := crypto.AESCipherFromString(fetchOptions.EncryptionKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create cipher")
+		}
+		cipher = c
+	}
+
+	pinnedCursor := fetchOptions.ReplicatedUpdateCursor
+	if pinnedCursor != "" {
+		if fetchOptions.LocalPath != "" {
+			return nil, errors.New("an update cursor can't be pinned when reading a replicated app from a local path")
+		}
+		if _, err := strconv.Atoi(pinnedCursor); err != nil {
+			return nil, util.ActionableError{Message: fmt.Sprintf("update cursor %q is not a channel sequence", pinnedCursor)}
+		}
+	}
+
+	// the replicated app is downloaded from the endpoint in the license
+	if fetchOptions.LocalPath == "" && fetchOptions.License != nil {
+		if err := checkAllowedHost(fetchOptions.License.Spec.Endpoint, fetchOptions.AllowedHosts); err != nil {
+			return nil, err
+		}
+	}
+
+	var baseUpstream *types.Upstream
+	if fetchOptions.PreferDelta {
+		baseUpstream = fetchOptions.BaseUpstream
+	}
+
+	upstream, err := downloadReplicated(u, fetchOptions.LocalPath, fetchOptions.RootDir, fetchOptions.UseAppDir, fetchOptions.License, fetchOptions.ConfigValues, pickCursor(fetchOptions), pickVersionLabel(fetchOptions), fetchOptions.ReplicatedChannel, cipher, baseUpstream, fetchOptions.EnforceLicense, fetchOptions.UserAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	// a release that isn't on the channel is answered with a different one
+	if pinnedCursor != "" && upstream.Resolved.Cursor != pinnedCursor {
+		return nil, util.ActionableError{
+			Message: fmt.Sprintf("release %s is not available on channel %q, the channel returned release %s", pinnedCursor, upstream.Resolved.ChannelName, upstream.Resolved.Cursor),
+		}
+	}
+
+	return upstream, nil
+}
+
+func fileDownloader(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	u, err := url.Parse(upstreamURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse file uri failed")
+	}
+
+	// a forced "file::" uri can be a plain path
+	if u.Scheme == "" {
+		return readLocalUpstream(upstreamURI, fetchOptions)
+	}
+
+	return readLocalUpstream(u.Path, fetchOptions)
+}
+
+func gitDownloader(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	gitSource, err := parseGitURI(upstreamURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse git uri")
+	}
+
+	if err := checkAllowedHost(gitSource.RepoURL, fetchOptions.AllowedHosts); err != nil {
+		return nil, err
+	}
+
+	return downloadGit(gitSource, fetchOptions)
+}
+
+func httpDownloader(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	return downloadHttp(upstreamURI, fetchOptions)
+}
@@ -0,0 +1,43 @@
+package upstream
+
+import (
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_RegisterDownloader(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	// the registry is global, so the scheme is removed for the other tests
+	defer func() {
+		downloadersMutex.Lock()
+		defer downloadersMutex.Unlock()
+		delete(downloaders, "artifacts")
+	}()
+
+	RegisterDownloader("artifacts", DownloaderFunc(func(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+		return &types.Upstream{
+			URI:  upstreamURI,
+			Name: "custom",
+			Type: "artifacts",
+		}, nil
+	}))
+
+	u, err := FetchUpstream("artifacts://internal/app", &FetchOptions{})
+	req.NoError(err)
+	assert.Equal(t, "artifacts://internal/app", u.URI)
+	assert.Equal(t, "custom", u.Name)
+
+	u, err = FetchUpstream("artifacts::internal/app", &FetchOptions{})
+	req.NoError(err)
+	assert.Equal(t, "internal/app", u.URI)
+
+	_, err = FetchUpstream("unregistered://internal/app", &FetchOptions{})
+	req.Error(err)
+}
@@ -0,0 +1,120 @@
+package upstream
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// readLocalUpstream reads a local path or file:// upstream, expanding environment variables in it
+// when FetchOptions.ExpandEnv is set
+func readLocalUpstream(upstreamPath string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	upstream, err := readFilesFromPath(upstreamPath, fetchOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if fetchOptions.ExpandEnv {
+		if err := expandEnvFiles(upstream.Files, os.LookupEnv, fetchOptions.ExpandEnvErrorOnUnset); err != nil {
+			return nil, err
+		}
+	}
+
+	return upstream, nil
+}
+
+// expandEnvFiles replaces ${VAR} and $VAR in the text files with the value from lookup. Unset
+// variables are replaced with an empty string, or are an error if errorOnUnset is set.
+func expandEnvFiles(files []types.UpstreamFile, lookup func(string) (string, bool), errorOnUnset bool) error {
+	for i, file := range files {
+		if isBinaryContent(file.Content) {
+			continue
+		}
+
+		unset := map[string]bool{}
+		expanded := expandEnv(string(file.Content), func(name string) string {
+			value, ok := lookup(name)
+			if !ok {
+				unset[name] = true
+			}
+			return value
+		})
+
+		if errorOnUnset && len(unset) > 0 {
+			names := []string{}
+			for name := range unset {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return util.ActionableError{
+				Message: fmt.Sprintf("environment variables %s used in %s are not set", strings.Join(names, ", "), file.Path),
+			}
+		}
+
+		files[i].Content = []byte(expanded)
+	}
+
+	return nil
+}
+
+// expandEnv is like os.Expand, but only expands ${NAME} and $NAME where NAME is a valid environment
+// variable name. Anything else, such as $1, $@, ${NAME:-default} or $$, is left as is, so that shell
+// snippets in manifests keep working.
+func expandEnv(s string, mapping func(string) string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			buf.WriteByte(s[i])
+			continue
+		}
+
+		switch {
+		case s[i+1] == '$':
+			buf.WriteString("$$")
+			i++
+		case s[i+1] == '{':
+			end := strings.IndexByte(s[i+2:], '}')
+			if end == -1 || !isEnvName(s[i+2:i+2+end]) {
+				buf.WriteByte(s[i])
+				continue
+			}
+			buf.WriteString(mapping(s[i+2 : i+2+end]))
+			i += end + 2
+		case isEnvNameStart(s[i+1]):
+			end := i + 2
+			for end < len(s) && isEnvNameChar(s[end]) {
+				end++
+			}
+			buf.WriteString(mapping(s[i+1 : end]))
+			i = end - 1
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	return buf.String()
+}
+
+// isEnvName returns true if name matches [A-Za-z_][A-Za-z0-9_]*
+func isEnvName(name string) bool {
+	if name == "" || !isEnvNameStart(name[0]) {
+		return false
+	}
+	for i := 1; i < len(name); i++ {
+		if !isEnvNameChar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isEnvNameStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isEnvNameChar(c byte) bool {
+	return isEnvNameStart(c) || ('0' <= c && c <= '9')
+}
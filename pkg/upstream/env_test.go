@@ -0,0 +1,128 @@
+package upstream
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_expandEnvFiles(t *testing.T) {
+	env := map[string]string{
+		"IMAGE": "nginx:1.19",
+		"EMPTY": "",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	tests := []struct {
+		name         string
+		content      string
+		errorOnUnset bool
+		expect       string
+		expectErr    string
+	}{
+		{
+			name:    "set",
+			content: "image: ${IMAGE}\nalso: $IMAGE",
+			expect:  "image: nginx:1.19\nalso: nginx:1.19",
+		},
+		{
+			name:         "set to empty",
+			content:      "value: '${EMPTY}'",
+			errorOnUnset: true,
+			expect:       "value: ''",
+		},
+		{
+			name:    "unset is empty",
+			content: "value: '${MISSING}'",
+			expect:  "value: ''",
+		},
+		{
+			name:         "shell snippets are left alone",
+			content:      `command: ["sh", "-c", "echo $1 $@ $? $$ ${1} ${IMAGE:-x} $"]`,
+			errorOnUnset: true,
+			expect:       `command: ["sh", "-c", "echo $1 $@ $? $$ ${1} ${IMAGE:-x} $"]`,
+		},
+		{
+			name:    "names end at the first other character",
+			content: "image: $IMAGE-alpine ${IMAGE}_x $$IMAGE",
+			expect:  "image: nginx:1.19-alpine nginx:1.19_x $$IMAGE",
+		},
+		{
+			name:         "unset is an error",
+			content:      "value: ${MISSING} ${IMAGE} $ANOTHER",
+			errorOnUnset: true,
+			expectErr:    "environment variables ANOTHER, MISSING used in deployment.yaml are not set",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			files := []types.UpstreamFile{{Path: "deployment.yaml", Content: []byte(test.content)}}
+			err := expandEnvFiles(files, lookup, test.errorOnUnset)
+			if test.expectErr != "" {
+				req.Error(err)
+				assert.IsType(t, util.ActionableError{}, err)
+				assert.Equal(t, test.expectErr, err.Error())
+				return
+			}
+			req.NoError(err)
+			assert.Equal(t, test.expect, string(files[0].Content))
+		})
+	}
+}
+
+func Test_expandEnvFilesSkipsBinary(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	binary := []byte("\x00$MISSING\xff")
+	files := []types.UpstreamFile{{Path: "image.bin", Content: binary}}
+	err := expandEnvFiles(files, func(string) (string, bool) { return "", false }, true)
+	req.NoError(err)
+	assert.Equal(t, binary, files[0].Content)
+}
+
+func Test_readLocalUpstreamExpandEnv(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	upstreamDir, err := ioutil.TempDir("", "upstream")
+	req.NoError(err)
+	defer os.RemoveAll(upstreamDir)
+
+	err = ioutil.WriteFile(filepath.Join(upstreamDir, "deployment.yaml"), []byte("image: ${KOTS_TEST_EXPAND_IMAGE}"), 0644)
+	req.NoError(err)
+
+	os.Setenv("KOTS_TEST_EXPAND_IMAGE", "nginx")
+	defer os.Unsetenv("KOTS_TEST_EXPAND_IMAGE")
+
+	// expansion is opt in
+	u, err := readLocalUpstream(upstreamDir, &FetchOptions{})
+	req.NoError(err)
+	req.Len(u.Files, 1)
+	assert.Equal(t, "image: ${KOTS_TEST_EXPAND_IMAGE}", string(u.Files[0].Content))
+
+	u, err = readLocalUpstream(upstreamDir, &FetchOptions{ExpandEnv: true})
+	req.NoError(err)
+	req.Len(u.Files, 1)
+	assert.Equal(t, "image: nginx", string(u.Files[0].Content))
+
+	os.Unsetenv("KOTS_TEST_EXPAND_IMAGE")
+	_, err = readLocalUpstream(upstreamDir, &FetchOptions{ExpandEnv: true, ExpandEnvErrorOnUnset: true})
+	req.Error(err)
+}
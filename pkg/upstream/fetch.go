@@ -2,12 +2,16 @@ package upstream
 
 import (
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
-	"github.com/replicatedhq/kots/pkg/crypto"
+	"github.com/replicatedhq/kots/pkg/logger"
+	"github.com/replicatedhq/kots/pkg/retry"
 	"github.com/replicatedhq/kots/pkg/upstream/types"
 	"github.com/replicatedhq/kots/pkg/util"
+	"k8s.io/client-go/kubernetes"
 )
 
 type FetchOptions struct {
@@ -16,6 +20,9 @@ type FetchOptions struct {
 	HelmRepoName        string
 	HelmRepoURI         string
 	HelmOptions         []string
+	HelmChartVersion    string
+	AllowPrerelease     bool
+	GPGKeyring          string
 	LocalPath           string
 	License             *kotsv1beta1.License
 	ConfigValues        *kotsv1beta1.ConfigValues
@@ -24,49 +31,289 @@ type FetchOptions struct {
 	CurrentCursor       string
 	CurrentChannel      string
 	CurrentVersionLabel string
+	// ReplicatedChannel overrides the channel that a replicated upstream is fetched from
+	ReplicatedChannel string
+	UserAgent         string
+	// SkipUnreadable will skip local files that can't be read, adding a warning
+	// to the upstream instead of failing
+	SkipUnreadable bool
+	// FollowSymlinks allows local upstreams to contain symlinks that resolve outside of the upstream path
+	FollowSymlinks bool
+	// Include and Exclude are gitignore style globs, matched against paths relative to the root of a
+	// local or archive upstream. Exclude takes precedence over Include, and both take precedence over
+	// a .kotsignore file in the upstream. When Include is set, only matching files are kept.
+	Include []string
+	Exclude []string
+	// GitCloneDepth is how many commits are fetched for a git upstream. It defaults to 1, so only
+	// the requested commit is fetched, and 0 fetches the full history.
+	GitCloneDepth *int
+	// GitToken is sent as the password for https git upstreams, such as a GitHub or GitLab personal
+	// access token. GitUsername defaults to x-access-token.
+	GitToken    string
+	GitUsername string
+	// StrictValidation fails the fetch with an actionable error if the upstream doesn't contain a helm
+	// chart or any kubernetes manifests, instead of failing later when it's rendered
+	StrictValidation bool
+	// ReadConcurrency is the number of files that are read at once from a local upstream.
+	// It defaults to the number of CPUs, and is capped to avoid running out of file descriptors.
+	ReadConcurrency int
+	// Clientset is used to read configmap:// upstreams
+	Clientset kubernetes.Interface
+	// TempDir is used for intermediate files, such as helm repo indexes and chart archives. It defaults
+	// to the system temp dir. Http and local upstreams are read in memory and don't use it.
+	TempDir string
+	// SnapshotDir saves the fetched upstream so that it can be read back with Offline
+	SnapshotDir string
+	// Offline reads the upstream from SnapshotDir instead of fetching it
+	Offline bool
+	// OnComplete is called with the downloader scheme, the total size of the upstream files and how
+	// long it took when a fetch finishes, whether it succeeded or not. FetchUpstreamMulti doesn't call it
+	// concurrently.
+	OnComplete func(scheme string, bytes int64, duration time.Duration, err error)
+	// Log receives debug logging of the resolved upstream and what was fetched
+	Log logger.Interface
+	// HTTPRetry retries the request for an http upstream after a network error or a 5xx or 429
+	// response. It isn't retried by default.
+	HTTPRetry retry.Options
+	// ExpandEnv replaces ${VAR} and $VAR in the text files of a local or file:// upstream with values
+	// from the environment. Unset variables are replaced with an empty string, unless
+	// ExpandEnvErrorOnUnset is set.
+	ExpandEnv             bool
+	ExpandEnvErrorOnUnset bool
+	// ReplicatedUpdateCursor pins a replicated upstream to the release with this channel sequence,
+	// instead of the latest release on the channel. The fetch fails if the release isn't available.
+	ReplicatedUpdateCursor string
+	// PreferDelta requests only the files that changed since BaseUpstream, a previous fetch of the same
+	// replicated upstream, and applies them to BaseUpstream. An endpoint that doesn't support deltas
+	// sends the full release instead, which is used as is without another request. The full release
+	// is also fetched when BaseUpstream is from a different channel.
+	PreferDelta  bool
+	BaseUpstream *types.Upstream
+	// CacheDir is where data that can be reused across fetches is kept, such as helm repo indexes,
+	// which are revalidated with the repo instead of downloaded again. Nothing is cached when it's empty.
+	CacheDir string
+	// MaxConcurrentFetches is the number of upstreams that FetchUpstreamMulti fetches at once. Upstreams
+	// are fetched one at a time when it's not set, and it's capped to avoid overwhelming upstream servers.
+	MaxConcurrentFetches int
+	// EnforceLicense fails fetching a replicated app when the license has expired, or when it doesn't
+	// have an entitlement that the application lists in requiredEntitlements
+	EnforceLicense bool
+	// AllowedHosts restricts the hosts that upstreams are fetched from to these exact hosts or path.Match
+	// patterns, such as *.example.com, when it's not empty. URIs with any other host are rejected by the
+	// helm, http, git and replicated downloaders before a request is made.
+	AllowedHosts []string
+	// BlockPrivateNetworks refuses to connect to loopback, link-local and private addresses when fetching
+	// an http upstream, including after a redirect. The address is checked after the host is resolved,
+	// for each connection. This protects against SSRF when uris come from untrusted users.
+	BlockPrivateNetworks bool
+	// ManifestPaths limits the fetched upstream to these files, and the files in these directories, when
+	// it's not empty. Paths are relative to the root of the upstream, and each must match at least one
+	// file. Unlike Include, it applies to every kind of upstream.
+	ManifestPaths []string
+	// HelmRepoUsername and HelmRepoPassword are sent to helm repos with basic auth. HelmRepoCAFile verifies
+	// the repo's certificate, and HelmRepoCertFile and HelmRepoKeyFile are the client certificate. Repo
+	// indexes aren't cached in CacheDir when any of them are set.
+	HelmRepoUsername string
+	HelmRepoPassword string
+	HelmRepoCAFile   string
+	HelmRepoCertFile string
+	HelmRepoKeyFile  string
 }
 
 func FetchUpstream(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	start := time.Now()
+	upstream, err := fetchUpstream(upstreamURI, fetchOptions)
+	if fetchOptions.OnComplete != nil {
+		fetchOptions.OnComplete(upstreamScheme(upstreamURI), upstreamSize(upstream), time.Since(start), err)
+	}
+	return upstream, err
+}
+
+func fetchUpstream(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	log := fetchOptions.Log
+	if log == nil {
+		log = logger.NewLogger()
+	}
+
+	if fetchOptions.Offline {
+		if fetchOptions.SnapshotDir == "" {
+			return nil, errors.New("a snapshot dir is required to fetch an upstream offline")
+		}
+
+		log.Debug("Reading upstream %s from snapshot %s", redactURI(upstreamURI), fetchOptions.SnapshotDir)
+		upstream, err := readSnapshot(fetchOptions.SnapshotDir, upstreamURI)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read upstream snapshot")
+		}
+		upstream.EncryptionKey = fetchOptions.EncryptionKey
+		setFileSources(upstream, upstreamURI)
+		if err := filterManifestPaths(upstream, fetchOptions.ManifestPaths); err != nil {
+			return nil, err
+		}
+		return upstream, nil
+	}
+
+	if err := util.ValidateTempDir(fetchOptions.TempDir); err != nil {
+		return nil, err
+	}
+
+	log.Debug("Fetching upstream %s", redactURI(upstreamURI))
+
 	upstream, err := downloadUpstream(upstreamURI, fetchOptions)
 	if err != nil {
 		return nil, errors.Wrap(err, "download upstream failed")
 	}
+	setFileSources(upstream, upstreamURI)
+
+	log.Debug("Fetched %d files for upstream %s", len(upstream.Files), upstream.Name)
+
+	if err := filterManifestPaths(upstream, fetchOptions.ManifestPaths); err != nil {
+		return nil, err
+	}
+
+	if fetchOptions.StrictValidation {
+		if err := validateUpstreamContent(upstream); err != nil {
+			return nil, err
+		}
+	}
+
+	if fetchOptions.SnapshotDir != "" {
+		if err := writeSnapshot(fetchOptions.SnapshotDir, upstreamURI, upstream); err != nil {
+			return nil, errors.Wrap(err, "failed to write upstream snapshot")
+		}
+	}
 
 	return upstream, nil
 }
 
-func downloadUpstream(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
-	if !util.IsURL(upstreamURI) {
-		return readFilesFromPath(upstreamURI)
+// sensitiveQueryParams are the query parameters that redactURI redacts, compared without case. They
+// include the signatures of presigned s3 and azure urls.
+var sensitiveQueryParams = []string{
+	"token",
+	"access_token",
+	"password",
+	"secret",
+	"api_key",
+	"apikey",
+	"sig",
+	"signature",
+	"x-amz-signature",
+	"x-amz-credential",
+	"x-amz-security-token",
+}
+
+// redactURI removes any credentials from the uri so that it can be logged
+func redactURI(upstreamURI string) string {
+	u, err := url.Parse(upstreamURI)
+	if err != nil {
+		return upstreamURI
 	}
 
-	var cipher *crypto.AESCipher
-	if fetchOptions.EncryptionKey != "" {
-		c, err := crypto.AESCipherFromString(fetchOptions.EncryptionKey)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to create cipher")
+	redacted := false
+	if u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		} else {
+			u.User = url.User("xxxxx")
 		}
-		cipher = c
+		redacted = true
+	}
+
+	query := u.Query()
+	for key := range query {
+		if isSensitiveQueryParam(key) {
+			query.Set(key, "xxxxx")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return upstreamURI
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+func isSensitiveQueryParam(key string) bool {
+	for _, param := range sensitiveQueryParams {
+		if strings.EqualFold(key, param) {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamScheme returns the name of the downloader that upstreamURI is fetched with
+func upstreamScheme(upstreamURI string) string {
+	if IsStdinUpstream(upstreamURI) {
+		return "stdin"
+	}
+	if forcedGetter, _ := splitForcedGetter(upstreamURI); forcedGetter != "" {
+		return forcedGetter
+	}
+	if !util.IsURL(upstreamURI) {
+		return "file"
 	}
 
 	u, err := url.ParseRequestURI(upstreamURI)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse request uri failed")
+		return ""
 	}
-	if u.Scheme == "helm" {
-		return downloadHelm(u, fetchOptions.HelmRepoURI)
+	return u.Scheme
+}
+
+// upstreamSize returns the total size of the upstream files
+func upstreamSize(upstream *types.Upstream) int64 {
+	if upstream == nil {
+		return 0
 	}
-	if u.Scheme == "replicated" {
-		return downloadReplicated(u, fetchOptions.LocalPath, fetchOptions.RootDir, fetchOptions.UseAppDir, fetchOptions.License, fetchOptions.ConfigValues, pickCursor(fetchOptions), pickVersionLabel(fetchOptions), cipher)
+
+	return upstream.TotalBytes()
+}
+
+// setFileSources records where the files of the upstream came from, for those that don't have a source yet
+func setFileSources(upstream *types.Upstream, upstreamURI string) {
+	source := upstreamScheme(upstreamURI)
+	sourceURI := redactURI(upstreamURI)
+	for i := range upstream.Files {
+		if upstream.Files[i].Source == "" {
+			upstream.Files[i].Source = source
+			upstream.Files[i].SourceURI = sourceURI
+		}
 	}
-	if u.Scheme == "git" {
-		return downloadGit(upstreamURI)
+}
+
+func downloadUpstream(upstreamURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	if IsStdinUpstream(upstreamURI) {
+		return readFilesFromStdin()
 	}
-	if u.Scheme == "http" || u.Scheme == "https" {
-		return downloadHttp(upstreamURI)
+
+	// a go-getter style forced type, such as "git::https://...", picks the downloader
+	// without needing to parse the remaining uri
+	forcedGetter, forcedURI := splitForcedGetter(upstreamURI)
+	if forcedGetter != "" {
+		downloader := getDownloader(forcedGetter)
+		if downloader == nil {
+			return nil, errors.Errorf("unsupported getter type %q", forcedGetter)
+		}
+		return downloader.Download(forcedURI, fetchOptions)
 	}
 
-	return nil, errors.Errorf("unknown protocol scheme %q", u.Scheme)
+	if !util.IsURL(upstreamURI) {
+		return readLocalUpstream(upstreamURI, fetchOptions)
+	}
+
+	u, err := url.ParseRequestURI(upstreamURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse request uri failed")
+	}
+
+	downloader := getDownloader(u.Scheme)
+	if downloader == nil {
+		return nil, errors.Errorf("unknown protocol scheme %q", u.Scheme)
+	}
+
+	return downloader.Download(upstreamURI, fetchOptions)
 }
 
 func pickVersionLabel(fetchOptions *FetchOptions) string {
@@ -77,6 +324,16 @@ func pickVersionLabel(fetchOptions *FetchOptions) string {
 }
 
 func pickCursor(fetchOptions *FetchOptions) ReplicatedCursor {
+	if fetchOptions.ReplicatedUpdateCursor != "" {
+		channelName := fetchOptions.ReplicatedChannel
+		if channelName == "" && fetchOptions.License != nil {
+			channelName = fetchOptions.License.Spec.ChannelName
+		}
+		return ReplicatedCursor{
+			ChannelName: channelName,
+			Cursor:      fetchOptions.ReplicatedUpdateCursor,
+		}
+	}
 	if fetchOptions.Airgap != nil && fetchOptions.Airgap.Spec.UpdateCursor != "" {
 		return ReplicatedCursor{
 			ChannelName: fetchOptions.Airgap.Spec.ChannelName,
@@ -1,12 +1,252 @@
 package upstream
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+
 	"github.com/pkg/errors"
 	"github.com/replicatedhq/kots/pkg/upstream/types"
 )
 
-func readFilesFromPath(filepath string) (*types.Upstream, error) {
-	return nil, errors.New("readFilesFromPath not implemented")
+func readFilesFromPath(upstreamPath string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	fi, err := os.Stat(upstreamPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to stat upstream path")
+	}
+
+	upstream := &types.Upstream{
+		URI:  upstreamPath,
+		Name: strings.TrimSuffix(filepath.Base(upstreamPath), filepath.Ext(upstreamPath)),
+		Type: "local",
+	}
+
+	if !fi.IsDir() {
+		content, err := ioutil.ReadFile(upstreamPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read upstream file")
+		}
+
+		if isGzip(content) || isZip(content) || isTar(content) {
+			files, err := readFilesFromStream(content)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to read upstream archive")
+			}
+			files, err = filterFiles(files, fetchOptions)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to filter upstream archive")
+			}
+			upstream.Files = files
+		} else {
+			upstream.Files = []types.UpstreamFile{{Path: filepath.Base(upstreamPath), Content: content, Mode: fi.Mode().Perm()}}
+		}
+
+		return upstream, nil
+	}
+
+	upstream.Name = filepath.Base(upstreamPath)
+
+	realUpstreamPath, err := filepath.EvalSymlinks(upstreamPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve upstream path")
+	}
+
+	kotsIgnore, err := ioutil.ReadFile(filepath.Join(upstreamPath, KotsIgnoreFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "failed to read %s", KotsIgnoreFile)
+	}
+	filter, err := newFileFilter(kotsIgnore, fetchOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create file filter")
+	}
+
+	// paths are collected while walking, and read afterwards by a pool of workers
+	toRead := []fileToRead{}
+	err = filepath.Walk(upstreamPath,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if fetchOptions.SkipUnreadable && path != upstreamPath {
+					upstream.Warnings = append(upstream.Warnings, fmt.Sprintf("skipped unreadable path %s: %s", path, err.Error()))
+					if info != nil && info.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				return err
+			}
+
+			relPath, err := filepath.Rel(upstreamPath, path)
+			if err != nil {
+				return errors.Wrap(err, "failed to get relative path")
+			}
+			relPath = filepath.ToSlash(relPath)
+
+			if info.IsDir() {
+				if path != upstreamPath && filter.skipDir(relPath) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if !filter.keepFile(relPath) {
+				return nil
+			}
+
+			readPath := path
+			mode := info.Mode().Perm()
+			if info.Mode()&os.ModeSymlink != 0 {
+				target, err := resolveSymlink(realUpstreamPath, path, fetchOptions.FollowSymlinks)
+				if err != nil {
+					if _, ok := err.(unsafeSymlinkError); ok || !fetchOptions.SkipUnreadable {
+						return err
+					}
+					upstream.Warnings = append(upstream.Warnings, fmt.Sprintf("skipped unreadable file %s: %s", path, err.Error()))
+					return nil
+				}
+
+				targetInfo, err := os.Stat(target)
+				if err != nil {
+					return errors.Wrapf(err, "failed to stat symlink target of %s", path)
+				}
+				if targetInfo.IsDir() {
+					// symlinked directories are not walked, this avoids loops through parent directories
+					upstream.Warnings = append(upstream.Warnings, fmt.Sprintf("skipped symlinked directory %s", path))
+					return nil
+				}
+
+				readPath = target
+				mode = targetInfo.Mode().Perm()
+			}
+
+			toRead = append(toRead, fileToRead{
+				Path:     path,
+				ReadPath: readPath,
+				RelPath:  relPath,
+				Mode:     mode,
+			})
+
+			return nil
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to walk upstream path")
+	}
+
+	results := readFilesConcurrently(toRead, readConcurrency(fetchOptions))
+	for i, result := range results {
+		if result.Err != nil {
+			if fetchOptions.SkipUnreadable {
+				upstream.Warnings = append(upstream.Warnings, fmt.Sprintf("skipped unreadable file %s: %s", toRead[i].Path, result.Err.Error()))
+				continue
+			}
+			return nil, errors.Wrapf(result.Err, "failed to read %s", toRead[i].Path)
+		}
+
+		upstream.Files = append(upstream.Files, types.UpstreamFile{
+			Path:    toRead[i].RelPath,
+			Content: result.Content,
+			Mode:    toRead[i].Mode,
+		})
+	}
+
+	return upstream, nil
+}
+
+// maxReadConcurrency caps the number of files that are open at once when reading a local upstream
+const maxReadConcurrency = 64
+
+type fileToRead struct {
+	Path     string
+	ReadPath string
+	RelPath  string
+	Mode     os.FileMode
+}
+
+type fileReadResult struct {
+	Content []byte
+	Err     error
+}
+
+func readConcurrency(fetchOptions *FetchOptions) int {
+	concurrency := fetchOptions.ReadConcurrency
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	if concurrency > maxReadConcurrency {
+		concurrency = maxReadConcurrency
+	}
+
+	return concurrency
+}
+
+// readFilesConcurrently reads the files with a bounded pool of workers. The results are in the same
+// order as the files.
+func readFilesConcurrently(files []fileToRead, concurrency int) []fileReadResult {
+	results := make([]fileReadResult, len(files))
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				content, err := ioutil.ReadFile(files[idx].ReadPath)
+				results[idx] = fileReadResult{Content: content, Err: err}
+			}
+		}()
+	}
+
+	for idx := range files {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+type unsafeSymlinkError struct {
+	Message string
+}
+
+func (e unsafeSymlinkError) Error() string {
+	return e.Message
+}
+
+// resolveSymlink returns the real path that a symlink points to. Symlink loops are an error, as are
+// symlinks that resolve outside of the root directory unless followSymlinks is set.
+func resolveSymlink(root string, link string, followSymlinks bool) (string, error) {
+	if _, err := os.Stat(link); err != nil {
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.ELOOP {
+			return "", unsafeSymlinkError{Message: fmt.Sprintf("symlink loop detected at %s", link)}
+		}
+		return "", err
+	}
+
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return "", err
+	}
+
+	if !followSymlinks && !isPathWithin(root, target) {
+		return "", unsafeSymlinkError{Message: fmt.Sprintf("symlink %s resolves to %s, which is outside of the upstream root", link, target)}
+	}
+
+	return target, nil
+}
+
+func isPathWithin(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
 
 func readFilesFromURI(upstreamURI string) (*types.Upstream, error) {
@@ -0,0 +1,177 @@
+package upstream
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_readFilesFromPathSkipUnreadable(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	upstreamDir, err := ioutil.TempDir("", "upstream")
+	req.NoError(err)
+	defer os.RemoveAll(upstreamDir)
+
+	err = ioutil.WriteFile(filepath.Join(upstreamDir, "deployment.yaml"), []byte("kind: Deployment"), 0644)
+	req.NoError(err)
+
+	// a dangling symlink can't be read
+	err = os.Symlink(filepath.Join(upstreamDir, "missing.yaml"), filepath.Join(upstreamDir, "broken.yaml"))
+	req.NoError(err)
+
+	_, err = readFilesFromPath(upstreamDir, &FetchOptions{})
+	req.Error(err)
+
+	u, err := readFilesFromPath(upstreamDir, &FetchOptions{SkipUnreadable: true})
+	req.NoError(err)
+	req.Len(u.Files, 1)
+	assert.Equal(t, "deployment.yaml", u.Files[0].Path)
+	req.Len(u.Warnings, 1)
+	assert.Contains(t, u.Warnings[0], "broken.yaml")
+}
+
+func Test_readFilesFromPathSymlinks(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	outsideDir, err := ioutil.TempDir("", "outside")
+	req.NoError(err)
+	defer os.RemoveAll(outsideDir)
+
+	err = ioutil.WriteFile(filepath.Join(outsideDir, "secret.yaml"), []byte("kind: Secret"), 0644)
+	req.NoError(err)
+
+	tests := []struct {
+		name           string
+		link           string
+		target         string
+		followSymlinks bool
+		wantErr        string
+	}{
+		{
+			name:    "self referential symlink",
+			link:    "loop.yaml",
+			target:  "loop.yaml",
+			wantErr: "symlink loop detected",
+		},
+		{
+			name:    "escaping symlink",
+			link:    "secret.yaml",
+			target:  filepath.Join(outsideDir, "secret.yaml"),
+			wantErr: "outside of the upstream root",
+		},
+		{
+			name:           "escaping symlink with follow symlinks",
+			link:           "secret.yaml",
+			target:         filepath.Join(outsideDir, "secret.yaml"),
+			followSymlinks: true,
+		},
+		{
+			name:   "symlink within the upstream",
+			link:   "link.yaml",
+			target: "deployment.yaml",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			upstreamDir, err := ioutil.TempDir("", "upstream")
+			req.NoError(err)
+			defer os.RemoveAll(upstreamDir)
+
+			err = ioutil.WriteFile(filepath.Join(upstreamDir, "deployment.yaml"), []byte("kind: Deployment"), 0644)
+			req.NoError(err)
+
+			err = os.Symlink(test.target, filepath.Join(upstreamDir, test.link))
+			req.NoError(err)
+
+			u, err := readFilesFromPath(upstreamDir, &FetchOptions{FollowSymlinks: test.followSymlinks, SkipUnreadable: true})
+			if test.wantErr != "" {
+				req.Error(err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				assert.Contains(t, err.Error(), test.link)
+				return
+			}
+			req.NoError(err)
+			req.Len(u.Files, 2)
+		})
+	}
+}
+
+func Test_readFilesFromPathConcurrentOrder(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	upstreamDir, err := ioutil.TempDir("", "upstream")
+	req.NoError(err)
+	defer os.RemoveAll(upstreamDir)
+
+	expectedPaths := []string{}
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("file-%03d.yaml", i)
+		req.NoError(ioutil.WriteFile(filepath.Join(upstreamDir, name), []byte(name), 0644))
+		expectedPaths = append(expectedPaths, name)
+	}
+
+	u, err := readFilesFromPath(upstreamDir, &FetchOptions{ReadConcurrency: 8})
+	req.NoError(err)
+
+	actualPaths := []string{}
+	for _, file := range u.Files {
+		actualPaths = append(actualPaths, file.Path)
+		assert.Equal(t, file.Path, string(file.Content))
+	}
+	assert.Equal(t, expectedPaths, actualPaths)
+}
+
+func Benchmark_readFilesFromPath(b *testing.B) {
+	upstreamDir, err := ioutil.TempDir("", "kots")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(upstreamDir)
+
+	content := []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: example\n")
+	for i := 0; i < 5000; i++ {
+		dir := filepath.Join(upstreamDir, fmt.Sprintf("dir-%d", i%50))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%d.yaml", i)), content, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	benchmarks := []struct {
+		name        string
+		concurrency int
+	}{
+		{name: "serial", concurrency: 1},
+		{name: "concurrent", concurrency: runtime.NumCPU() * 4},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				if _, err := readFilesFromPath(upstreamDir, &FetchOptions{ReadConcurrency: bm.concurrency}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
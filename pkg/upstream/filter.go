@@ -0,0 +1,214 @@
+package upstream
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+)
+
+// KotsIgnoreFile lists files to leave out of a local or archive upstream, in gitignore syntax.
+// It's read from the upstream root and isn't included in the upstream itself.
+const KotsIgnoreFile = ".kotsignore"
+
+type ignorePattern struct {
+	regexp  *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// fileFilter decides which upstream files are kept. FetchOptions.Exclude takes precedence over
+// FetchOptions.Include, and both take precedence over the upstream's .kotsignore. When Include is
+// set, only files that match it are kept.
+type fileFilter struct {
+	ignorePatterns []ignorePattern
+	include        []*regexp.Regexp
+	exclude        []*regexp.Regexp
+}
+
+func newFileFilter(kotsIgnore []byte, fetchOptions *FetchOptions) (*fileFilter, error) {
+	filter := &fileFilter{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(kotsIgnore))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pattern := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			pattern.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			pattern.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		re, err := globRegexp(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s pattern %q", KotsIgnoreFile, scanner.Text())
+		}
+		pattern.regexp = re
+
+		filter.ignorePatterns = append(filter.ignorePatterns, pattern)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", KotsIgnoreFile)
+	}
+
+	for _, glob := range fetchOptions.Include {
+		re, err := globRegexp(glob)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid include pattern %q", glob)
+		}
+		filter.include = append(filter.include, re)
+	}
+	for _, glob := range fetchOptions.Exclude {
+		re, err := globRegexp(glob)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid exclude pattern %q", glob)
+		}
+		filter.exclude = append(filter.exclude, re)
+	}
+
+	return filter, nil
+}
+
+// skipDir returns true if nothing under the directory can be kept, so it doesn't need to be walked
+func (f *fileFilter) skipDir(relPath string) bool {
+	if matchesAny(f.exclude, relPath) {
+		return true
+	}
+	return len(f.include) == 0 && f.isIgnored(relPath, true)
+}
+
+// keepFile returns true if the file at relPath should be part of the upstream. The parent
+// directories are checked too, for file lists that weren't walked with skipDir.
+func (f *fileFilter) keepFile(relPath string) bool {
+	if relPath == KotsIgnoreFile {
+		return false
+	}
+
+	dirs := strings.Split(relPath, "/")
+	dirs = dirs[:len(dirs)-1]
+	for i := range dirs {
+		if matchesAny(f.exclude, strings.Join(dirs[:i+1], "/")) {
+			return false
+		}
+	}
+	if matchesAny(f.exclude, relPath) {
+		return false
+	}
+
+	if len(f.include) > 0 {
+		return matchesAny(f.include, relPath)
+	}
+
+	// as with gitignore, a file can't be re-included if its directory is ignored
+	for i := range dirs {
+		if f.isIgnored(strings.Join(dirs[:i+1], "/"), true) {
+			return false
+		}
+	}
+	return !f.isIgnored(relPath, false)
+}
+
+// isIgnored applies the .kotsignore patterns in order, the last matching pattern wins
+func (f *fileFilter) isIgnored(relPath string, isDir bool) bool {
+	ignored := false
+	for _, pattern := range f.ignorePatterns {
+		if pattern.dirOnly && !isDir {
+			continue
+		}
+		if pattern.regexp.MatchString(relPath) {
+			ignored = !pattern.negate
+		}
+	}
+	return ignored
+}
+
+// filterFiles applies the .kotsignore at the root of files and the fetch options to a list of files
+func filterFiles(files []types.UpstreamFile, fetchOptions *FetchOptions) ([]types.UpstreamFile, error) {
+	var kotsIgnore []byte
+	for _, file := range files {
+		if file.Path == KotsIgnoreFile {
+			kotsIgnore = file.Content
+		}
+	}
+
+	filter, err := newFileFilter(kotsIgnore, fetchOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []types.UpstreamFile{}
+	for _, file := range files {
+		if filter.keepFile(file.Path) {
+			filtered = append(filtered, file)
+		}
+	}
+
+	return filtered, nil
+}
+
+func matchesAny(res []*regexp.Regexp, relPath string) bool {
+	for _, re := range res {
+		if re.MatchString(relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// globRegexp converts a gitignore style glob to a regexp. Globs without a slash match at any depth,
+// and "**" matches across directories.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	anchored := strings.Contains(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+	if glob == "" {
+		return nil, errors.New("empty pattern")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	if !anchored {
+		sb.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end == -1 {
+				return nil, errors.New("unterminated character class")
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+
+	return regexp.Compile(sb.String())
+}
@@ -0,0 +1,104 @@
+package upstream
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_readFilesFromPathKotsIgnore(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	upstreamDir, err := ioutil.TempDir("", "upstream")
+	req.NoError(err)
+	defer os.RemoveAll(upstreamDir)
+
+	files := map[string]string{
+		KotsIgnoreFile:            "# docs aren't manifests\ndocs/\n",
+		"deployment.yaml":         "kind: Deployment",
+		"README.md":               "# readme",
+		"docs/install.yaml":       "kind: Example",
+		"manifests/service.yaml":  "kind: Service",
+		"manifests/docs/notes.md": "notes",
+	}
+	for path, content := range files {
+		req.NoError(os.MkdirAll(filepath.Join(upstreamDir, filepath.Dir(path)), 0755))
+		req.NoError(ioutil.WriteFile(filepath.Join(upstreamDir, path), []byte(content), 0644))
+	}
+
+	u, err := readFilesFromPath(upstreamDir, &FetchOptions{})
+	req.NoError(err)
+	assert.Equal(t, []string{"README.md", "deployment.yaml", "manifests/service.yaml"}, upstreamFilePaths(u.Files))
+
+	u, err = readFilesFromPath(upstreamDir, &FetchOptions{Exclude: []string{"*.md"}})
+	req.NoError(err)
+	assert.Equal(t, []string{"deployment.yaml", "manifests/service.yaml"}, upstreamFilePaths(u.Files))
+}
+
+func Test_filterFiles(t *testing.T) {
+	files := []types.UpstreamFile{
+		{Path: KotsIgnoreFile, Content: []byte("*.md\n!CHANGELOG.md\ntests/\n")},
+		{Path: "CHANGELOG.md"},
+		{Path: "README.md"},
+		{Path: "chart/README.md"},
+		{Path: "chart/values.yaml"},
+		{Path: "manifests/deployment.yaml"},
+		{Path: "tests/deployment.yaml"},
+	}
+
+	tests := []struct {
+		name         string
+		fetchOptions FetchOptions
+		expected     []string
+	}{
+		{
+			name:     "kotsignore",
+			expected: []string{"CHANGELOG.md", "chart/values.yaml", "manifests/deployment.yaml"},
+		},
+		{
+			name:         "exclude",
+			fetchOptions: FetchOptions{Exclude: []string{"/chart"}},
+			expected:     []string{"CHANGELOG.md", "manifests/deployment.yaml"},
+		},
+		{
+			name:         "include overrides kotsignore",
+			fetchOptions: FetchOptions{Include: []string{"**/*.md", "tests/*"}},
+			expected:     []string{"CHANGELOG.md", "README.md", "chart/README.md", "tests/deployment.yaml"},
+		},
+		{
+			name:         "exclude overrides include",
+			fetchOptions: FetchOptions{Include: []string{"*.yaml"}, Exclude: []string{"tests/**"}},
+			expected:     []string{"chart/values.yaml", "manifests/deployment.yaml"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			filtered, err := filterFiles(files, &test.fetchOptions)
+			req.NoError(err)
+			assert.Equal(t, test.expected, upstreamFilePaths(filtered))
+		})
+	}
+}
+
+func upstreamFilePaths(files []types.UpstreamFile) []string {
+	paths := []string{}
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
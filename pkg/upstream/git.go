@@ -1,10 +1,326 @@
 package upstream
 
 import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
 	"github.com/pkg/errors"
 	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
 )
 
-func downloadGit(gitURI string) (*types.Upstream, error) {
-	return nil, errors.New("downloadGit not implemented")
+// forcedGetterRegexp matches go-getter style forcing prefixes such as "git::https://..."
+var forcedGetterRegexp = regexp.MustCompile(`^([A-Za-z0-9]+)::(.+)$`)
+
+type GitSource struct {
+	RepoURL string
+	Ref     string
+	Subdir  string
+}
+
+// splitForcedGetter returns the forced getter type and the remaining uri.
+// If there is no forcing prefix, the type is empty and the uri is returned as is.
+func splitForcedGetter(upstreamURI string) (string, string) {
+	matches := forcedGetterRegexp.FindStringSubmatch(upstreamURI)
+	if matches == nil {
+		return "", upstreamURI
+	}
+
+	return matches[1], matches[2]
+}
+
+// parseGitURI parses a go-getter style git uri. A subdirectory can be specified after a
+// double slash, and the branch, tag or commit can be specified with a "ref" query param.
+// For example, https://github.com/org/repo//sub/dir?ref=v1.0.0
+func parseGitURI(gitURI string) (*GitSource, error) {
+	src := gitURI
+	if src == "" {
+		return nil, errors.New("empty git uri")
+	}
+
+	subdir := ""
+	searchFrom := 0
+	if idx := strings.Index(src, "://"); idx >= 0 {
+		searchFrom = idx + len("://")
+	}
+	if idx := strings.Index(src[searchFrom:], "//"); idx >= 0 {
+		idx += searchFrom
+		subdir = src[idx+len("//"):]
+		src = src[:idx]
+
+		// the query string belongs to the repo url, not the subdir
+		if q := strings.Index(subdir, "?"); q >= 0 {
+			src = src + subdir[q:]
+			subdir = subdir[:q]
+		}
+	}
+
+	ref := ""
+	if q := strings.Index(src, "?"); q >= 0 {
+		values, err := url.ParseQuery(src[q+1:])
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse git uri query")
+		}
+
+		ref = values.Get("ref")
+		values.Del("ref")
+
+		src = src[:q]
+		if encoded := values.Encode(); encoded != "" {
+			src = src + "?" + encoded
+		}
+	}
+
+	gitSource := GitSource{
+		RepoURL: src,
+		Ref:     ref,
+		Subdir:  strings.Trim(subdir, "/"),
+	}
+
+	return &gitSource, nil
+}
+
+// defaultGitCloneDepth is used when FetchOptions.GitCloneDepth is not set
+const defaultGitCloneDepth = 1
+
+func downloadGit(gitSource *GitSource, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	depth := defaultGitCloneDepth
+	if fetchOptions.GitCloneDepth != nil {
+		depth = *fetchOptions.GitCloneDepth
+	}
+
+	for _, segment := range strings.Split(gitSource.Subdir, "/") {
+		if segment == ".." {
+			return nil, util.ActionableError{Message: fmt.Sprintf("git subdirectory %q must be inside the repo", gitSource.Subdir)}
+		}
+	}
+
+	cloneDir, err := ioutil.TempDir(fetchOptions.TempDir, "kots-git")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create clone dir")
+	}
+	defer os.RemoveAll(cloneDir)
+
+	auth, err := gitAuthConfig(gitSource.RepoURL, fetchOptions, fetchOptions.GitToken != "" && gitSupportsConfigEnv())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cloneGitRepo(gitSource, depth, cloneDir, auth); err != nil {
+		return nil, errors.Wrapf(redactGitToken(err, fetchOptions), "failed to clone %s", redactURI(gitSource.RepoURL))
+	}
+
+	commitSHA, err := runGit(cloneDir, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get checked out commit")
+	}
+
+	// the upstream is the checked out files, not the repo
+	if err := os.RemoveAll(filepath.Join(cloneDir, ".git")); err != nil {
+		return nil, errors.Wrap(err, "failed to remove git dir")
+	}
+
+	upstream, err := readFilesFromPath(filepath.Join(cloneDir, filepath.FromSlash(gitSource.Subdir)), fetchOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read files from repo")
+	}
+
+	upstream.URI = gitSource.RepoURL
+	upstream.Name = strings.TrimSuffix(path.Base(gitSource.RepoURL), ".git")
+	upstream.Type = "git"
+	upstream.Resolved.CommitSHA = commitSHA
+
+	return upstream, nil
+}
+
+// defaultGitTokenUsername is sent with FetchOptions.GitToken when there's no GitUsername. GitHub and
+// GitLab accept any username with a personal access token.
+const defaultGitTokenUsername = "x-access-token"
+
+// gitAuth passes credentials to the git commands that talk to the remote, either in the environment or
+// as config args
+type gitAuth struct {
+	Env  []string
+	Args []string
+}
+
+// gitAuthConfig configures git to send the token in fetchOptions as basic auth credentials to the host of
+// repoURL. The token is sent in a header rather than added to the url, so that it isn't written to the
+// repo config. The header is set in the environment when git supports it (2.31 and later), so that it
+// isn't visible to other users in the process list. Older git ignores those variables, so it's passed
+// with -c instead.
+func gitAuthConfig(repoURL string, fetchOptions *FetchOptions, configEnvSupported bool) (*gitAuth, error) {
+	if fetchOptions.GitToken == "" {
+		return &gitAuth{}, nil
+	}
+
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Scheme != "https" {
+		return nil, util.ActionableError{Message: fmt.Sprintf("a git token can only be used with https git urls, not %s", redactURI(repoURL))}
+	}
+
+	// the header is only sent to this host
+	hostURL := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}
+	key := fmt.Sprintf("http.%s.extraHeader", hostURL.String())
+	value := "Authorization: Basic " + gitBasicCredentials(fetchOptions)
+
+	if !configEnvSupported {
+		return &gitAuth{Args: []string{"-c", key + "=" + value}}, nil
+	}
+	return &gitAuth{
+		Env: []string{
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=" + key,
+			"GIT_CONFIG_VALUE_0=" + value,
+		},
+	}, nil
+}
+
+// gitSupportsConfigEnv returns true if the installed git reads config from GIT_CONFIG_COUNT, which was
+// added in git 2.31
+func gitSupportsConfigEnv() bool {
+	output, err := runGit("", "version")
+	if err != nil {
+		return false
+	}
+	return gitVersionAtLeast(output, 2, 31)
+}
+
+// gitVersionAtLeast parses the output of git version, such as "git version 2.24.3 (Apple Git-128)"
+func gitVersionAtLeast(versionOutput string, major int, minor int) bool {
+	fields := strings.Fields(versionOutput)
+	if len(fields) < 3 {
+		return false
+	}
+
+	parts := strings.Split(fields[2], ".")
+	if len(parts) < 2 {
+		return false
+	}
+	gitMajor, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	gitMinor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+
+	return gitMajor > major || (gitMajor == major && gitMinor >= minor)
+}
+
+// gitBasicCredentials returns the base64 encoded basic auth credentials for the token in fetchOptions
+func gitBasicCredentials(fetchOptions *FetchOptions) string {
+	username := fetchOptions.GitUsername
+	if username == "" {
+		username = defaultGitTokenUsername
+	}
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + fetchOptions.GitToken))
+}
+
+// redactGitToken removes the token in fetchOptions, and the basic auth credentials that include it,
+// from err, for errors that include git's output
+func redactGitToken(err error, fetchOptions *FetchOptions) error {
+	if fetchOptions.GitToken == "" {
+		return err
+	}
+
+	message := err.Error()
+	redacted := strings.Replace(message, gitBasicCredentials(fetchOptions), "xxxxx", -1)
+	redacted = strings.Replace(redacted, fetchOptions.GitToken, "xxxxx", -1)
+	if redacted == message {
+		return err
+	}
+	return errors.New(redacted)
+}
+
+// cloneGitRepo checks out gitSource.Ref, or the default branch, into dir. A depth of 0 fetches the
+// full history. A ref that can't be fetched at the given depth, such as an abbreviated commit sha,
+// falls back to fetching the full history. The auth is added to each git command that talks to the
+// remote.
+func cloneGitRepo(gitSource *GitSource, depth int, dir string, auth *gitAuth) error {
+	remoteGit := func(args ...string) (string, error) {
+		return runGitWithEnv(dir, auth.Env, append(append([]string{}, auth.Args...), args...)...)
+	}
+
+	if _, err := runGit(dir, "init", "--quiet"); err != nil {
+		return err
+	}
+	if _, err := runGit(dir, "remote", "add", "origin", gitSource.RepoURL); err != nil {
+		return err
+	}
+
+	ref := gitSource.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	if depth > 0 {
+		_, err := remoteGit("fetch", "--quiet", "--depth", strconv.Itoa(depth), "origin", ref)
+		if err == nil {
+			_, err = runGit(dir, "checkout", "--quiet", "FETCH_HEAD")
+			return err
+		}
+	}
+
+	fetchArgs := []string{"fetch", "--quiet", "--tags", "origin", "+refs/heads/*:refs/remotes/origin/*"}
+	if _, err := os.Stat(filepath.Join(dir, ".git", "shallow")); err == nil {
+		fetchArgs = append(fetchArgs, "--unshallow")
+	}
+	if _, err := remoteGit(fetchArgs...); err != nil {
+		return err
+	}
+
+	if gitSource.Ref == "" {
+		if _, err := remoteGit("fetch", "--quiet", "origin", "HEAD"); err != nil {
+			return err
+		}
+		ref = "FETCH_HEAD"
+	} else if _, err := runGit(dir, "rev-parse", "--verify", "--quiet", "origin/"+ref+"^{commit}"); err == nil {
+		// branches are only fetched as remote tracking branches
+		ref = "origin/" + ref
+	}
+
+	_, err := runGit(dir, "checkout", "--quiet", ref)
+	return err
+}
+
+func runGit(dir string, args ...string) (string, error) {
+	return runGitWithEnv(dir, nil, args...)
+}
+
+// runGitWithEnv runs git with env added to the environment
+func runGitWithEnv(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	// never wait for credentials on a terminal
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "git %s failed: %s", gitSubcommand(args), strings.TrimSpace(string(output)))
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// gitSubcommand returns the name of the git command in args, after any -c config args
+func gitSubcommand(args []string) string {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-c" {
+			i++
+			continue
+		}
+		return args[i]
+	}
+	return ""
 }
@@ -4,6 +4,8 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -11,89 +13,181 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/Masterminds/semver"
 	"github.com/pkg/errors"
 	"github.com/replicatedhq/kots/pkg/upstream/types"
 	"github.com/replicatedhq/kots/pkg/util"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"golang.org/x/crypto/openpgp/packet"
 	"k8s.io/helm/cmd/helm/search"
 	"k8s.io/helm/pkg/downloader"
 	"k8s.io/helm/pkg/getter"
-	"k8s.io/helm/pkg/helm/environment"
 	"k8s.io/helm/pkg/helm/helmpath"
 	"k8s.io/helm/pkg/repo"
 )
 
-func getUpdatesHelm(u *url.URL, repoURI string) ([]Update, error) {
+func getUpdatesHelm(u *url.URL, fetchOptions *FetchOptions) ([]Update, error) {
 	repoName, chartName, _, err := parseHelmURL(u)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse helm uri")
 	}
 
+	repoURI := fetchOptions.HelmRepoURI
+	if repoURI != "" {
+		repoURI, err = normalizeHelmRepoURI(repoURI)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	helmHome, err := ioutil.TempDir("", "kots")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create temporary helm home")
 	}
 	defer os.RemoveAll(helmHome)
 
-	i, err := helmLoadRepositoriesIndex(helmHome, repoName, repoURI)
+	i, err := helmLoadRepositoriesIndex(helmHome, repoName, repoURI, fetchOptions)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load helm repositories")
 	}
 
 	var updates []Update
+	for _, version := range chartVersions(i, chartName) {
+		updates = append(updates, Update{Cursor: version})
+	}
+	return updates, nil
+}
+
+// ListHelmVersions returns the versions of chartName in the helm repo at repoURI, highest first, without
+// downloading the chart. Pre-releases are included, and versions that aren't semver are listed last.
+func ListHelmVersions(repoURI string, chartName string, fetchOptions *FetchOptions) ([]string, error) {
+	repoURI, err := normalizeHelmRepoURI(repoURI)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAllowedHost(repoURI, fetchOptions.AllowedHosts); err != nil {
+		return nil, err
+	}
+
+	helmHome, err := ioutil.TempDir(fetchOptions.TempDir, "kots")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary helm home")
+	}
+	defer os.RemoveAll(helmHome)
+
+	// the repo name is only used to key the index
+	i, err := helmLoadRepositoriesIndex(helmHome, "kots", repoURI, fetchOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load helm repositories")
+	}
+
+	versions := chartVersions(i, chartName)
+	if len(versions) == 0 {
+		return nil, errors.Errorf("chart %s not found in helm repo %s", chartName, repoURI)
+	}
+
+	return sortChartVersions(versions), nil
+}
+
+// chartVersions returns the versions of chartName in the index, in index order
+func chartVersions(i *search.Index, chartName string) []string {
+	versions := []string{}
 	for _, result := range i.All() {
 		if result.Chart.GetName() != chartName {
 			continue
 		}
-
-		updates = append(updates, Update{Cursor: result.Chart.GetVersion()})
+		versions = append(versions, result.Chart.GetVersion())
 	}
-	return updates, nil
+	return versions
 }
 
-func downloadHelm(u *url.URL, repoURI string) (*types.Upstream, error) {
-	repoName, chartName, chartVersion, err := parseHelmURL(u)
+// sortChartVersions sorts semver versions highest first, followed by any other versions in reverse
+// string order
+func sortChartVersions(versions []string) []string {
+	sorted := append([]string{}, versions...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		va, errA := semver.NewVersion(sorted[a])
+		vb, errB := semver.NewVersion(sorted[b])
+		switch {
+		case errA == nil && errB == nil:
+			return va.GreaterThan(vb)
+		case errA == nil:
+			return true
+		case errB == nil:
+			return false
+		default:
+			return sorted[a] > sorted[b]
+		}
+	})
+	return sorted
+}
+
+func downloadHelm(u *url.URL, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	helmSource, err := resolveHelmSource(u, fetchOptions)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse helm uri")
 	}
+	repoName := helmSource.RepoName
+	repoURI := helmSource.RepoURI
+	chartName := helmSource.ChartName
+	chartVersion := helmSource.ChartVersion
+	keyring := fetchOptions.GPGKeyring
 
-	if repoURI == "" {
-		repoURI = getKnownHelmRepoURI(repoName)
+	if err := checkAllowedHost(repoURI, fetchOptions.AllowedHosts); err != nil {
+		return nil, err
 	}
 
-	helmHome, err := ioutil.TempDir("", "kots")
+	helmHome, err := ioutil.TempDir(fetchOptions.TempDir, "kots")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create temporary helm home")
 	}
 	defer os.RemoveAll(helmHome)
 
-	i, err := helmLoadRepositoriesIndex(helmHome, repoName, repoURI)
+	i, err := helmLoadRepositoriesIndex(helmHome, repoName, repoURI, fetchOptions)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load helm repositories")
 	}
 
-	if chartVersion == "" {
-		highestChartVersion := semver.MustParse("0.0.0")
-		for _, result := range i.All() {
-			if result.Chart.GetName() != chartName {
-				continue
-			}
+	availableVersions := chartVersions(i, chartName)
 
-			v, err := semver.NewVersion(result.Chart.GetVersion())
-			if err != nil {
-				return nil, errors.Wrap(err, "unable to parse chart version")
-			}
+	chartVersion, versionWarnings, err := resolveChartVersion(availableVersions, chartVersion, fetchOptions.AllowPrerelease)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve chart version")
+	}
 
-			if v.GreaterThan(highestChartVersion) {
-				highestChartVersion = v
-			}
-		}
+	chartArchivePath, err := downloadChartArchive(i, helmHome, repoURI, chartName, chartVersion, keyring, fetchOptions)
+	if err != nil {
+		return nil, err
+	}
 
-		chartVersion = highestChartVersion.String()
+	upstream, err := chartArchiveToSparseUpstream(chartArchivePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse chart archive as upstream")
 	}
 
+	if err := addChartDependencies(upstream, helmHome, fetchOptions); err != nil {
+		return nil, errors.Wrap(err, "failed to add chart dependencies")
+	}
+
+	upstream.URI = u.RequestURI()
+	upstream.Name = chartName
+	upstream.UpdateCursor = chartVersion
+	upstream.VersionLabel = chartVersion
+	upstream.Resolved = types.ResolvedUpstream{
+		ChartVersion: chartVersion,
+	}
+	upstream.Warnings = append(upstream.Warnings, versionWarnings...)
+
+	return upstream, nil
+}
+
+// downloadChartArchive downloads chartVersion of chartName from the repo to helmHome, and returns the
+// path to the archive. The archive is checked against the digest in the index, and its provenance is
+// verified when a keyring is set.
+func downloadChartArchive(i *search.Index, helmHome string, repoURI string, chartName string, chartVersion string, keyring string, fetchOptions *FetchOptions) (string, error) {
 	for _, result := range i.All() {
 		if result.Chart.GetName() != chartName {
 			continue
@@ -106,39 +200,166 @@ func downloadHelm(u *url.URL, repoURI string) (*types.Upstream, error) {
 		dl := downloader.ChartDownloader{
 			HelmHome: helmpath.Home(helmHome),
 			Out:      os.Stdout,
-			Getters:  getter.All(environment.EnvSettings{}),
+			Getters:  helmRepoGetters(fetchOptions),
+		}
+		if keyring != "" {
+			dl.Verify = downloader.VerifyAlways
+			dl.Keyring = keyring
 		}
 
-		archiveDir, err := ioutil.TempDir("", "archive")
+		archiveDir, err := ioutil.TempDir(helmHome, "archive")
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to create archive directory for chart")
+			return "", errors.Wrap(err, "failed to create archive directory for chart")
 		}
-		defer os.RemoveAll(archiveDir)
 
-		chartRef, err := repo.FindChartInRepoURL(repoURI, result.Chart.GetName(), chartVersion, "", "", "", getter.All(environment.EnvSettings{}))
+		chartRef, err := repo.FindChartInRepoURL(repoURI, result.Chart.GetName(), chartVersion, fetchOptions.HelmRepoCertFile, fetchOptions.HelmRepoKeyFile, fetchOptions.HelmRepoCAFile, helmRepoGetters(fetchOptions))
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to find chart in repo url")
+			return "", errors.Wrap(err, "failed to find chart in repo url")
+		}
+
+		// the index can point to a chart on a different host
+		if err := checkAllowedHost(chartRef, fetchOptions.AllowedHosts); err != nil {
+			return "", err
 		}
 
 		_, _, err = dl.DownloadTo(chartRef, result.Chart.GetVersion(), archiveDir)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to download chart")
+			if keyring != "" {
+				provFile := path.Join(archiveDir, fmt.Sprintf("%s-%s.tgz.prov", chartName, chartVersion))
+				signerKey := provenanceSignerKey(provFile)
+				if signerKey == "" {
+					signerKey = "unknown"
+				}
+				return "", errors.Wrapf(err, "failed to download and verify provenance of chart %s %s with keyring %s, signed by key %s", chartName, chartVersion, keyring, signerKey)
+			}
+			return "", errors.Wrap(err, "failed to download chart")
+		}
+
+		chartArchivePath := path.Join(archiveDir, fmt.Sprintf("%s-%s.tgz", chartName, chartVersion))
+		if err := verifyChartDigest(chartArchivePath, result.Chart.Digest); err != nil {
+			return "", errors.Wrap(err, "failed to verify chart digest")
+		}
+
+		return chartArchivePath, nil
+	}
+
+	return "", errors.New("chart version not found")
+}
+
+// provenanceSignerKey returns the id of the key that signed the provenance file, or an empty string if
+// the file can't be read or isn't signed
+func provenanceSignerKey(provFile string) string {
+	b, err := ioutil.ReadFile(provFile)
+	if err != nil {
+		return ""
+	}
+
+	block, _ := clearsign.Decode(b)
+	if block == nil {
+		return ""
+	}
+
+	p, err := packet.Read(block.ArmoredSignature.Body)
+	if err != nil {
+		return ""
+	}
+
+	switch sig := p.(type) {
+	case *packet.Signature:
+		if sig.IssuerKeyId != nil {
+			return fmt.Sprintf("%X", *sig.IssuerKeyId)
+		}
+	case *packet.SignatureV3:
+		return fmt.Sprintf("%X", sig.IssuerKeyId)
+	}
+
+	return ""
+}
+
+// resolveChartVersion picks the chart version to download. An empty version will pick the highest
+// available release, including pre-releases, an exact match is used as is, and anything else is treated
+// as a semver constraint that resolves to the highest matching version. Pre-releases only match
+// constraints that include a pre-release, unless allowPrerelease is set. Versions that aren't semver are
+// skipped, and a warning is returned for each of them.
+func resolveChartVersion(availableVersions []string, versionOrConstraint string, allowPrerelease bool) (string, []string, error) {
+	for _, availableVersion := range availableVersions {
+		if versionOrConstraint != "" && availableVersion == versionOrConstraint {
+			return availableVersion, nil, nil
+		}
+	}
+
+	var constraint *semver.Constraints
+	if versionOrConstraint != "" {
+		c, err := semver.NewConstraint(versionOrConstraint)
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "invalid chart version constraint %q", versionOrConstraint)
 		}
+		constraint = c
+	}
 
-		upstream, err := chartArchiveToSparseUpstream(path.Join(archiveDir, fmt.Sprintf("%s-%s.tgz", chartName, chartVersion)))
+	warnings := []string{}
+	var highestChartVersion *semver.Version
+	for _, availableVersion := range availableVersions {
+		v, err := semver.NewVersion(availableVersion)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to parse chart archive as upstream")
+			warnings = append(warnings, fmt.Sprintf("skipped chart version %q that isn't semver: %s", availableVersion, err.Error()))
+			continue
 		}
 
-		upstream.URI = u.RequestURI()
-		upstream.Name = chartName
-		upstream.UpdateCursor = chartVersion
-		upstream.VersionLabel = chartVersion
+		if constraint != nil {
+			checkVersion := v
+			if allowPrerelease && v.Prerelease() != "" {
+				withoutPrerelease, err := v.SetPrerelease("")
+				if err != nil {
+					return "", warnings, errors.Wrap(err, "failed to remove prerelease from chart version")
+				}
+				checkVersion = &withoutPrerelease
+			}
+
+			if !constraint.Check(checkVersion) {
+				continue
+			}
+		}
+
+		if highestChartVersion == nil || v.GreaterThan(highestChartVersion) {
+			highestChartVersion = v
+		}
+	}
+
+	if highestChartVersion == nil {
+		if versionOrConstraint == "" {
+			return "", warnings, errors.New("no chart versions found")
+		}
+		return "", warnings, errors.Errorf("no chart version matches %q", versionOrConstraint)
+	}
+
+	return highestChartVersion.Original(), warnings, nil
+}
+
+// verifyChartDigest compares the sha256 of the chart archive to the digest
+// published in the repo index. Repos that don't publish a digest are not verified.
+func verifyChartDigest(chartArchivePath string, expectedDigest string) error {
+	if expectedDigest == "" {
+		return nil
+	}
+
+	f, err := os.Open(chartArchivePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to open chart archive")
+	}
+	defer f.Close()
 
-		return upstream, nil
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return errors.Wrap(err, "failed to hash chart archive")
 	}
 
-	return nil, errors.New("chart version not found")
+	actualDigest := hex.EncodeToString(h.Sum(nil))
+	if !strings.EqualFold(actualDigest, expectedDigest) {
+		return errors.Errorf("chart digest mismatch: expected %s, got %s", expectedDigest, actualDigest)
+	}
+
+	return nil
 }
 
 func chartArchiveToSparseUpstream(chartArchivePath string) (*types.Upstream, error) {
@@ -155,7 +376,33 @@ func chartArchiveToSparseUpstream(chartArchivePath string) (*types.Upstream, err
 	return upstream, nil
 }
 
-func helmLoadRepositoriesIndex(helmHome, repoName, repoURI string) (*search.Index, error) {
+// hasHelmRepoAuth returns true if fetchOptions has credentials or tls files for helm repos
+func hasHelmRepoAuth(fetchOptions *FetchOptions) bool {
+	return fetchOptions.HelmRepoUsername != "" || fetchOptions.HelmRepoPassword != "" || fetchOptions.HelmRepoCAFile != "" ||
+		fetchOptions.HelmRepoCertFile != "" || fetchOptions.HelmRepoKeyFile != ""
+}
+
+// helmRepoGetters returns the getters for helm repo indexes and charts, which send the credentials and
+// use the tls files in fetchOptions
+func helmRepoGetters(fetchOptions *FetchOptions) getter.Providers {
+	return getter.Providers{
+		{
+			Schemes: []string{"http", "https"},
+			New: func(URL, CertFile, KeyFile, CAFile string) (getter.Getter, error) {
+				g, err := getter.NewHTTPGetter(URL, fetchOptions.HelmRepoCertFile, fetchOptions.HelmRepoKeyFile, fetchOptions.HelmRepoCAFile)
+				if err != nil {
+					return nil, err
+				}
+				g.SetCredentials(fetchOptions.HelmRepoUsername, fetchOptions.HelmRepoPassword)
+				return g, nil
+			},
+		},
+	}
+}
+
+// helmLoadRepositoriesIndex downloads the index of the repo. When fetchOptions.CacheDir is set, the index
+// is cached there and is only downloaded again when the repo has changed it.
+func helmLoadRepositoriesIndex(helmHome, repoName, repoURI string, fetchOptions *FetchOptions) (*search.Index, error) {
 	if repoURI == "" {
 		repoURI = getKnownHelmRepoURI(repoName)
 	}
@@ -169,13 +416,13 @@ func helmLoadRepositoriesIndex(helmHome, repoName, repoURI string) (*search.Inde
 	}
 	reposFile := filepath.Join(helmHome, "repository", "repositories.yaml")
 
-	repoIndexFile, err := ioutil.TempFile("", "index")
+	repoIndexFile, err := ioutil.TempFile(helmHome, "index")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create temporary index file")
 	}
 	defer os.Remove(repoIndexFile.Name())
 
-	cacheIndexFile, err := ioutil.TempFile("", "cache")
+	cacheIndexFile, err := ioutil.TempFile(helmHome, "cache")
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create cache index file")
 	}
@@ -189,16 +436,27 @@ repositories: []`
 	}
 
 	c := repo.Entry{
-		Name:  repoName,
-		Cache: repoIndexFile.Name(),
-		URL:   repoURI,
+		Name:     repoName,
+		Cache:    repoIndexFile.Name(),
+		URL:      repoURI,
+		Username: fetchOptions.HelmRepoUsername,
+		Password: fetchOptions.HelmRepoPassword,
+		CertFile: fetchOptions.HelmRepoCertFile,
+		KeyFile:  fetchOptions.HelmRepoKeyFile,
+		CAFile:   fetchOptions.HelmRepoCAFile,
 	}
-	r, err := repo.NewChartRepository(&c, getter.All(environment.EnvSettings{}))
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create chart repository")
-	}
-	if err := r.DownloadIndexFile(cacheIndexFile.Name()); err != nil {
-		return nil, errors.Wrap(err, "failed to download index file")
+	if fetchOptions.CacheDir != "" && !hasHelmRepoAuth(fetchOptions) {
+		if err := downloadCachedHelmIndex(repoURI, fetchOptions.CacheDir, repoIndexFile.Name(), fetchOptions.UserAgent); err != nil {
+			return nil, errors.Wrap(err, "failed to download index file")
+		}
+	} else {
+		r, err := repo.NewChartRepository(&c, helmRepoGetters(fetchOptions))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create chart repository")
+		}
+		if err := r.DownloadIndexFile(cacheIndexFile.Name()); err != nil {
+			return nil, errors.Wrap(err, "failed to download index file")
+		}
 	}
 
 	rf, err := repo.LoadRepositoriesFile(reposFile)
@@ -235,6 +493,90 @@ func parseHelmURL(u *url.URL) (string, string, string, error) {
 	return repo, chartName, chartVersion, nil
 }
 
+type helmSource struct {
+	RepoName     string
+	RepoURI      string
+	ChartName    string
+	ChartVersion string
+}
+
+// resolveHelmSource combines the helm uri with the fetch options. The uri can include "version" and "repo"
+// query params, such as helm://mychart?version=1.2.3&repo=https://charts.example.com, and the
+// HelmChartVersion and HelmRepoURI fetch options take precedence over anything in the uri.
+func resolveHelmSource(u *url.URL, fetchOptions *FetchOptions) (*helmSource, error) {
+	repoName, chartName, chartVersion, err := parseHelmURL(u)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse helm url")
+	}
+
+	query := u.Query()
+	if queryVersion := query.Get("version"); queryVersion != "" {
+		if chartVersion != "" && chartVersion != queryVersion {
+			return nil, errors.Errorf("chart version %q conflicts with version query param %q", chartVersion, queryVersion)
+		}
+		chartVersion = queryVersion
+	}
+	repoURI := query.Get("repo")
+
+	// with a repo uri, the chart can be named without a repo, as in helm://mychart?repo=...
+	if chartName == "" {
+		chartName = repoName
+	}
+
+	if fetchOptions.HelmChartVersion != "" {
+		chartVersion = fetchOptions.HelmChartVersion
+	}
+	if fetchOptions.HelmRepoURI != "" {
+		repoURI = fetchOptions.HelmRepoURI
+	}
+	if repoURI == "" {
+		repoURI = getKnownHelmRepoURI(repoName)
+	} else {
+		normalizedRepoURI, err := normalizeHelmRepoURI(repoURI)
+		if err != nil {
+			return nil, err
+		}
+		repoURI = normalizedRepoURI
+	}
+
+	if chartName == "" {
+		return nil, errors.New("helm uri does not include a chart name")
+	}
+
+	source := helmSource{
+		RepoName:     repoName,
+		RepoURI:      repoURI,
+		ChartName:    chartName,
+		ChartVersion: chartVersion,
+	}
+
+	return &source, nil
+}
+
+// normalizeHelmRepoURI checks that repoURI is an http or https url, and removes any trailing slash
+func normalizeHelmRepoURI(repoURI string) (string, error) {
+	if strings.HasPrefix(repoURI, "oci://") {
+		return "", errors.Errorf("helm repo uri %s is an oci registry, only http and https chart repositories are supported", repoURI)
+	}
+	if !strings.Contains(repoURI, "://") {
+		return "", errors.Errorf("helm repo uri %s is missing a scheme, try https://%s", repoURI, repoURI)
+	}
+
+	u, err := url.Parse(repoURI)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid helm repo uri %s", repoURI)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.Errorf("helm repo uri %s has unsupported scheme %q, only http and https are supported", repoURI, u.Scheme)
+	}
+	if u.Host == "" {
+		return "", errors.Errorf("helm repo uri %s does not include a host", repoURI)
+	}
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	return u.String(), nil
+}
+
 func getKnownHelmRepoURI(repoName string) string {
 	val, ok := KnownRepos[repoName]
 	if !ok {
@@ -256,7 +598,11 @@ func readTarGz(source string) ([]types.UpstreamFile, error) {
 		return nil, errors.Wrap(err, "failed to create gzip reader")
 	}
 
-	tarReader := tar.NewReader(gzf)
+	return readTar(gzf)
+}
+
+func readTar(r io.Reader) ([]types.UpstreamFile, error) {
+	tarReader := tar.NewReader(r)
 
 	upstreamFiles := []types.UpstreamFile{}
 	for {
@@ -269,10 +615,13 @@ func readTarGz(source string) ([]types.UpstreamFile, error) {
 			return nil, errors.Wrap(err, "failed to advance in tar archive")
 		}
 
-		name := header.Name
-
 		switch header.Typeflag {
 		case tar.TypeReg:
+			name, err := util.SanitizeArchivePath(header.Name)
+			if err != nil {
+				return nil, err
+			}
+
 			buf := new(bytes.Buffer)
 			_, err = buf.ReadFrom(tarReader)
 			if err != nil {
@@ -281,6 +630,7 @@ func readTarGz(source string) ([]types.UpstreamFile, error) {
 			upstreamFile := types.UpstreamFile{
 				Path:    name,
 				Content: buf.Bytes(),
+				Mode:    header.FileInfo().Mode().Perm(),
 			}
 
 			upstreamFiles = append(upstreamFiles, upstreamFile)
@@ -289,7 +639,11 @@ func readTarGz(source string) ([]types.UpstreamFile, error) {
 		}
 	}
 
-	// remove any common prefix from all files
+	return removeCommonPrefix(upstreamFiles), nil
+}
+
+// removeCommonPrefix strips any directory prefix shared by all files
+func removeCommonPrefix(upstreamFiles []types.UpstreamFile) []types.UpstreamFile {
 	if len(upstreamFiles) > 0 {
 		firstFileDir, _ := path.Split(upstreamFiles[0].Path)
 		commonPrefix := strings.Split(firstFileDir, string(os.PathSeparator))
@@ -317,5 +671,5 @@ func readTarGz(source string) ([]types.UpstreamFile, error) {
 		upstreamFiles = cleanedUpstreamFiles
 	}
 
-	return upstreamFiles, nil
+	return upstreamFiles
 }
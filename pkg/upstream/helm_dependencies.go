@@ -0,0 +1,138 @@
+package upstream
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// chartDependency is a dependency from requirements.yaml, or from Chart.yaml in an apiVersion v2 chart
+type chartDependency struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Repository string `json:"repository"`
+}
+
+type chartDependencies struct {
+	Dependencies []chartDependency `json:"dependencies"`
+}
+
+// listChartDependencies returns the dependencies that are declared by the chart at the root of files
+func listChartDependencies(files []types.UpstreamFile) ([]chartDependency, error) {
+	dependencies := []chartDependency{}
+	for _, file := range files {
+		if file.Path != "requirements.yaml" && file.Path != "Chart.yaml" {
+			continue
+		}
+
+		d := chartDependencies{}
+		if err := yaml.Unmarshal(file.Content, &d); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s", file.Path)
+		}
+		dependencies = append(dependencies, d.Dependencies...)
+	}
+
+	return dependencies, nil
+}
+
+// hasVendoredDependency returns true if the charts directory already has a subchart named name,
+// either as an archive or as a directory
+func hasVendoredDependency(files []types.UpstreamFile, name string) bool {
+	for _, file := range files {
+		if !strings.HasPrefix(file.Path, "charts/") {
+			continue
+		}
+
+		rel := strings.TrimPrefix(file.Path, "charts/")
+		if strings.HasPrefix(rel, name+"/") {
+			return true
+		}
+		// archives are named <name>-<version>.tgz
+		if path.Dir(rel) == "." && strings.HasSuffix(rel, ".tgz") && strings.HasPrefix(rel, name+"-") {
+			version := strings.TrimSuffix(strings.TrimPrefix(rel, name+"-"), ".tgz")
+			if len(version) > 0 && version[0] >= '0' && version[0] <= '9' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addChartDependencies downloads the dependencies of the chart in upstream that aren't already in its
+// charts directory, and adds them to it as archives. The highest version from each dependency's repo
+// that matches its version constraint is used.
+func addChartDependencies(upstream *types.Upstream, helmHome string, fetchOptions *FetchOptions) error {
+	dependencies, err := listChartDependencies(upstream.Files)
+	if err != nil {
+		return errors.Wrap(err, "failed to list chart dependencies")
+	}
+
+	for _, dependency := range dependencies {
+		if hasVendoredDependency(upstream.Files, dependency.Name) {
+			continue
+		}
+
+		archive, version, versionWarnings, err := downloadChartDependency(dependency, helmHome, fetchOptions)
+		upstream.Warnings = append(upstream.Warnings, versionWarnings...)
+		if err != nil {
+			return util.ActionableError{
+				Message: fmt.Sprintf("failed to resolve chart dependency %s %s from %q: %s", dependency.Name, dependency.Version, dependency.Repository, err),
+			}
+		}
+
+		upstream.Files = append(upstream.Files, types.UpstreamFile{
+			Path:    path.Join("charts", fmt.Sprintf("%s-%s.tgz", dependency.Name, version)),
+			Content: archive,
+		})
+	}
+
+	return nil
+}
+
+// downloadChartDependency returns the chart archive and the version that was resolved for dependency, and
+// warnings about versions in the repo that were skipped
+func downloadChartDependency(dependency chartDependency, helmHome string, fetchOptions *FetchOptions) ([]byte, string, []string, error) {
+	if dependency.Repository == "" || strings.HasPrefix(dependency.Repository, "file://") {
+		return nil, "", nil, errors.New("the dependency is not in the chart's charts directory")
+	}
+	if strings.HasPrefix(dependency.Repository, "@") || strings.HasPrefix(dependency.Repository, "alias:") {
+		return nil, "", nil, errors.New("repository aliases are not supported, use the repository url")
+	}
+
+	repoURI, err := normalizeHelmRepoURI(dependency.Repository)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if err := checkAllowedHost(repoURI, fetchOptions.AllowedHosts); err != nil {
+		return nil, "", nil, err
+	}
+
+	i, err := helmLoadRepositoriesIndex(helmHome, dependency.Name, repoURI, fetchOptions)
+	if err != nil {
+		return nil, "", nil, errors.Wrap(err, "failed to load helm repository")
+	}
+
+	version, versionWarnings, err := resolveChartVersion(chartVersions(i, dependency.Name), dependency.Version, fetchOptions.AllowPrerelease)
+	if err != nil {
+		return nil, "", versionWarnings, err
+	}
+
+	// provenance is only verified for the chart that was requested
+	archivePath, err := downloadChartArchive(i, helmHome, repoURI, dependency.Name, version, "", fetchOptions)
+	if err != nil {
+		return nil, "", versionWarnings, err
+	}
+
+	archive, err := ioutil.ReadFile(archivePath)
+	if err != nil {
+		return nil, "", versionWarnings, errors.Wrap(err, "failed to read chart archive")
+	}
+
+	return archive, version, versionWarnings, nil
+}
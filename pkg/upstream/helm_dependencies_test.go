@@ -0,0 +1,129 @@
+package upstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_listChartDependencies(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	files := []types.UpstreamFile{
+		{Path: "Chart.yaml", Content: []byte("apiVersion: v2\nname: app\ndependencies:\n- name: redis\n  version: ~10.0.0\n  repository: https://charts.example.com\n")},
+		{Path: "requirements.yaml", Content: []byte("dependencies:\n- name: postgresql\n  version: 8.x\n  repository: https://charts.example.com\n")},
+		{Path: "charts/redis/requirements.yaml", Content: []byte("dependencies:\n- name: nested\n")},
+	}
+
+	dependencies, err := listChartDependencies(files)
+	req.NoError(err)
+	assert.Equal(t, []chartDependency{
+		{Name: "redis", Version: "~10.0.0", Repository: "https://charts.example.com"},
+		{Name: "postgresql", Version: "8.x", Repository: "https://charts.example.com"},
+	}, dependencies)
+
+	dependencies, err = listChartDependencies([]types.UpstreamFile{{Path: "Chart.yaml", Content: []byte("name: app")}})
+	req.NoError(err)
+	assert.Empty(t, dependencies)
+}
+
+func Test_hasVendoredDependency(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	files := []types.UpstreamFile{
+		{Path: "Chart.yaml"},
+		{Path: "charts/redis-10.0.1.tgz"},
+		{Path: "charts/postgresql/Chart.yaml"},
+		{Path: "charts/memcached-exporter-1.0.0.tgz"},
+	}
+
+	assert.True(t, hasVendoredDependency(files, "redis"))
+	assert.True(t, hasVendoredDependency(files, "postgresql"))
+	assert.True(t, hasVendoredDependency(files, "memcached-exporter"))
+	assert.False(t, hasVendoredDependency(files, "memcached"))
+	assert.False(t, hasVendoredDependency(files, "mysql"))
+}
+
+func Test_addChartDependencies(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	var redisArchive bytes.Buffer
+	gzw := gzip.NewWriter(&redisArchive)
+	tw := tar.NewWriter(gzw)
+	chartYAML := []byte("name: redis\nversion: 10.0.2\n")
+	req.NoError(tw.WriteHeader(&tar.Header{Name: "redis/Chart.yaml", Mode: 0644, Size: int64(len(chartYAML)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write(chartYAML)
+	req.NoError(err)
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index.yaml":
+			fmt.Fprintf(w, `apiVersion: v1
+entries:
+  redis:
+  - name: redis
+    version: 10.0.2
+    urls:
+    - %[1]s/redis-10.0.2.tgz
+  - name: redis
+    version: 10.1.0
+    urls:
+    - %[1]s/redis-10.1.0.tgz
+`, server.URL)
+		case "/redis-10.0.2.tgz":
+			w.Write(redisArchive.Bytes())
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	helmHome, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(helmHome)
+
+	upstream := &types.Upstream{
+		Files: []types.UpstreamFile{
+			{Path: "Chart.yaml", Content: []byte("name: app")},
+			{Path: "requirements.yaml", Content: []byte(fmt.Sprintf("dependencies:\n- name: redis\n  version: ~10.0.0\n  repository: %s\n- name: vendored\n  version: 1.0.0\n  repository: file://../vendored\n", server.URL))},
+			{Path: "charts/vendored/Chart.yaml", Content: []byte("name: vendored")},
+		},
+	}
+
+	err = addChartDependencies(upstream, helmHome, &FetchOptions{})
+	req.NoError(err)
+	req.Len(upstream.Files, 4)
+	assert.Equal(t, "charts/redis-10.0.2.tgz", upstream.Files[3].Path)
+	assert.Equal(t, redisArchive.Bytes(), upstream.Files[3].Content)
+
+	// a version that isn't in the repo names the dependency
+	upstream = &types.Upstream{
+		Files: []types.UpstreamFile{
+			{Path: "requirements.yaml", Content: []byte(fmt.Sprintf("dependencies:\n- name: redis\n  version: ~11.0.0\n  repository: %s\n", server.URL))},
+		},
+	}
+	err = addChartDependencies(upstream, helmHome, &FetchOptions{})
+	req.Error(err)
+	assert.IsType(t, util.ActionableError{}, err)
+	assert.Contains(t, err.Error(), "redis ~11.0.0")
+}
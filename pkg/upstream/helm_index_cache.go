@@ -0,0 +1,166 @@
+package upstream
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// helmIndexValidators are the response headers that a cached helm repo index is revalidated with. The
+// checksum of the index that they were received with pairs them, since the files are written separately.
+type helmIndexValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	IndexSHA256  string `json:"indexSHA256,omitempty"`
+}
+
+// helmIndexCacheDir is where the index of the repo at repoURI is cached in cacheDir
+func helmIndexCacheDir(cacheDir string, repoURI string) string {
+	sum := sha256.Sum256([]byte(repoURI))
+	return filepath.Join(cacheDir, "helm-index", hex.EncodeToString(sum[:]))
+}
+
+// helmIndexURL returns the url of the index.yaml in the repo, in the same way that helm does
+func helmIndexURL(repoURI string) (string, error) {
+	u, err := url.Parse(repoURI)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse repo uri")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/index.yaml"
+	return u.String(), nil
+}
+
+// downloadCachedHelmIndex writes the index of the repo at repoURI to indexFile. The index is cached in
+// cacheDir, and the cached copy is used when the repo responds to a conditional request with a 304.
+func downloadCachedHelmIndex(repoURI string, cacheDir string, indexFile string, userAgent string) error {
+	dir := helmIndexCacheDir(cacheDir, repoURI)
+	cachedIndexFile := filepath.Join(dir, "index.yaml")
+	validatorsFile := filepath.Join(dir, "validators.json")
+
+	cachedIndex, err := ioutil.ReadFile(cachedIndexFile)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to read cached index")
+	}
+
+	// an unreadable validators file, or one that was written with a different index, means that the
+	// cached index is downloaded again
+	validators := helmIndexValidators{}
+	if cachedIndex != nil {
+		if b, err := ioutil.ReadFile(validatorsFile); err == nil {
+			json.Unmarshal(b, &validators)
+		}
+		if validators.IndexSHA256 != helmIndexSHA256(cachedIndex) {
+			validators = helmIndexValidators{}
+		}
+	}
+
+	indexURL, err := helmIndexURL(repoURI)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", indexURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create index request")
+	}
+	setUserAgent(req, userAgent)
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to get index")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && (validators.ETag != "" || validators.LastModified != "") {
+		if err := ioutil.WriteFile(indexFile, cachedIndex, 0644); err != nil {
+			return errors.Wrap(err, "failed to write cached index")
+		}
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return util.NewHTTPStatusError(indexURL, resp)
+	}
+
+	index, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read index")
+	}
+	if err := ioutil.WriteFile(indexFile, index, 0644); err != nil {
+		return errors.Wrap(err, "failed to write index")
+	}
+
+	// the cache can be shared by fetches that run at the same time, so each file is replaced atomically
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "failed to create index cache dir")
+	}
+	if err := writeHelmIndexCacheFile(cachedIndexFile, index); err != nil {
+		return errors.Wrap(err, "failed to cache index")
+	}
+
+	validators = helmIndexValidators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		IndexSHA256:  helmIndexSHA256(index),
+	}
+	if validators.ETag == "" && validators.LastModified == "" {
+		if err := os.Remove(validatorsFile); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to remove cached index validators")
+		}
+		return nil
+	}
+	b, err := json.Marshal(validators)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal cached index validators")
+	}
+	if err := writeHelmIndexCacheFile(validatorsFile, b); err != nil {
+		return errors.Wrap(err, "failed to write cached index validators")
+	}
+
+	return nil
+}
+
+func helmIndexSHA256(index []byte) string {
+	sum := sha256.Sum256(index)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeHelmIndexCacheFile writes a temp file next to filename and renames it into place, so that the
+// file is never read partially written
+func writeHelmIndexCacheFile(filename string, content []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return errors.Wrap(err, "failed to create temp file")
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		return errors.Wrap(err, "failed to write temp file")
+	}
+	if err := tmpFile.Close(); err != nil {
+		return errors.Wrap(err, "failed to close temp file")
+	}
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return errors.Wrap(err, "failed to set temp file mode")
+	}
+	if err := os.Rename(tmpFile.Name(), filename); err != nil {
+		return errors.Wrap(err, "failed to rename temp file")
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package upstream
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_downloadCachedHelmIndex(t *testing.T) {
+	tests := []struct {
+		name        string
+		validator   string
+		conditional string
+	}{
+		{
+			name:        "etag",
+			validator:   "ETag",
+			conditional: "If-None-Match",
+		},
+		{
+			name:        "last modified",
+			validator:   "Last-Modified",
+			conditional: "If-Modified-Since",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			const validatorValue = "Wed, 21 Oct 2015 07:28:00 GMT"
+			downloads := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req.Equal("/charts/index.yaml", r.URL.Path)
+				if r.Header.Get(test.conditional) == validatorValue {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				downloads++
+				w.Header().Set(test.validator, validatorValue)
+				w.Write([]byte("apiVersion: v1\n"))
+			}))
+			defer server.Close()
+
+			cacheDir, err := ioutil.TempDir("", "kots")
+			req.NoError(err)
+			defer os.RemoveAll(cacheDir)
+
+			for i := 0; i < 2; i++ {
+				indexFile := filepath.Join(cacheDir, "index.yaml")
+				err = downloadCachedHelmIndex(server.URL+"/charts/", cacheDir, indexFile, "")
+				req.NoError(err)
+
+				index, err := ioutil.ReadFile(indexFile)
+				req.NoError(err)
+				assert.Equal(t, "apiVersion: v1\n", string(index))
+				os.Remove(indexFile)
+			}
+
+			assert.Equal(t, 1, downloads)
+		})
+	}
+}
+
+func Test_downloadCachedHelmIndexWithoutValidators(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req.Empty(r.Header.Get("If-None-Match"))
+		req.Empty(r.Header.Get("If-Modified-Since"))
+		req.Equal("my-agent/1.0", r.UserAgent())
+		downloads++
+		w.Write([]byte("apiVersion: v1\n"))
+	}))
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(cacheDir)
+
+	for i := 0; i < 2; i++ {
+		err = downloadCachedHelmIndex(server.URL, cacheDir, filepath.Join(cacheDir, "index.yaml"), "my-agent/1.0")
+		req.NoError(err)
+	}
+
+	assert.Equal(t, 2, downloads)
+}
+
+func Test_downloadCachedHelmIndexError(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(cacheDir)
+
+	err = downloadCachedHelmIndex(server.URL, cacheDir, filepath.Join(cacheDir, "index.yaml"), "")
+	req.Error(err)
+	assert.IsType(t, util.HTTPStatusError{}, err)
+}
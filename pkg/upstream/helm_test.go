@@ -1,12 +1,23 @@
 package upstream
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.undefinedlabs.com/scopeagent"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
 )
 
 func Test_parseHelmURL(t *testing.T) {
@@ -50,3 +61,437 @@ func Test_parseHelmURL(t *testing.T) {
 		})
 	}
 }
+
+func Test_resolveHelmSource(t *testing.T) {
+	tests := []struct {
+		name         string
+		uri          string
+		fetchOptions FetchOptions
+		expected     helmSource
+		wantErr      bool
+	}{
+		{
+			name: "uri only",
+			uri:  "helm://mychart?version=1.2.3&repo=https://charts.example.com",
+			expected: helmSource{
+				RepoName:     "mychart",
+				RepoURI:      "https://charts.example.com",
+				ChartName:    "mychart",
+				ChartVersion: "1.2.3",
+			},
+		},
+		{
+			name: "options only",
+			uri:  "helm://stable/mysql",
+			fetchOptions: FetchOptions{
+				HelmRepoURI:      "https://charts.example.com",
+				HelmChartVersion: "1.3.1",
+			},
+			expected: helmSource{
+				RepoName:     "stable",
+				RepoURI:      "https://charts.example.com",
+				ChartName:    "mysql",
+				ChartVersion: "1.3.1",
+			},
+		},
+		{
+			name: "options take precedence over the uri",
+			uri:  "helm://mychart?version=1.2.3&repo=https://charts.example.com",
+			fetchOptions: FetchOptions{
+				HelmRepoURI:      "https://other.example.com",
+				HelmChartVersion: "2.0.0",
+			},
+			expected: helmSource{
+				RepoName:     "mychart",
+				RepoURI:      "https://other.example.com",
+				ChartName:    "mychart",
+				ChartVersion: "2.0.0",
+			},
+		},
+		{
+			name: "known repo",
+			uri:  "helm://stable/mysql?version=1.3.1",
+			expected: helmSource{
+				RepoName:     "stable",
+				RepoURI:      "https://kubernetes-charts.storage.googleapis.com",
+				ChartName:    "mysql",
+				ChartVersion: "1.3.1",
+			},
+		},
+		{
+			name: "trailing slash is removed",
+			uri:  "helm://stable/mysql",
+			fetchOptions: FetchOptions{
+				HelmRepoURI: "https://charts.example.com/stable//",
+			},
+			expected: helmSource{
+				RepoName:  "stable",
+				RepoURI:   "https://charts.example.com/stable",
+				ChartName: "mysql",
+			},
+		},
+		{
+			name: "missing scheme",
+			uri:  "helm://stable/mysql",
+			fetchOptions: FetchOptions{
+				HelmRepoURI: "charts.example.com",
+			},
+			wantErr: true,
+		},
+		{
+			name: "oci registry",
+			uri:  "helm://stable/mysql",
+			fetchOptions: FetchOptions{
+				HelmRepoURI: "oci://registry.example.com/charts",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsupported scheme",
+			uri:  "helm://stable/mysql",
+			fetchOptions: FetchOptions{
+				HelmRepoURI: "ftp://charts.example.com",
+			},
+			wantErr: true,
+		},
+		{
+			name: "malformed uri",
+			uri:  "helm://stable/mysql",
+			fetchOptions: FetchOptions{
+				HelmRepoURI: "https://charts example.com",
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing host",
+			uri:  "helm://stable/mysql",
+			fetchOptions: FetchOptions{
+				HelmRepoURI: "https:///charts",
+			},
+			wantErr: true,
+		},
+		{
+			name:    "conflicting versions in the uri",
+			uri:     "helm://stable/mysql@1.3.1?version=1.4.0",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			u, err := url.ParseRequestURI(test.uri)
+			req.NoError(err)
+
+			actual, err := resolveHelmSource(u, &test.fetchOptions)
+			if test.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+			assert.Equal(t, test.expected, *actual)
+		})
+	}
+}
+
+func Test_resolveChartVersion(t *testing.T) {
+	availableVersions := []string{"1.3.1", "1.4.0", "1.9.2", "2.0.0", "2.1.0", "2.2.0-beta.1"}
+
+	tests := []struct {
+		name                string
+		availableVersions   []string
+		versionOrConstraint string
+		allowPrerelease     bool
+		expected            string
+		expectedWarnings    int
+		wantErr             bool
+	}{
+		{
+			name:                "latest",
+			versionOrConstraint: "",
+			expected:            "2.2.0-beta.1",
+		},
+		{
+			name:                "exact",
+			versionOrConstraint: "1.4.0",
+			expected:            "1.4.0",
+		},
+		{
+			name:                "caret",
+			versionOrConstraint: "^1.0.0",
+			expected:            "1.9.2",
+		},
+		{
+			name:                "range",
+			versionOrConstraint: ">=1.4 <2",
+			expected:            "1.9.2",
+		},
+		{
+			name:                "prerelease allowed",
+			versionOrConstraint: "^2.0.0",
+			allowPrerelease:     true,
+			expected:            "2.2.0-beta.1",
+		},
+		{
+			name:                "no match",
+			versionOrConstraint: "^3.0.0",
+			wantErr:             true,
+		},
+		{
+			name:                "invalid constraint",
+			versionOrConstraint: "not-a-version",
+			wantErr:             true,
+		},
+		{
+			name:                "invalid version in index",
+			availableVersions:   []string{"1.3.1", "latest", "1.4.0"},
+			versionOrConstraint: "^1.0.0",
+			expected:            "1.4.0",
+			expectedWarnings:    1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			versions := availableVersions
+			if test.availableVersions != nil {
+				versions = test.availableVersions
+			}
+
+			actual, warnings, err := resolveChartVersion(versions, test.versionOrConstraint, test.allowPrerelease)
+			if test.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+			assert.Equal(t, test.expected, actual)
+			assert.Len(t, warnings, test.expectedWarnings)
+		})
+	}
+}
+
+func Test_verifyChartDigest(t *testing.T) {
+	archive := []byte("chart archive")
+	sum := sha256.Sum256(archive)
+	digest := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name           string
+		expectedDigest string
+		wantErr        bool
+	}{
+		{
+			name:           "match",
+			expectedDigest: digest,
+		},
+		{
+			name:           "match ignores case",
+			expectedDigest: strings.ToUpper(digest),
+		},
+		{
+			name:           "no digest in the index",
+			expectedDigest: "",
+		},
+		{
+			name:           "mismatch",
+			expectedDigest: strings.Repeat("0", 64),
+			wantErr:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			tmpDir, err := ioutil.TempDir("", "kots")
+			req.NoError(err)
+			defer os.RemoveAll(tmpDir)
+
+			chartArchivePath := filepath.Join(tmpDir, "mychart-1.0.0.tgz")
+			req.NoError(ioutil.WriteFile(chartArchivePath, archive, 0644))
+
+			err = verifyChartDigest(chartArchivePath, test.expectedDigest)
+			if test.wantErr {
+				req.Error(err)
+				assert.Contains(t, err.Error(), "chart digest mismatch")
+				return
+			}
+			req.NoError(err)
+		})
+	}
+}
+
+func Test_downloadChartArchiveBadProvenance(t *testing.T) {
+	// the provenance of the second test is signed by a key that isn't in the keyring
+	signer, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	require.NoError(t, err)
+	var signedProvenance bytes.Buffer
+	signWriter, err := clearsign.Encode(&signedProvenance, signer.PrivateKey, nil)
+	require.NoError(t, err)
+	_, err = signWriter.Write([]byte("name: mychart\n"))
+	require.NoError(t, err)
+	require.NoError(t, signWriter.Close())
+
+	tests := []struct {
+		name      string
+		provFile  []byte
+		signerKey string
+	}{
+		{
+			name:      "not signed",
+			provFile:  []byte("not a signature"),
+			signerKey: "unknown",
+		},
+		{
+			name:      "signed by an unknown key",
+			provFile:  signedProvenance.Bytes(),
+			signerKey: signer.PrimaryKey.KeyIdString(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			indexYAML := `apiVersion: v1
+entries:
+  mychart:
+  - name: mychart
+    version: 1.0.0
+    urls:
+    - mychart-1.0.0.tgz
+`
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/index.yaml":
+					w.Write([]byte(indexYAML))
+				case "/mychart-1.0.0.tgz":
+					w.Write([]byte("chart archive"))
+				case "/mychart-1.0.0.tgz.prov":
+					w.Write(test.provFile)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			helmHome, err := ioutil.TempDir("", "kots")
+			req.NoError(err)
+			defer os.RemoveAll(helmHome)
+
+			entity, err := openpgp.NewEntity("kots", "", "kots@example.com", nil)
+			req.NoError(err)
+			keyring, err := os.Create(filepath.Join(helmHome, "pubring.gpg"))
+			req.NoError(err)
+			req.NoError(entity.Serialize(keyring))
+			req.NoError(keyring.Close())
+
+			i, err := helmLoadRepositoriesIndex(helmHome, "myrepo", server.URL, &FetchOptions{})
+			req.NoError(err)
+
+			_, err = downloadChartArchive(i, helmHome, server.URL, "mychart", "1.0.0", keyring.Name(), &FetchOptions{})
+			req.Error(err)
+			assert.Contains(t, err.Error(), "provenance of chart mychart 1.0.0")
+			assert.Contains(t, err.Error(), "with keyring "+keyring.Name())
+			assert.Contains(t, err.Error(), "signed by key "+test.signerKey)
+		})
+	}
+}
+
+func Test_ListHelmVersions(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	indexYAML := `apiVersion: v1
+entries:
+  mychart:
+  - name: mychart
+    version: 1.2.0
+    urls:
+    - mychart-1.2.0.tgz
+  - name: mychart
+    version: 1.10.0
+    urls:
+    - mychart-1.10.0.tgz
+  - name: mychart
+    version: 2.0.0-beta.1
+    urls:
+    - mychart-2.0.0-beta.1.tgz
+  other:
+  - name: other
+    version: 3.0.0
+    urls:
+    - other-3.0.0.tgz
+`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/charts/index.yaml" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(indexYAML))
+	}))
+	defer server.Close()
+
+	versions, err := ListHelmVersions(server.URL+"/charts/", "mychart", &FetchOptions{})
+	req.NoError(err)
+	assert.Equal(t, []string{"2.0.0-beta.1", "1.10.0", "1.2.0"}, versions)
+
+	_, err = ListHelmVersions(server.URL+"/charts", "missing", &FetchOptions{})
+	req.Error(err)
+	assert.Contains(t, err.Error(), "chart missing not found")
+
+	_, err = ListHelmVersions("charts.example.com", "mychart", &FetchOptions{})
+	req.Error(err)
+}
+
+func Test_ListHelmVersionsAuth(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	indexYAML := `apiVersion: v1
+entries:
+  mychart:
+  - name: mychart
+    version: 1.0.0
+    urls:
+    - mychart-1.0.0.tgz
+`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "user" || password != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(indexYAML))
+	}))
+	defer server.Close()
+
+	_, err := ListHelmVersions(server.URL, "mychart", &FetchOptions{})
+	req.Error(err)
+
+	versions, err := ListHelmVersions(server.URL, "mychart", &FetchOptions{HelmRepoUsername: "user", HelmRepoPassword: "pass"})
+	req.NoError(err)
+	assert.Equal(t, []string{"1.0.0"}, versions)
+}
+
+func Test_sortChartVersions(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	versions := []string{"1.2.0", "latest", "1.10.0", "v0.9.0", "1.10.0-rc.1", "canary"}
+	assert.Equal(t, []string{"1.10.0", "1.10.0-rc.1", "1.2.0", "v0.9.0", "latest", "canary"}, sortChartVersions(versions))
+	assert.Equal(t, "1.2.0", versions[0])
+}
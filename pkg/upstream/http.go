@@ -1,10 +1,232 @@
 package upstream
 
 import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
 	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/retry"
 	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/replicatedhq/kots/pkg/version"
 )
 
-func downloadHttp(httpURI string) (*types.Upstream, error) {
-	return nil, errors.New("downloadHttp not implemented")
+func downloadHttp(httpURI string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	u, err := url.ParseRequestURI(httpURI)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse http uri")
+	}
+
+	// a go-getter style checksum can be included in the uri, it's not sent to the server
+	expectedChecksum := u.Query().Get("checksum")
+	if expectedChecksum != "" {
+		query := u.Query()
+		query.Del("checksum")
+		u.RawQuery = query.Encode()
+		httpURI = u.String()
+	}
+
+	if err := checkAllowedHost(httpURI, fetchOptions.AllowedHosts); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", httpURI, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to call newrequest")
+	}
+
+	setUserAgent(req, fetchOptions.UserAgent)
+	req.Header.Set("Accept", "application/gzip, application/x-tar, application/zip, application/yaml, */*")
+	// setting this disables the transport's own gzip handling, the response is decoded by decodeContent
+	req.Header.Set("Accept-Encoding", acceptEncoding())
+
+	// the client is shared by the retries, so that they can reuse its connections
+	client := upstreamHTTPClient(fetchOptions)
+
+	// only the request is retried, a failure reading the body fails the download
+	var resp *http.Response
+	err = retry.Do(fetchOptions.HTTPRetry, retry.IsTemporary, func() error {
+		r, err := client.Do(req)
+		if err != nil {
+			return errors.Wrap(err, "failed to execute get request")
+		}
+		if r.StatusCode != http.StatusOK {
+			defer r.Body.Close()
+			return errors.Wrap(util.NewHTTPStatusError(httpURI, r), "failed to download upstream")
+		}
+		resp = r
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// the transport encoding is removed before the archive type is detected, and the checksum is of
+	// the decoded content
+	decoded, err := decodeContent(resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode response")
+	}
+	defer decoded.Close()
+
+	// the body is extracted as it's downloaded, and hashed as it passes through
+	hash := sha256.New()
+	body := io.TeeReader(decoded, hash)
+
+	files, err := readFilesFromReader(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read files from response")
+	}
+	files, err = filterFiles(files, fetchOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to filter files")
+	}
+
+	if expectedChecksum != "" {
+		// archive readers can stop before the end of the stream
+		if _, err := io.Copy(ioutil.Discard, body); err != nil {
+			return nil, errors.Wrap(err, "failed to read response body")
+		}
+
+		if err := verifyHttpChecksum(expectedChecksum, hex.EncodeToString(hash.Sum(nil))); err != nil {
+			return nil, errors.Wrap(err, "failed to verify checksum")
+		}
+	}
+
+	upstream := &types.Upstream{
+		URI:   httpURI,
+		Name:  httpUpstreamName(u),
+		Type:  "http",
+		Files: files,
+	}
+
+	return upstream, nil
+}
+
+// contentDecoders are the content encodings that downloadHttp accepts, in order of preference.
+// Another encoding, such as br or deflate, is supported by adding its decoder here.
+var contentDecoders = []struct {
+	encoding  string
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}{
+	{
+		encoding: "gzip",
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+}
+
+func acceptEncoding() string {
+	encodings := []string{}
+	for _, decoder := range contentDecoders {
+		encodings = append(encodings, decoder.encoding)
+	}
+	return strings.Join(encodings, ", ")
+}
+
+// decodeContent returns the response body with the Content-Encoding removed. Encodings are listed in
+// the order they were applied, so they're removed in reverse.
+func decodeContent(resp *http.Response) (io.ReadCloser, error) {
+	body := ioutil.NopCloser(resp.Body)
+	closers := []io.Closer{}
+
+	encodings := strings.Split(resp.Header.Get("Content-Encoding"), ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+		if encoding == "" || encoding == "identity" {
+			continue
+		}
+
+		decoded, err := newContentDecoder(encoding, body)
+		if err != nil {
+			closeAll(closers)
+			return nil, err
+		}
+		closers = append(closers, decoded)
+		body = decoded
+	}
+
+	return readCloser{Reader: body, closers: closers}, nil
+}
+
+func newContentDecoder(encoding string, r io.Reader) (io.ReadCloser, error) {
+	for _, decoder := range contentDecoders {
+		if decoder.encoding == encoding {
+			decoded, err := decoder.newReader(r)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to create %s reader", encoding)
+			}
+			return decoded, nil
+		}
+	}
+	return nil, errors.Errorf("unsupported content encoding %q", encoding)
+}
+
+// readCloser closes each of the decoders, the response body is closed separately
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r readCloser) Close() error {
+	return closeAll(r.closers)
+}
+
+func closeAll(closers []io.Closer) error {
+	var firstErr error
+	for _, closer := range closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func verifyHttpChecksum(expectedChecksum string, actualSHA256 string) error {
+	checksumType, checksumValue := "sha256", expectedChecksum
+	if parts := strings.SplitN(expectedChecksum, ":", 2); len(parts) == 2 {
+		checksumType, checksumValue = parts[0], parts[1]
+	}
+
+	if checksumType != "sha256" {
+		return errors.Errorf("unsupported checksum type %q", checksumType)
+	}
+
+	if !strings.EqualFold(checksumValue, actualSHA256) {
+		return errors.Errorf("checksum mismatch: expected %s, got %s", checksumValue, actualSHA256)
+	}
+
+	return nil
+}
+
+// httpUpstreamName uses the last path element, without any archive extension, as the name
+func httpUpstreamName(u *url.URL) string {
+	name := path.Base(u.Path)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip", ".yaml", ".yml"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+
+	if name == "" || name == "." || name == "/" {
+		return u.Hostname()
+	}
+
+	return name
+}
+
+// setUserAgent sets the user agent of an outbound request to userAgent, or to the kots user agent when
+// it's empty
+func setUserAgent(req *http.Request, userAgent string) {
+	if userAgent == "" {
+		userAgent = version.UserAgent()
+	}
+	req.Header.Set("User-Agent", userAgent)
 }
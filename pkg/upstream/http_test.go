@@ -0,0 +1,217 @@
+package upstream
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/replicatedhq/kots/pkg/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_downloadHttp(t *testing.T) {
+	req := require.New(t)
+
+	var tarGz bytes.Buffer
+	gzw := gzip.NewWriter(&tarGz)
+	tw := tar.NewWriter(gzw)
+	content := []byte("apiVersion: v1\nkind: ConfigMap\n")
+	req.NoError(tw.WriteHeader(&tar.Header{Name: "app/configmap.yaml", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write(content)
+	req.NoError(err)
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+
+	sum := sha256.Sum256(tarGz.Bytes())
+	checksum := hex.EncodeToString(sum[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("checksum") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write(tarGz.Bytes())
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		uri     string
+		wantErr bool
+	}{
+		{
+			name: "no checksum",
+			uri:  server.URL + "/app.tar.gz",
+		},
+		{
+			name: "matching checksum",
+			uri:  server.URL + "/app.tar.gz?checksum=sha256:" + checksum,
+		},
+		{
+			name:    "mismatched checksum",
+			uri:     server.URL + "/app.tar.gz?checksum=sha256:0000",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			u, err := downloadHttp(test.uri, &FetchOptions{})
+			if test.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+
+			assert.Equal(t, "app", u.Name)
+			req.Len(u.Files, 1)
+			assert.Equal(t, "configmap.yaml", u.Files[0].Path)
+			assert.Equal(t, content, u.Files[0].Content)
+		})
+	}
+}
+
+func Test_downloadHttpContentEncoding(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	var tarGz bytes.Buffer
+	gzw := gzip.NewWriter(&tarGz)
+	tw := tar.NewWriter(gzw)
+	content := []byte("apiVersion: v1\nkind: ConfigMap\n")
+	req.NoError(tw.WriteHeader(&tar.Header{Name: "app/configmap.yaml", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write(content)
+	req.NoError(err)
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+
+	gzipEncode := func(b []byte) []byte {
+		var encoded bytes.Buffer
+		gzw := gzip.NewWriter(&encoded)
+		_, err := gzw.Write(b)
+		req.NoError(err)
+		req.NoError(gzw.Close())
+		return encoded.Bytes()
+	}
+
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+		wantPath        string
+		wantErr         bool
+	}{
+		{
+			name:            "gzip encoded manifest",
+			contentEncoding: "gzip",
+			body:            gzipEncode(content),
+			wantPath:        "manifest.yaml",
+		},
+		{
+			name:            "gzip encoded archive",
+			contentEncoding: "gzip",
+			body:            gzipEncode(tarGz.Bytes()),
+			wantPath:        "configmap.yaml",
+		},
+		{
+			name:     "server ignores accept encoding",
+			body:     tarGz.Bytes(),
+			wantPath: "configmap.yaml",
+		},
+		{
+			name:            "identity",
+			contentEncoding: "identity",
+			body:            content,
+			wantPath:        "manifest.yaml",
+		},
+		{
+			name:            "unsupported encoding",
+			contentEncoding: "br",
+			body:            content,
+			wantErr:         true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "gzip", r.Header.Get("Accept-Encoding"))
+				if test.contentEncoding != "" {
+					w.Header().Set("Content-Encoding", test.contentEncoding)
+				}
+				w.Write(test.body)
+			}))
+			defer server.Close()
+
+			u, err := downloadHttp(server.URL+"/configmap.yaml", &FetchOptions{})
+			if test.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+
+			req.Len(u.Files, 1)
+			assert.Equal(t, test.wantPath, u.Files[0].Path)
+			assert.Equal(t, content, u.Files[0].Content)
+		})
+	}
+}
+
+func Test_downloadHttpRetry(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	content := []byte("apiVersion: v1\nkind: ConfigMap\n")
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/missing"):
+			w.WriteHeader(http.StatusNotFound)
+		case requests < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write(content)
+		}
+	}))
+	defer server.Close()
+
+	retryOptions := retry.Options{MaxAttempts: 3, InitialInterval: time.Millisecond}
+
+	u, err := downloadHttp(server.URL+"/manifest.yaml", &FetchOptions{HTTPRetry: retryOptions})
+	req.NoError(err)
+	assert.Equal(t, 3, requests)
+	req.Len(u.Files, 1)
+	assert.Equal(t, content, u.Files[0].Content)
+
+	// not found isn't retried
+	requests = 0
+	_, err = downloadHttp(server.URL+"/missing", &FetchOptions{HTTPRetry: retryOptions})
+	req.Error(err)
+	assert.Equal(t, 1, requests)
+
+	// or anything without retry options
+	requests = 0
+	_, err = downloadHttp(server.URL+"/manifest.yaml", &FetchOptions{})
+	req.Error(err)
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,48 @@
+package upstream
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	kotslicense "github.com/replicatedhq/kots/pkg/license"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// enforceLicense returns an actionable error if the license expired before now, or doesn't have an
+// entitlement that the application requires. A boolean entitlement also has to be true.
+func enforceLicense(license *kotsv1beta1.License, application *kotsv1beta1.Application, now time.Time) error {
+	if license == nil {
+		return util.ActionableError{Message: "A license is required to enforce it"}
+	}
+
+	expiration, err := kotslicense.GetExpiration(license)
+	if err != nil {
+		return errors.Wrap(err, "failed to get license expiration")
+	}
+	if expiration != nil && expiration.Before(now) {
+		return util.ActionableError{Message: fmt.Sprintf("License expired on %s", expiration.Format("2006-01-02"))}
+	}
+
+	if application == nil {
+		return nil
+	}
+
+	missing := []string{}
+	for _, name := range application.Spec.RequiredEntitlements {
+		entitlement, ok := license.Spec.Entitlements[name]
+		if !ok || (entitlement.Value.Type == kotsv1beta1.Bool && !entitlement.Value.BoolVal) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 1 {
+		return util.ActionableError{Message: fmt.Sprintf("License is missing entitlement %s, which the application requires", missing[0])}
+	}
+	if len(missing) > 1 {
+		return util.ActionableError{Message: fmt.Sprintf("License is missing entitlements %s, which the application requires", strings.Join(missing, ", "))}
+	}
+
+	return nil
+}
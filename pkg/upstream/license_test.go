@@ -0,0 +1,101 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_enforceLicense(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name                 string
+		entitlements         map[string]kotsv1beta1.EntitlementField
+		requiredEntitlements []string
+		expectError          string
+	}{
+		{
+			name: "no expiration",
+		},
+		{
+			name: "not expired",
+			entitlements: map[string]kotsv1beta1.EntitlementField{
+				"expires_at": stringEntitlement("2020-06-02T00:00:00Z"),
+			},
+		},
+		{
+			name: "expired",
+			entitlements: map[string]kotsv1beta1.EntitlementField{
+				"expires_at": stringEntitlement("2019-02-06T08:00:00Z"),
+			},
+			expectError: "License expired on 2019-02-06",
+		},
+		{
+			name: "required entitlements",
+			entitlements: map[string]kotsv1beta1.EntitlementField{
+				"seats":        {Value: kotsv1beta1.EntitlementValue{Type: kotsv1beta1.Int, IntVal: 10}},
+				"sso_enabled":  {Value: kotsv1beta1.EntitlementValue{Type: kotsv1beta1.Bool, BoolVal: true}},
+				"support_tier": stringEntitlement("gold"),
+			},
+			requiredEntitlements: []string{"seats", "sso_enabled", "support_tier"},
+		},
+		{
+			name: "missing entitlement",
+			entitlements: map[string]kotsv1beta1.EntitlementField{
+				"seats": {Value: kotsv1beta1.EntitlementValue{Type: kotsv1beta1.Int, IntVal: 10}},
+			},
+			requiredEntitlements: []string{"seats", "sso_enabled"},
+			expectError:          "License is missing entitlement sso_enabled, which the application requires",
+		},
+		{
+			name: "false and missing entitlements",
+			entitlements: map[string]kotsv1beta1.EntitlementField{
+				"sso_enabled": {Value: kotsv1beta1.EntitlementValue{Type: kotsv1beta1.Bool, BoolVal: false}},
+			},
+			requiredEntitlements: []string{"sso_enabled", "seats"},
+			expectError:          "License is missing entitlements sso_enabled, seats, which the application requires",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			license := &kotsv1beta1.License{
+				Spec: kotsv1beta1.LicenseSpec{
+					Entitlements: test.entitlements,
+				},
+			}
+			application := &kotsv1beta1.Application{
+				Spec: kotsv1beta1.ApplicationSpec{
+					RequiredEntitlements: test.requiredEntitlements,
+				},
+			}
+
+			err := enforceLicense(license, application, now)
+			if test.expectError == "" {
+				req.NoError(err)
+				return
+			}
+
+			req.Error(err)
+			assert.IsType(t, util.ActionableError{}, err)
+			assert.Equal(t, test.expectError, err.Error())
+		})
+	}
+}
+
+func stringEntitlement(value string) kotsv1beta1.EntitlementField {
+	return kotsv1beta1.EntitlementField{
+		Value:     kotsv1beta1.EntitlementValue{Type: kotsv1beta1.String, StrVal: value},
+		ValueType: "String",
+	}
+}
@@ -0,0 +1,58 @@
+package upstream
+
+import (
+	"path"
+	"strings"
+
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// userdataDir holds the files that kots adds to an upstream, rather than manifests from it
+const userdataDir = "userdata"
+
+// filterManifestPaths keeps only the upstream files that are one of manifestPaths, or are in a directory
+// that is. Paths are relative to the root of the upstream. An error is returned if any of the paths
+// doesn't match a file, so that a typo doesn't silently leave manifests out. Files in userdata/, such
+// as the license and config values of a replicated upstream, are always kept.
+func filterManifestPaths(upstream *types.Upstream, manifestPaths []string) error {
+	if len(manifestPaths) == 0 {
+		return nil
+	}
+
+	cleanPaths := []string{}
+	for _, manifestPath := range manifestPaths {
+		cleanPath := strings.TrimPrefix(path.Clean("/"+manifestPath), "/")
+		cleanPaths = append(cleanPaths, cleanPath)
+	}
+
+	matched := make([]bool, len(cleanPaths))
+	files := []types.UpstreamFile{}
+	for _, file := range upstream.Files {
+		keep := strings.HasPrefix(file.Path, userdataDir+"/")
+		for i, cleanPath := range cleanPaths {
+			if cleanPath == "" || file.Path == cleanPath || strings.HasPrefix(file.Path, cleanPath+"/") {
+				matched[i] = true
+				keep = true
+			}
+		}
+		if keep {
+			files = append(files, file)
+		}
+	}
+
+	unmatched := []string{}
+	for i, manifestPath := range manifestPaths {
+		if !matched[i] {
+			unmatched = append(unmatched, manifestPath)
+		}
+	}
+	if len(unmatched) > 0 {
+		return util.ActionableError{
+			Message: "no upstream files were found at the manifest paths " + strings.Join(unmatched, ", "),
+		}
+	}
+
+	upstream.Files = files
+	return nil
+}
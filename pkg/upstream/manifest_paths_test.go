@@ -0,0 +1,128 @@
+package upstream
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_filterManifestPaths(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "kots-manifest-paths")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	// a repo with manifests for several apps, pushed to a bare repo
+	srcDir := filepath.Join(tmpDir, "src")
+	files := map[string]string{
+		"README.md":                              "# manifests",
+		"apps/web/deployment.yaml":               "kind: Deployment",
+		"apps/web/service.yaml":                  "kind: Service",
+		"apps/web-admin/deployment.yaml":         "kind: Deployment",
+		"apps/worker/deployment.yaml":            "kind: Deployment",
+		"cluster/namespace.yaml":                 "kind: Namespace",
+		"cluster/monitoring/servicemonitor.yaml": "kind: ServiceMonitor",
+	}
+	for name, content := range files {
+		req.NoError(os.MkdirAll(filepath.Dir(filepath.Join(srcDir, name)), 0755))
+		req.NoError(ioutil.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644))
+	}
+	_, err = runGit(srcDir, "init", "--quiet")
+	req.NoError(err)
+	_, err = runGit(srcDir, "add", ".")
+	req.NoError(err)
+	_, err = runGit(srcDir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "manifests")
+	req.NoError(err)
+
+	bareDir := filepath.Join(tmpDir, "manifests.git")
+	_, err = runGit(tmpDir, "clone", "--quiet", "--bare", srcDir, bareDir)
+	req.NoError(err)
+	repoURL := "file://" + bareDir
+
+	tests := []struct {
+		name          string
+		manifestPaths []string
+		expected      []string
+	}{
+		{
+			name:          "directory",
+			manifestPaths: []string{"apps/web"},
+			expected:      []string{"apps/web/deployment.yaml", "apps/web/service.yaml"},
+		},
+		{
+			name:          "directory with a trailing slash",
+			manifestPaths: []string{"./cluster/"},
+			expected:      []string{"cluster/monitoring/servicemonitor.yaml", "cluster/namespace.yaml"},
+		},
+		{
+			name:          "exact file",
+			manifestPaths: []string{"apps/worker/deployment.yaml"},
+			expected:      []string{"apps/worker/deployment.yaml"},
+		},
+		{
+			name:          "directory and file",
+			manifestPaths: []string{"apps/web-admin", "cluster/namespace.yaml"},
+			expected:      []string{"apps/web-admin/deployment.yaml", "cluster/namespace.yaml"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			upstream, err := downloadGit(&GitSource{RepoURL: repoURL}, &FetchOptions{TempDir: tmpDir})
+			req.NoError(err)
+			req.NoError(filterManifestPaths(upstream, test.manifestPaths))
+
+			actual := []string{}
+			for _, file := range upstream.Files {
+				actual = append(actual, file.Path)
+				assert.Equal(t, files[file.Path], string(file.Content))
+			}
+			assert.ElementsMatch(t, test.expected, actual)
+		})
+	}
+
+	// a path that doesn't match anything fails, even when other paths do
+	upstream, err := downloadGit(&GitSource{RepoURL: repoURL}, &FetchOptions{TempDir: tmpDir})
+	req.NoError(err)
+	err = filterManifestPaths(upstream, []string{"apps/web", "apps/we", "apps/api"})
+	req.Error(err)
+	assert.IsType(t, util.ActionableError{}, err)
+	assert.Contains(t, err.Error(), "apps/we, apps/api")
+	assert.Len(t, upstream.Files, len(files))
+}
+
+func Test_filterManifestPathsKeepsUserdata(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	upstream := &types.Upstream{
+		Files: []types.UpstreamFile{
+			{Path: "deployment.yaml"},
+			{Path: "extras/configmap.yaml"},
+			{Path: "userdata/license.yaml"},
+			{Path: "userdata/config.yaml"},
+		},
+	}
+	req.NoError(filterManifestPaths(upstream, []string{"deployment.yaml"}))
+
+	actual := []string{}
+	for _, file := range upstream.Files {
+		actual = append(actual, file.Path)
+	}
+	assert.Equal(t, []string{"deployment.yaml", "userdata/license.yaml", "userdata/config.yaml"}, actual)
+}
@@ -0,0 +1,168 @@
+package upstream
+
+import (
+	"context"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/logger"
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// FetchUpstreamMulti fetches each of upstreamURIs in order with FetchUpstream, and merges their files
+// into a single upstream. This allows a chart to be combined with local patches without merging them
+// on disk first.
+//
+// Files are matched by their cleaned, slash separated path. When more than one upstream has a file at
+// the same path, the file from the last of them is kept, in the position of the first. Files that are
+// only in later upstreams are added after the files of the upstreams before them. The uri, name, type,
+// cursor, version and resolved fields come from the first upstream, and the warnings of all of them
+// are kept.
+//
+// Upstreams are fetched in parallel when MaxConcurrentFetches is more than 1, and are merged in the same
+// order either way. SnapshotDir can't be used then, and OnComplete is never called concurrently.
+func FetchUpstreamMulti(upstreamURIs []string, fetchOptions *FetchOptions) (*types.Upstream, error) {
+	if len(upstreamURIs) == 0 {
+		return nil, errors.New("no upstream uris to fetch")
+	}
+
+	log := fetchOptions.Log
+	if log == nil {
+		log = logger.NewLogger()
+	}
+
+	// each fetch would replace the snapshot of the others
+	concurrency := fetchConcurrency(fetchOptions, len(upstreamURIs))
+	if concurrency > 1 && fetchOptions.SnapshotDir != "" {
+		return nil, util.ActionableError{
+			Message: "A snapshot dir can't be used when upstreams are fetched in parallel. Set the max concurrent fetches to 1, or fetch without a snapshot dir.",
+		}
+	}
+
+	results := fetchUpstreamsConcurrently(upstreamURIs, fetchOptions, concurrency)
+
+	// fetches that were skipped because another one failed aren't reported
+	for i, result := range results {
+		if result.Err != nil && result.Err != context.Canceled {
+			return nil, errors.Wrapf(result.Err, "failed to fetch upstream %s", redactURI(upstreamURIs[i]))
+		}
+	}
+
+	upstreams := []*types.Upstream{}
+	for _, result := range results {
+		upstreams = append(upstreams, result.Upstream)
+	}
+
+	return mergeUpstreams(upstreams, log), nil
+}
+
+// maxConcurrentFetches caps the number of upstreams that are fetched at once, so that a long list of
+// upstreams on the same server doesn't overwhelm it
+const maxConcurrentFetches = 8
+
+type upstreamFetchResult struct {
+	Upstream *types.Upstream
+	Err      error
+}
+
+func fetchConcurrency(fetchOptions *FetchOptions, upstreamCount int) int {
+	concurrency := fetchOptions.MaxConcurrentFetches
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if concurrency > maxConcurrentFetches {
+		concurrency = maxConcurrentFetches
+	}
+	if concurrency > upstreamCount {
+		concurrency = upstreamCount
+	}
+
+	return concurrency
+}
+
+// fetchUpstreamsConcurrently fetches the upstreams with a bounded pool of workers. The results are in the
+// same order as the uris. After a fetch fails, the fetches that haven't started yet are skipped, and
+// their results have the context error. FetchUpstream can't be cancelled, so fetches that are in
+// progress run to completion.
+func fetchUpstreamsConcurrently(upstreamURIs []string, fetchOptions *FetchOptions, concurrency int) []upstreamFetchResult {
+	results := make([]upstreamFetchResult, len(upstreamURIs))
+
+	// the workers share the fetch options, so the callback is serialized
+	if fetchOptions.OnComplete != nil && concurrency > 1 {
+		onComplete := fetchOptions.OnComplete
+		var onCompleteMu sync.Mutex
+		workerOptions := *fetchOptions
+		workerOptions.OnComplete = func(scheme string, bytes int64, duration time.Duration, err error) {
+			onCompleteMu.Lock()
+			defer onCompleteMu.Unlock()
+			onComplete(scheme, bytes, duration, err)
+		}
+		fetchOptions = &workerOptions
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				if err := ctx.Err(); err != nil {
+					results[idx] = upstreamFetchResult{Err: err}
+					continue
+				}
+				upstream, err := FetchUpstream(upstreamURIs[idx], fetchOptions)
+				results[idx] = upstreamFetchResult{Upstream: upstream, Err: err}
+				if err != nil {
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for idx := range upstreamURIs {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+
+	return results
+}
+
+// mergeUpstreams merges the files of upstreams, as described by FetchUpstreamMulti
+func mergeUpstreams(upstreams []*types.Upstream, log logger.Interface) *types.Upstream {
+	merged := *upstreams[0]
+	merged.Files = []types.UpstreamFile{}
+	merged.Warnings = []string{}
+
+	// the index of each path in merged.Files, and the upstream that it came from
+	fileIndexes := map[string]int{}
+	fileSources := map[string]string{}
+	for _, upstream := range upstreams {
+		for _, file := range upstream.Files {
+			filePath := path.Clean(file.Path)
+			file.Path = filePath
+
+			if idx, ok := fileIndexes[filePath]; ok {
+				log.Debug("Upstream %s overrides %s from upstream %s", redactURI(upstream.URI), filePath, redactURI(fileSources[filePath]))
+				merged.Files[idx] = file
+				fileSources[filePath] = upstream.URI
+				continue
+			}
+
+			fileIndexes[filePath] = len(merged.Files)
+			fileSources[filePath] = upstream.URI
+			merged.Files = append(merged.Files, file)
+		}
+
+		merged.Warnings = append(merged.Warnings, upstream.Warnings...)
+	}
+
+	return &merged
+}
@@ -0,0 +1,186 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/replicatedhq/kots/pkg/logger"
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_FetchUpstreamMulti(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	chartDir, err := ioutil.TempDir("", "chart")
+	req.NoError(err)
+	defer os.RemoveAll(chartDir)
+
+	patchesDir, err := ioutil.TempDir("", "patches")
+	req.NoError(err)
+	defer os.RemoveAll(patchesDir)
+
+	chartFiles := map[string]string{
+		"deployment.yaml": "kind: Deployment",
+		"service.yaml":    "kind: Service",
+	}
+	for name, content := range chartFiles {
+		req.NoError(ioutil.WriteFile(filepath.Join(chartDir, name), []byte(content), 0644))
+	}
+
+	patchFiles := map[string]string{
+		"configmap.yaml": "kind: ConfigMap",
+		"service.yaml":   "kind: Service\nspec: {}",
+	}
+	for name, content := range patchFiles {
+		req.NoError(ioutil.WriteFile(filepath.Join(patchesDir, name), []byte(content), 0644))
+	}
+
+	u, err := FetchUpstreamMulti([]string{chartDir, patchesDir}, &FetchOptions{})
+	req.NoError(err)
+
+	assert.Equal(t, chartDir, u.URI)
+	assert.Equal(t, filepath.Base(chartDir), u.Name)
+
+	got := map[string]string{}
+	for _, file := range u.Files {
+		got[file.Path] = string(file.Content)
+	}
+	assert.Equal(t, map[string]string{
+		"configmap.yaml":  "kind: ConfigMap",
+		"deployment.yaml": "kind: Deployment",
+		"service.yaml":    "kind: Service\nspec: {}",
+	}, got)
+
+	_, err = FetchUpstreamMulti([]string{}, &FetchOptions{})
+	req.Error(err)
+
+	_, err = FetchUpstreamMulti([]string{chartDir, filepath.Join(patchesDir, "missing")}, &FetchOptions{})
+	req.Error(err)
+}
+
+func Test_FetchUpstreamMultiConcurrent(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	root, err := ioutil.TempDir("", "upstreams")
+	req.NoError(err)
+	defer os.RemoveAll(root)
+
+	// every upstream overrides shared.yaml, so the merge order decides which one is kept
+	upstreamURIs := []string{}
+	for i := 0; i < 12; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("upstream-%d", i))
+		req.NoError(os.MkdirAll(dir, 0755))
+		req.NoError(ioutil.WriteFile(filepath.Join(dir, "shared.yaml"), []byte(dir), 0644))
+		req.NoError(ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%d.yaml", i)), []byte(dir), 0644))
+		upstreamURIs = append(upstreamURIs, dir)
+	}
+
+	serial, err := FetchUpstreamMulti(upstreamURIs, &FetchOptions{})
+	req.NoError(err)
+
+	concurrent, err := FetchUpstreamMulti(upstreamURIs, &FetchOptions{MaxConcurrentFetches: 4})
+	req.NoError(err)
+
+	assert.Equal(t, serial.Files, concurrent.Files)
+	assert.Equal(t, upstreamURIs[0], concurrent.URI)
+	for _, file := range concurrent.Files {
+		if file.Path == "shared.yaml" {
+			assert.Equal(t, upstreamURIs[11], string(file.Content))
+		}
+	}
+
+	// the callback isn't called concurrently, so it doesn't need to lock
+	completed := 0
+	_, err = FetchUpstreamMulti(upstreamURIs, &FetchOptions{
+		MaxConcurrentFetches: 4,
+		OnComplete: func(scheme string, bytes int64, duration time.Duration, err error) {
+			completed++
+		},
+	})
+	req.NoError(err)
+	assert.Equal(t, len(upstreamURIs), completed)
+
+	_, err = FetchUpstreamMulti(upstreamURIs, &FetchOptions{MaxConcurrentFetches: 4, SnapshotDir: filepath.Join(root, "snapshot")})
+	req.Error(err)
+	assert.IsType(t, util.ActionableError{}, err)
+
+	missing := filepath.Join(root, "missing")
+	_, err = FetchUpstreamMulti(append(upstreamURIs, missing), &FetchOptions{MaxConcurrentFetches: 4})
+	req.Error(err)
+	assert.True(t, strings.Contains(err.Error(), missing))
+}
+
+func Test_fetchConcurrency(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	assert.Equal(t, 1, fetchConcurrency(&FetchOptions{}, 5))
+	assert.Equal(t, 3, fetchConcurrency(&FetchOptions{MaxConcurrentFetches: 3}, 5))
+	assert.Equal(t, 2, fetchConcurrency(&FetchOptions{MaxConcurrentFetches: 3}, 2))
+	assert.Equal(t, maxConcurrentFetches, fetchConcurrency(&FetchOptions{MaxConcurrentFetches: 100}, 100))
+}
+
+func Test_fetchUpstreamsConcurrentlySkipsAfterError(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	dir, err := ioutil.TempDir("", "upstream")
+	req.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// with a single worker, the fetches after the failed one never start
+	results := fetchUpstreamsConcurrently([]string{filepath.Join(dir, "missing"), dir, dir}, &FetchOptions{}, 1)
+	req.Len(results, 3)
+	req.Error(results[0].Err)
+	assert.Equal(t, context.Canceled, results[1].Err)
+	assert.Equal(t, context.Canceled, results[2].Err)
+}
+
+func Test_mergeUpstreamsOrder(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	first := upstreamWithFiles("first", "a.yaml", "b.yaml")
+	first.Warnings = []string{"first warning"}
+	second := upstreamWithFiles("second", "c.yaml", "./a.yaml")
+	second.Warnings = []string{"second warning"}
+	third := upstreamWithFiles("third", "b.yaml")
+
+	merged := mergeUpstreams([]*types.Upstream{first, second, third}, logger.NewLogger())
+
+	// overrides keep the position of the first file at that path
+	assert.Equal(t, []types.UpstreamFile{
+		{Path: "a.yaml", Content: []byte("second")},
+		{Path: "b.yaml", Content: []byte("third")},
+		{Path: "c.yaml", Content: []byte("second")},
+	}, merged.Files)
+	assert.Equal(t, "first", merged.URI)
+	assert.Equal(t, []string{"first warning", "second warning"}, merged.Warnings)
+
+	// the upstreams that were merged aren't changed
+	assert.Equal(t, "./a.yaml", second.Files[1].Path)
+	assert.Equal(t, []byte("first"), first.Files[0].Content)
+}
+
+func upstreamWithFiles(uri string, paths ...string) *types.Upstream {
+	u := &types.Upstream{URI: uri}
+	for _, p := range paths {
+		u.Files = append(u.Files, types.UpstreamFile{Path: p, Content: []byte(uri)})
+	}
+	return u
+}
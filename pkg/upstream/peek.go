@@ -31,14 +31,14 @@ func getUpdatesUpstream(upstreamURI string, fetchOptions *FetchOptions) ([]Updat
 		return nil, errors.Wrap(err, "parse request uri failed")
 	}
 	if u.Scheme == "helm" {
-		return getUpdatesHelm(u, fetchOptions.HelmRepoURI)
+		return getUpdatesHelm(u, fetchOptions)
 	}
 	if u.Scheme == "replicated" {
 		cursor := ReplicatedCursor{
 			ChannelName: fetchOptions.CurrentChannel,
 			Cursor:      fetchOptions.CurrentCursor,
 		}
-		return getUpdatesReplicated(u, fetchOptions.LocalPath, cursor, fetchOptions.CurrentVersionLabel, fetchOptions.License)
+		return getUpdatesReplicated(u, fetchOptions.LocalPath, cursor, fetchOptions.CurrentVersionLabel, fetchOptions.License, fetchOptions.UserAgent)
 	}
 	if u.Scheme == "git" {
 		// return getUpdatesGit(upstreamURI)
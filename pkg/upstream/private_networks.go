@@ -0,0 +1,112 @@
+package upstream
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// privateNetworks are the networks that FetchOptions.BlockPrivateNetworks refuses to connect to:
+// loopback, link-local (which includes cloud metadata endpoints), private and shared address space
+var privateNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+// PrivateNetworkError is returned when FetchOptions.BlockPrivateNetworks prevents a connection
+type PrivateNetworkError struct {
+	Address string
+}
+
+func (e PrivateNetworkError) Error() string {
+	return fmt.Sprintf("refusing to connect to %s, which is in a private network", e.Address)
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockPrivateNetworks is a net.Dialer control function that fails connections to private networks.
+// It's called with the address that was resolved for the connection, so a host that resolves to a
+// different address on a later lookup can't get around it.
+func blockPrivateNetworks(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrap(err, "failed to split host and port")
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errors.Errorf("failed to parse ip address %s", host)
+	}
+	if isPrivateIP(ip) {
+		return PrivateNetworkError{Address: address}
+	}
+
+	return nil
+}
+
+// upstreamHTTPClient returns the http client to fetch upstreams with. Redirects are subject to the same
+// restrictions as the first request.
+func upstreamHTTPClient(fetchOptions *FetchOptions) *http.Client {
+	if len(fetchOptions.AllowedHosts) == 0 && !fetchOptions.BlockPrivateNetworks {
+		return http.DefaultClient
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return checkAllowedHost(req.URL.String(), fetchOptions.AllowedHosts)
+		},
+	}
+
+	if fetchOptions.BlockPrivateNetworks {
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   blockPrivateNetworks,
+		}
+		// proxies aren't used, since a proxy would connect to the upstream without the check
+		client.Transport = &http.Transport{
+			DialContext:           dialer.DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		}
+	}
+
+	return client
+}
@@ -0,0 +1,73 @@
+package upstream
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_isPrivateIP(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "172.20.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fe80::1", "fd00::1", "::ffff:169.254.169.254"} {
+		assert.True(t, isPrivateIP(net.ParseIP(ip)), ip)
+	}
+	for _, ip := range []string{"8.8.8.8", "172.32.0.1", "2606:4700::1111"} {
+		assert.False(t, isPrivateIP(net.ParseIP(ip)), ip)
+	}
+}
+
+func Test_downloadHttpBlockPrivateNetworks(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	// the test server is on loopback
+	_, err := downloadHttp(server.URL+"/manifest.yaml", &FetchOptions{BlockPrivateNetworks: true})
+	req.Error(err)
+	assert.IsType(t, PrivateNetworkError{}, privateNetworkErrorCause(err))
+	assert.Equal(t, 0, requests)
+
+	// with loopback allowed, the server can be reached but its redirect to the metadata endpoint can't
+	defaultPrivateNetworks := privateNetworks
+	defer func() { privateNetworks = defaultPrivateNetworks }()
+	privateNetworks = []*net.IPNet{}
+	for _, network := range defaultPrivateNetworks {
+		if !network.Contains(net.ParseIP("127.0.0.1")) {
+			privateNetworks = append(privateNetworks, network)
+		}
+	}
+
+	_, err = downloadHttp(server.URL+"/manifest.yaml", &FetchOptions{BlockPrivateNetworks: true})
+	req.Error(err)
+	assert.Equal(t, PrivateNetworkError{Address: "169.254.169.254:80"}, privateNetworkErrorCause(err))
+	assert.Equal(t, 1, requests)
+}
+
+// privateNetworkErrorCause unwraps the error that the dialer returned through the http client
+func privateNetworkErrorCause(err error) error {
+	urlErr, ok := errors.Cause(err).(*url.Error)
+	if !ok {
+		return err
+	}
+	opErr, ok := urlErr.Err.(*net.OpError)
+	if !ok {
+		return urlErr.Err
+	}
+	return opErr.Err
+}
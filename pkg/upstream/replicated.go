@@ -16,6 +16,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
@@ -24,7 +25,6 @@ import (
 	"github.com/replicatedhq/kots/pkg/template"
 	"github.com/replicatedhq/kots/pkg/upstream/types"
 	"github.com/replicatedhq/kots/pkg/util"
-	"github.com/replicatedhq/kots/pkg/version"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	serializer "k8s.io/apimachinery/pkg/runtime/serializer/json"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -45,6 +45,8 @@ type ReplicatedUpstream struct {
 	AppSlug      string
 	VersionLabel *string
 	Sequence     *int
+	// UserAgent is sent with each request to the replicated app endpoint
+	UserAgent string
 }
 
 type ReplicatedCursor struct {
@@ -74,7 +76,7 @@ func (this ReplicatedCursor) Equal(other ReplicatedCursor) bool {
 	return this.ChannelName == other.ChannelName && this.Cursor == other.Cursor
 }
 
-func getUpdatesReplicated(u *url.URL, localPath string, currentCursor ReplicatedCursor, versionLabel string, license *kotsv1beta1.License) ([]Update, error) {
+func getUpdatesReplicated(u *url.URL, localPath string, currentCursor ReplicatedCursor, versionLabel string, license *kotsv1beta1.License, userAgent string) ([]Update, error) {
 	if localPath != "" {
 		parsedLocalRelease, err := readReplicatedAppFromLocalPath(localPath, currentCursor, versionLabel)
 		if err != nil {
@@ -93,6 +95,7 @@ func getUpdatesReplicated(u *url.URL, localPath string, currentCursor Replicated
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse replicated upstream")
 	}
+	replicatedUpstream.UserAgent = userAgent
 
 	remoteLicense, err := getSuccessfulHeadResponse(replicatedUpstream, license)
 	if err != nil {
@@ -114,7 +117,8 @@ func getUpdatesReplicated(u *url.URL, localPath string, currentCursor Replicated
 	return updates, nil
 }
 
-func downloadReplicated(u *url.URL, localPath string, rootDir string, useAppDir bool, license *kotsv1beta1.License, existingConfigValues *kotsv1beta1.ConfigValues, updateCursor ReplicatedCursor, versionLabel string, cipher *crypto.AESCipher) (*types.Upstream, error) {
+// downloadReplicated fetches the release from the channel in the uri, or from replicatedChannel if it's set
+func downloadReplicated(u *url.URL, localPath string, rootDir string, useAppDir bool, license *kotsv1beta1.License, existingConfigValues *kotsv1beta1.ConfigValues, updateCursor ReplicatedCursor, versionLabel string, replicatedChannel string, cipher *crypto.AESCipher, baseUpstream *types.Upstream, enforceLicenseEntitlements bool, userAgent string) (*types.Upstream, error) {
 	var release *Release
 
 	if localPath != "" {
@@ -134,18 +138,28 @@ func downloadReplicated(u *url.URL, localPath string, rootDir string, useAppDir
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to parse replicated upstream")
 		}
+		replicatedUpstream.UserAgent = userAgent
+
+		if replicatedChannel != "" {
+			replicatedUpstream.Channel = &replicatedChannel
+
+			// the sequence of the current channel doesn't apply to a different channel
+			if updateCursor.ChannelName != replicatedChannel {
+				updateCursor = ReplicatedCursor{ChannelName: replicatedChannel}
+			}
+		}
 
 		remoteLicense, err := getSuccessfulHeadResponse(replicatedUpstream, license)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to get successful head response")
 		}
 
-		downloadedRelease, err := downloadReplicatedApp(replicatedUpstream, remoteLicense, updateCursor)
+		downloadedRelease, err := downloadReplicatedApp(replicatedUpstream, remoteLicense, updateCursor, replicatedDeltaBase(baseUpstream, updateCursor))
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to download replicated app")
 		}
 
-		license, err = kotslicense.GetLatestLicense(license)
+		license, err = kotslicense.GetLatestLicenseWithUserAgent(license, userAgent)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to get latest license")
 		}
@@ -157,6 +171,12 @@ func downloadReplicated(u *url.URL, localPath string, rootDir string, useAppDir
 
 	application := findAppInRelease(release) // this function never returns nil
 
+	if enforceLicenseEntitlements {
+		if err := enforceLicense(license, application, time.Now()); err != nil {
+			return nil, errors.Wrap(err, "failed to enforce license")
+		}
+	}
+
 	// NOTE: this currently comes from the application spec and not the channel release meta
 	if release.ReleaseNotes == "" {
 		release.ReleaseNotes = application.Spec.ReleaseNotes
@@ -207,6 +227,15 @@ func downloadReplicated(u *url.URL, localPath string, rootDir string, useAppDir
 		channelName = license.Spec.ChannelName
 	}
 
+	// the channel reported by the server is the one that was actually resolved
+	resolvedChannelName := release.UpdateCursor.ChannelName
+	if resolvedChannelName == "" && localPath == "" {
+		resolvedChannelName = replicatedChannel
+	}
+	if resolvedChannelName == "" {
+		resolvedChannelName = channelName
+	}
+
 	upstream := &types.Upstream{
 		URI:           u.RequestURI(),
 		Name:          application.Name,
@@ -217,6 +246,10 @@ func downloadReplicated(u *url.URL, localPath string, rootDir string, useAppDir
 		VersionLabel:  release.VersionLabel,
 		ReleaseNotes:  release.ReleaseNotes,
 		EncryptionKey: cipher.ToString(),
+		Resolved: types.ResolvedUpstream{
+			ChannelName: resolvedChannelName,
+			Cursor:      release.UpdateCursor.Cursor,
+		},
 	}
 
 	return upstream, nil
@@ -248,7 +281,7 @@ func (r *ReplicatedUpstream) getRequest(method string, license *kotsv1beta1.Lice
 		return nil, errors.Wrap(err, "failed to call newrequest")
 	}
 
-	req.Header.Add("User-Agent", fmt.Sprintf("KOTS/%s", version.Version()))
+	setUserAgent(req, r.UserAgent)
 	req.Header.Set("Authorization", fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", license.Spec.LicenseID, license.Spec.LicenseID)))))
 
 	return req, nil
@@ -295,6 +328,10 @@ func getSuccessfulHeadResponse(replicatedUpstream *ReplicatedUpstream, license *
 		return nil, util.ActionableError{Message: "License is expired"}
 	}
 
+	if headResp.StatusCode == 404 && replicatedUpstream.Channel != nil && isChannelNotFound(replicatedUpstream, license) {
+		return nil, util.ActionableError{Message: fmt.Sprintf("License does not grant access to channel %q, or the channel doesn't exist", *replicatedUpstream.Channel)}
+	}
+
 	if headResp.StatusCode >= 400 {
 		return nil, errors.Errorf("unexpected result from head request: %d", headResp.StatusCode)
 	}
@@ -302,6 +339,25 @@ func getSuccessfulHeadResponse(replicatedUpstream *ReplicatedUpstream, license *
 	return license, nil
 }
 
+// isChannelNotFound returns true when the app can be fetched from the license's own channel, so that a 404
+// for the channel of replicatedUpstream is because of the channel, and not an app slug that doesn't exist
+func isChannelNotFound(replicatedUpstream *ReplicatedUpstream, license *kotsv1beta1.License) bool {
+	licenseChannelUpstream := *replicatedUpstream
+	licenseChannelUpstream.Channel = nil
+
+	headReq, err := licenseChannelUpstream.getRequest("HEAD", license, ReplicatedCursor{})
+	if err != nil {
+		return false
+	}
+	headResp, err := http.DefaultClient.Do(headReq)
+	if err != nil {
+		return false
+	}
+	headResp.Body.Close()
+
+	return headResp.StatusCode < 400
+}
+
 func readReplicatedAppFromLocalPath(localPath string, localCursor ReplicatedCursor, versionLabel string) (*Release, error) {
 	release := Release{
 		Manifests:    make(map[string][]byte),
@@ -339,11 +395,16 @@ func readReplicatedAppFromLocalPath(localPath string, localCursor ReplicatedCurs
 	return &release, nil
 }
 
-func downloadReplicatedApp(replicatedUpstream *ReplicatedUpstream, license *kotsv1beta1.License, cursor ReplicatedCursor) (*Release, error) {
+// downloadReplicatedApp downloads the release at cursor. When baseUpstream is set, only the files that
+// changed since it are requested, and are applied to it if the endpoint supports deltas.
+func downloadReplicatedApp(replicatedUpstream *ReplicatedUpstream, license *kotsv1beta1.License, cursor ReplicatedCursor, baseUpstream *types.Upstream) (*Release, error) {
 	getReq, err := replicatedUpstream.getRequest("GET", license, cursor)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create http request")
 	}
+	if baseUpstream != nil {
+		requestReplicatedDelta(getReq, baseUpstream)
+	}
 	getResp, err := http.DefaultClient.Do(getReq)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to execute get request")
@@ -405,6 +466,17 @@ func downloadReplicatedApp(replicatedUpstream *ReplicatedUpstream, license *kots
 		i++
 	}
 
+	if getResp.Header.Get(replicatedDeltaHeader) == "true" {
+		if baseUpstream == nil {
+			return nil, errors.New("received a delta release without requesting one")
+		}
+		manifests, err := applyReplicatedDelta(baseUpstream, release.Manifests)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to apply delta to release %s", baseUpstream.Resolved.Cursor)
+		}
+		release.Manifests = manifests
+	}
+
 	return &release, nil
 }
 
@@ -434,7 +506,7 @@ func listPendingChannelReleases(replicatedUpstream *ReplicatedUpstream, license
 		return nil, errors.Wrap(err, "failed to call newrequest")
 	}
 
-	req.Header.Add("User-Agent", fmt.Sprintf("KOTS/%s", version.Version()))
+	setUserAgent(req, replicatedUpstream.UserAgent)
 	req.Header.Set("Authorization", fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", license.Spec.LicenseID, license.Spec.LicenseID)))))
 
 	resp, err := http.DefaultClient.Do(req)
@@ -768,7 +840,7 @@ func getApplicationMetadataFromHost(host string, upstream *url.URL) ([]byte, err
 		return nil, errors.Wrap(err, "failed to call newrequest")
 	}
 
-	getReq.Header.Add("User-Agent", fmt.Sprintf("KOTS/%s", version.Version()))
+	setUserAgent(getReq, "")
 
 	getResp, err := http.DefaultClient.Do(getReq)
 	if err != nil {
@@ -0,0 +1,72 @@
+package upstream
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+)
+
+const (
+	// replicatedDeltaHeader is set to true by an endpoint that answered with only the files that
+	// changed since the base channel sequence. Endpoints that don't support deltas ignore the
+	// baseChannelSequence param and send the full release without it.
+	replicatedDeltaHeader = "X-Replicated-Delta"
+	// replicatedDeltaDeletedFile is a file in a delta that lists the paths that were removed since the
+	// base release, one per line. Paths in a delta are relative to the root of the upstream.
+	replicatedDeltaDeletedFile = ".kots-delta/deleted"
+)
+
+// replicatedDeltaBase returns the upstream that a delta can be requested against for cursor, or nil
+// if the full release has to be fetched
+func replicatedDeltaBase(baseUpstream *types.Upstream, cursor ReplicatedCursor) *types.Upstream {
+	if baseUpstream == nil || baseUpstream.Type != "replicated" || baseUpstream.Resolved.Cursor == "" {
+		return nil
+	}
+	// sequences are per channel
+	if cursor.ChannelName != "" && baseUpstream.Resolved.ChannelName != "" && cursor.ChannelName != baseUpstream.Resolved.ChannelName {
+		return nil
+	}
+	return baseUpstream
+}
+
+// requestReplicatedDelta asks for only the files that changed since the base upstream
+func requestReplicatedDelta(req *http.Request, baseUpstream *types.Upstream) {
+	query := req.URL.Query()
+	query.Set("baseChannelSequence", baseUpstream.Resolved.Cursor)
+	req.URL.RawQuery = query.Encode()
+}
+
+// applyReplicatedDelta returns the manifests of the base upstream with the delta applied. The user
+// data in the base upstream is left out, since it's written again for every release.
+func applyReplicatedDelta(baseUpstream *types.Upstream, delta map[string][]byte) (map[string][]byte, error) {
+	manifests := map[string][]byte{}
+	for _, file := range baseUpstream.Files {
+		if strings.HasPrefix(file.Path, "userdata/") {
+			continue
+		}
+		manifests[file.Path] = file.Content
+	}
+
+	for _, deleted := range strings.Split(string(delta[replicatedDeltaDeletedFile]), "\n") {
+		deleted = strings.TrimSpace(deleted)
+		if deleted == "" {
+			continue
+		}
+		if _, ok := manifests[path.Clean(deleted)]; !ok {
+			return nil, errors.Errorf("delta deletes %s, which is not in the base upstream", deleted)
+		}
+		delete(manifests, path.Clean(deleted))
+	}
+
+	for filename, content := range delta {
+		if filename == replicatedDeltaDeletedFile {
+			continue
+		}
+		manifests[path.Clean(filename)] = content
+	}
+
+	return manifests, nil
+}
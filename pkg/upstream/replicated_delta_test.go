@@ -0,0 +1,63 @@
+package upstream
+
+import (
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_replicatedDeltaBase(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	base := &types.Upstream{
+		Type:     "replicated",
+		Resolved: types.ResolvedUpstream{ChannelName: "Stable", Cursor: "3"},
+	}
+
+	assert.Equal(t, base, replicatedDeltaBase(base, ReplicatedCursor{ChannelName: "Stable", Cursor: "4"}))
+	assert.Equal(t, base, replicatedDeltaBase(base, ReplicatedCursor{}))
+	assert.Nil(t, replicatedDeltaBase(nil, ReplicatedCursor{}))
+	assert.Nil(t, replicatedDeltaBase(base, ReplicatedCursor{ChannelName: "Beta"}))
+	assert.Nil(t, replicatedDeltaBase(&types.Upstream{Type: "replicated"}, ReplicatedCursor{}))
+	assert.Nil(t, replicatedDeltaBase(&types.Upstream{Type: "helm", Resolved: base.Resolved}, ReplicatedCursor{}))
+}
+
+func Test_applyReplicatedDelta(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	base := &types.Upstream{
+		Files: []types.UpstreamFile{
+			{Path: "deployment.yaml", Content: []byte("deployment v1")},
+			{Path: "service.yaml", Content: []byte("service v1")},
+			{Path: "configmap.yaml", Content: []byte("configmap v1")},
+			{Path: "userdata/license.yaml", Content: []byte("license")},
+		},
+	}
+
+	manifests, err := applyReplicatedDelta(base, map[string][]byte{
+		"deployment.yaml":          []byte("deployment v2"),
+		"ingress.yaml":             []byte("ingress v1"),
+		replicatedDeltaDeletedFile: []byte("configmap.yaml\n"),
+	})
+	req.NoError(err)
+	assert.Equal(t, map[string][]byte{
+		"deployment.yaml": []byte("deployment v2"),
+		"service.yaml":    []byte("service v1"),
+		"ingress.yaml":    []byte("ingress v1"),
+	}, manifests)
+
+	// the base is not modified
+	assert.Len(t, base.Files, 4)
+
+	_, err = applyReplicatedDelta(base, map[string][]byte{
+		replicatedDeltaDeletedFile: []byte("missing.yaml"),
+	})
+	req.Error(err)
+	assert.Contains(t, err.Error(), "missing.yaml")
+}
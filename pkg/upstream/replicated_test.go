@@ -1,6 +1,11 @@
 package upstream
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 
@@ -346,3 +351,229 @@ func Test_getRequest(t *testing.T) {
 		assert.Equal(t, test.expectedURL, request.URL.String())
 	}
 }
+
+func Test_downloadReplicatedChannelAccess(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	requestedPaths := []string{}
+	userAgents := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPaths = append(requestedPaths, r.URL.Path)
+		userAgents = append(userAgents, r.UserAgent())
+		if r.URL.Path != "/release/my-app/Stable" && r.URL.Path != "/release/my-app" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	license := &kotsv1beta1.License{
+		Spec: kotsv1beta1.LicenseSpec{
+			Endpoint:    server.URL,
+			AppSlug:     "my-app",
+			ChannelName: "Stable",
+		},
+	}
+
+	u, err := url.ParseRequestURI("replicated://my-app/Stable")
+	req.NoError(err)
+
+	// the app can be fetched from the license channel, so the requested channel is the problem
+	_, err = downloadReplicated(u, "", "", false, license, nil, ReplicatedCursor{ChannelName: "Stable", Cursor: "3"}, "", "Beta", nil, nil, false, "my-agent/1.0")
+	req.Error(err)
+	assert.Contains(t, err.Error(), `License does not grant access to channel "Beta"`)
+	assert.Equal(t, []string{"/release/my-app/Beta", "/release/my-app"}, requestedPaths)
+	assert.Equal(t, []string{"my-agent/1.0", "my-agent/1.0"}, userAgents)
+
+	// an app that doesn't exist isn't reported as a channel that can't be accessed
+	requestedPaths = []string{}
+	license.Spec.AppSlug = "other-app"
+	_, err = downloadReplicated(u, "", "", false, license, nil, ReplicatedCursor{ChannelName: "Stable", Cursor: "3"}, "", "Beta", nil, nil, false, "")
+	req.Error(err)
+	assert.NotContains(t, err.Error(), "grant access")
+	assert.Contains(t, err.Error(), "unexpected result from head request: 404")
+	assert.Equal(t, []string{"/release/other-app/Beta", "/release/other-app"}, requestedPaths)
+}
+
+func Test_replicatedDownloaderPinnedCursor(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	release := testReleaseArchive(t, map[string]string{"configmap.yaml": "apiVersion: v1\nkind: ConfigMap\n"})
+
+	license := &kotsv1beta1.License{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "kots.io/v1beta1",
+			Kind:       "License",
+		},
+		Spec: kotsv1beta1.LicenseSpec{
+			AppSlug:     "my-app",
+			ChannelName: "Stable",
+		},
+	}
+
+	// the channel has releases 3 and 5, and answers with the latest for any other sequence
+	requestedSequences := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/license/my-app" {
+			w.Write(MustMarshalLicense(license))
+			return
+		}
+		if r.Method == "HEAD" {
+			return
+		}
+
+		sequence := r.URL.Query().Get("channelSequence")
+		requestedSequences = append(requestedSequences, sequence)
+		if sequence != "3" {
+			sequence = "5"
+		}
+		w.Header().Set("X-Replicated-ChannelSequence", sequence)
+		w.Header().Set("X-Replicated-ChannelName", "Stable")
+		w.Write(release)
+	}))
+	defer server.Close()
+	license.Spec.Endpoint = server.URL
+
+	// the latest release is resolved when the cursor isn't pinned
+	u, err := replicatedDownloader("replicated://my-app", &FetchOptions{License: license})
+	req.NoError(err)
+	assert.Equal(t, "5", u.UpdateCursor)
+	assert.Equal(t, "5", u.Resolved.Cursor)
+
+	u, err = replicatedDownloader("replicated://my-app", &FetchOptions{License: license, ReplicatedUpdateCursor: "3"})
+	req.NoError(err)
+	assert.Equal(t, "3", u.UpdateCursor)
+	assert.Equal(t, "3", u.Resolved.Cursor)
+
+	_, err = replicatedDownloader("replicated://my-app", &FetchOptions{License: license, ReplicatedUpdateCursor: "4"})
+	req.Error(err)
+	assert.Equal(t, `release 4 is not available on channel "Stable", the channel returned release 5`, err.Error())
+
+	assert.Equal(t, []string{"", "3", "4"}, requestedSequences)
+
+	_, err = replicatedDownloader("replicated://my-app", &FetchOptions{License: license, ReplicatedUpdateCursor: "latest"})
+	req.Error(err)
+	assert.Equal(t, []string{"", "3", "4"}, requestedSequences)
+}
+
+// testReleaseArchive returns a release tar gz with the files
+func testReleaseArchive(t *testing.T, files map[string]string) []byte {
+	req := require.New(t)
+
+	var archive bytes.Buffer
+	gzw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gzw)
+	for name, content := range files {
+		req.NoError(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		req.NoError(err)
+	}
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+
+	return archive.Bytes()
+}
+
+func Test_replicatedDownloaderPreferDelta(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	license := &kotsv1beta1.License{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "kots.io/v1beta1",
+			Kind:       "License",
+		},
+		Spec: kotsv1beta1.LicenseSpec{
+			AppSlug:     "my-app",
+			ChannelName: "Stable",
+		},
+	}
+
+	fullRelease := testReleaseArchive(t, map[string]string{
+		"manifests/deployment.yaml": "deployment v2",
+		"manifests/service.yaml":    "service v1",
+	})
+	deltaRelease := testReleaseArchive(t, map[string]string{
+		"deployment.yaml":          "deployment v2",
+		replicatedDeltaDeletedFile: "configmap.yaml\n",
+	})
+
+	supportsDelta := true
+	baseSequences := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/license/my-app" {
+			w.Write(MustMarshalLicense(license))
+			return
+		}
+		if r.Method == "HEAD" {
+			return
+		}
+
+		baseSequence := r.URL.Query().Get("baseChannelSequence")
+		baseSequences = append(baseSequences, baseSequence)
+		w.Header().Set("X-Replicated-ChannelSequence", "4")
+		w.Header().Set("X-Replicated-ChannelName", "Stable")
+		if supportsDelta && baseSequence == "3" {
+			w.Header().Set(replicatedDeltaHeader, "true")
+			w.Write(deltaRelease)
+			return
+		}
+		w.Write(fullRelease)
+	}))
+	defer server.Close()
+	license.Spec.Endpoint = server.URL
+
+	baseUpstream := &types.Upstream{
+		Type: "replicated",
+		Files: []types.UpstreamFile{
+			{Path: "deployment.yaml", Content: []byte("deployment v1")},
+			{Path: "service.yaml", Content: []byte("service v1")},
+			{Path: "configmap.yaml", Content: []byte("configmap v1")},
+			{Path: "userdata/license.yaml", Content: []byte("old license")},
+		},
+		Resolved: types.ResolvedUpstream{ChannelName: "Stable", Cursor: "3"},
+	}
+	fetchOptions := &FetchOptions{
+		License:      license,
+		PreferDelta:  true,
+		BaseUpstream: baseUpstream,
+	}
+
+	filesByPath := func(u *types.Upstream) map[string]string {
+		files := map[string]string{}
+		for _, file := range u.Files {
+			files[file.Path] = string(file.Content)
+		}
+		return files
+	}
+
+	u, err := replicatedDownloader("replicated://my-app", fetchOptions)
+	req.NoError(err)
+	assert.Equal(t, "4", u.Resolved.Cursor)
+	files := filesByPath(u)
+	assert.Equal(t, "deployment v2", files["deployment.yaml"])
+	assert.Equal(t, "service v1", files["service.yaml"])
+	assert.NotContains(t, files, "configmap.yaml")
+	assert.NotContains(t, files, replicatedDeltaDeletedFile)
+	assert.Equal(t, string(MustMarshalLicense(license)), files["userdata/license.yaml"])
+
+	// the full release is used when the endpoint doesn't support deltas
+	supportsDelta = false
+	u, err = replicatedDownloader("replicated://my-app", fetchOptions)
+	req.NoError(err)
+	full := filesByPath(u)
+	delete(full, "userdata/license.yaml")
+	assert.Equal(t, map[string]string{"deployment.yaml": "deployment v2", "service.yaml": "service v1"}, full)
+
+	// a delta isn't requested without PreferDelta
+	_, err = replicatedDownloader("replicated://my-app", &FetchOptions{License: license, BaseUpstream: baseUpstream})
+	req.NoError(err)
+
+	assert.Equal(t, []string{"3", "3", ""}, baseSequences)
+}
@@ -0,0 +1,117 @@
+package upstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+const (
+	snapshotMetadataFile = "upstream.json"
+	snapshotFilesDir     = "files"
+)
+
+// upstreamSnapshot is the metadata saved next to the files of a fetched upstream, so that the
+// same upstream can be read back with FetchOptions.Offline. The uris are saved with any credentials
+// redacted.
+type upstreamSnapshot struct {
+	RequestedURI string         `json:"requestedURI"`
+	Upstream     types.Upstream `json:"upstream"`
+	FilePaths    []string       `json:"filePaths"`
+}
+
+// writeSnapshot replaces any snapshot in snapshotDir with the fetched upstream
+func writeSnapshot(snapshotDir string, upstreamURI string, upstream *types.Upstream) error {
+	filesDir := filepath.Join(snapshotDir, snapshotFilesDir)
+	if err := os.RemoveAll(filesDir); err != nil {
+		return errors.Wrap(err, "failed to remove previous snapshot")
+	}
+
+	snapshot := upstreamSnapshot{
+		RequestedURI: redactURI(upstreamURI),
+		Upstream:     *upstream,
+	}
+	snapshot.Upstream.URI = redactURI(upstream.URI)
+	snapshot.Upstream.Files = nil
+	// the encryption key isn't saved, it's passed in again when the snapshot is read
+	snapshot.Upstream.EncryptionKey = ""
+
+	for _, file := range upstream.Files {
+		filePath, err := util.SanitizeArchivePath(file.Path)
+		if err != nil {
+			return errors.Wrap(err, "failed to get snapshot file path")
+		}
+
+		fullPath := filepath.Join(filesDir, filepath.FromSlash(filePath))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0700); err != nil {
+			return errors.Wrapf(err, "failed to create snapshot dir for %s", file.Path)
+		}
+		if err := ioutil.WriteFile(fullPath, file.Content, 0600); err != nil {
+			return errors.Wrapf(err, "failed to write snapshot file %s", file.Path)
+		}
+
+		snapshot.FilePaths = append(snapshot.FilePaths, file.Path)
+	}
+
+	b, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal snapshot metadata")
+	}
+	if err := ioutil.WriteFile(filepath.Join(snapshotDir, snapshotMetadataFile), b, 0600); err != nil {
+		return errors.Wrap(err, "failed to write snapshot metadata")
+	}
+
+	return nil
+}
+
+// readSnapshot reads the upstream that was saved in snapshotDir for upstreamURI
+func readSnapshot(snapshotDir string, upstreamURI string) (*types.Upstream, error) {
+	b, err := ioutil.ReadFile(filepath.Join(snapshotDir, snapshotMetadataFile))
+	if os.IsNotExist(err) {
+		return nil, util.ActionableError{
+			Message: fmt.Sprintf("No upstream snapshot was found in %s. Fetch the upstream once without offline mode to create it.", snapshotDir),
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read snapshot metadata")
+	}
+
+	var snapshot upstreamSnapshot
+	if err := json.Unmarshal(b, &snapshot); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal snapshot metadata")
+	}
+
+	if snapshot.RequestedURI != redactURI(upstreamURI) {
+		return nil, errors.Errorf("snapshot in %s is of upstream %s, not %s", snapshotDir, snapshot.RequestedURI, redactURI(upstreamURI))
+	}
+
+	upstream := snapshot.Upstream
+	// the credentials that were redacted are the ones that were passed in again
+	if upstream.URI == snapshot.RequestedURI {
+		upstream.URI = upstreamURI
+	}
+	for _, snapshotPath := range snapshot.FilePaths {
+		filePath, err := util.SanitizeArchivePath(snapshotPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get snapshot file path")
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(snapshotDir, snapshotFilesDir, filepath.FromSlash(filePath)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read snapshot file %s", snapshotPath)
+		}
+
+		upstream.Files = append(upstream.Files, types.UpstreamFile{
+			Path:    snapshotPath,
+			Content: content,
+		})
+	}
+
+	return &upstream, nil
+}
@@ -0,0 +1,165 @@
+package upstream
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+const StdinUpstreamURI = "stdin://"
+
+var (
+	stdinMutex    sync.Mutex
+	stdinConsumed bool
+)
+
+// IsStdinUpstream returns true if the upstream uri refers to stdin
+func IsStdinUpstream(upstreamURI string) bool {
+	return upstreamURI == "-" || upstreamURI == StdinUpstreamURI
+}
+
+// readFilesFromStdin reads a tar, tar.gz, zip or plain yaml stream from stdin.
+// Stdin can only be consumed once per process, a second call will return an error.
+func readFilesFromStdin() (*types.Upstream, error) {
+	stdinMutex.Lock()
+	defer stdinMutex.Unlock()
+
+	if stdinConsumed {
+		return nil, errors.New("stdin has already been consumed")
+	}
+	stdinConsumed = true
+
+	files, err := readFilesFromReader(os.Stdin)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read files from stdin")
+	}
+
+	upstream := &types.Upstream{
+		URI:   StdinUpstreamURI,
+		Name:  "stdin",
+		Type:  "stdin",
+		Files: files,
+	}
+
+	return upstream, nil
+}
+
+// readFilesFromStream sniffs the content to detect a gzip, zip or tar archive.
+// Anything else is treated as a single yaml stream.
+func readFilesFromStream(content []byte) ([]types.UpstreamFile, error) {
+	return readFilesFromReader(bytes.NewReader(content))
+}
+
+// readFilesFromReader is the streaming version of readFilesFromStream. Tar and tar.gz
+// archives are extracted as they are read, zip archives require random access and
+// are buffered in memory.
+func readFilesFromReader(r io.Reader) ([]types.UpstreamFile, error) {
+	br := bufio.NewReaderSize(r, 512)
+	header, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "failed to read stream")
+	}
+	if len(header) == 0 {
+		return nil, errors.New("no content")
+	}
+
+	if isGzip(header) {
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create gzip reader")
+		}
+		defer gzr.Close()
+
+		return readTarOrManifest(gzr)
+	}
+
+	if isZip(header) {
+		content, err := ioutil.ReadAll(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read zip archive")
+		}
+		return readZip(content)
+	}
+
+	return readTarOrManifest(br)
+}
+
+func readTarOrManifest(r io.Reader) ([]types.UpstreamFile, error) {
+	br := bufio.NewReaderSize(r, 512)
+	header, err := br.Peek(262)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "failed to read stream")
+	}
+
+	if isTar(header) {
+		return readTar(br)
+	}
+
+	content, err := ioutil.ReadAll(br)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read manifest")
+	}
+
+	return []types.UpstreamFile{{Path: "manifest.yaml", Content: content}}, nil
+}
+
+func readZip(content []byte) ([]types.UpstreamFile, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create zip reader")
+	}
+
+	upstreamFiles := []types.UpstreamFile{}
+	for _, f := range zipReader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		name, err := util.SanitizeArchivePath(f.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open %s in zip archive", f.Name)
+		}
+
+		buf := new(bytes.Buffer)
+		_, err = io.Copy(buf, rc)
+		rc.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s from zip archive", f.Name)
+		}
+
+		upstreamFiles = append(upstreamFiles, types.UpstreamFile{
+			Path:    name,
+			Content: buf.Bytes(),
+			Mode:    f.Mode().Perm(),
+		})
+	}
+
+	return removeCommonPrefix(upstreamFiles), nil
+}
+
+func isGzip(content []byte) bool {
+	return len(content) >= 2 && content[0] == 0x1f && content[1] == 0x8b
+}
+
+func isZip(content []byte) bool {
+	return bytes.HasPrefix(content, []byte("PK\x03\x04"))
+}
+
+func isTar(content []byte) bool {
+	// the ustar magic is at offset 257 in the first header block
+	return len(content) >= 262 && string(content[257:262]) == "ustar"
+}
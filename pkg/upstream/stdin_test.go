@@ -0,0 +1,95 @@
+package upstream
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_readFilesFromStream(t *testing.T) {
+	req := require.New(t)
+
+	var tarGz bytes.Buffer
+	gzw := gzip.NewWriter(&tarGz)
+	tw := tar.NewWriter(gzw)
+	content := []byte("apiVersion: v1\nkind: ConfigMap\n")
+	req.NoError(tw.WriteHeader(&tar.Header{Name: "app/configmap.yaml", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write(content)
+	req.NoError(err)
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+
+	tests := []struct {
+		name          string
+		content       []byte
+		expectedPaths []string
+	}{
+		{
+			name:          "yaml stream",
+			content:       content,
+			expectedPaths: []string{"manifest.yaml"},
+		},
+		{
+			name:          "tar.gz",
+			content:       tarGz.Bytes(),
+			expectedPaths: []string{"configmap.yaml"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			files, err := readFilesFromStream(test.content)
+			req.NoError(err)
+
+			actualPaths := []string{}
+			for _, file := range files {
+				actualPaths = append(actualPaths, file.Path)
+				assert.Equal(t, content, file.Content)
+			}
+			assert.Equal(t, test.expectedPaths, actualPaths)
+		})
+	}
+}
+
+func Test_readFilesFromStreamUnsafePath(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	content := []byte("root:x:0:0:root:/root:/bin/bash\n")
+
+	var tarGz bytes.Buffer
+	gzw := gzip.NewWriter(&tarGz)
+	tw := tar.NewWriter(gzw)
+	req.NoError(tw.WriteHeader(&tar.Header{Name: "app/../../etc/passwd", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write(content)
+	req.NoError(err)
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+
+	_, err = readFilesFromStream(tarGz.Bytes())
+	req.Error(err)
+	assert.Contains(t, err.Error(), "archive contains unsafe path")
+
+	var zipArchive bytes.Buffer
+	zw := zip.NewWriter(&zipArchive)
+	w, err := zw.Create("../etc/passwd")
+	req.NoError(err)
+	_, err = w.Write(content)
+	req.NoError(err)
+	req.NoError(zw.Close())
+
+	_, err = readFilesFromStream(zipArchive.Bytes())
+	req.Error(err)
+	assert.Contains(t, err.Error(), "archive contains unsafe path")
+}
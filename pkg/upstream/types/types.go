@@ -1,6 +1,7 @@
 package types
 
 import (
+	"os"
 	"path"
 
 	kotsscheme "github.com/replicatedhq/kots/kotskinds/client/kotsclientset/scheme"
@@ -14,6 +15,18 @@ func init() {
 type UpstreamFile struct {
 	Path    string
 	Content []byte
+	// Mode is the permission bits of the file in the upstream, or 0 when the upstream doesn't have them
+	Mode os.FileMode
+	// Source is the downloader that produced the file, such as "helm" or "git", and SourceURI is the
+	// upstream that it came from, with any credentials removed. In an upstream that was merged from
+	// several others, they tell which upstream each file is from.
+	Source    string
+	SourceURI string
+}
+
+// Size returns the number of bytes in the file
+func (f UpstreamFile) Size() int64 {
+	return int64(len(f.Content))
 }
 
 type Upstream struct {
@@ -26,6 +39,28 @@ type Upstream struct {
 	VersionLabel  string
 	ReleaseNotes  string
 	EncryptionKey string
+	Resolved      ResolvedUpstream
+	Warnings      []string
+}
+
+// TotalBytes returns the total size of the files in the upstream
+func (u *Upstream) TotalBytes() int64 {
+	var total int64
+	for _, file := range u.Files {
+		total += file.Size()
+	}
+	return total
+}
+
+// ResolvedUpstream records exactly what an upstream uri resolved to at fetch time,
+// so that a later fetch can be pinned to the same content
+type ResolvedUpstream struct {
+	ChartVersion string
+	ChannelName  string
+	Cursor       string
+	CommitSHA    string
+	AppSlug      string
+	VersionLabel string
 }
 
 type WriteOptions struct {
@@ -0,0 +1,62 @@
+package upstream
+
+import (
+	"bufio"
+	"bytes"
+	"path"
+	"strings"
+
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+)
+
+// validateUpstreamContent returns an actionable error if the upstream doesn't contain a helm chart
+// or any kubernetes manifests, including kots kinds. It's a quick check of the file names and top
+// level keys, so that an upstream that points at the wrong content fails before it's rendered.
+func validateUpstreamContent(upstream *types.Upstream) error {
+	for _, file := range upstream.Files {
+		if path.Base(file.Path) == "Chart.yaml" {
+			return nil
+		}
+
+		ext := path.Ext(file.Path)
+		if ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		if hasManifest(file.Content) {
+			return nil
+		}
+	}
+
+	return util.ActionableError{
+		Message: "no KOTS application or Helm chart found in upstream " + redactURI(upstream.URI),
+	}
+}
+
+// hasManifest returns true if any yaml document in content has a top level apiVersion and kind
+func hasManifest(content []byte) bool {
+	hasAPIVersion, hasKind := false, false
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(content)+1)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.HasPrefix(line, "---") {
+			hasAPIVersion, hasKind = false, false
+			continue
+		}
+
+		if value := strings.TrimPrefix(line, "apiVersion:"); value != line && strings.TrimSpace(value) != "" {
+			hasAPIVersion = true
+		}
+		if value := strings.TrimPrefix(line, "kind:"); value != line && strings.TrimSpace(value) != "" {
+			hasKind = true
+		}
+
+		if hasAPIVersion && hasKind {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,129 @@
+package upstream
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/replicatedhq/kots/pkg/upstream/types"
+	"github.com/replicatedhq/kots/pkg/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_FetchUpstreamStrictValidation(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	emptyDir := filepath.Join(tmpDir, "empty")
+	req.NoError(os.Mkdir(emptyDir, 0755))
+
+	// a tarball of a repo without any manifests
+	tarballPath := filepath.Join(tmpDir, "repo.tar.gz")
+	f, err := os.Create(tarballPath)
+	req.NoError(err)
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+	for name, content := range map[string]string{
+		"README.md":   "# not an app",
+		"install.sh":  "#!/bin/sh",
+		"values.yaml": "replicas: 1",
+	} {
+		req.NoError(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err = tw.Write([]byte(content))
+		req.NoError(err)
+	}
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+	req.NoError(f.Close())
+
+	appDir := filepath.Join(tmpDir, "app")
+	req.NoError(os.Mkdir(appDir, 0755))
+	req.NoError(ioutil.WriteFile(filepath.Join(appDir, "deployment.yaml"), []byte("apiVersion: apps/v1\nkind: Deployment"), 0644))
+
+	for _, upstreamURI := range []string{emptyDir, tarballPath} {
+		_, err := FetchUpstream(upstreamURI, &FetchOptions{StrictValidation: true})
+		req.Error(err)
+		assert.IsType(t, util.ActionableError{}, err)
+		assert.Contains(t, err.Error(), "no KOTS application or Helm chart found in upstream")
+
+		// without strict validation, the content isn't checked
+		_, err = FetchUpstream(upstreamURI, &FetchOptions{})
+		req.NoError(err)
+	}
+
+	_, err = FetchUpstream(appDir, &FetchOptions{StrictValidation: true})
+	req.NoError(err)
+}
+
+func Test_validateUpstreamContent(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "no files",
+			files:   map[string]string{},
+			wantErr: true,
+		},
+		{
+			name:  "helm chart",
+			files: map[string]string{"my-chart/Chart.yaml": "name: my-chart", "my-chart/values.yaml": "replicas: 1"},
+		},
+		{
+			name:  "kots kind",
+			files: map[string]string{"kots-app.yaml": "apiVersion: kots.io/v1beta1\nkind: Application\nmetadata:\n  name: app"},
+		},
+		{
+			name:  "manifest in a later document",
+			files: map[string]string{"manifests.yml": "replicas: 1\n---\napiVersion: v1\nkind: Service"},
+		},
+		{
+			name:    "apiVersion and kind in different documents",
+			files:   map[string]string{"manifests.yaml": "apiVersion: v1\n---\nkind: Service"},
+			wantErr: true,
+		},
+		{
+			name:    "nested apiVersion and kind",
+			files:   map[string]string{"values.yaml": "ref:\n  apiVersion: v1\n  kind: Service"},
+			wantErr: true,
+		},
+		{
+			name:    "manifest that isn't yaml",
+			files:   map[string]string{"deployment.json": "apiVersion: apps/v1\nkind: Deployment"},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			u := &types.Upstream{URI: "https://example.com/app.tar.gz"}
+			for p, content := range test.files {
+				u.Files = append(u.Files, types.UpstreamFile{Path: p, Content: []byte(content)})
+			}
+
+			err := validateUpstreamContent(u)
+			if test.wantErr {
+				req.Error(err)
+			} else {
+				req.NoError(err)
+			}
+		})
+	}
+}
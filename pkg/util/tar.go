@@ -5,22 +5,136 @@ import (
 	"compress/gzip"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
+// SanitizeArchivePath cleans the name of an archive entry and returns it as a relative, slash separated path.
+// Names that would escape the directory the archive is extracted to are rejected.
+func SanitizeArchivePath(name string) (string, error) {
+	cleaned := path.Clean(strings.TrimPrefix(filepath.ToSlash(name), "/"))
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", errors.Errorf("archive contains unsafe path %q", name)
+	}
+
+	return cleaned, nil
+}
+
 func ExtractTGZArchive(tgzFile string, destDir string) error {
-	fileReader, err := os.Open(tgzFile)
+	_, err := ExtractTGZArchiveFiles(tgzFile, destDir)
+	return err
+}
+
+// ExtractTGZArchiveFiles extracts the archive in the same way as ExtractTGZArchive, and returns the sorted,
+// slash separated paths of the files that were written, relative to destDir
+func ExtractTGZArchiveFiles(tgzFile string, destDir string) ([]string, error) {
+	return ExtractTGZArchiveFilesMatching(tgzFile, destDir, nil)
+}
+
+// ExtractTGZArchiveFilesMatching is ExtractTGZArchiveFiles, but only the files whose sanitized path is
+// accepted by match are written. Directory entries are skipped when match is set, the directories of
+// the matching files are still created. A nil match extracts everything.
+func ExtractTGZArchiveFilesMatching(tgzFile string, destDir string, match func(name string) bool) ([]string, error) {
+	return extractTGZArchiveFiles(tgzFile, destDir, "", match)
+}
+
+// ExtractTGZArchiveFilesFlattened is ExtractTGZArchiveFilesMatching, but when every entry in the archive is
+// under a single top-level folder, that folder is left out and its contents are extracted directly to
+// destDir. The paths that are returned and passed to match don't include the folder.
+func ExtractTGZArchiveFilesFlattened(tgzFile string, destDir string, match func(name string) bool) ([]string, error) {
+	topLevelFolder, err := tgzArchiveTopLevelFolder(tgzFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find top level folder")
+	}
+
+	return extractTGZArchiveFiles(tgzFile, destDir, topLevelFolder, match)
+}
+
+// IsTarGz returns true if the gzip file gzFile contains a tar archive, and false if it's a single
+// compressed file. An error is returned if it isn't gzip.
+func IsTarGz(gzFile string) (bool, error) {
+	fileReader, err := os.Open(gzFile)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to open gzip file")
+	}
+
+	defer fileReader.Close()
+
+	gzReader, err := gzip.NewReader(fileReader)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create gzip reader")
+	}
+
+	// an empty archive has no headers, so it's a tar if the first header can be read or there isn't one
+	_, err = tar.NewReader(gzReader).Next()
+	switch err {
+	case nil, io.EOF:
+		return true, nil
+	case tar.ErrHeader, io.ErrUnexpectedEOF:
+		return false, nil
+	default:
+		return false, errors.Wrap(err, "failed to read gzip data")
+	}
+}
+
+// ExtractGzipFile decompresses gzFile, a gzip of a single file, to destDir/name
+func ExtractGzipFile(gzFile string, destDir string, name string) error {
+	name, err := SanitizeArchivePath(name)
+	if err != nil {
+		return err
+	}
+
+	fileReader, err := os.Open(gzFile)
 	if err != nil {
-		return errors.Wrap(err, "failed to open tgz file")
+		return errors.Wrap(err, "failed to open gzip file")
 	}
 
+	defer fileReader.Close()
+
 	gzReader, err := gzip.NewReader(fileReader)
 	if err != nil {
 		return errors.Wrap(err, "failed to create gzip reader")
 	}
 
+	fileName := filepath.Join(destDir, name)
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return errors.Wrapf(err, "failed to create directory %q", filepath.Dir(fileName))
+	}
+
+	fileWriter, err := os.Create(fileName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create file %q", name)
+	}
+
+	defer fileWriter.Close()
+
+	if _, err := io.Copy(fileWriter, gzReader); err != nil {
+		return errors.Wrapf(err, "failed to write file %q", name)
+	}
+
+	return nil
+}
+
+// tgzArchiveTopLevelFolder returns the name of the folder that contains every entry in the archive,
+// or an empty string if there are files at the root or more than one top-level folder
+func tgzArchiveTopLevelFolder(tgzFile string) (string, error) {
+	fileReader, err := os.Open(tgzFile)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open tgz file")
+	}
+
+	defer fileReader.Close()
+
+	gzReader, err := gzip.NewReader(fileReader)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create gzip reader")
+	}
+
+	topLevelFolder := ""
 	tarReader := tar.NewReader(gzReader)
 	for {
 		hdr, err := tarReader.Next()
@@ -28,15 +142,89 @@ func ExtractTGZArchive(tgzFile string, destDir string) error {
 			break
 		}
 		if err != nil {
-			return errors.Wrap(err, "failed to read tar data")
+			return "", errors.Wrap(err, "failed to read tar data")
 		}
 
-		if hdr.Typeflag != tar.TypeReg {
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir && hdr.Typeflag != tar.TypeSymlink {
+			continue
+		}
+
+		name, err := SanitizeArchivePath(hdr.Name)
+		if err != nil {
+			return "", err
+		}
+		if name == "." {
+			continue
+		}
+
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) == 1 && hdr.Typeflag != tar.TypeDir {
+			return "", nil
+		}
+		if topLevelFolder != "" && topLevelFolder != parts[0] {
+			return "", nil
+		}
+		topLevelFolder = parts[0]
+	}
+
+	return topLevelFolder, nil
+}
+
+// extractTGZArchiveFiles extracts the archive, leaving stripFolder out of the paths when it's set. File
+// modes and symlinks are kept, and symlinks that point outside of destDir are rejected.
+func extractTGZArchiveFiles(tgzFile string, destDir string, stripFolder string, match func(name string) bool) ([]string, error) {
+	fileReader, err := os.Open(tgzFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open tgz file")
+	}
+
+	defer fileReader.Close()
+
+	gzReader, err := gzip.NewReader(fileReader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create gzip reader")
+	}
+
+	files := []string{}
+	tarReader := tar.NewReader(gzReader)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read tar data")
+		}
+
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir && hdr.Typeflag != tar.TypeSymlink {
+			continue
+		}
+
+		name, err := SanitizeArchivePath(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if stripFolder != "" {
+			if name == stripFolder {
+				continue
+			}
+			name = strings.TrimPrefix(name, stripFolder+"/")
+		}
+
+		if match != nil && (hdr.Typeflag == tar.TypeDir || !match(name)) {
+			continue
+		}
+
+		if hdr.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(filepath.Join(destDir, name), 0755); err != nil {
+				return nil, errors.Wrapf(err, "failed to create directory %q", hdr.Name)
+			}
 			continue
 		}
 
 		err = func() error {
-			fileName := filepath.Join(destDir, hdr.Name)
+			fileName := filepath.Join(destDir, name)
 
 			filePath, _ := filepath.Split(fileName)
 			err := os.MkdirAll(filePath, 0755)
@@ -44,7 +232,25 @@ func ExtractTGZArchive(tgzFile string, destDir string) error {
 				return errors.Wrapf(err, "failed to create directory %q", filePath)
 			}
 
-			fileWriter, err := os.Create(fileName)
+			if hdr.Typeflag == tar.TypeSymlink {
+				target, err := sanitizeSymlinkTarget(destDir, filePath, hdr.Name, hdr.Linkname)
+				if err != nil {
+					return err
+				}
+				if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+					return errors.Wrapf(err, "failed to replace %q", hdr.Name)
+				}
+				if err := os.Symlink(target, fileName); err != nil {
+					return errors.Wrapf(err, "failed to create symlink %q", hdr.Name)
+				}
+				return nil
+			}
+
+			mode := hdr.FileInfo().Mode().Perm()
+			if mode == 0 {
+				mode = 0644
+			}
+			fileWriter, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
 			if err != nil {
 				return errors.Wrapf(err, "failed to create file %q", hdr.Name)
 			}
@@ -59,9 +265,43 @@ func ExtractTGZArchive(tgzFile string, destDir string) error {
 			return nil
 		}()
 		if err != nil {
-			return err
+			return nil, err
 		}
+
+		files = append(files, name)
 	}
 
-	return nil
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// sanitizeSymlinkTarget returns the cleaned target of the symlink name, which is created in parentDir.
+// Targets that are absolute or would resolve outside of destDir are rejected. parentDir is resolved,
+// since it can be under a symlink from an earlier entry.
+func sanitizeSymlinkTarget(destDir string, parentDir string, name string, linkname string) (string, error) {
+	target := path.Clean(filepath.ToSlash(linkname))
+	if path.IsAbs(target) || filepath.IsAbs(linkname) {
+		return "", errors.Errorf("archive contains unsafe symlink %q to %q", name, linkname)
+	}
+
+	resolvedDestDir, err := filepath.EvalSymlinks(destDir)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to resolve destination directory")
+	}
+	resolvedParentDir, err := filepath.EvalSymlinks(parentDir)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve directory of %q", name)
+	}
+	relParentDir, err := filepath.Rel(resolvedDestDir, resolvedParentDir)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve directory of %q", name)
+	}
+
+	resolved := path.Join(filepath.ToSlash(relParentDir), target)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", errors.Errorf("archive contains unsafe symlink %q to %q", name, linkname)
+	}
+
+	return filepath.FromSlash(target), nil
 }
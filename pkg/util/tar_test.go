@@ -0,0 +1,414 @@
+package util
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.undefinedlabs.com/scopeagent"
+)
+
+func Test_SanitizeArchivePath(t *testing.T) {
+	tests := []struct {
+		name        string
+		archivePath string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:        "relative",
+			archivePath: "upstream/app.yaml",
+			expected:    "upstream/app.yaml",
+		},
+		{
+			name:        "leading dot",
+			archivePath: "./upstream/app.yaml",
+			expected:    "upstream/app.yaml",
+		},
+		{
+			name:        "absolute",
+			archivePath: "/upstream/app.yaml",
+			expected:    "upstream/app.yaml",
+		},
+		{
+			name:        "traversal that stays inside",
+			archivePath: "upstream/../base/app.yaml",
+			expected:    "base/app.yaml",
+		},
+		{
+			name:        "traversal",
+			archivePath: "../../etc/passwd",
+			expectError: true,
+		},
+		{
+			name:        "nested traversal",
+			archivePath: "upstream/../../etc/passwd",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			actual, err := SanitizeArchivePath(test.archivePath)
+			if test.expectError {
+				req.Error(err)
+				assert.Contains(t, err.Error(), "archive contains unsafe path")
+				return
+			}
+			req.NoError(err)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func Test_ExtractTGZArchiveUnsafePath(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+	f, err := os.Create(archivePath)
+	req.NoError(err)
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+	content := []byte("escaped")
+	req.NoError(tw.WriteHeader(&tar.Header{Name: "../escaped.txt", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err = tw.Write(content)
+	req.NoError(err)
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+	req.NoError(f.Close())
+
+	destDir := filepath.Join(tmpDir, "dest")
+	err = ExtractTGZArchive(archivePath, destDir)
+	req.Error(err)
+	assert.Contains(t, err.Error(), "archive contains unsafe path")
+
+	_, err = os.Stat(filepath.Join(tmpDir, "escaped.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_ExtractTGZArchiveFiles(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+	f, err := os.Create(archivePath)
+	req.NoError(err)
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+	req.NoError(tw.WriteHeader(&tar.Header{Name: "./upstream/", Mode: 0755, Typeflag: tar.TypeDir}))
+	for _, name := range []string{"./upstream/userdata/config.yaml", "./base/kustomization.yaml", "./upstream/app.yaml"} {
+		req.NoError(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name)), Typeflag: tar.TypeReg}))
+		_, err = tw.Write([]byte(name))
+		req.NoError(err)
+	}
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+	req.NoError(f.Close())
+
+	destDir := filepath.Join(tmpDir, "dest")
+	files, err := ExtractTGZArchiveFiles(archivePath, destDir)
+	req.NoError(err)
+	assert.Equal(t, []string{
+		"base/kustomization.yaml",
+		"upstream/app.yaml",
+		"upstream/userdata/config.yaml",
+	}, files)
+
+	content, err := ioutil.ReadFile(filepath.Join(destDir, "upstream", "app.yaml"))
+	req.NoError(err)
+	assert.Equal(t, "./upstream/app.yaml", string(content))
+}
+
+func Test_ExtractTGZArchiveModesAndSymlinks(t *testing.T) {
+	type entry struct {
+		name     string
+		linkname string
+		mode     int64
+	}
+
+	tests := []struct {
+		name        string
+		entries     []entry
+		expectError string
+	}{
+		{
+			name: "mode and symlink are kept",
+			entries: []entry{
+				{name: "app/run.sh", mode: 0755},
+				{name: "app/config.yaml", mode: 0600},
+				{name: "app/link.yaml", linkname: "config.yaml"},
+				{name: "app/scripts", linkname: "."},
+			},
+		},
+		{
+			name: "absolute symlink",
+			entries: []entry{
+				{name: "app/passwd", linkname: "/etc/passwd"},
+			},
+			expectError: "archive contains unsafe symlink",
+		},
+		{
+			name: "symlink outside of the destination",
+			entries: []entry{
+				{name: "app/escape", linkname: "../../escape"},
+			},
+			expectError: "archive contains unsafe symlink",
+		},
+		{
+			name: "symlink under a symlinked directory",
+			entries: []entry{
+				{name: "root", linkname: "."},
+				{name: "root/escape", linkname: "../escape"},
+			},
+			expectError: "archive contains unsafe symlink",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			tmpDir, err := ioutil.TempDir("", "kots")
+			req.NoError(err)
+			defer os.RemoveAll(tmpDir)
+
+			archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+			f, err := os.Create(archivePath)
+			req.NoError(err)
+			gzw := gzip.NewWriter(f)
+			tw := tar.NewWriter(gzw)
+			for _, e := range test.entries {
+				if e.linkname != "" {
+					req.NoError(tw.WriteHeader(&tar.Header{Name: e.name, Linkname: e.linkname, Mode: 0777, Typeflag: tar.TypeSymlink}))
+					continue
+				}
+				req.NoError(tw.WriteHeader(&tar.Header{Name: e.name, Mode: e.mode, Size: int64(len(e.name)), Typeflag: tar.TypeReg}))
+				_, err = tw.Write([]byte(e.name))
+				req.NoError(err)
+			}
+			req.NoError(tw.Close())
+			req.NoError(gzw.Close())
+			req.NoError(f.Close())
+
+			destDir := filepath.Join(tmpDir, "dest")
+			req.NoError(os.MkdirAll(destDir, 0755))
+			_, err = ExtractTGZArchiveFiles(archivePath, destDir)
+			if test.expectError != "" {
+				req.Error(err)
+				assert.Contains(t, err.Error(), test.expectError)
+				return
+			}
+			req.NoError(err)
+
+			for _, e := range test.entries {
+				fi, err := os.Lstat(filepath.Join(destDir, e.name))
+				req.NoError(err)
+				if e.linkname != "" {
+					assert.True(t, fi.Mode()&os.ModeSymlink != 0, e.name)
+					continue
+				}
+				assert.Equal(t, os.FileMode(e.mode), fi.Mode().Perm(), e.name)
+			}
+
+			content, err := ioutil.ReadFile(filepath.Join(destDir, "app", "scripts", "link.yaml"))
+			req.NoError(err)
+			assert.Equal(t, "app/config.yaml", string(content))
+		})
+	}
+}
+
+func Test_ExtractTGZArchiveFilesMatching(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+	f, err := os.Create(archivePath)
+	req.NoError(err)
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+	req.NoError(tw.WriteHeader(&tar.Header{Name: "./upstream/", Mode: 0755, Typeflag: tar.TypeDir}))
+	for _, name := range []string{"./upstream/userdata/config.yaml", "./base/kustomization.yaml", "./upstream/app.yaml"} {
+		req.NoError(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name)), Typeflag: tar.TypeReg}))
+		_, err = tw.Write([]byte(name))
+		req.NoError(err)
+	}
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+	req.NoError(f.Close())
+
+	destDir := filepath.Join(tmpDir, "dest")
+	files, err := ExtractTGZArchiveFilesMatching(archivePath, destDir, func(name string) bool {
+		return name == "upstream/userdata/config.yaml"
+	})
+	req.NoError(err)
+	assert.Equal(t, []string{"upstream/userdata/config.yaml"}, files)
+
+	content, err := ioutil.ReadFile(filepath.Join(destDir, "upstream", "userdata", "config.yaml"))
+	req.NoError(err)
+	assert.Equal(t, "./upstream/userdata/config.yaml", string(content))
+
+	_, err = os.Stat(filepath.Join(destDir, "base"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(destDir, "upstream", "app.yaml"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_tgzArchiveTopLevelFolder(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	tests := []struct {
+		name    string
+		entries []string
+		want    string
+	}{
+		{
+			name:    "single folder",
+			entries: []string{"./my-app/", "./my-app/upstream/app.yaml", "./my-app/base/kustomization.yaml"},
+			want:    "my-app",
+		},
+		{
+			name:    "single folder without a directory entry",
+			entries: []string{"my-app/upstream/app.yaml"},
+			want:    "my-app",
+		},
+		{
+			name:    "multiple folders",
+			entries: []string{"upstream/app.yaml", "base/kustomization.yaml"},
+			want:    "",
+		},
+		{
+			name:    "file at the root",
+			entries: []string{"my-app/upstream/app.yaml", "README.md"},
+			want:    "",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			tmpDir, err := ioutil.TempDir("", "kots")
+			req.NoError(err)
+			defer os.RemoveAll(tmpDir)
+
+			archivePath := filepath.Join(tmpDir, "archive.tar.gz")
+			f, err := os.Create(archivePath)
+			req.NoError(err)
+			gzw := gzip.NewWriter(f)
+			tw := tar.NewWriter(gzw)
+			for _, name := range test.entries {
+				if strings.HasSuffix(name, "/") {
+					req.NoError(tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Typeflag: tar.TypeDir}))
+					continue
+				}
+				req.NoError(tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(name)), Typeflag: tar.TypeReg}))
+				_, err = tw.Write([]byte(name))
+				req.NoError(err)
+			}
+			req.NoError(tw.Close())
+			req.NoError(gzw.Close())
+			req.NoError(f.Close())
+
+			got, err := tgzArchiveTopLevelFolder(archivePath)
+			req.NoError(err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func Test_IsTarGz(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	content := []byte("apiVersion: v1\nkind: ConfigMap\n")
+
+	tgzPath := filepath.Join(tmpDir, "archive.tar.gz")
+	f, err := os.Create(tgzPath)
+	req.NoError(err)
+	gzw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gzw)
+	req.NoError(tw.WriteHeader(&tar.Header{Name: "configmap.yaml", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err = tw.Write(content)
+	req.NoError(err)
+	req.NoError(tw.Close())
+	req.NoError(gzw.Close())
+	req.NoError(f.Close())
+
+	emptyTgzPath := filepath.Join(tmpDir, "empty.tar.gz")
+	f, err = os.Create(emptyTgzPath)
+	req.NoError(err)
+	gzw = gzip.NewWriter(f)
+	req.NoError(tar.NewWriter(gzw).Close())
+	req.NoError(gzw.Close())
+	req.NoError(f.Close())
+
+	gzPath := filepath.Join(tmpDir, "configmap.yaml.gz")
+	f, err = os.Create(gzPath)
+	req.NoError(err)
+	gzw = gzip.NewWriter(f)
+	_, err = gzw.Write(content)
+	req.NoError(err)
+	req.NoError(gzw.Close())
+	req.NoError(f.Close())
+
+	plainPath := filepath.Join(tmpDir, "configmap.yaml")
+	req.NoError(ioutil.WriteFile(plainPath, content, 0644))
+
+	isTarGz, err := IsTarGz(tgzPath)
+	req.NoError(err)
+	assert.True(t, isTarGz)
+
+	isTarGz, err = IsTarGz(emptyTgzPath)
+	req.NoError(err)
+	assert.True(t, isTarGz)
+
+	isTarGz, err = IsTarGz(gzPath)
+	req.NoError(err)
+	assert.False(t, isTarGz)
+
+	_, err = IsTarGz(plainPath)
+	req.Error(err)
+
+	destDir := filepath.Join(tmpDir, "dest")
+	req.NoError(ExtractGzipFile(gzPath, destDir, "configmap.yaml"))
+	extracted, err := ioutil.ReadFile(filepath.Join(destDir, "configmap.yaml"))
+	req.NoError(err)
+	assert.Equal(t, content, extracted)
+
+	req.Error(ExtractGzipFile(gzPath, destDir, "../configmap.yaml"))
+}
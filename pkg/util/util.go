@@ -4,9 +4,14 @@ import (
 	"bytes"
 	crand "crypto/rand"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/big"
 	rand "math/rand"
+	"net/http"
 	"net/url"
+	"os"
+	"strings"
 	"time"
 )
 
@@ -128,3 +133,47 @@ type ActionableError struct {
 func (e ActionableError) Error() string {
 	return fmt.Sprintf("%s", e.Message)
 }
+
+// HTTPStatusError is returned when a request completes with an unexpected status code
+type HTTPStatusError struct {
+	StatusCode int
+	URL        string
+	Body       string
+}
+
+func (e HTTPStatusError) Error() string {
+	if e.Body != "" {
+		return fmt.Sprintf("unexpected status code from %s: %d: %s", e.URL, e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("unexpected status code from %s: %d", e.URL, e.StatusCode)
+}
+
+// NewHTTPStatusError creates an HTTPStatusError from the response, including
+// the beginning of the response body to help diagnose the failure
+func NewHTTPStatusError(url string, resp *http.Response) HTTPStatusError {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return HTTPStatusError{
+		StatusCode: resp.StatusCode,
+		URL:        url,
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
+
+// ValidateTempDir checks that a temp file can be created in dir. An empty dir is the system temp dir,
+// and isn't checked.
+func ValidateTempDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	f, err := ioutil.TempFile(dir, "kots-check")
+	if err != nil {
+		return ActionableError{
+			Message: fmt.Sprintf("Temp dir %s is not writable: %v", dir, err),
+		}
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	return nil
+}
@@ -1,6 +1,11 @@
 package util
 
 import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -194,3 +199,39 @@ func TestCompareStringArrays(t *testing.T) {
 		})
 	}
 }
+
+func Test_NewHTTPStatusError(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+
+	resp := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Body:       ioutil.NopCloser(strings.NewReader("  app not found\n")),
+	}
+
+	err := NewHTTPStatusError("http://localhost/api/v1/download", resp)
+	assert.Equal(t, http.StatusNotFound, err.StatusCode)
+	assert.Equal(t, "app not found", err.Body)
+	assert.Equal(t, "unexpected status code from http://localhost/api/v1/download: 404: app not found", err.Error())
+}
+
+func Test_ValidateTempDir(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	req.NoError(ValidateTempDir(""))
+
+	tmpDir, err := ioutil.TempDir("", "kots")
+	req.NoError(err)
+	defer os.RemoveAll(tmpDir)
+
+	req.NoError(ValidateTempDir(tmpDir))
+	files, err := ioutil.ReadDir(tmpDir)
+	req.NoError(err)
+	assert.Empty(t, files)
+
+	err = ValidateTempDir(filepath.Join(tmpDir, "missing"))
+	req.Error(err)
+	assert.Contains(t, err.Error(), "is not writable")
+}
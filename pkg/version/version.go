@@ -1,6 +1,7 @@
 package version
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"runtime"
@@ -31,6 +32,16 @@ type GoInfo struct {
 	Arch     string `json:"arch,omitempty"`
 }
 
+// Info is the version metadata used to identify this build of kots
+type Info struct {
+	Version   string    `json:"version"`
+	GitSHA    string    `json:"git,omitempty"`
+	BuildTime time.Time `json:"buildTime,omitempty"`
+}
+
+// DevVersion is reported when the version was not set with -ldflags
+const DevVersion = "dev"
+
 // initBuild sets up the version info from build args
 func initBuild() {
 	build.Version = version
@@ -52,11 +63,30 @@ func GetBuild() Build {
 	return build
 }
 
+// Get gets the version metadata, with a dev version for builds that did not set one
+func Get() Info {
+	info := Info{
+		Version:   build.Version,
+		GitSHA:    build.GitSHA,
+		BuildTime: build.BuildTime,
+	}
+	if info.Version == "" {
+		info.Version = DevVersion
+	}
+
+	return info
+}
+
 // Version gets the version
 func Version() string {
 	return build.Version
 }
 
+// UserAgent gets the user agent that kots uses to identify itself in outbound requests
+func UserAgent() string {
+	return fmt.Sprintf("KOTS/%s", Get().Version)
+}
+
 // GitSHA gets the gitsha
 func GitSHA() string {
 	return build.GitSHA
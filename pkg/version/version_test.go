@@ -209,3 +209,73 @@ func TestIsLatestRelease(t *testing.T) {
 		})
 	}
 }
+
+func TestUserAgent(t *testing.T) {
+	scopetest := scopeagent.StartTest(t)
+	defer scopetest.End()
+	req := require.New(t)
+
+	defer func(v string) {
+		version = v
+		initBuild()
+	}(version)
+
+	version = "v1.2.3"
+	initBuild()
+
+	req.Equal("KOTS/v1.2.3", UserAgent())
+}
+
+func TestGet(t *testing.T) {
+	aTime, err := time.Parse(time.RFC3339, "2019-06-26T18:53:19Z")
+	require.NoError(t, err, "parse constant time")
+
+	defer func(v, sha, bt string) {
+		version, gitSHA, buildTime = v, sha, bt
+		initBuild()
+	}(version, gitSHA, buildTime)
+
+	tests := []struct {
+		name      string
+		version   string
+		gitSHA    string
+		buildTime string
+		want      Info
+	}{
+		{
+			name: "no ldflags",
+			want: Info{
+				Version: DevVersion,
+			},
+		},
+		{
+			name:      "ldflags",
+			version:   "v1.2.3",
+			gitSHA:    "e21cf800acca2aa972e7f5f65f7134b5da92f05f",
+			buildTime: "2019-06-26T18:53:19Z",
+			want: Info{
+				Version:   "v1.2.3",
+				GitSHA:    "e21cf80",
+				BuildTime: aTime,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scopetest := scopeagent.StartTest(t)
+			defer scopetest.End()
+			req := require.New(t)
+
+			version = tt.version
+			gitSHA = tt.gitSHA
+			buildTime = tt.buildTime
+			initBuild()
+
+			req.Equal(tt.want, Get())
+		})
+	}
+
+	version = ""
+	initBuild()
+	require.Equal(t, "KOTS/dev", UserAgent())
+}